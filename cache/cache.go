@@ -5,11 +5,19 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
+	"log"
+	"net"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	rdb "github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
 )
 
 // Resolver is a function that returns a cacheable resource on a cache miss.
@@ -24,22 +32,45 @@ type Cacher interface {
 	SetNx(string, string, int) error
 	Delete(string) error
 	GetKeys(string) ([]string, error)
+	GetKeysPage(string, uint64, int) ([]string, uint64, error)
 	Decr(string) error
+	DecrFloor(string, int64) (int64, error)
+	Incr(string) (int64, error)
 	Check() (bool, error)
 }
 
+// resolveShared runs fn for key through g, so simultaneous misses on a cold
+// key share one call and its result instead of each fetching.
+func resolveShared(g *singleflight.Group, key string, fn Resolver) (string, error) {
+	val, err, _ := g.Do(key, func() (any, error) {
+		return fn()
+	})
+	if err != nil {
+		return "", err
+	}
+	return val.(string), nil
+}
+
 type memory struct {
-	cache map[string]string
+	mu       sync.RWMutex
+	cache    map[string]string
+	inflight singleflight.Group
 }
 
 var ErrKeyNotFound = errors.New("key not found")
 
+// ErrFloorReached is returned by DecrFloor when the value is already at or
+// below the floor, in which case it is left unchanged.
+var ErrFloorReached = errors.New("value at or below floor")
+
 // NewMemory creates a new in-memory cache
 func NewMemory() *memory {
 	return &memory{cache: make(map[string]string)}
 }
 
 func (m *memory) Get(key string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	if hit, ok := m.cache[key]; ok {
 		return hit, nil
 	} else {
@@ -48,20 +79,33 @@ func (m *memory) Get(key string) (string, error) {
 }
 
 func (m *memory) GetOrFetch(key string, onMiss Resolver) (string, error) {
-	if hit, ok := m.cache[key]; ok {
+	if hit, err := m.Get(key); err == nil {
 		return hit, nil
 	}
 
-	val, err := onMiss()
+	val, err := resolveShared(&m.inflight, key, func() (string, error) {
+		// A flight that finished after the miss above has already stored
+		// the value
+		if hit, err := m.Get(key); err == nil {
+			return hit, nil
+		}
+		val, err := onMiss()
+		if err != nil {
+			return "", err
+		}
+		m.Set(key, val)
+		return val, nil
+	})
 	if err != nil {
-		return "", fmt.Errorf("unable to resolve cache miss: %v", err)
+		return "", fmt.Errorf("unable to resolve cache miss: %w", err)
 	}
 
-	m.cache[key] = val
-	return m.cache[key], nil
+	return val, nil
 }
 
 func (m *memory) GetKeys(pattern string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	var keys []string
 	search := strings.Replace(pattern, "*", "", 1)
 	for k := range m.cache {
@@ -72,7 +116,27 @@ func (m *memory) GetKeys(pattern string) ([]string, error) {
 	return keys, nil
 }
 
+// GetKeysPage returns up to count keys matching pattern, starting at cursor,
+// and the cursor for the next page, which is 0 once every key has been
+// returned. Keys are paged in sorted order; the cursor is an offset into them.
+func (m *memory) GetKeysPage(pattern string, cursor uint64, count int) ([]string, uint64, error) {
+	keys, _ := m.GetKeys(pattern)
+	slices.Sort(keys)
+	if cursor >= uint64(len(keys)) {
+		return nil, 0, nil
+	}
+
+	end := min(cursor+uint64(max(count, 1)), uint64(len(keys)))
+	next := end
+	if end == uint64(len(keys)) {
+		next = 0
+	}
+	return keys[cursor:end], next, nil
+}
+
 func (m *memory) Set(key string, val string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.cache[key] = val
 	return nil
 }
@@ -82,16 +146,24 @@ func (m *memory) SetEx(key string, val string, seconds int) error {
 }
 
 func (m *memory) SetNx(key string, val string, seconds int) error {
-	m.cache[key] = val
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.cache[key]; !ok {
+		m.cache[key] = val
+	}
 	return nil
 }
 
 func (m *memory) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	delete(m.cache, key)
 	return nil
 }
 
 func (m *memory) Decr(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	val, ok := m.cache[key]
 	if !ok {
 		return fmt.Errorf("key not in cache")
@@ -106,12 +178,66 @@ func (m *memory) Decr(key string) error {
 	return nil
 }
 
+// DecrFloor decrements the integer at key and returns the new value, unless
+// the value is already at or below floor. The check and decrement happen under
+// the cache lock, so concurrent callers can never take the value past floor.
+func (m *memory) DecrFloor(key string, floor int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	val, ok := m.cache[key]
+	if !ok {
+		return 0, ErrKeyNotFound
+	}
+
+	ival, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse value as int: %v", err)
+	}
+	if ival <= floor {
+		return ival, ErrFloorReached
+	}
+
+	m.cache[key] = strconv.FormatInt(ival-1, 10)
+	return ival - 1, nil
+}
+
+// Incr increments the integer at key, starting from 0 if it doesn't exist, and
+// returns the new value.
+func (m *memory) Incr(key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ival int64
+	if val, ok := m.cache[key]; ok {
+		var err error
+		if ival, err = strconv.ParseInt(val, 10, 64); err != nil {
+			return 0, fmt.Errorf("unable to parse value as int: %v", err)
+		}
+	}
+
+	m.cache[key] = strconv.FormatInt(ival+1, 10)
+	return ival + 1, nil
+}
+
 func (m *memory) Check() (bool, error) {
 	return true, nil
 }
 
+// Retry and reconnect timing for the Redis cache. Operations that hit a
+// connection error retry up to redisRetries times, backing off from
+// redisRetryBackoff; a background reconnect then pings with backoff capped at
+// redisReconnectMaxBackoff until the server answers.
+const (
+	redisRetries             = 3
+	redisRetryBackoff        = 50 * time.Millisecond
+	redisReconnectMaxBackoff = 30 * time.Second
+)
+
 type redis struct {
-	conn *rdb.Client
+	conn     *rdb.Client
+	inflight singleflight.Group
+	// reconnecting is set while a background reconnect is running, during
+	// which operations fail fast instead of retrying.
+	reconnecting atomic.Bool
 }
 
 func NewRedis(host string, port int) *redis {
@@ -130,9 +256,88 @@ func NewRedis(host string, port int) *redis {
 	return &redis{conn: rdb.NewClient(cacheops)}
 }
 
-func (r *redis) Get(key string) (string, error) {
+// isConnError reports whether err means the connection to Redis failed, as
+// opposed to a miss (rdb.Nil) or an error reply from the server.
+func isConnError(err error) bool {
+	if err == nil || errors.Is(err, rdb.Nil) {
+		return false
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, rdb.ErrPoolTimeout) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// do runs op, retrying with exponential backoff while it fails with a
+// connection error. Retries are skipped while a background reconnect is
+// running, so a downed server doesn't hold up every request. Only idempotent
+// operations should be retried; see once.
+func (r *redis) do(op func(ctx context.Context) error) error {
 	ctx := context.TODO()
-	hit, err := r.conn.Get(ctx, key).Result()
+	backoff := redisRetryBackoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = op(ctx)
+		if !isConnError(err) {
+			return err
+		}
+		if attempt == redisRetries || r.reconnecting.Load() {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	r.reconnect()
+	return err
+}
+
+// once runs op a single time, starting a background reconnect if it fails
+// with a connection error. It suits operations like DECR that must not run
+// twice if the reply, rather than the request, was lost.
+func (r *redis) once(op func(ctx context.Context) error) error {
+	err := op(context.TODO())
+	if isConnError(err) {
+		r.reconnect()
+	}
+	return err
+}
+
+// reconnect starts pinging Redis in the background, with exponential backoff,
+// until it answers. Only one reconnect runs at a time.
+func (r *redis) reconnect() {
+	if !r.reconnecting.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer r.reconnecting.Store(false)
+		log.Println("lost connection to Redis - reconnecting")
+		backoff := redisRetryBackoff
+		for {
+			err := r.conn.Ping(context.TODO()).Err()
+			if err == nil {
+				log.Println("reconnected to Redis")
+				return
+			}
+			if errors.Is(err, rdb.ErrClosed) {
+				return
+			}
+			time.Sleep(backoff)
+			backoff = min(backoff*2, redisReconnectMaxBackoff)
+		}
+	}()
+}
+
+func (r *redis) Get(key string) (string, error) {
+	var hit string
+	err := r.do(func(ctx context.Context) (err error) {
+		hit, err = r.conn.Get(ctx, key).Result()
+		return err
+	})
 
 	if err != nil && err != rdb.Nil {
 		return "", fmt.Errorf("unable to fetch from Redis: %v", err)
@@ -147,24 +352,29 @@ func (r *redis) Get(key string) (string, error) {
 }
 
 func (r *redis) GetOrFetch(key string, onMiss Resolver) (string, error) {
-	ctx := context.TODO()
-	hit, err := r.conn.Get(ctx, key).Result()
+	hit, err := r.Get(key)
 
-	if err != nil && err != rdb.Nil {
-		return "", fmt.Errorf("unable to fetch from Redis: %v", err)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return "", err
 	}
 
 	// Handle cache miss
-	if err == rdb.Nil {
-		val, err := onMiss()
+	if errors.Is(err, ErrKeyNotFound) {
+		val, err := resolveShared(&r.inflight, key, func() (string, error) {
+			val, err := onMiss()
+			if err != nil {
+				return "", err
+			}
+
+			if err := r.Set(key, val); err != nil {
+				// Log and eat error. Not worth crashing the request.
+				log.Printf("unable to cache resolved cache value: %v\n", err)
+			}
+
+			return val, nil
+		})
 		if err != nil {
-			return "", fmt.Errorf("unable to resolve cache miss: %v", err)
-		}
-
-		if err := r.conn.Set(ctx, key, val, 0).Err(); err != nil {
-			// Log and eat error. Not worth crashing the request.
-			// TODO Replace with proper logger
-			fmt.Printf("unable to cache resolved cache value: %v\n", err)
+			return "", fmt.Errorf("unable to resolve cache miss: %w", err)
 		}
 
 		return val, nil
@@ -174,14 +384,13 @@ func (r *redis) GetOrFetch(key string, onMiss Resolver) (string, error) {
 }
 
 func (r *redis) GetKeys(pattern string) ([]string, error) {
-	ctx := context.TODO()
 	var cursor uint64
 	var keys []string
 
 	for {
-		k, c, err := r.conn.Scan(ctx, cursor, pattern, 10).Result()
+		k, c, err := r.GetKeysPage(pattern, cursor, 10)
 		if err != nil {
-			return keys, fmt.Errorf("unable to scan keys from redis: %v", err)
+			return keys, err
 		}
 
 		keys = append(keys, k...)
@@ -195,36 +404,347 @@ func (r *redis) GetKeys(pattern string) ([]string, error) {
 	return keys, nil
 }
 
+// GetKeysPage runs a single SCAN for pattern from cursor, returning the keys
+// found and the cursor for the next page, which is 0 once the scan is
+// complete. count is a hint: Redis may return more or fewer keys.
+func (r *redis) GetKeysPage(pattern string, cursor uint64, count int) ([]string, uint64, error) {
+	var keys []string
+	var next uint64
+	err := r.do(func(ctx context.Context) (err error) {
+		keys, next, err = r.conn.Scan(ctx, cursor, pattern, int64(count)).Result()
+		return err
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to scan keys from redis: %v", err)
+	}
+	return keys, next, nil
+}
+
 func (r *redis) Set(key string, val string) error {
 	return r.SetEx(key, val, 0)
 }
 
 func (r *redis) SetEx(key string, val string, seconds int) error {
-	ctx := context.TODO()
-	return r.conn.Set(ctx, key, val, time.Duration(seconds)*time.Second).Err()
+	return r.do(func(ctx context.Context) error {
+		return r.conn.Set(ctx, key, val, time.Duration(seconds)*time.Second).Err()
+	})
 }
 
 func (r *redis) SetNx(key string, val string, seconds int) error {
-	ctx := context.TODO()
-	return r.conn.SetNX(ctx, key, val, time.Second*time.Duration(seconds)).Err()
+	return r.do(func(ctx context.Context) error {
+		return r.conn.SetNX(ctx, key, val, time.Second*time.Duration(seconds)).Err()
+	})
 }
 
 func (r *redis) Delete(key string) error {
-	ctx := context.TODO()
-	return r.conn.Del(ctx, key).Err()
+	return r.do(func(ctx context.Context) error {
+		return r.conn.Del(ctx, key).Err()
+	})
 }
 
 func (r *redis) Decr(key string) error {
-	ctx := context.TODO()
-	return r.conn.Decr(ctx, key).Err()
+	return r.once(func(ctx context.Context) error {
+		return r.conn.Decr(ctx, key).Err()
+	})
+}
+
+// decrFloorScript decrements KEYS[1] unless it is at or below ARGV[1]. It
+// returns {status, value}: status 1 if decremented, 0 if the floor was reached,
+// and -1 if the key doesn't exist.
+var decrFloorScript = rdb.NewScript(`
+local v = redis.call("GET", KEYS[1])
+if not v then
+	return {-1, 0}
+end
+v = tonumber(v)
+if v <= tonumber(ARGV[1]) then
+	return {0, v}
+end
+return {1, redis.call("DECR", KEYS[1])}
+`)
+
+// DecrFloor decrements the integer at key and returns the new value, unless
+// the value is already at or below floor. It runs as a Lua script, so the
+// check and decrement are atomic across every client of the server.
+func (r *redis) DecrFloor(key string, floor int64) (int64, error) {
+	var res []int64
+	err := r.once(func(ctx context.Context) (err error) {
+		res, err = decrFloorScript.Run(ctx, r.conn, []string{key}, floor).Int64Slice()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(res) != 2 {
+		return 0, fmt.Errorf("unexpected DecrFloor reply: %v", res)
+	}
+
+	switch res[0] {
+	case -1:
+		return 0, ErrKeyNotFound
+	case 0:
+		return res[1], ErrFloorReached
+	default:
+		return res[1], nil
+	}
+}
+
+// Incr increments the integer at key, starting from 0 if it doesn't exist, and
+// returns the new value. Like Decr it isn't retried, so a hit is never counted
+// twice.
+func (r *redis) Incr(key string) (int64, error) {
+	var val int64
+	err := r.once(func(ctx context.Context) (err error) {
+		val, err = r.conn.Incr(ctx, key).Result()
+		return err
+	})
+	return val, err
 }
 
 func (r *redis) Check() (bool, error) {
-	ctx := context.TODO()
-	p, err := r.conn.Ping(ctx).Result()
+	var p string
+	err := r.once(func(ctx context.Context) (err error) {
+		p, err = r.conn.Ping(ctx).Result()
+		return err
+	})
 	if err != nil {
 		return false, err
 	}
 
 	return p == "PONG", nil
 }
+
+// OpStats counts the outcomes of one kind of cache operation. Hits and Misses
+// are only tracked for lookups (Get and GetOrFetch).
+type OpStats struct {
+	Calls  uint64 `json:"calls"`
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	Errors uint64 `json:"errors"`
+}
+
+// CacheStats reports per-operation counters and the overall lookup hit ratio.
+type CacheStats struct {
+	Ops      map[string]OpStats `json:"ops"`
+	Hits     uint64             `json:"hits"`
+	Misses   uint64             `json:"misses"`
+	HitRatio float64            `json:"hitRatio"`
+}
+
+// StatsReporter is implemented by caches that track their own effectiveness.
+type StatsReporter interface {
+	Stats() CacheStats
+}
+
+// InstrumentedCache wraps a Cacher and counts hits, misses, and errors for
+// each operation.
+type InstrumentedCache struct {
+	Cacher
+	mu  sync.Mutex
+	ops map[string]*OpStats
+}
+
+// NewInstrumentedCache wraps c with operation counters.
+func NewInstrumentedCache(c Cacher) *InstrumentedCache {
+	return &InstrumentedCache{Cacher: c, ops: make(map[string]*OpStats)}
+}
+
+// record counts a call to op. hit and miss are ignored for operations that
+// aren't lookups.
+func (ic *InstrumentedCache) record(op string, hit, miss bool, err error) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	s, ok := ic.ops[op]
+	if !ok {
+		s = &OpStats{}
+		ic.ops[op] = s
+	}
+	s.Calls++
+	switch {
+	case hit:
+		s.Hits++
+	case miss:
+		s.Misses++
+	}
+	if err != nil {
+		s.Errors++
+	}
+}
+
+// Stats returns a snapshot of the counters.
+func (ic *InstrumentedCache) Stats() CacheStats {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	stats := CacheStats{Ops: make(map[string]OpStats, len(ic.ops))}
+	for op, s := range ic.ops {
+		stats.Ops[op] = *s
+		stats.Hits += s.Hits
+		stats.Misses += s.Misses
+	}
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(lookups)
+	}
+
+	return stats
+}
+
+func (ic *InstrumentedCache) Get(key string) (string, error) {
+	val, err := ic.Cacher.Get(key)
+	switch {
+	case err == nil:
+		ic.record("get", true, false, nil)
+	case errors.Is(err, ErrKeyNotFound):
+		ic.record("get", false, true, nil)
+	default:
+		ic.record("get", false, false, err)
+	}
+	return val, err
+}
+
+func (ic *InstrumentedCache) GetOrFetch(key string, onMiss Resolver) (string, error) {
+	missed := false
+	val, err := ic.Cacher.GetOrFetch(key, func() (string, error) {
+		missed = true
+		return onMiss()
+	})
+	ic.record("getOrFetch", !missed && err == nil, missed, err)
+	return val, err
+}
+
+func (ic *InstrumentedCache) Set(key string, val string) error {
+	err := ic.Cacher.Set(key, val)
+	ic.record("set", false, false, err)
+	return err
+}
+
+func (ic *InstrumentedCache) SetEx(key string, val string, seconds int) error {
+	err := ic.Cacher.SetEx(key, val, seconds)
+	ic.record("setEx", false, false, err)
+	return err
+}
+
+func (ic *InstrumentedCache) SetNx(key string, val string, seconds int) error {
+	err := ic.Cacher.SetNx(key, val, seconds)
+	ic.record("setNx", false, false, err)
+	return err
+}
+
+func (ic *InstrumentedCache) Delete(key string) error {
+	err := ic.Cacher.Delete(key)
+	ic.record("delete", false, false, err)
+	return err
+}
+
+func (ic *InstrumentedCache) GetKeys(pattern string) ([]string, error) {
+	keys, err := ic.Cacher.GetKeys(pattern)
+	ic.record("getKeys", false, false, err)
+	return keys, err
+}
+
+func (ic *InstrumentedCache) GetKeysPage(pattern string, cursor uint64, count int) ([]string, uint64, error) {
+	keys, next, err := ic.Cacher.GetKeysPage(pattern, cursor, count)
+	ic.record("getKeysPage", false, false, err)
+	return keys, next, err
+}
+
+func (ic *InstrumentedCache) Decr(key string) error {
+	err := ic.Cacher.Decr(key)
+	ic.record("decr", false, false, err)
+	return err
+}
+
+func (ic *InstrumentedCache) DecrFloor(key string, floor int64) (int64, error) {
+	val, err := ic.Cacher.DecrFloor(key, floor)
+	// Reaching the floor is an expected outcome, not a cache failure
+	if errors.Is(err, ErrFloorReached) {
+		ic.record("decrFloor", false, false, nil)
+	} else {
+		ic.record("decrFloor", false, false, err)
+	}
+	return val, err
+}
+
+func (ic *InstrumentedCache) Incr(key string) (int64, error) {
+	val, err := ic.Cacher.Incr(key)
+	ic.record("incr", false, false, err)
+	return val, err
+}
+
+// NamespacedCache wraps a Cacher and prefixes every key with a namespace, so
+// several deployments (e.g. staging and prod) can share one server without
+// seeing each other's keys. Keys returned by GetKeys have the namespace
+// stripped, so they can be passed straight back to the other methods.
+type NamespacedCache struct {
+	Cacher
+	prefix string
+}
+
+// NewNamespacedCache wraps c so every key lives under namespace. An empty
+// namespace returns c unchanged.
+func NewNamespacedCache(c Cacher, namespace string) Cacher {
+	namespace = strings.TrimSuffix(strings.TrimSpace(namespace), ":")
+	if namespace == "" {
+		return c
+	}
+	return &NamespacedCache{Cacher: c, prefix: namespace + ":"}
+}
+
+// Unwrap returns the cache that keys are written to, e.g. to reach a
+// StatsReporter beneath the namespace.
+func (nc *NamespacedCache) Unwrap() Cacher {
+	return nc.Cacher
+}
+
+func (nc *NamespacedCache) Get(key string) (string, error) {
+	return nc.Cacher.Get(nc.prefix + key)
+}
+
+func (nc *NamespacedCache) GetOrFetch(key string, onMiss Resolver) (string, error) {
+	return nc.Cacher.GetOrFetch(nc.prefix+key, onMiss)
+}
+
+func (nc *NamespacedCache) Set(key string, val string) error {
+	return nc.Cacher.Set(nc.prefix+key, val)
+}
+
+func (nc *NamespacedCache) SetEx(key string, val string, seconds int) error {
+	return nc.Cacher.SetEx(nc.prefix+key, val, seconds)
+}
+
+func (nc *NamespacedCache) SetNx(key string, val string, seconds int) error {
+	return nc.Cacher.SetNx(nc.prefix+key, val, seconds)
+}
+
+func (nc *NamespacedCache) Delete(key string) error {
+	return nc.Cacher.Delete(nc.prefix + key)
+}
+
+func (nc *NamespacedCache) GetKeys(pattern string) ([]string, error) {
+	keys, err := nc.Cacher.GetKeys(nc.prefix + pattern)
+	for i, k := range keys {
+		keys[i] = strings.TrimPrefix(k, nc.prefix)
+	}
+	return keys, err
+}
+
+func (nc *NamespacedCache) GetKeysPage(pattern string, cursor uint64, count int) ([]string, uint64, error) {
+	keys, next, err := nc.Cacher.GetKeysPage(nc.prefix+pattern, cursor, count)
+	for i, k := range keys {
+		keys[i] = strings.TrimPrefix(k, nc.prefix)
+	}
+	return keys, next, err
+}
+
+func (nc *NamespacedCache) Decr(key string) error {
+	return nc.Cacher.Decr(nc.prefix + key)
+}
+
+func (nc *NamespacedCache) DecrFloor(key string, floor int64) (int64, error) {
+	return nc.Cacher.DecrFloor(nc.prefix+key, floor)
+}
+
+func (nc *NamespacedCache) Incr(key string) (int64, error) {
+	return nc.Cacher.Incr(nc.prefix + key)
+}
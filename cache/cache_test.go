@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoryGetOrFetchConcurrent(t *testing.T) {
+	const (
+		keys    = 5
+		callers = 50
+	)
+	m := NewMemory()
+	var fetches [keys]atomic.Int32
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := range keys * callers {
+		k := i % keys
+		key := fmt.Sprintf("recipes:raw:%d", k)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			val, err := m.GetOrFetch(key, func() (string, error) {
+				fetches[k].Add(1)
+				// Hold the flight open so the other callers pile up on it
+				time.Sleep(10 * time.Millisecond)
+				return fmt.Sprintf("value %d", k), nil
+			})
+			if err != nil {
+				t.Errorf("GetOrFetch(%s): %v", key, err)
+				return
+			}
+			if want := fmt.Sprintf("value %d", k); val != want {
+				t.Errorf("GetOrFetch(%s) = %q, want %q", key, val, want)
+			}
+		}()
+	}
+	// Readers and writers on unrelated keys run alongside
+	for i := range callers {
+		key := fmt.Sprintf("sessions:%d", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if err := m.Set(key, "session"); err != nil {
+				t.Errorf("Set(%s): %v", key, err)
+			}
+			if val, err := m.Get(key); err != nil || val != "session" {
+				t.Errorf("Get(%s) = %q, %v", key, val, err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	for k := range keys {
+		if n := fetches[k].Load(); n != 1 {
+			t.Errorf("key %d fetched %d times, want 1", k, n)
+		}
+	}
+}
+
+func TestMemoryGetOrFetchError(t *testing.T) {
+	m := NewMemory()
+	errFetch := errors.New("fetch failed")
+
+	if _, err := m.GetOrFetch("recipes:raw:x", func() (string, error) { return "", errFetch }); !errors.Is(err, errFetch) {
+		t.Fatalf("GetOrFetch error = %v, want the resolver's error", err)
+	}
+	if _, err := m.Get("recipes:raw:x"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("a failed fetch was cached: %v", err)
+	}
+
+	// The failure isn't remembered, so the next call fetches again
+	val, err := m.GetOrFetch("recipes:raw:x", func() (string, error) { return "value", nil })
+	if err != nil || val != "value" {
+		t.Errorf("GetOrFetch after a failure = %q, %v", val, err)
+	}
+}
+
+func TestMemoryDecrFloorConcurrent(t *testing.T) {
+	m := NewMemory()
+	if err := m.Set("quotas:a", "3"); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var spent atomic.Int32
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := m.DecrFloor("quotas:a", 0); err == nil {
+				spent.Add(1)
+			} else if !errors.Is(err, ErrFloorReached) {
+				t.Errorf("DecrFloor: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := spent.Load(); n != 3 {
+		t.Errorf("%d decrements succeeded, want 3", n)
+	}
+	if val, _ := m.Get("quotas:a"); val != "0" {
+		t.Errorf("quota = %q, want 0", val)
+	}
+}
@@ -0,0 +1,230 @@
+// Package client is a Go client for the wine pairing suggestions webapp's
+// JSON API, for services that want pairings without hand-rolling requests.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+
+	"github.com/thedahv/wine-pairing-suggestions/models"
+)
+
+// The webapp's auth and response headers and cookies.
+const (
+	apiKeyHeader      = "X-API-Key"
+	sessionCookieName = "wine-suggestions-session"
+	truncatedHeader   = "X-Suggestions-Truncated"
+)
+
+// envelope is the shape responses take from a webapp configured to wrap them
+// (RESPONSE_ENVELOPE=true). Meta is only checked for a request ID, to tell an
+// envelope from a payload that happens to have a "data" field.
+type envelope struct {
+	Data  json.RawMessage `json:"data"`
+	Error string          `json:"error"`
+	Meta  struct {
+		RequestID string `json:"requestId"`
+	} `json:"meta"`
+}
+
+// unwrap returns the payload of body if it is an envelope, or body as-is.
+func unwrap(body []byte) []byte {
+	var env envelope
+	if err := json.Unmarshal(body, &env); err != nil || env.Meta.RequestID == "" {
+		return body
+	}
+	return env.Data
+}
+
+// maxErrorBodyBytes bounds how much of an error response is read into an
+// Error.
+const maxErrorBodyBytes = 4 << 10
+
+// Client calls a webapp at a base URL. It is safe for concurrent use.
+type Client struct {
+	baseURL *url.URL
+	http    *http.Client
+	apiKey  string
+	session string
+}
+
+// Option configures a Client built with New.
+type Option func(*Client) error
+
+// WithHTTPClient sends requests with c instead of a default client with its
+// own cookie jar.
+func WithHTTPClient(c *http.Client) Option {
+	return func(cl *Client) error {
+		if c == nil {
+			return fmt.Errorf("HTTP client is nil")
+		}
+		cl.http = c
+		return nil
+	}
+}
+
+// WithAPIKey authenticates requests with a partner API key, which the webapp
+// checks before any session cookie.
+func WithAPIKey(key string) Option {
+	return func(cl *Client) error {
+		cl.apiKey = key
+		return nil
+	}
+}
+
+// WithSession authenticates requests as the account with an existing session,
+// sending accountID as the session cookie.
+func WithSession(accountID string) Option {
+	return func(cl *Client) error {
+		cl.session = accountID
+		return nil
+	}
+}
+
+// New returns a client for the webapp at baseURL, e.g.
+// "https://wine.example.com".
+func New(baseURL string, options ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid base URL %q: must be an absolute http(s) URL", baseURL)
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create cookie jar: %v", err)
+	}
+	c := &Client{baseURL: u, http: &http.Client{Jar: jar}}
+	for _, option := range options {
+		if err := option(c); err != nil {
+			return nil, fmt.Errorf("unable to apply option: %v", err)
+		}
+	}
+
+	return c, nil
+}
+
+// Error is a non-2xx response from the webapp.
+type Error struct {
+	StatusCode int
+	// Message is the server's error message, or the raw body if it wasn't a
+	// JSON error.
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("webapp responded %d: %s", e.StatusCode, e.Message)
+}
+
+// ErrUnauthorized is matched by errors for requests the webapp rejected for
+// missing or invalid credentials.
+var ErrUnauthorized = errors.New("not authorized")
+
+func (e *Error) Is(target error) bool {
+	return target == ErrUnauthorized && e.StatusCode == http.StatusUnauthorized
+}
+
+// CreateSummary summarizes the recipe at recipeURL, which the webapp requires
+// before GetSuggestions. It spends quota unless the summary is cached.
+func (c *Client) CreateSummary(ctx context.Context, recipeURL string) (models.Summary, error) {
+	var summary models.Summary
+	if _, err := c.do(ctx, http.MethodPost, "/recipes/summary/"+url.PathEscape(recipeURL), &summary); err != nil {
+		return models.Summary{}, err
+	}
+	// The webapp only returns successful summaries
+	summary.Ok = true
+
+	return summary, nil
+}
+
+// GetSuggestions returns wine pairings for the recipe at recipeURL, which
+// must have been summarized with CreateSummary. The response has no Summary;
+// it is returned by CreateSummary.
+func (c *Client) GetSuggestions(ctx context.Context, recipeURL string) (models.SuggestionsResponse, error) {
+	var resp models.SuggestionsResponse
+	header, err := c.do(ctx, http.MethodGet, "/recipes/suggestions/"+url.PathEscape(recipeURL), &resp.Suggestions)
+	if err != nil {
+		return models.SuggestionsResponse{}, err
+	}
+	resp.Truncated = header.Get(truncatedHeader) == "true"
+
+	return resp, nil
+}
+
+// Recent returns a sample of recipe URLs with cached pairings.
+func (c *Client) Recent(ctx context.Context) ([]string, error) {
+	var urls []string
+	if _, err := c.do(ctx, http.MethodGet, "/recipes/suggestions/recent", &urls); err != nil {
+		return nil, err
+	}
+	return urls, nil
+}
+
+// do sends an authenticated request to path and decodes a JSON response into
+// out, unwrapping it first if the webapp sends responses in an envelope. It
+// returns the response headers.
+func (c *Client) do(ctx context.Context, method, path string, out any) (http.Header, error) {
+	// path is already escaped, so it is joined as-is
+	u := c.baseURL.String() + path
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to build request: %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set(apiKeyHeader, c.apiKey)
+	}
+	if c.session != "" {
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: c.session})
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s failed: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.Header, responseError(resp)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.Header, fmt.Errorf("unable to read %s %s response: %v", method, path, err)
+	}
+	if err := json.Unmarshal(unwrap(body), out); err != nil {
+		return resp.Header, fmt.Errorf("unable to decode %s %s response: %v", method, path, err)
+	}
+
+	return resp.Header, nil
+}
+
+// responseError reads an error response, which is JSON from most routes, in
+// an envelope if the webapp wraps responses, but plain text from the session
+// check.
+func responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyBytes))
+	var serverErr struct {
+		Message string `json:"message"`
+	}
+	var env envelope
+	msg := strings.TrimSpace(string(body))
+	if err := json.Unmarshal(body, &serverErr); err == nil && serverErr.Message != "" {
+		msg = serverErr.Message
+	} else if err := json.Unmarshal(body, &env); err == nil && env.Error != "" {
+		msg = env.Error
+	}
+	if msg == "" {
+		msg = http.StatusText(resp.StatusCode)
+	}
+
+	return &Error{StatusCode: resp.StatusCode, Message: msg}
+}
@@ -0,0 +1,146 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tmc/langchaingo/llms"
+
+	"github.com/thedahv/wine-pairing-suggestions/cache"
+	"github.com/thedahv/wine-pairing-suggestions/data"
+	"github.com/thedahv/wine-pairing-suggestions/data/datatest"
+	wpmcp "github.com/thedahv/wine-pairing-suggestions/mcp"
+	"github.com/thedahv/wine-pairing-suggestions/webapp"
+)
+
+const recipeHTML = `<html><head><title>Braised Short Ribs</title></head><body><article>
+<h1>Braised Short Ribs</h1>
+<p>Season the short ribs generously, brown them in a heavy pot, then braise them in red wine with onions, carrots, garlic and thyme for three hours until the meat falls off the bone.</p>
+<ul><li>4 beef short ribs</li><li>1 bottle red wine</li><li>2 onions</li><li>3 carrots</li><li>6 cloves garlic</li></ul>
+<p>Reduce the braising liquid to a glossy sauce and serve over mashed potatoes.</p>
+</article></body></html>`
+
+const summaryJSON = `{
+	"ok": true,
+	"summary": "Rich, heavy braised beef short ribs in a savory red wine sauce with sweet carrots, onions and thyme; umami-laden and fatty.",
+	"flavorProfile": {"sweetness": 1, "acidity": 2, "fat": 4, "spice": 0, "weight": 5}
+}`
+
+// summaryModel answers every prompt with summaryJSON.
+type summaryModel struct{}
+
+func (summaryModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: summaryJSON}}}, nil
+}
+
+func (m summaryModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+// newTestServer serves the real webapp handlers, with an account "acct" and a
+// stored pairing for a recipe, and returns the webapp's and recipe's URLs.
+func newTestServer(t *testing.T, options ...webapp.Option) (string, string) {
+	t.Helper()
+	recipes := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, recipeHTML)
+	}))
+	t.Cleanup(recipes.Close)
+	recipeURL := recipes.URL + "/braised-short-ribs"
+
+	dl, _ := datatest.NewDataLayer(t)
+	ctx := context.Background()
+	if _, err := dl.CreateAccountWithQuota(ctx, "acct", "acct@example.com", 5); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dl.CreateRecipePairing(ctx, recipeURL, data.PairingTypeURL, "Braised ribs", []data.Suggestion{
+		{Style: "Syrah", Region: "Northern Rhône", Confidence: 0.8},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	wa, err := webapp.NewWebapp(0, append([]webapp.Option{
+		webapp.WithMemoryCache(),
+		webapp.WithDatabase(dl),
+		webapp.WithModel(summaryModel{}, wpmcp.MakeServer(cache.NewMemory())),
+		webapp.WithLogLevel(webapp.LogLevelError),
+	}, options...)...)
+	if err != nil {
+		t.Fatalf("unable to create webapp: %v", err)
+	}
+	srv := httptest.NewServer(wa.Handler())
+	t.Cleanup(srv.Close)
+
+	return srv.URL, recipeURL
+}
+
+func TestClient(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		options []webapp.Option
+	}{
+		{"plain responses", nil},
+		{"envelope", []webapp.Option{webapp.WithResponseEnvelope()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			baseURL, recipeURL := newTestServer(t, tc.options...)
+			c, err := New(baseURL, WithSession("acct"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := context.Background()
+
+			summary, err := c.CreateSummary(ctx, recipeURL)
+			if err != nil {
+				t.Fatalf("CreateSummary: %v", err)
+			}
+			if !strings.HasPrefix(summary.Summary, "Rich, heavy") {
+				t.Errorf("summary = %+v", summary)
+			}
+
+			resp, err := c.GetSuggestions(ctx, recipeURL)
+			if err != nil {
+				t.Fatalf("GetSuggestions: %v", err)
+			}
+			if len(resp.Suggestions) != 1 || resp.Suggestions[0].Style != "Syrah" {
+				t.Errorf("suggestions = %+v, want the stored pairing", resp.Suggestions)
+			}
+
+			// Errors carry the server's message, wrapped or not
+			_, err = c.GetSuggestions(ctx, "not a url")
+			var clientErr *Error
+			if !errors.As(err, &clientErr) || clientErr.StatusCode != http.StatusBadRequest || strings.Contains(clientErr.Message, "{") {
+				t.Errorf("invalid URL error = %v, want a 400 with the server's message", err)
+			}
+
+			anon, err := New(baseURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := anon.CreateSummary(ctx, recipeURL); !errors.Is(err, ErrUnauthorized) {
+				t.Errorf("anonymous CreateSummary = %v, want ErrUnauthorized", err)
+			}
+		})
+	}
+}
+
+func TestUnwrap(t *testing.T) {
+	for _, tc := range []struct {
+		name, body, want string
+	}{
+		{"envelope", `{"data": [1, 2], "meta": {"requestId": "abc"}}`, `[1, 2]`},
+		{"array", `[1, 2]`, `[1, 2]`},
+		{"payload with a data field", `{"data": 1}`, `{"data": 1}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := string(unwrap([]byte(tc.body))); got != tc.want {
+				t.Errorf("unwrap(%s) = %s, want %s", tc.body, got, tc.want)
+			}
+		})
+	}
+}
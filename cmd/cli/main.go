@@ -2,94 +2,244 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/briandowns/spinner"
+	"github.com/tmc/langchaingo/llms"
+
 	"github.com/thedahv/wine-pairing-suggestions/helpers"
 	"github.com/thedahv/wine-pairing-suggestions/models"
-	"github.com/tmc/langchaingo/llms"
 )
 
+const usage = `Usage:
+  %[1]s summarize [-json] <recipe-url>   summarize a recipe
+  %[1]s suggest [-json] <recipe-url>     summarize a recipe and suggest wines for it
+  %[1]s pair [-json] "<dish>"            suggest wines for a dish described in text
+
+Running %[1]s <recipe-url> without a command is deprecated; use suggest.
+`
+
+// errUsage is returned for command lines that don't match usage.
+var errUsage = errors.New("invalid arguments")
+
+// modelFactory creates the model commands run against. It is only called once
+// the arguments are known to be valid.
+type modelFactory func(ctx context.Context) (llms.Model, error)
+
+// command is a CLI subcommand taking a single positional argument.
+type command struct {
+	name string
+	run  func(ctx context.Context, model llms.Model, arg string, asJSON bool, out io.Writer) error
+}
+
+var commands = []command{
+	{name: "summarize", run: runSummarize},
+	{name: "suggest", run: runSuggest},
+	{name: "pair", run: runPair},
+}
+
 func main() {
-	args := os.Args[1:]
-	if len(args) != 1 {
-		log.Fatalf("Usage: %s <recipe-url>", os.Args[0])
+	err := run(context.Background(), os.Args[1:], os.Stdout, os.Stderr, func(ctx context.Context) (llms.Model, error) {
+		return models.MakeBedrockModel(ctx)
+	})
+	if errors.Is(err, errUsage) {
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		fmt.Fprintf(os.Stderr, usage, os.Args[0])
+		os.Exit(2)
 	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
 
-	recipeURL := args[0]
+// run dispatches args to a subcommand. A lone argument that isn't a command
+// name is treated as "suggest <arg>" for compatibility with the original CLI,
+// with a deprecation warning on stderr.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer, newModel modelFactory) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: a command is required", errUsage)
+	}
 
-	ctx := context.Background()
-	model, err := models.MakeBedrockModel(ctx)
+	name, rest := args[0], args[1:]
+	cmd, ok := findCommand(name)
+	if !ok {
+		if len(args) != 1 || strings.HasPrefix(name, "-") {
+			return fmt.Errorf("%w: unknown command %q", errUsage, name)
+		}
+		fmt.Fprintf(stderr, "Warning: running without a command is deprecated; use \"suggest %s\"\n", name)
+		cmd, _ = findCommand("suggest")
+		rest = args
+	}
 
+	flags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	asJSON := flags.Bool("json", false, "print JSON instead of text")
+	if err := flags.Parse(rest); err != nil {
+		return fmt.Errorf("%w: %s: %v", errUsage, cmd.name, err)
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("%w: %s takes exactly one argument, got %d", errUsage, cmd.name, flags.NArg())
+	}
+	arg := strings.TrimSpace(flags.Arg(0))
+	if arg == "" {
+		return fmt.Errorf("%w: %s needs a non-empty argument", errUsage, cmd.name)
+	}
+
+	model, err := newModel(ctx)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	spinner := spinner.New(spinner.CharSets[9], 100*time.Millisecond)
+	return cmd.run(ctx, model, arg, *asJSON, stdout)
+}
+
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
 
-	rdr, err := helpers.FetchRawFromURL(recipeURL)
-	fmt.Println("Fetching the recipe.")
-	spinner.Start()
+// runSummarize prints the summary of the recipe at recipeURL.
+func runSummarize(ctx context.Context, model llms.Model, recipeURL string, asJSON bool, out io.Writer) error {
+	summary, err := summarizeURL(ctx, model, recipeURL, progress(out, asJSON))
 	if err != nil {
-		log.Fatal("unable to fetch recipe:", err)
+		return err
 	}
-	spinner.Stop()
 
-	raw, err := io.ReadAll(rdr)
+	if asJSON {
+		return json.NewEncoder(out).Encode(summary)
+	}
+	printSummary(out, summary)
+	return nil
+}
+
+// runSuggest summarizes the recipe at recipeURL and prints wine pairings for
+// it.
+func runSuggest(ctx context.Context, model llms.Model, recipeURL string, asJSON bool, out io.Writer) error {
+	status := progress(out, asJSON)
+	summary, err := summarizeURL(ctx, model, recipeURL, status)
 	if err != nil {
-		log.Fatal("unable to read raw response:", err)
+		return err
+	}
+	if !asJSON {
+		printSummary(out, summary)
+		fmt.Fprintln(out)
 	}
 
-	fmt.Println("Summarizing the recipe.")
-	spinner.Start()
-	markdown, err := helpers.CreateMarkdownFromRaw(recipeURL, string(raw))
+	suggestions, err := suggest(ctx, model, summary, status)
 	if err != nil {
-		log.Fatal("unable to create markdown from raw:", err)
+		return err
+	}
+
+	if asJSON {
+		return json.NewEncoder(out).Encode(models.SuggestionsResponse{Suggestions: suggestions, Summary: summary.Summary})
 	}
+	printSuggestions(out, suggestions)
+	return nil
+}
 
-	summary, err := models.SummarizeRecipe(ctx, model, markdown)
+// runPair prints wine pairings for a dish described in text, without
+// fetching a recipe.
+func runPair(ctx context.Context, model llms.Model, dish string, asJSON bool, out io.Writer) error {
+	suggestions, err := suggest(ctx, model, models.Summary{Ok: true, Summary: dish}, progress(out, asJSON))
 	if err != nil {
-		log.Fatal("unable to summarize recipe:", err)
+		return err
 	}
-	spinner.Stop()
 
-	fmt.Println("Recipe Summary:")
-	fmt.Println(summary)
-	fmt.Println()
-	fmt.Println()
+	if asJSON {
+		return json.NewEncoder(out).Encode(suggestions)
+	}
+	printSuggestions(out, suggestions)
+	return nil
+}
 
-	prompt := fmt.Sprintf(`
-		Role: You are a wine-minded foodie who wants to make wine accessible to
-		everyone, particularly focusing on wine's relationship with food. Rather
-		than being highbrow and inaccessible, you bias for approachable
-		suggestions that are easy to understand.
+// progress returns how a command reports what it is doing: a message and a
+// spinner on out, or nothing at all when printing JSON.
+func progress(out io.Writer, quiet bool) func(msg string) (done func()) {
+	if quiet {
+		return func(string) func() { return func() {} }
+	}
+	return func(msg string) func() {
+		fmt.Fprintln(out, msg)
+		if out != os.Stdout {
+			return func() {}
+		}
+		s := spinner.New(spinner.CharSets[9], 100*time.Millisecond)
+		s.Start()
+		return s.Stop
+	}
+}
 
-		Context: You are given a recipe in markdown format with an intent to
-		think about wines that would pair well:
+// summarizeURL fetches the recipe at recipeURL and summarizes it.
+func summarizeURL(ctx context.Context, model llms.Model, recipeURL string, status func(string) func()) (models.Summary, error) {
+	done := status("Fetching the recipe.")
+	rdr, _, err := helpers.FetchRawFromURL(recipeURL)
+	if err != nil {
+		done()
+		return models.Summary{}, fmt.Errorf("unable to fetch recipe: %v", err)
+	}
+	raw, err := io.ReadAll(rdr)
+	done()
+	if err != nil {
+		return models.Summary{}, fmt.Errorf("unable to read raw response: %v", err)
+	}
 
-		<RECIPE_SUMMARY>
-		%s
-		</RECIPE_SUMMARY>
+	done = status("Summarizing the recipe.")
+	defer done()
+	markdown, err := helpers.CreateMarkdownFromRawWithOptions(recipeURL, string(raw), helpers.MarkdownOptionsFromEnv())
+	if err != nil {
+		return models.Summary{}, fmt.Errorf("unable to create markdown from raw: %v", err)
+	}
+
+	output, err := models.SummarizeRecipe(ctx, model, markdown)
+	if err != nil {
+		return models.Summary{}, fmt.Errorf("unable to summarize recipe: %v", err)
+	}
+	summary, err := models.ParseSummary(output)
+	if err != nil {
+		return models.Summary{}, fmt.Errorf("unable to parse recipe summary: %v", err)
+	}
+	if !summary.Ok {
+		return models.Summary{}, fmt.Errorf("unable to summarize recipe: %s", summary.AbortReason)
+	}
 
-		Task: Generate up to ten wine pairings, describing the wine name,
-		producer, and vintage. Offer a one-sentence tasting notes for the wine
-		and then another sentence on why it pairs well with the dish.`,
-		summary,
-	)
+	return summary, nil
+}
 
-	fmt.Println("Generating wine pairings.")
-	spinner.Start()
-	answer, err := llms.GenerateFromSinglePrompt(ctx, model, prompt)
+// suggest generates wine pairings for a recipe summary or, wrapped in one, a
+// dish description.
+func suggest(ctx context.Context, model llms.Model, summary models.Summary, status func(string) func()) ([]models.Suggestion, error) {
+	done := status("Generating wine pairings.")
+	defer done()
+	suggestions, err := models.GeneratePairingSuggestionsTyped(ctx, model, summary)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("unable to generate wine pairings: %v", err)
 	}
-	spinner.Stop()
+	return suggestions, nil
+}
 
-	fmt.Println()
-	fmt.Println()
-	fmt.Println(answer)
+func printSummary(out io.Writer, summary models.Summary) {
+	fmt.Fprintln(out, "Recipe Summary:")
+	fmt.Fprintln(out, summary.Summary)
+	fmt.Fprintln(out)
+}
+
+func printSuggestions(out io.Writer, suggestions []models.Suggestion) {
+	fmt.Fprintln(out)
+	for i, s := range suggestions {
+		fmt.Fprintf(out, "%d. %s (%s)\n", i+1, s.Style, s.Region)
+		fmt.Fprintf(out, "   %s\n", s.Description)
+		fmt.Fprintf(out, "   %s\n\n", s.PairingNote)
+	}
 }
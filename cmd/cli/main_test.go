@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tmc/langchaingo/llms"
+
+	"github.com/thedahv/wine-pairing-suggestions/models"
+)
+
+const recipeHTML = `<html><head><title>Braised Short Ribs</title></head><body><article>
+<h1>Braised Short Ribs</h1>
+<p>Season the short ribs generously, brown them in a heavy pot, then braise them in red wine with onions, carrots, garlic and thyme for three hours until the meat falls off the bone.</p>
+<ul><li>4 beef short ribs</li><li>1 bottle red wine</li><li>2 onions</li><li>3 carrots</li><li>6 cloves garlic</li></ul>
+<p>Reduce the braising liquid to a glossy sauce and serve over mashed potatoes.</p>
+</article></body></html>`
+
+const summaryJSON = `{
+	"ok": true,
+	"summary": "Rich, heavy braised beef short ribs in a savory red wine sauce.",
+	"flavorProfile": {"sweetness": 1, "acidity": 2, "fat": 4, "spice": 0, "weight": 5},
+	"servings": 4
+}`
+
+const suggestionsJSON = `[{"style": "Syrah", "region": "Northern Rhône", "description": "Savory red.", "pairingNote": "Echoes the braise.", "confidence": 0.8}]`
+
+// testModel answers summary prompts with summaryJSON and every other prompt
+// with suggestionsJSON, recording the prompts it is sent.
+type testModel struct {
+	prompts []string
+}
+
+func (m *testModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	var sb strings.Builder
+	for _, message := range messages {
+		for _, part := range message.Parts {
+			if text, ok := part.(llms.TextContent); ok {
+				sb.WriteString(text.Text)
+			}
+		}
+	}
+	prompt := sb.String()
+	m.prompts = append(m.prompts, prompt)
+
+	answer := suggestionsJSON
+	if strings.Contains(prompt, "Summarize this recipe for wine pairing") {
+		answer = summaryJSON
+	}
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: answer}}}, nil
+}
+
+func (m *testModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+// runCLI runs args against a testModel, returning the model (nil if it was
+// never created), stdout, stderr, and the error.
+func runCLI(t *testing.T, args ...string) (*testModel, string, string, error) {
+	t.Helper()
+	var model *testModel
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), args, &stdout, &stderr, func(ctx context.Context) (llms.Model, error) {
+		model = &testModel{}
+		return model, nil
+	})
+	return model, stdout.String(), stderr.String(), err
+}
+
+// recipeServer serves recipeHTML and returns its URL.
+func recipeServer(t *testing.T) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(recipeHTML))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/braised-short-ribs"
+}
+
+func TestRunSuggest(t *testing.T) {
+	recipeURL := recipeServer(t)
+
+	for _, tc := range []struct {
+		name string
+		args []string
+		// deprecated is whether a deprecation warning is expected
+		deprecated bool
+	}{
+		{"suggest", []string{"suggest", recipeURL}, false},
+		{"without a command", []string{recipeURL}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			model, stdout, stderr, err := runCLI(t, tc.args...)
+			if err != nil {
+				t.Fatalf("run: %v", err)
+			}
+			if got := strings.Contains(stderr, "deprecated"); got != tc.deprecated {
+				t.Errorf("stderr = %q, want a deprecation warning: %v", stderr, tc.deprecated)
+			}
+			if !strings.Contains(stdout, "Syrah") {
+				t.Errorf("stdout = %q, want the suggestions", stdout)
+			}
+			if len(model.prompts) != 2 {
+				t.Fatalf("model was sent %d prompts, want a summary and a suggestions prompt", len(model.prompts))
+			}
+			// The whole summary reaches the suggestions prompt, not just its text
+			prompt := model.prompts[1]
+			for _, want := range []string{"<CANDIDATE_STYLES>", "<SERVINGS>"} {
+				if !strings.Contains(prompt, want) {
+					t.Errorf("suggestions prompt is missing %s:\n%s", want, prompt)
+				}
+			}
+		})
+	}
+}
+
+func TestRunSuggestJSON(t *testing.T) {
+	_, stdout, _, err := runCLI(t, "suggest", "-json", recipeServer(t))
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	var resp models.SuggestionsResponse
+	if err := json.Unmarshal([]byte(stdout), &resp); err != nil {
+		t.Fatalf("stdout isn't a suggestions response: %v\n%s", err, stdout)
+	}
+	if len(resp.Suggestions) != 1 || resp.Suggestions[0].Style != "Syrah" || !strings.HasPrefix(resp.Summary, "Rich, heavy") {
+		t.Errorf("response = %+v", resp)
+	}
+}
+
+func TestRunPair(t *testing.T) {
+	model, stdout, _, err := runCLI(t, "pair", "-json", "grilled salmon with dill")
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	var suggestions []models.Suggestion
+	if err := json.Unmarshal([]byte(stdout), &suggestions); err != nil || len(suggestions) != 1 {
+		t.Fatalf("stdout = %q, %v; want the suggestions", stdout, err)
+	}
+	if len(model.prompts) != 1 || !strings.Contains(model.prompts[0], "grilled salmon with dill") {
+		t.Errorf("prompts = %q, want one naming the dish", model.prompts)
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	for _, args := range [][]string{
+		nil,
+		{"summarize"},
+		{"suggest", "a", "b"},
+		{"pair", "  "},
+		{"-json"},
+		{"suggest", "-nope", "https://example.com"},
+		{"nope", "https://example.com"},
+	} {
+		model, _, _, err := runCLI(t, args...)
+		if !errors.Is(err, errUsage) {
+			t.Errorf("run(%q) = %v, want errUsage", args, err)
+		}
+		if model != nil {
+			t.Errorf("run(%q) created a model", args)
+		}
+	}
+}
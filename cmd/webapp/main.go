@@ -2,13 +2,17 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/thedahv/wine-pairing-suggestions/cache"
 	"github.com/thedahv/wine-pairing-suggestions/data"
+	"github.com/thedahv/wine-pairing-suggestions/helpers"
 	"github.com/thedahv/wine-pairing-suggestions/mcp"
 	"github.com/thedahv/wine-pairing-suggestions/models"
 	"github.com/thedahv/wine-pairing-suggestions/webapp"
@@ -33,28 +37,149 @@ func main() {
 
 	ctx := context.Background()
 
-	model, err := models.MakeClaude(ctx)
+	var modelOptions []models.Option
+	if n, err := strconv.Atoi(os.Getenv("MODEL_MAX_TOKENS")); err == nil && n > 0 {
+		modelOptions = append(modelOptions, models.WithMaxTokens(n))
+	}
+	if ttl := os.Getenv("CREDENTIALS_CACHE_TTL"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			log.Fatalf("unable to parse CREDENTIALS_CACHE_TTL: %v", err)
+		}
+		helpers.CredentialsTTL = d
+	}
+	makeModel := models.MakeClaude
+	if os.Getenv("BEDROCK_FALLBACK") == "true" {
+		makeModel = models.MakeClaudeWithBedrockFallback
+	}
+	model, err := makeModel(ctx, modelOptions...)
 	if err != nil {
 		log.Fatalf("unable to create model: %v", err)
 	}
+	model = models.NewRetryModel(model)
 
 	fmt.Printf("Connecting to cache (host=%s, host=%d)... ", host, cachePort)
-	c := cache.NewRedis(host, cachePort)
+	c := cache.NewInstrumentedCache(cache.NewRedis(host, cachePort))
 	fmt.Println("Connected")
-	s := mcp.MakeServer(c)
+	var allowedDomains []string
+	if domains := os.Getenv("ALLOWED_RECIPE_DOMAINS"); domains != "" {
+		allowedDomains = strings.Split(domains, ",")
+	}
+	markdownOptions := helpers.MarkdownOptionsFromEnv()
+	// The MCP tools write through their own handle, so they need the namespace too
+	namespace := os.Getenv("CACHE_NAMESPACE")
+	s := mcp.MakeServer(cache.NewNamespacedCache(c, namespace),
+		mcp.WithAllowedDomains(allowedDomains),
+		mcp.WithMarkdownOptions(markdownOptions),
+	)
 
 	dl, err := data.Create(ctx)
 	if err != nil {
 		log.Fatalf("unable to connect to database")
 	}
 
-	wa, err := webapp.NewWebapp(serverPort,
+	var apiKeys map[string]webapp.ApiKeyConfig
+	if keys := os.Getenv("API_KEYS"); keys != "" {
+		if err := json.Unmarshal([]byte(keys), &apiKeys); err != nil {
+			log.Fatalf("unable to parse API_KEYS: %v", err)
+		}
+	}
+
+	logLevel, err := webapp.ParseLogLevel(os.Getenv("LOG_LEVEL"))
+	if err != nil {
+		log.Fatalf("unable to parse LOG_LEVEL: %v", err)
+	}
+
+	var requestTimeout time.Duration
+	if timeout := os.Getenv("REQUEST_TIMEOUT"); timeout != "" {
+		if requestTimeout, err = time.ParseDuration(timeout); err != nil {
+			log.Fatalf("unable to parse REQUEST_TIMEOUT: %v", err)
+		}
+	}
+
+	if conns := os.Getenv("FETCH_MAX_CONNS_PER_HOST"); conns != "" {
+		n, err := strconv.Atoi(conns)
+		if err != nil {
+			log.Fatalf("unable to parse FETCH_MAX_CONNS_PER_HOST: %v", err)
+		}
+		helpers.SetFetchClient(helpers.NewFetchClient(helpers.FetchClientConfig{MaxConnsPerHost: n}))
+	}
+	if types := os.Getenv("FETCH_ALLOWED_CONTENT_TYPES"); types != "" {
+		helpers.AllowedContentTypes = strings.Split(types, ",")
+	}
+
+	options := []webapp.Option{
+		webapp.WithAllowedRecipeDomains(allowedDomains),
+		webapp.WithApiKeys(apiKeys),
 		webapp.WithCache(c),
+		webapp.WithCacheNamespace(namespace),
 		webapp.WithDatabase(dl),
 		webapp.WithGoogleClientID(os.Getenv("GOOGLE_CLIENT_ID")),
 		webapp.WithHostname(os.Getenv("HOSTNAME")),
+		webapp.WithLogLevel(logLevel),
+		webapp.WithMarkdownOptions(markdownOptions),
 		webapp.WithModel(model, s),
-	)
+		webapp.WithRequestTimeout(requestTimeout),
+	}
+	if os.Getenv("DISABLE_AGENT") == "true" {
+		options = append(options, webapp.WithAgentDisabled())
+	}
+	if os.Getenv("NON_ALCOHOLIC_ONLY") == "true" {
+		options = append(options, webapp.WithNonAlcoholicOnly())
+	}
+	if os.Getenv("RESPONSE_ENVELOPE") == "true" {
+		options = append(options, webapp.WithResponseEnvelope())
+	}
+	if chars := os.Getenv("MIN_CONTENT_CHARS"); chars != "" {
+		n, err := strconv.Atoi(chars)
+		if err != nil {
+			log.Fatalf("unable to parse MIN_CONTENT_CHARS: %v", err)
+		}
+		options = append(options, webapp.WithMinContentChars(n))
+	}
+	if prefix := os.Getenv("BASE_PATH"); prefix != "" {
+		options = append(options, webapp.WithBasePath(prefix))
+	}
+	if fallback := os.Getenv("FALLBACK_SUGGESTIONS"); fallback != "" {
+		suggestions := webapp.DefaultFallbackSuggestions
+		if fallback != "default" {
+			if err := json.Unmarshal([]byte(fallback), &suggestions); err != nil {
+				log.Fatalf("unable to parse FALLBACK_SUGGESTIONS: %v", err)
+			}
+		}
+		options = append(options, webapp.WithFallbackSuggestions(suggestions))
+	}
+	if interval := os.Getenv("POPULAR_REFRESH_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			log.Fatalf("unable to parse POPULAR_REFRESH_INTERVAL: %v", err)
+		}
+		topN := 10
+		if n := os.Getenv("POPULAR_REFRESH_TOP_N"); n != "" {
+			if topN, err = strconv.Atoi(n); err != nil {
+				log.Fatalf("unable to parse POPULAR_REFRESH_TOP_N: %v", err)
+			}
+		}
+		options = append(options, webapp.WithPopularRefresh(d, topN))
+	}
+	if rate := os.Getenv("PROMPT_LOG_SAMPLE_RATE"); rate != "" {
+		r, err := strconv.ParseFloat(rate, 64)
+		if err != nil {
+			log.Fatalf("unable to parse PROMPT_LOG_SAMPLE_RATE: %v", err)
+		}
+		var sink webapp.PromptSink = webapp.NewCachePromptSink(cache.NewNamespacedCache(c, namespace))
+		if file := os.Getenv("PROMPT_LOG_FILE"); file != "" {
+			fileSink, err := webapp.NewFilePromptSink(file)
+			if err != nil {
+				log.Fatalf("unable to open PROMPT_LOG_FILE: %v", err)
+			}
+			defer fileSink.Close()
+			sink = fileSink
+		}
+		options = append(options, webapp.WithPromptLogging(sink, r))
+	}
+
+	wa, err := webapp.NewWebapp(serverPort, options...)
 
 	if err != nil {
 		log.Fatalf("unable to build webapp: %v", err)
@@ -21,6 +21,10 @@ type DataLayer struct {
 
 var ErrNotFound = errors.New("item not found")
 
+// ErrQuotaExhausted is returned by DecrementAccountQuota when the account has
+// no quota left to spend.
+var ErrQuotaExhausted = errors.New("account quota exhausted")
+
 type Account struct {
 	ID    string `dynamodbav:"ID"`
 	Email string `dynamodbav:"Email"`
@@ -43,10 +47,11 @@ type RecipePairing struct {
 }
 
 type Suggestion struct {
-	Style       string `dynamodbav:"Style"`
-	Region      string `dynamodbav:"Region"`
-	Description string `dynamodbav:"Description"`
-	PairingNote string `dynamodbav:"PairingNote"`
+	Style       string  `dynamodbav:"Style"`
+	Region      string  `dynamodbav:"Region"`
+	Description string  `dynamodbav:"Description"`
+	PairingNote string  `dynamodbav:"PairingNote"`
+	Confidence  float64 `dynamodbav:"Confidence"`
 }
 
 func Create(ctx context.Context) (*DataLayer, error) {
@@ -131,24 +136,29 @@ func (dl *DataLayer) GetAccountByID(ctx context.Context, id string) (Account, er
 // CreateAccount creates a new user account with a default quota if it doesn't already exist.
 // If the account exists, it should simply return the existing account details.
 func (dl *DataLayer) CreateAccount(ctx context.Context, id string, email string) (Account, error) {
-	fmt.Printf("[CreateAccount] Creating for email=%s, id=%s\n", email, id)
+	return dl.CreateAccountWithQuota(ctx, id, email, defaultQuota)
+}
+
+// CreateAccountWithQuota behaves like CreateAccount, but starts a new account
+// with the given quota instead of the default.
+func (dl *DataLayer) CreateAccountWithQuota(ctx context.Context, id string, email string, quota int) (Account, error) {
+	log.Printf("[CreateAccount] Creating for id=%s\n", id)
 	existingAccount, err := dl.GetAccountByID(ctx, id)
 	if err == nil {
-		fmt.Println("[CreateAccount] Found existing")
+		log.Println("[CreateAccount] Found existing")
 		return existingAccount, nil
 	}
 	if !errors.Is(err, ErrNotFound) {
-		fmt.Println("[CreateAccount] Found error that wasn't NotFound error")
+		log.Println("[CreateAccount] Found error that wasn't NotFound error")
 		return Account{}, fmt.Errorf("error checking for existing account: %w", err)
 	}
 
 	newAccount := Account{
 		ID:    id,
 		Email: email,
-		Quota: defaultQuota,
+		Quota: quota,
 	}
-	fmt.Println("[CreateAccount] Set up new account to save")
-	fmt.Println(newAccount)
+	log.Println("[CreateAccount] Set up new account to save")
 
 	item, err := attributevalue.MarshalMap(newAccount)
 	if err != nil {
@@ -196,7 +206,7 @@ func (dl *DataLayer) DecrementAccountQuota(ctx context.Context, id string) error
 	if err != nil {
 		var ccf *types.ConditionalCheckFailedException
 		if errors.As(err, &ccf) {
-			return fmt.Errorf("cannot decrement quota, it is already at or below zero")
+			return fmt.Errorf("cannot decrement quota, it is already at or below zero: %w", ErrQuotaExhausted)
 		}
 		return fmt.Errorf("failed to decrement account quota: %w", err)
 	}
@@ -204,6 +214,25 @@ func (dl *DataLayer) DecrementAccountQuota(ctx context.Context, id string) error
 	return nil
 }
 
+// DeleteAccount removes the account with the given ID. Deleting an account
+// that doesn't exist is not an error.
+func (dl *DataLayer) DeleteAccount(ctx context.Context, id string) error {
+	key, err := attributevalue.MarshalMap(map[string]string{"ID": id})
+	if err != nil {
+		return fmt.Errorf("failed to marshal key for DeleteAccount: %w", err)
+	}
+
+	_, err = dl.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String("Accounts"),
+		Key:       key,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete account: %w", err)
+	}
+
+	return nil
+}
+
 // ResetAllAccountQuotas scans all accounts and resets their quota to the default value.
 // This is useful for periodic quota refreshes (e.g., weekly).
 func (dl *DataLayer) ResetAllAccountQuotas(ctx context.Context) error {
@@ -335,6 +364,25 @@ func (dl *DataLayer) CreateRecipePairing(ctx context.Context, id string, pairing
 	return pairing, nil
 }
 
+// DeleteRecipePairing removes the pairing with the given ID. Deleting a
+// pairing that doesn't exist is not an error.
+func (dl *DataLayer) DeleteRecipePairing(ctx context.Context, id string) error {
+	key, err := attributevalue.MarshalMap(map[string]string{"ID": id})
+	if err != nil {
+		return fmt.Errorf("failed to marshal key for DeleteRecipePairing: %w", err)
+	}
+
+	_, err = dl.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String("RecipePairings"),
+		Key:       key,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete recipe pairing: %w", err)
+	}
+
+	return nil
+}
+
 // DiagnoseRecipePairings scans the RecipePairings table and logs what it finds.
 // This is a diagnostic function to help debug issues with GetRecentRecipePairingIDs.
 func (dl *DataLayer) DiagnoseRecipePairings(ctx context.Context) error {
@@ -0,0 +1,79 @@
+package data_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/thedahv/wine-pairing-suggestions/data"
+	"github.com/thedahv/wine-pairing-suggestions/data/datatest"
+)
+
+func TestAccountQuota(t *testing.T) {
+	ctx := context.Background()
+	dl, _ := datatest.NewDataLayer(t)
+
+	if _, err := dl.GetAccountByID(ctx, "a"); !errors.Is(err, data.ErrNotFound) {
+		t.Fatalf("GetAccountByID before create = %v, want ErrNotFound", err)
+	}
+	if _, err := dl.CreateAccountWithQuota(ctx, "a", "a@example.com", 2); err != nil {
+		t.Fatalf("CreateAccountWithQuota: %v", err)
+	}
+	// Creating again keeps the existing account
+	if account, err := dl.CreateAccountWithQuota(ctx, "a", "other@example.com", 5); err != nil || account.Email != "a@example.com" || account.Quota != 2 {
+		t.Fatalf("CreateAccountWithQuota on an existing account = %+v, %v", account, err)
+	}
+
+	for range 2 {
+		if err := dl.DecrementAccountQuota(ctx, "a"); err != nil {
+			t.Fatalf("DecrementAccountQuota: %v", err)
+		}
+	}
+	if err := dl.DecrementAccountQuota(ctx, "a"); !errors.Is(err, data.ErrQuotaExhausted) {
+		t.Errorf("DecrementAccountQuota at zero = %v, want ErrQuotaExhausted", err)
+	}
+	if account, err := dl.GetAccountByID(ctx, "a"); err != nil || account.Quota != 0 {
+		t.Errorf("account after spending = %+v, %v; want quota 0", account, err)
+	}
+
+	if err := dl.ResetAllAccountQuotas(ctx); err != nil {
+		t.Fatalf("ResetAllAccountQuotas: %v", err)
+	}
+	if account, err := dl.GetAccountByID(ctx, "a"); err != nil || account.Quota != 10 {
+		t.Errorf("account after reset = %+v, %v; want quota 10", account, err)
+	}
+
+	if err := dl.DeleteAccount(ctx, "a"); err != nil {
+		t.Fatalf("DeleteAccount: %v", err)
+	}
+	if _, err := dl.GetAccountByID(ctx, "a"); !errors.Is(err, data.ErrNotFound) {
+		t.Errorf("GetAccountByID after delete = %v, want ErrNotFound", err)
+	}
+}
+
+func TestRecentRecipePairings(t *testing.T) {
+	ctx := context.Background()
+	dl, _ := datatest.NewDataLayer(t)
+
+	for _, id := range []string{"https://example.com/a", "https://example.com/b"} {
+		if _, err := dl.CreateRecipePairing(ctx, id, data.PairingTypeURL, "summary", []data.Suggestion{{Style: "Pinot Noir"}}); err != nil {
+			t.Fatalf("CreateRecipePairing(%s): %v", id, err)
+		}
+	}
+	if _, err := dl.CreateRecipePairing(ctx, "hash", data.PairingTypeContentHash, "summary", nil); err != nil {
+		t.Fatalf("CreateRecipePairing(hash): %v", err)
+	}
+
+	pairing, err := dl.GetRecipePairing(ctx, "https://example.com/a")
+	if err != nil || len(pairing.Suggestions) != 1 || pairing.Suggestions[0].Style != "Pinot Noir" {
+		t.Fatalf("GetRecipePairing = %+v, %v", pairing, err)
+	}
+
+	ids, err := dl.GetRecentRecipePairingIDs(ctx, data.PairingTypeURL, 10)
+	if err != nil {
+		t.Fatalf("GetRecentRecipePairingIDs: %v", err)
+	}
+	if len(ids) != 2 || ids[0] != "https://example.com/b" {
+		t.Errorf("GetRecentRecipePairingIDs = %v, want both URLs, newest first", ids)
+	}
+}
@@ -0,0 +1,439 @@
+// Package datatest serves an in-memory stand-in for the parts of the DynamoDB
+// API the data layer uses, so code built on data.DataLayer can be tested
+// without DynamoDB Local.
+//
+// Only the expressions data.DataLayer writes are understood: key conditions of
+// the form "a = :v", SET updates of ":v", "a + :v" or "a - :v", and conditions
+// comparing one attribute with one value. Anything else fails with a
+// ValidationException so a test notices. Every table exists and is keyed on ID.
+// Indexes are assumed to be named "<hash>-<range>-index", as the tables' are,
+// and to project only keys.
+package datatest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/thedahv/wine-pairing-suggestions/data"
+)
+
+// targetPrefix precedes the operation name in the X-Amz-Target header.
+const targetPrefix = "DynamoDB_20120810."
+
+// item is a DynamoDB item with its attribute values in their JSON form, e.g.
+// {"S": "abc"} or {"N": "10"}.
+type item map[string]json.RawMessage
+
+// attributeValue is the scalar part of an attribute value's JSON form.
+type attributeValue struct {
+	S *string `json:"S,omitempty"`
+	N *string `json:"N,omitempty"`
+}
+
+// Server is a fake DynamoDB endpoint. Its methods are safe for concurrent use,
+// and each request is applied atomically, so conditional updates race as they
+// would against DynamoDB.
+type Server struct {
+	URL string
+
+	srv     *httptest.Server
+	mu      sync.Mutex
+	tables  map[string]map[string]item
+	failing map[string]bool
+}
+
+// NewServer starts a Server with empty tables. Close it when done.
+func NewServer() *Server {
+	s := &Server{tables: map[string]map[string]item{}, failing: map[string]bool{}}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// NewDataLayer starts a Server for the rest of t and returns a data layer
+// connected to it with fake credentials. It sets environment variables, so it
+// can't be used in parallel tests.
+func NewDataLayer(t testing.TB) (*data.DataLayer, *Server) {
+	t.Helper()
+	s := NewServer()
+	t.Cleanup(s.Close)
+
+	t.Setenv("DYNAMODB_ENDPOINT", s.URL)
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_CONFIG_FILE", "/nonexistent")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/nonexistent")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+
+	dl, err := data.Create(context.Background())
+	if err != nil {
+		t.Fatalf("unable to create data layer: %v", err)
+	}
+	return dl, s
+}
+
+// Fail makes the named operations, e.g. "UpdateItem", fail with a
+// ResourceNotFoundException, which the SDK doesn't retry, until Fail is
+// called again. Calling it with no operations restores them all.
+func (s *Server) Fail(operations ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing = map[string]bool{}
+	for _, op := range operations {
+		s.failing[op] = true
+	}
+}
+
+// Len returns the number of items in table.
+func (s *Server) Len(table string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.tables[table])
+}
+
+// apiError is a DynamoDB error response.
+type apiError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	return e.Type + ": " + e.Message
+}
+
+func validationError(format string, args ...any) *apiError {
+	return &apiError{Type: "com.amazonaws.dynamodb.v20120810#ValidationException", Message: fmt.Sprintf(format, args...)}
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	op, ok := strings.CutPrefix(r.Header.Get("X-Amz-Target"), targetPrefix)
+	if !ok {
+		http.Error(w, "unknown target", http.StatusBadRequest)
+		return
+	}
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeResponse(w, nil, validationError("unable to decode request: %v", err))
+		return
+	}
+
+	s.mu.Lock()
+	var resp any
+	var err *apiError
+	if s.failing[op] {
+		err = &apiError{Type: "com.amazonaws.dynamodb.v20120810#ResourceNotFoundException", Message: "Requested resource not found"}
+	} else {
+		resp, err = s.apply(op, req)
+	}
+	s.mu.Unlock()
+
+	writeResponse(w, resp, err)
+}
+
+// writeResponse writes resp, or err if set, with the CRC32 checksum the SDK
+// validates every response body against.
+func writeResponse(w http.ResponseWriter, resp any, err *apiError) {
+	status := http.StatusOK
+	var body any = resp
+	if err != nil {
+		status, body = http.StatusBadRequest, err
+	} else if resp == nil {
+		body = struct{}{}
+	}
+	out, marshalErr := json.Marshal(body)
+	if marshalErr != nil {
+		http.Error(w, marshalErr.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	w.Header().Set("X-Amz-Crc32", strconv.FormatUint(uint64(crc32.ChecksumIEEE(out)), 10))
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+// request has the fields of every supported operation's input.
+type request struct {
+	TableName                 string
+	IndexName                 string
+	Key                       item
+	Item                      item
+	KeyConditionExpression    string
+	UpdateExpression          string
+	ConditionExpression       string
+	ExpressionAttributeNames  map[string]string
+	ExpressionAttributeValues item
+	Limit                     int
+	ScanIndexForward          *bool
+}
+
+// apply runs op against the tables; s.mu must be held.
+func (s *Server) apply(op string, req request) (any, *apiError) {
+	table := s.tables[req.TableName]
+	if table == nil {
+		table = map[string]item{}
+		s.tables[req.TableName] = table
+	}
+
+	switch op {
+	case "DescribeTable":
+		return map[string]any{"Table": map[string]any{"TableName": req.TableName, "TableStatus": "ACTIVE"}}, nil
+	case "GetItem":
+		id, err := keyID(req.Key)
+		if err != nil {
+			return nil, err
+		}
+		if it, ok := table[id]; ok {
+			return map[string]any{"Item": it}, nil
+		}
+		return nil, nil
+	case "PutItem":
+		id, err := keyID(req.Item)
+		if err != nil {
+			return nil, err
+		}
+		table[id] = req.Item
+		return nil, nil
+	case "DeleteItem":
+		id, err := keyID(req.Key)
+		if err != nil {
+			return nil, err
+		}
+		delete(table, id)
+		return nil, nil
+	case "UpdateItem":
+		return nil, s.update(table, req)
+	case "Query":
+		return s.query(table, req)
+	case "Scan":
+		items := sortedItems(table, "ID", true)
+		if req.Limit > 0 && len(items) > req.Limit {
+			items = items[:req.Limit]
+		}
+		return map[string]any{"Items": items, "Count": len(items)}, nil
+	default:
+		return nil, validationError("unsupported operation %s", op)
+	}
+}
+
+// keyID returns the ID from an item or key.
+func keyID(key item) (string, *apiError) {
+	v, ok := scalar(key["ID"])
+	if !ok || v.S == nil {
+		return "", validationError("missing string key ID")
+	}
+	return *v.S, nil
+}
+
+func scalar(raw json.RawMessage) (attributeValue, bool) {
+	var v attributeValue
+	if raw == nil || json.Unmarshal(raw, &v) != nil || (v.S == nil && v.N == nil) {
+		return attributeValue{}, false
+	}
+	return v, true
+}
+
+var (
+	comparisonRx = regexp.MustCompile(`^\s*([#\w]+)\s*(=|<>|<=|>=|<|>)\s*(:\w+)\s*$`)
+	existsRx     = regexp.MustCompile(`^\s*attribute_(not_)?exists\(\s*([#\w]+)\s*\)\s*$`)
+	assignmentRx = regexp.MustCompile(`^\s*([#\w]+)\s*=\s*(?:([#\w]+)\s*([+-])\s*)?(:\w+)\s*$`)
+)
+
+// name resolves an attribute name placeholder like "#type".
+func name(n string, names map[string]string) string {
+	if strings.HasPrefix(n, "#") {
+		return names[n]
+	}
+	return n
+}
+
+// compare orders two scalars: numbers numerically, strings lexically.
+func compare(a, b attributeValue) (int, bool) {
+	switch {
+	case a.N != nil && b.N != nil:
+		x, errX := strconv.ParseFloat(*a.N, 64)
+		y, errY := strconv.ParseFloat(*b.N, 64)
+		if errX != nil || errY != nil {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	case a.S != nil && b.S != nil:
+		return strings.Compare(*a.S, *b.S), true
+	}
+	return 0, false
+}
+
+// matches evaluates a condition against it, which is nil if the item doesn't
+// exist.
+func matches(cond string, it item, req request) (bool, *apiError) {
+	if m := existsRx.FindStringSubmatch(cond); m != nil {
+		_, exists := it[name(m[2], req.ExpressionAttributeNames)]
+		return exists == (m[1] == ""), nil
+	}
+	m := comparisonRx.FindStringSubmatch(cond)
+	if m == nil {
+		return false, validationError("unsupported expression %q", cond)
+	}
+	want, ok := scalar(req.ExpressionAttributeValues[m[3]])
+	if !ok {
+		return false, validationError("missing value %s", m[3])
+	}
+	got, ok := scalar(it[name(m[1], req.ExpressionAttributeNames)])
+	if !ok {
+		// Comparisons with missing attributes are false
+		return false, nil
+	}
+	c, ok := compare(got, want)
+	if !ok {
+		return false, nil
+	}
+	switch m[2] {
+	case "=":
+		return c == 0, nil
+	case "<>":
+		return c != 0, nil
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	default:
+		return c >= 0, nil
+	}
+}
+
+func (s *Server) update(table map[string]item, req request) *apiError {
+	id, err := keyID(req.Key)
+	if err != nil {
+		return err
+	}
+	existing := table[id]
+	if req.ConditionExpression != "" {
+		ok, err := matches(req.ConditionExpression, existing, req)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return &apiError{Type: "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException", Message: "The conditional request failed"}
+		}
+	}
+
+	set, ok := strings.CutPrefix(strings.TrimSpace(req.UpdateExpression), "SET ")
+	if !ok {
+		return validationError("unsupported update %q", req.UpdateExpression)
+	}
+	updated := item{}
+	for k, v := range existing {
+		updated[k] = v
+	}
+	updated["ID"] = req.Key["ID"]
+	for _, assignment := range strings.Split(set, ",") {
+		m := assignmentRx.FindStringSubmatch(assignment)
+		if m == nil {
+			return validationError("unsupported update %q", assignment)
+		}
+		value, ok := scalar(req.ExpressionAttributeValues[m[4]])
+		if !ok {
+			return validationError("missing value %s", m[4])
+		}
+		if m[2] != "" {
+			operand, ok := scalar(existing[name(m[2], req.ExpressionAttributeNames)])
+			if !ok || operand.N == nil || value.N == nil {
+				return validationError("the provided expression refers to an attribute that does not exist or isn't a number")
+			}
+			x, _ := strconv.ParseFloat(*operand.N, 64)
+			y, _ := strconv.ParseFloat(*value.N, 64)
+			if m[3] == "-" {
+				y = -y
+			}
+			n := strconv.FormatFloat(x+y, 'f', -1, 64)
+			value = attributeValue{N: &n}
+		}
+		out, _ := json.Marshal(value)
+		updated[name(m[1], req.ExpressionAttributeNames)] = out
+	}
+	table[id] = updated
+
+	return nil
+}
+
+func (s *Server) query(table map[string]item, req request) (any, *apiError) {
+	m := comparisonRx.FindStringSubmatch(req.KeyConditionExpression)
+	if m == nil || m[2] != "=" {
+		return nil, validationError("unsupported key condition %q", req.KeyConditionExpression)
+	}
+	hash := name(m[1], req.ExpressionAttributeNames)
+
+	rangeKey := "ID"
+	if req.IndexName != "" {
+		parts := strings.Split(strings.TrimSuffix(req.IndexName, "-index"), "-")
+		if len(parts) != 2 || parts[0] != hash {
+			return nil, validationError("unsupported index %q", req.IndexName)
+		}
+		rangeKey = parts[1]
+	}
+
+	forward := req.ScanIndexForward == nil || *req.ScanIndexForward
+	var items []item
+	for _, it := range sortedItems(table, rangeKey, forward) {
+		ok, err := matches(req.KeyConditionExpression, it, req)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if req.IndexName != "" {
+			it = item{"ID": it["ID"], hash: it[hash], rangeKey: it[rangeKey]}
+		}
+		items = append(items, it)
+		if req.Limit > 0 && len(items) == req.Limit {
+			break
+		}
+	}
+
+	return map[string]any{"Items": items, "Count": len(items)}, nil
+}
+
+// sortedItems returns the items of table ordered by the scalar attribute by.
+func sortedItems(table map[string]item, by string, forward bool) []item {
+	items := make([]item, 0, len(table))
+	for _, it := range table {
+		items = append(items, it)
+	}
+	slices.SortFunc(items, func(a, b item) int {
+		x, _ := scalar(a[by])
+		y, _ := scalar(b[by])
+		c, _ := compare(x, y)
+		if !forward {
+			c = -c
+		}
+		return c
+	})
+	return items
+}
@@ -1,61 +1,967 @@
 package helpers
 
 import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
 	"crypto/rsa"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"html"
 	"io"
 	"log"
 	"math/big"
+	"mime"
 	"net/http"
 	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	md "github.com/JohannesKaufmann/html-to-markdown"
+	"github.com/PuerkitoBio/goquery"
 	"github.com/golang-jwt/jwt/v5"
 )
 
 const googleCertsURL = "https://www.googleapis.com/oauth2/v3/certs"
 
 // FetchRawFromURL fetches raw HTML encoding recipe content from the given URL.
-func FetchRawFromURL(u string) (io.ReadCloser, error) {
-	httpClient := &http.Client{Timeout: 15 * time.Second}
-	req, err := http.NewRequest("GET", u, nil)
+// Redirects are followed, and the URL the content was finally served from is
+// returned so callers can cache it under its canonical address.
+func FetchRawFromURL(u string) (io.ReadCloser, string, error) {
+	return FetchRawFromURLContext(context.Background(), u)
+}
+
+// FetchClientConfig tunes the HTTP client recipes and feeds are fetched with.
+// Zero fields take the defaults noted on each.
+type FetchClientConfig struct {
+	// Timeout bounds a whole fetch, including reading the body. Default 15s.
+	Timeout time.Duration
+	// MaxIdleConns caps the keep-alive connections kept across all sites.
+	// Default 100.
+	MaxIdleConns int
+	// MaxIdleConnsPerHost caps the keep-alive connections kept per site.
+	// Default 10.
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost caps concurrent fetches from one site; further fetches
+	// wait for a connection. Default 0, no limit.
+	MaxConnsPerHost int
+	// IdleConnTimeout is how long an unused keep-alive connection is kept.
+	// Default 90s.
+	IdleConnTimeout time.Duration
+}
+
+// NewFetchClient returns an HTTP client for fetching recipes, pooling
+// connections as configured by cfg.
+func NewFetchClient(cfg FetchClientConfig) *http.Client {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 15 * time.Second
+	}
+	if cfg.MaxIdleConns == 0 {
+		cfg.MaxIdleConns = 100
+	}
+	if cfg.MaxIdleConnsPerHost == 0 {
+		cfg.MaxIdleConnsPerHost = 10
+	}
+	if cfg.IdleConnTimeout == 0 {
+		cfg.IdleConnTimeout = 90 * time.Second
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = cfg.MaxIdleConns
+	transport.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	transport.MaxConnsPerHost = cfg.MaxConnsPerHost
+	transport.IdleConnTimeout = cfg.IdleConnTimeout
+
+	return &http.Client{Timeout: cfg.Timeout, Transport: transport}
+}
+
+// fetchClient is shared by every fetch that doesn't bring its own client, so
+// connections to a recipe site are reused.
+var fetchClient atomic.Pointer[http.Client]
+
+func init() {
+	fetchClient.Store(NewFetchClient(FetchClientConfig{}))
+}
+
+// FetchClient returns the client fetches use by default.
+func FetchClient() *http.Client {
+	return fetchClient.Load()
+}
+
+// SetFetchClient replaces the client fetches use by default, e.g. with one
+// from NewFetchClient configured at startup.
+func SetFetchClient(c *http.Client) {
+	fetchClient.Store(c)
+}
+
+// ErrUnsupportedContentType is returned by fetches of content, such as PDFs or
+// images, that isn't HTML and has no registered extractor.
+var ErrUnsupportedContentType = errors.New("unsupported content type")
+
+// AllowedContentTypes lists the media types fetched content is accepted as
+// without an extractor. Responses without a Content-Type are accepted too.
+// Set it at startup, e.g. from FETCH_ALLOWED_CONTENT_TYPES.
+var AllowedContentTypes = []string{"text/html", "application/xhtml+xml"}
+
+// FeedContentTypes lists the media types FetchFromFeed accepts feeds as.
+// AllowedContentTypes and the content extractors don't apply to feeds.
+var FeedContentTypes = []string{"application/rss+xml", "application/atom+xml", "application/xml", "text/xml"}
+
+// ContentExtractor turns fetched content of some other media type into HTML
+// the markdown converter understands.
+type ContentExtractor func(content []byte) (string, error)
+
+var (
+	extractorsMu sync.RWMutex
+	extractors   = map[string]ContentExtractor{
+		"text/plain": extractPlainText,
+	}
+)
+
+// RegisterContentExtractor lets fetches accept mediaType, e.g.
+// "application/pdf", converting the content with extract. Plain text is
+// handled out of the box.
+func RegisterContentExtractor(mediaType string, extract ContentExtractor) {
+	extractorsMu.Lock()
+	defer extractorsMu.Unlock()
+	extractors[strings.ToLower(mediaType)] = extract
+}
+
+// contentExtractor returns the extractor registered for mediaType, if any.
+func contentExtractor(mediaType string) (ContentExtractor, bool) {
+	extractorsMu.RLock()
+	defer extractorsMu.RUnlock()
+	extract, ok := extractors[mediaType]
+	return extract, ok
+}
+
+// paragraphBreakRx matches the blank lines between plain text paragraphs.
+var paragraphBreakRx = regexp.MustCompile(`\n\s*\n`)
+
+// extractPlainText wraps each blank-line separated paragraph of plain text in
+// <p>, keeping line breaks within it.
+func extractPlainText(content []byte) (string, error) {
+	var sb strings.Builder
+	for _, para := range paragraphBreakRx.Split(strings.ReplaceAll(string(content), "\r\n", "\n"), -1) {
+		if para = strings.TrimSpace(para); para != "" {
+			sb.WriteString("<p>" + strings.ReplaceAll(html.EscapeString(para), "\n", "<br>") + "</p>\n")
+		}
+	}
+	return sb.String(), nil
+}
+
+// FetchRawFromURLContext works like FetchRawFromURL, and gives up when ctx is
+// done.
+func FetchRawFromURLContext(ctx context.Context, u string) (io.ReadCloser, string, error) {
+	return FetchRawFromURLWithClient(ctx, nil, u)
+}
+
+// FetchRawFromURLWithClient works like FetchRawFromURLContext, using
+// httpClient instead of the shared FetchClient. A nil httpClient uses
+// FetchClient. Content that isn't one of AllowedContentTypes is converted by
+// its registered ContentExtractor, or rejected with ErrUnsupportedContentType.
+func FetchRawFromURLWithClient(ctx context.Context, httpClient *http.Client, u string) (io.ReadCloser, string, error) {
+	return fetchRaw(ctx, httpClient, u, AllowedContentTypes, true)
+}
+
+// fetchRaw fetches u, accepting content that is one of allowed or, if
+// convert is set, has a registered ContentExtractor.
+func fetchRaw(ctx context.Context, httpClient *http.Client, u string, allowed []string, convert bool) (io.ReadCloser, string, error) {
+	if httpClient == nil {
+		httpClient = FetchClient()
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, "", fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; RecipeFetcher/1.0)")
+	// Setting Accept-Encoding ourselves turns off the transport's transparent
+	// gzip handling, so decodeBody takes care of both encodings.
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		fmt.Printf("error fetching raw for url (%s): %v\n", u, err)
-		return nil, fmt.Errorf("failed to fetch URL: %w", err)
+		log.Printf("error fetching raw for url (%s): %v\n", u, err)
+		return nil, "", fmt.Errorf("failed to fetch URL: %w", err)
 	}
 
 	if !(resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusBadGateway) {
-		return nil, fmt.Errorf("failed to fetch URL: received status code %d", resp.StatusCode)
+		resp.Body.Close()
+		return nil, "", fmt.Errorf("failed to fetch URL: received status code %d", resp.StatusCode)
+	}
+
+	var extract ContentExtractor
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			mediaType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+		}
+		if !slices.Contains(allowed, mediaType) {
+			var ok bool
+			if extract, ok = contentExtractor(mediaType); !ok || !convert {
+				resp.Body.Close()
+				return nil, "", fmt.Errorf("%w: %s", ErrUnsupportedContentType, mediaType)
+			}
+		}
+	}
+
+	body, err := decodeBody(resp)
+	if err != nil {
+		resp.Body.Close()
+		return nil, "", fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil && params["charset"] != "" {
+		defer body.Close()
+		contents, err := io.ReadAll(body)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read response: %w", err)
+		}
+		body = io.NopCloser(strings.NewReader(ToUTF8(string(contents), params["charset"])))
+	}
+
+	if extract != nil {
+		defer body.Close()
+		contents, err := io.ReadAll(body)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read response: %w", err)
+		}
+		extracted, err := extract(contents)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to extract content: %w", err)
+		}
+		body = io.NopCloser(strings.NewReader(extracted))
+	}
+
+	return body, resp.Request.URL.String(), nil
+}
+
+// decodedBody closes both the decompressing reader and the response body.
+type decodedBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (d *decodedBody) Close() error {
+	var err error
+	for _, c := range d.closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// decodeBody wraps the response body to decompress gzip or deflate content.
+// Bodies with no or an unknown Content-Encoding are returned as-is.
+func decodeBody(resp *http.Response) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))) {
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return &decodedBody{Reader: zr, closers: []io.Closer{zr, resp.Body}}, nil
+	case "deflate":
+		// Servers disagree on whether deflate means zlib-wrapped or raw
+		// DEFLATE data, so sniff for the zlib header.
+		br := bufio.NewReader(resp.Body)
+		if header, err := br.Peek(2); err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			zr, err := zlib.NewReader(br)
+			if err != nil {
+				return nil, err
+			}
+			return &decodedBody{Reader: zr, closers: []io.Closer{zr, resp.Body}}, nil
+		}
+		fr := flate.NewReader(br)
+		return &decodedBody{Reader: fr, closers: []io.Closer{fr, resp.Body}}, nil
+	default:
+		return resp.Body, nil
+	}
+}
+
+// feedDocument covers both RSS 2.0 (<rss><channel><item>) and Atom (<feed>
+// <entry>) documents; only the fields FetchFromFeed needs are decoded.
+type feedDocument struct {
+	Items   []feedItem  `xml:"channel>item"`
+	Entries []feedEntry `xml:"entry"`
+}
+
+type feedItem struct {
+	Link    string `xml:"link"`
+	GUID    string `xml:"guid"`
+	Encoded string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+}
+
+type feedEntry struct {
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+	} `xml:"link"`
+	Content struct {
+		Type string `xml:"type,attr"`
+		Body string `xml:",chardata"`
+	} `xml:"content"`
+}
+
+// FeedURLFor guesses the feed for the site serving entryURL. Most food blogs
+// run WordPress, which publishes full-content feeds at /feed/.
+func FeedURLFor(entryURL string) string {
+	u, err := url.Parse(entryURL)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host + "/feed/"
+}
+
+// FetchFromFeed fetches the RSS or Atom feed at feedURL and returns the full
+// HTML content of the entry linking to entryURL: content:encoded for RSS, or
+// an HTML <content> for Atom. It reports false if the feed can't be read, has
+// no such entry, or the entry only carries a summary.
+func FetchFromFeed(feedURL, entryURL string) (string, bool) {
+	rdr, _, err := fetchRaw(context.Background(), nil, feedURL, FeedContentTypes, false)
+	if err != nil {
+		return "", false
+	}
+	defer rdr.Close()
+
+	return ParseFeedContent(rdr, entryURL)
+}
+
+// ParseFeedContent finds the entry linking to entryURL in the RSS or Atom feed
+// read from r and returns its full HTML content; see FetchFromFeed.
+func ParseFeedContent(r io.Reader, entryURL string) (string, bool) {
+	var doc feedDocument
+	dec := xml.NewDecoder(r)
+	// FetchRawFromURL has already transcoded the body where it could
+	dec.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) { return input, nil }
+	if err := dec.Decode(&doc); err != nil {
+		return "", false
+	}
+
+	want := feedLinkKey(entryURL)
+	for _, item := range doc.Items {
+		if feedLinkKey(item.Link) != want && feedLinkKey(item.GUID) != want {
+			continue
+		}
+		if content := strings.TrimSpace(item.Encoded); content != "" {
+			return content, true
+		}
+		return "", false
+	}
+	for _, entry := range doc.Entries {
+		matched := false
+		for _, link := range entry.Links {
+			if (link.Rel == "" || link.Rel == "alternate") && feedLinkKey(link.Href) == want {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		content := strings.TrimSpace(entry.Content.Body)
+		if content == "" || !(entry.Content.Type == "html" || entry.Content.Type == "xhtml") {
+			return "", false
+		}
+		return content, true
 	}
 
-	return resp.Body, nil
+	return "", false
+}
+
+// feedLinkKey normalizes a link for comparison, ignoring the scheme, host
+// case, and trailing slashes, which feeds and shared links often disagree on.
+func feedLinkKey(link string) string {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	key := strings.ToLower(strings.TrimPrefix(u.Host, "www.")) + strings.TrimSuffix(u.Path, "/")
+	if u.RawQuery != "" {
+		key += "?" + u.RawQuery
+	}
+	return key
+}
+
+// ErrInvalidRecipeURL is returned by ValidateRecipeURL for input that isn't
+// an absolute http(s) URL.
+var ErrInvalidRecipeURL = errors.New("invalid recipe URL")
+
+// ValidateRecipeURL checks that raw is an absolute http or https URL with a
+// host, so inputs like "javascript:..." or relative paths never reach the
+// fetcher. It returns the URL with surrounding space trimmed and the scheme and
+// host lowercased.
+func ValidateRecipeURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", fmt.Errorf("%w: URL is empty", ErrInvalidRecipeURL)
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidRecipeURL, err)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("%w: must start with http:// or https://", ErrInvalidRecipeURL)
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("%w: missing host", ErrInvalidRecipeURL)
+	}
+	u.Host = strings.ToLower(u.Host)
+
+	return u.String(), nil
+}
+
+// HostAllowed reports whether the host of rawURL is one of domains or a
+// subdomain of one. An empty domains list allows every host.
+func HostAllowed(rawURL string, domains []string) bool {
+	if len(domains) == 0 {
+		return true
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(parsed.Hostname())
+	if host == "" {
+		return false
+	}
+
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(d), "."))
+		if d == "" {
+			continue
+		}
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// metaCharsetRx matches the charset declared by <meta charset="..."> or
+// <meta http-equiv="Content-Type" content="text/html; charset=...">.
+var metaCharsetRx = regexp.MustCompile(`(?i)<meta[^>]+charset\s*=\s*["']?([\w-]+)`)
+
+// windows1252 maps bytes 0x80-0x9F to the characters Windows-1252 puts there.
+// Every other byte maps to the Unicode code point of the same value.
+var windows1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ',
+}
+
+// ToUTF8 transcodes Latin-1 and Windows-1252 content to UTF-8. The charset
+// comes from the given label (e.g. from a Content-Type header) or, if empty, a
+// <meta> tag in the content. Content that is already valid UTF-8 is returned
+// unchanged whatever it claims to be, since mislabeled pages are common; so is
+// content in a charset we can't decode.
+func ToUTF8(content, charset string) string {
+	if utf8.ValidString(content) {
+		return content
+	}
+
+	if charset == "" {
+		if m := metaCharsetRx.FindStringSubmatch(content); m != nil {
+			charset = m[1]
+		}
+	}
+	switch strings.ToLower(charset) {
+	// Per the HTML spec, Latin-1 labels are decoded as Windows-1252. Pages
+	// that claim UTF-8 (or nothing) but aren't are almost always Windows-1252.
+	case "", "utf-8", "utf8", "iso-8859-1", "iso8859-1", "latin1", "l1", "us-ascii", "ascii", "windows-1252", "cp1252":
+	default:
+		return content
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(content))
+	for i := 0; i < len(content); i++ {
+		b := content[i]
+		if b >= 0x80 && b <= 0x9F {
+			sb.WriteRune(windows1252[b-0x80])
+		} else {
+			sb.WriteRune(rune(b))
+		}
+	}
+
+	return sb.String()
+}
+
+// languageStopwords are common function words for each language DetectLanguage
+// recognizes, keyed by ISO 639-1 code. Words shared across languages are left
+// out so they don't blur the counts.
+var languageStopwords = map[string][]string{
+	"en": {"the", "and", "with", "for", "until", "into", "then", "of", "to", "add", "minutes", "over", "it", "is", "or"},
+	"it": {"il", "di", "che", "con", "per", "gli", "della", "delle", "nel", "aggiungere", "minuti", "fino", "sale", "olio", "poi"},
+	"fr": {"le", "les", "et", "du", "des", "avec", "pour", "dans", "ajouter", "jusqu'à", "au", "une", "puis", "sel", "huile"},
+	"es": {"el", "los", "las", "y", "con", "para", "del", "hasta", "añadir", "minutos", "una", "sal", "aceite", "luego", "en"},
+	"de": {"der", "die", "das", "und", "mit", "für", "bis", "minuten", "ein", "eine", "dann", "salz", "zugeben", "im", "den"},
+	"pt": {"o", "os", "com", "para", "do", "da", "até", "adicionar", "minutos", "uma", "sal", "azeite", "depois", "em", "no"},
+}
+
+// LanguageNames maps the codes DetectLanguage returns to English names.
+var LanguageNames = map[string]string{
+	"en": "English",
+	"it": "Italian",
+	"fr": "French",
+	"es": "Spanish",
+	"de": "German",
+	"pt": "Portuguese",
+}
+
+// minLanguageHits is how many stopwords the best language must match before
+// DetectLanguage trusts it.
+const minLanguageHits = 5
+
+// DetectLanguage guesses the language of text by counting common function
+// words, returning an ISO 639-1 code from LanguageNames. It returns "" if the
+// text is too short or too mixed to tell. Ties go to English.
+func DetectLanguage(text string) string {
+	lookup := make(map[string][]string)
+	for lang, words := range languageStopwords {
+		for _, w := range words {
+			lookup[w] = append(lookup[w], lang)
+		}
+	}
+
+	counts := make(map[string]int)
+	for _, field := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	}) {
+		for _, lang := range lookup[strings.Trim(field, "'")] {
+			counts[lang]++
+		}
+	}
+
+	best, bestCount := "", 0
+	for _, lang := range []string{"en", "it", "fr", "es", "de", "pt"} {
+		if counts[lang] > bestCount {
+			best, bestCount = lang, counts[lang]
+		}
+	}
+	if bestCount < minLanguageHits {
+		return ""
+	}
+
+	return best
+}
+
+// boilerplateSelectors match page chrome that never carries recipe content.
+const boilerplateSelectors = "script, style, noscript, iframe, svg, form, nav, header, footer, aside, [role=navigation], [role=banner], [role=contentinfo], [role=complementary]"
+
+// boilerplateRx matches class and id names commonly used for ads, comments,
+// and other non-content blocks.
+var boilerplateRx = regexp.MustCompile(`(?i)\b(ad|ads|advert\w*|banner|comment\w*|sidebar|share|social|newsletter|subscribe|promo\w*|related|popup|modal|cookie\w*|breadcrumbs?|menu)\b`)
+
+// mainContentSelectors are tried in order to locate the primary content of the
+// page once boilerplate is removed.
+var mainContentSelectors = []string{
+	`[itemtype*="schema.org/Recipe"]`,
+	"article",
+	"main",
+	"[role=main]",
+	"#content",
+	".content",
+}
+
+// minMainContentLength is the amount of text the extracted content must keep
+// before we trust it over the whole page.
+const minMainContentLength = 200
+
+// ExtractMainContent isolates the primary article in an HTML document,
+// dropping navigation, ads, comments, and similar boilerplate. If no
+// convincing main content is found, it returns the original HTML unchanged.
+func ExtractMainContent(content string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		return content
+	}
+
+	doc.Find(boilerplateSelectors).Remove()
+	doc.Find("[class], [id]").Each(func(_ int, s *goquery.Selection) {
+		// Containers like <body class="has-sidebar"> hold the content we want
+		if s.Is("html, body, main, article") || s.Find("main, article").Length() > 0 {
+			return
+		}
+		class, _ := s.Attr("class")
+		id, _ := s.Attr("id")
+		if boilerplateRx.MatchString(class) || boilerplateRx.MatchString(id) {
+			s.Remove()
+		}
+	})
+
+	main := doc.Find("body")
+	for _, selector := range mainContentSelectors {
+		if found := doc.Find(selector).First(); found.Length() > 0 {
+			main = found
+			break
+		}
+	}
+
+	if len(strings.TrimSpace(main.Text())) < minMainContentLength {
+		return content
+	}
+
+	extracted, err := goquery.OuterHtml(main)
+	if err != nil {
+		return content
+	}
+
+	return extracted
+}
+
+// RecipeMetadata is what a page's schema.org Recipe JSON-LD says about
+// quantities. Zero fields weren't found.
+type RecipeMetadata struct {
+	Servings         int
+	TotalTimeMinutes int
+}
+
+// isoDurationRx matches the ISO 8601 durations recipes use for times, e.g.
+// "PT1H30M" or "P0DT45M".
+var isoDurationRx = regexp.MustCompile(`(?i)^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:\d+(?:\.\d+)?S)?)?$`)
+
+// leadingNumberRx finds the first whole number in a yield like "Serves 4-6".
+var leadingNumberRx = regexp.MustCompile(`\d+`)
+
+// RecipeMetadataFromHTML reads servings and total time from the first
+// schema.org Recipe in the page's JSON-LD, including ones nested in an
+// "@graph". It reports whether a Recipe was found. Total time falls back to
+// prep plus cook time.
+func RecipeMetadataFromHTML(content string) (RecipeMetadata, bool) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		return RecipeMetadata{}, false
+	}
+
+	var meta RecipeMetadata
+	found := false
+	doc.Find(`script[type="application/ld+json"]`).EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		var data any
+		if err := json.Unmarshal([]byte(s.Text()), &data); err != nil {
+			return true
+		}
+		recipe := findJSONLDRecipe(data)
+		if recipe == nil {
+			return true
+		}
+		found = true
+		meta.Servings = parseRecipeYield(recipe["recipeYield"])
+		if meta.TotalTimeMinutes = parseISODuration(recipe["totalTime"]); meta.TotalTimeMinutes == 0 {
+			meta.TotalTimeMinutes = parseISODuration(recipe["prepTime"]) + parseISODuration(recipe["cookTime"])
+		}
+		return false
+	})
+
+	return meta, found
+}
+
+// findJSONLDRecipe returns the first object typed Recipe in decoded JSON-LD.
+func findJSONLDRecipe(data any) map[string]any {
+	switch v := data.(type) {
+	case []any:
+		for _, item := range v {
+			if recipe := findJSONLDRecipe(item); recipe != nil {
+				return recipe
+			}
+		}
+	case map[string]any:
+		switch t := v["@type"].(type) {
+		case string:
+			if t == "Recipe" {
+				return v
+			}
+		case []any:
+			if slices.Contains(t, any("Recipe")) {
+				return v
+			}
+		}
+		if graph, ok := v["@graph"]; ok {
+			return findJSONLDRecipe(graph)
+		}
+	}
+	return nil
+}
+
+// parseRecipeYield reads servings from a recipeYield, which sites give as a
+// number, a string like "4 servings", or a list of either.
+func parseRecipeYield(yield any) int {
+	switch v := yield.(type) {
+	case float64:
+		return int(v)
+	case string:
+		if n, err := strconv.Atoi(leadingNumberRx.FindString(v)); err == nil {
+			return n
+		}
+	case []any:
+		for _, item := range v {
+			if n := parseRecipeYield(item); n > 0 {
+				return n
+			}
+		}
+	}
+	return 0
+}
+
+// parseISODuration returns an ISO 8601 duration in whole minutes, or 0 if it
+// isn't one.
+func parseISODuration(duration any) int {
+	s, _ := duration.(string)
+	m := isoDurationRx.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0
+	}
+	minutes := 0
+	for i, unit := range []int{24 * 60, 60, 1} {
+		if n, err := strconv.Atoi(m[i+1]); err == nil {
+			minutes += n * unit
+		}
+	}
+	return minutes
+}
+
+// MarkdownOptions controls how recipe HTML is converted to markdown. Scripts
+// and styles are always dropped.
+type MarkdownOptions struct {
+	// StripImages drops images instead of writing them as markdown images.
+	StripImages bool
+	// KeepLinks writes links as markdown links. When false, only the link
+	// text is kept.
+	KeepLinks bool
+	// RemoveSelectors lists CSS selectors, e.g. ".comments" or "#newsletter",
+	// whose elements are dropped before converting.
+	RemoveSelectors []string
+	// MaxChars bounds the converted markdown; longer output is cut down to
+	// its most recipe-like portion (see TruncateMarkdown). Zero means no limit.
+	MaxChars int
+}
+
+// DefaultMarkdownOptions keeps images and links and removes nothing else.
+func DefaultMarkdownOptions() MarkdownOptions {
+	return MarkdownOptions{KeepLinks: true}
+}
+
+// MarkdownOptionsFromEnv returns DefaultMarkdownOptions adjusted by
+// MARKDOWN_STRIP_IMAGES and MARKDOWN_DROP_LINKS ("true" to enable),
+// MARKDOWN_REMOVE_SELECTORS (a comma-separated list), and MARKDOWN_MAX_CHARS.
+func MarkdownOptionsFromEnv() MarkdownOptions {
+	opts := DefaultMarkdownOptions()
+	opts.StripImages = os.Getenv("MARKDOWN_STRIP_IMAGES") == "true"
+	opts.KeepLinks = os.Getenv("MARKDOWN_DROP_LINKS") != "true"
+	for _, sel := range strings.Split(os.Getenv("MARKDOWN_REMOVE_SELECTORS"), ",") {
+		if sel = strings.TrimSpace(sel); sel != "" {
+			opts.RemoveSelectors = append(opts.RemoveSelectors, sel)
+		}
+	}
+	if v := os.Getenv("MARKDOWN_MAX_CHARS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			opts.MaxChars = n
+		} else {
+			log.Printf("ignoring invalid MARKDOWN_MAX_CHARS %q\n", v)
+		}
+	}
+
+	return opts
 }
 
 // CreateMarkdownFromRaw converts HTML-encoded recipe content and returns it in
-// markdown format. Helpful when passing web content to an LLM.
+// markdown format, using DefaultMarkdownOptions. Helpful when passing web
+// content to an LLM.
 func CreateMarkdownFromRaw(domainURL, content string) (string, error) {
+	return CreateMarkdownFromRawWithOptions(domainURL, content, DefaultMarkdownOptions())
+}
+
+// CreateMarkdownFromRawWithOptions works like CreateMarkdownFromRaw, converting
+// as opts says. Content that isn't valid UTF-8 is transcoded first (see
+// ToUTF8), then the main content is extracted unless
+// DISABLE_CONTENT_EXTRACTION is "true". Output over opts.MaxChars is truncated
+// with TruncateMarkdown.
+func CreateMarkdownFromRawWithOptions(domainURL, content string, opts MarkdownOptions) (string, error) {
 	u, err := url.Parse(domainURL)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse domain URL: %w", err)
 	}
+	content = ToUTF8(content, "")
+	if os.Getenv("DISABLE_CONTENT_EXTRACTION") != "true" {
+		content = ExtractMainContent(content)
+	}
 	converter := md.NewConverter(u.Scheme+"://"+u.Hostname(), true, nil)
+	removed := opts.RemoveSelectors
+	if opts.StripImages {
+		removed = append(removed[:len(removed):len(removed)], "img", "picture")
+	}
+	if len(removed) > 0 {
+		converter.Before(func(selec *goquery.Selection) {
+			for _, sel := range removed {
+				selec.Find(sel).Remove()
+			}
+		})
+	}
+	if !opts.KeepLinks {
+		converter.AddRules(md.Rule{
+			Filter: []string{"a"},
+			Replacement: func(content string, _ *goquery.Selection, _ *md.Options) *string {
+				return md.String(content)
+			},
+		})
+	}
 	markdown, err := converter.ConvertString(content)
 	if err != nil {
 		return "", fmt.Errorf("failed to convert HTML to markdown: %w", err)
 	}
+	if truncated, ok := TruncateMarkdown(markdown, opts.MaxChars); ok {
+		log.Printf("truncated markdown for %s from %d to %d characters\n", domainURL, len(markdown), len(truncated))
+		markdown = truncated
+	}
 
 	return markdown, nil
 }
 
+// recipeKeywordRx matches words that mark the parts of a page a summary needs:
+// section headings, measures, and cooking verbs.
+var recipeKeywordRx = regexp.MustCompile(`(?i)\b(ingredients?|instructions?|directions?|method|steps?|serves|servings|yield|prep|cook|bake|roast|simmer|boil|saut[eé]|grill|fry|whisk|stir|season|cups?|tbsp|tsp|tablespoons?|teaspoons?|grams?|g|kg|ml|oz|ounces?|pounds?|lbs?|minutes?|hours?)\b`)
+
+// TruncateMarkdown cuts markdown down to at most maxChars bytes, keeping the
+// run of consecutive blocks (paragraphs, lists, headings) with the most recipe
+// keywords so the ingredients and instructions survive long stories and
+// comment threads. It reports whether anything was cut; maxChars <= 0 or
+// markdown already within budget leaves it unchanged.
+func TruncateMarkdown(markdown string, maxChars int) (string, bool) {
+	if maxChars <= 0 || len(markdown) <= maxChars {
+		return markdown, false
+	}
+
+	blocks := strings.Split(markdown, "\n\n")
+	scores := make([]int, len(blocks))
+	for i, b := range blocks {
+		scores[i] = len(recipeKeywordRx.FindAllStringIndex(b, -1))
+	}
+
+	// Slide a window of whole blocks across the page, keeping the best
+	// scoring one that fits; ties go to the earliest window.
+	bestStart, bestEnd, bestScore := 0, 0, -1
+	start, size, score := 0, 0, 0
+	for end, b := range blocks {
+		size += len(b)
+		if end > start {
+			size += 2
+		}
+		score += scores[end]
+		for size > maxChars && start < end {
+			size -= len(blocks[start]) + 2
+			score -= scores[start]
+			start++
+		}
+		if size <= maxChars && score > bestScore {
+			bestStart, bestEnd, bestScore = start, end+1, score
+		}
+	}
+
+	if bestScore < 0 {
+		// Every block is over budget on its own, so cut the best one.
+		best := 0
+		for i := range blocks {
+			if scores[i] > scores[best] {
+				best = i
+			}
+		}
+		return truncateUTF8(blocks[best], maxChars), true
+	}
+
+	return strings.Join(blocks[bestStart:bestEnd], "\n\n"), true
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a rune.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+
+	return s[:n]
+}
+
+// Memo keeps the result of an expensive fetch, such as a network call, until
+// it expires, so repeated calls and warm Lambda invocations skip the fetch.
+// Failed fetches aren't kept. The zero value is ready to use and safe for
+// concurrent use.
+type Memo[T any] struct {
+	mu      sync.Mutex
+	value   T
+	expires time.Time
+}
+
+// Get returns the kept value if it hasn't expired, and otherwise calls fetch
+// and keeps its result for as long as fetch says. Concurrent callers wait for
+// a single fetch.
+func (m *Memo[T]) Get(fetch func() (T, time.Duration, error)) (T, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if time.Now().Before(m.expires) {
+		return m.value, nil
+	}
+
+	value, ttl, err := fetch()
+	if err != nil {
+		return value, err
+	}
+	m.value, m.expires = value, time.Now().Add(ttl)
+	return value, nil
+}
+
+// Reset forgets the kept value, so the next Get fetches.
+func (m *Memo[T]) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var zero T
+	m.value, m.expires = zero, time.Time{}
+}
+
+// CredentialsTTL is how long fetched credentials, such as Google's signing
+// certs, are reused before being fetched again. Google's own Cache-Control
+// max-age is honored when it is shorter.
+var CredentialsTTL = time.Hour
+
+// googleKeys keeps the parsed Google certs between calls to GetGoogleJWTToken.
+var googleKeys Memo[googleKeysResponse]
+
+// maxAgeRx finds the max-age directive of a Cache-Control header.
+var maxAgeRx = regexp.MustCompile(`(?i)\bmax-age=(\d+)`)
+
+// cacheMaxAge returns the max-age of a Cache-Control header, if it has one.
+func cacheMaxAge(header string) (time.Duration, bool) {
+	m := maxAgeRx.FindStringSubmatch(header)
+	if m == nil {
+		return 0, false
+	}
+	seconds, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
 type googleKeysResponse struct {
 	Keys []struct {
 		Algorithm string `json:"alg"`
@@ -73,30 +979,19 @@ type Claims struct {
 	jwt.RegisteredClaims
 }
 
+// GetGoogleJWTToken returns Google's public key for verifying sign-in tokens
+// signed with algorithm. Google's certs are fetched at most once per
+// CredentialsTTL.
 func GetGoogleJWTToken(algorithm string) (*rsa.PublicKey, error) {
 	l := log.New(log.Default().Writer(), "GetGoogleJWTToken", log.Default().Flags())
 	l.Println("GetGoogleJWTToken")
 	key := &rsa.PublicKey{}
 
-	c := http.Client{}
-	resp, err := c.Get(googleCertsURL)
-	if err != nil {
-		log.Printf("Google fetch failed: %v\n", err)
-		return key, fmt.Errorf("unable to fetch from Google: %v", err)
-	}
-	defer resp.Body.Close()
-
-	contents, err := io.ReadAll(resp.Body)
-
+	response, err := googleKeys.Get(func() (googleKeysResponse, time.Duration, error) {
+		return fetchGoogleKeys(l)
+	})
 	if err != nil {
-		l.Printf("JWT read failed: %v\n", err)
-		return key, fmt.Errorf("unable to read response body: %v", err)
-	}
-
-	var response googleKeysResponse
-	if err := json.Unmarshal(contents, &response); err != nil {
-		l.Printf("failed parse: %v\n", err)
-		return key, fmt.Errorf("unable to parse response JSON: %v", err)
+		return key, err
 	}
 
 	for _, k := range response.Keys {
@@ -120,6 +1015,38 @@ func GetGoogleJWTToken(algorithm string) (*rsa.PublicKey, error) {
 	return key, fmt.Errorf("algorithm '%s' was not in certificates response", algorithm)
 }
 
+// fetchGoogleKeys downloads Google's current signing certs, returning how long
+// they may be reused.
+func fetchGoogleKeys(l *log.Logger) (googleKeysResponse, time.Duration, error) {
+	var response googleKeysResponse
+
+	l.Println("Fetching Google certs")
+	c := http.Client{}
+	resp, err := c.Get(googleCertsURL)
+	if err != nil {
+		l.Printf("Google fetch failed: %v\n", err)
+		return response, 0, fmt.Errorf("unable to fetch from Google: %v", err)
+	}
+	defer resp.Body.Close()
+
+	contents, err := io.ReadAll(resp.Body)
+	if err != nil {
+		l.Printf("JWT read failed: %v\n", err)
+		return response, 0, fmt.Errorf("unable to read response body: %v", err)
+	}
+
+	if err := json.Unmarshal(contents, &response); err != nil {
+		l.Printf("failed parse: %v\n", err)
+		return response, 0, fmt.Errorf("unable to parse response JSON: %v", err)
+	}
+
+	ttl := CredentialsTTL
+	if maxAge, ok := cacheMaxAge(resp.Header.Get("Cache-Control")); ok && maxAge < ttl {
+		ttl = maxAge
+	}
+	return response, ttl, nil
+}
+
 // ServerErrror models server error responses
 type ServerError struct {
 	Message string `json:"message"`
@@ -127,8 +1054,8 @@ type ServerError struct {
 
 // SendJSONError sends the error message as a JSON-encoded server error response
 func SendJSONError(w http.ResponseWriter, err error, status int) {
-	w.WriteHeader(status)
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	out, _ := json.Marshal(ServerError{
 		Message: err.Error(),
 	})
@@ -141,3 +1068,14 @@ func HashContent(content string) string {
 	io.WriteString(h, content)
 	return string(h.Sum(nil))
 }
+
+// HashRecipeContent returns a hex SHA-256 hash of recipe text that ignores
+// case, punctuation, markdown syntax, and whitespace, so a recipe pasted as
+// text hashes the same as the markdown converted from its web page.
+func HashRecipeContent(content string) string {
+	words := strings.FieldsFunc(strings.ToLower(content), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	sum := sha256.Sum256([]byte(strings.Join(words, " ")))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,57 @@
+package helpers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:content="http://purl.org/rss/1.0/modules/content/">
+<channel>
+<item>
+<link>https://example.com/braised-ribs</link>
+<content:encoded><![CDATA[<h1>Braised Ribs</h1><p>Brown the ribs.</p>]]></content:encoded>
+</item>
+</channel>
+</rss>`
+
+func TestFetchFromFeedContentTypes(t *testing.T) {
+	for _, contentType := range []string{"application/rss+xml", "application/atom+xml; charset=utf-8", "text/xml"} {
+		t.Run(contentType, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", contentType)
+				w.Write([]byte(testFeed))
+			}))
+			defer srv.Close()
+
+			content, ok := FetchFromFeed(srv.URL+"/feed/", "https://example.com/braised-ribs")
+			if !ok {
+				t.Fatalf("FetchFromFeed found no entry")
+			}
+			if !strings.Contains(content, "Brown the ribs.") {
+				t.Errorf("FetchFromFeed = %q, want the entry's content", content)
+			}
+
+			// Recipes are still held to AllowedContentTypes
+			if _, _, err := FetchRawFromURL(srv.URL + "/feed/"); !errors.Is(err, ErrUnsupportedContentType) {
+				t.Errorf("FetchRawFromURL error = %v, want ErrUnsupportedContentType", err)
+			}
+		})
+	}
+}
+
+func TestFetchFromFeedRejectsOtherContentTypes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Plain text has an extractor for recipes, but isn't a feed
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(testFeed))
+	}))
+	defer srv.Close()
+
+	if _, ok := FetchFromFeed(srv.URL+"/feed/", "https://example.com/braised-ribs"); ok {
+		t.Errorf("FetchFromFeed accepted a text/plain feed")
+	}
+}
@@ -10,13 +10,17 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 
 	"github.com/thedahv/wine-pairing-suggestions/cache"
 	"github.com/thedahv/wine-pairing-suggestions/data"
+	wphelpers "github.com/thedahv/wine-pairing-suggestions/helpers"
 	helpers "github.com/thedahv/wine-pairing-suggestions/lambdahelpers"
 	"github.com/thedahv/wine-pairing-suggestions/mcp"
 	"github.com/thedahv/wine-pairing-suggestions/models"
@@ -26,6 +30,13 @@ import (
 // Handler represents the Lambda handler with shared resources
 type Handler struct {
 	webapp *webapp.Webapp
+	// routes is the webapp's route table, compiled for Lambda paths
+	routes []route
+}
+
+// newHandler returns a Handler serving wa's routes.
+func newHandler(wa *webapp.Webapp) *Handler {
+	return &Handler{webapp: wa, routes: compileRoutes(wa.Routes())}
 }
 
 // NewHandler creates a new Lambda handler with initialized dependencies
@@ -33,10 +44,26 @@ func NewHandler() (*Handler, error) {
 	ctx := context.Background()
 
 	// Initialize model
-	model, err := models.MakeClaude(ctx)
+	var modelOptions []models.Option
+	if n, err := strconv.Atoi(os.Getenv("MODEL_MAX_TOKENS")); err == nil && n > 0 {
+		modelOptions = append(modelOptions, models.WithMaxTokens(n))
+	}
+	if ttl := os.Getenv("CREDENTIALS_CACHE_TTL"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse CREDENTIALS_CACHE_TTL: %v", err)
+		}
+		wphelpers.CredentialsTTL = d
+	}
+	makeModel := models.MakeClaude
+	if os.Getenv("BEDROCK_FALLBACK") == "true" {
+		makeModel = models.MakeClaudeWithBedrockFallback
+	}
+	model, err := makeModel(ctx, modelOptions...)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create model: %v", err)
 	}
+	model = models.NewRetryModel(model)
 
 	// Prepare webapp options
 	var options []webapp.Option
@@ -59,7 +86,9 @@ func NewHandler() (*Handler, error) {
 		log.Println("using memory cache")
 		c = cache.NewMemory()
 	}
-	options = append(options, webapp.WithCache(c))
+	c = cache.NewInstrumentedCache(c)
+	namespace := os.Getenv("CACHE_NAMESPACE")
+	options = append(options, webapp.WithCache(c), webapp.WithCacheNamespace(namespace))
 
 	// Add other options
 	if clientID := os.Getenv("GOOGLE_CLIENT_ID"); clientID != "" {
@@ -68,8 +97,89 @@ func NewHandler() (*Handler, error) {
 	if hostname := os.Getenv("HOSTNAME"); hostname != "" {
 		options = append(options, webapp.WithHostname(hostname))
 	}
+	if keys := os.Getenv("API_KEYS"); keys != "" {
+		var apiKeys map[string]webapp.ApiKeyConfig
+		if err := json.Unmarshal([]byte(keys), &apiKeys); err != nil {
+			return nil, fmt.Errorf("unable to parse API_KEYS: %v", err)
+		}
+		options = append(options, webapp.WithApiKeys(apiKeys))
+	}
+
+	logLevel, err := webapp.ParseLogLevel(os.Getenv("LOG_LEVEL"))
+	if err != nil {
+		return nil, err
+	}
+	options = append(options, webapp.WithLogLevel(logLevel))
 
-	options = append(options, webapp.WithModel(model, mcp.MakeServer(c)))
+	if timeout := os.Getenv("REQUEST_TIMEOUT"); timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse REQUEST_TIMEOUT: %v", err)
+		}
+		options = append(options, webapp.WithRequestTimeout(d))
+	}
+	if chars := os.Getenv("MIN_CONTENT_CHARS"); chars != "" {
+		n, err := strconv.Atoi(chars)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse MIN_CONTENT_CHARS: %v", err)
+		}
+		options = append(options, webapp.WithMinContentChars(n))
+	}
+	if prefix := os.Getenv("BASE_PATH"); prefix != "" {
+		options = append(options, webapp.WithBasePath(prefix))
+	}
+	if fallback := os.Getenv("FALLBACK_SUGGESTIONS"); fallback != "" {
+		suggestions := webapp.DefaultFallbackSuggestions
+		if fallback != "default" {
+			if err := json.Unmarshal([]byte(fallback), &suggestions); err != nil {
+				return nil, fmt.Errorf("unable to parse FALLBACK_SUGGESTIONS: %v", err)
+			}
+		}
+		options = append(options, webapp.WithFallbackSuggestions(suggestions))
+	}
+	if rate := os.Getenv("PROMPT_LOG_SAMPLE_RATE"); rate != "" {
+		r, err := strconv.ParseFloat(rate, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse PROMPT_LOG_SAMPLE_RATE: %v", err)
+		}
+		// Lambda's disk doesn't outlive the instance, so records go to the cache
+		options = append(options, webapp.WithPromptLogging(webapp.NewCachePromptSink(cache.NewNamespacedCache(c, namespace)), r))
+	}
+
+	if conns := os.Getenv("FETCH_MAX_CONNS_PER_HOST"); conns != "" {
+		n, err := strconv.Atoi(conns)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse FETCH_MAX_CONNS_PER_HOST: %v", err)
+		}
+		wphelpers.SetFetchClient(wphelpers.NewFetchClient(wphelpers.FetchClientConfig{MaxConnsPerHost: n}))
+	}
+	if types := os.Getenv("FETCH_ALLOWED_CONTENT_TYPES"); types != "" {
+		wphelpers.AllowedContentTypes = strings.Split(types, ",")
+	}
+
+	var allowedDomains []string
+	if domains := os.Getenv("ALLOWED_RECIPE_DOMAINS"); domains != "" {
+		allowedDomains = strings.Split(domains, ",")
+		options = append(options, webapp.WithAllowedRecipeDomains(allowedDomains))
+	}
+	if os.Getenv("DISABLE_AGENT") == "true" {
+		options = append(options, webapp.WithAgentDisabled())
+	}
+	if os.Getenv("NON_ALCOHOLIC_ONLY") == "true" {
+		options = append(options, webapp.WithNonAlcoholicOnly())
+	}
+	if os.Getenv("RESPONSE_ENVELOPE") == "true" {
+		options = append(options, webapp.WithResponseEnvelope())
+	}
+	markdownOptions := wphelpers.MarkdownOptionsFromEnv()
+	options = append(options, webapp.WithMarkdownOptions(markdownOptions))
+
+	// The MCP tools write through their own handle, so they need the namespace too
+	toolServer := mcp.MakeServer(cache.NewNamespacedCache(c, namespace),
+		mcp.WithAllowedDomains(allowedDomains),
+		mcp.WithMarkdownOptions(markdownOptions),
+	)
+	options = append(options, webapp.WithModel(model, toolServer))
 
 	db, err := data.Create(ctx)
 	if err != nil {
@@ -87,9 +197,7 @@ func NewHandler() (*Handler, error) {
 		return nil, fmt.Errorf("unable to create webapp: %v", err)
 	}
 
-	return &Handler{
-		webapp: wa,
-	}, nil
+	return newHandler(wa), nil
 }
 
 // HandleRequest processes API Gateway requests
@@ -103,8 +211,8 @@ func (h *Handler) HandleRequest(ctx context.Context, request events.APIGatewayV2
 	// Create response recorder
 	recorder := newResponseRecorder()
 
-	// Route the request
-	h.routeRequest(recorder, httpReq)
+	// Route the request, bounded by the configured request timeout
+	h.webapp.HeadHandler(h.webapp.EnvelopeHandler(h.webapp.TimeoutHandler(h.webapp.ModelHandler(http.HandlerFunc(h.routeRequest))))).ServeHTTP(recorder, httpReq)
 
 	// Convert back to API Gateway response
 	return h.convertToAPIGatewayResponse(recorder), nil
@@ -193,43 +301,106 @@ func (h *Handler) convertToHTTPRequest(request events.APIGatewayV2HTTPRequest) (
 	return req, nil
 }
 
-// routeRequest handles routing logic similar to the original webapp
-func (h *Handler) routeRequest(w http.ResponseWriter, r *http.Request) {
-	path := r.URL.Path
-	method := r.Method
+// route is an entry of the webapp's route table, compiled for matching Lambda
+// request paths. The path arrives decoded, so recipe URLs may contain slashes,
+// and a {url} wildcard spans any number of segments rather than the one the
+// ServeMux allows.
+type route struct {
+	method  string
+	rx      *regexp.Regexp
+	names   []string
+	handler http.HandlerFunc
+	// literal is the length of the pattern outside its wildcards. Longer
+	// ones are more specific, like "/recipes/suggestions/recent" over
+	// "/recipes/suggestions/{url}", and are tried first.
+	literal int
+}
+
+// wildcardRx matches the wildcards in a route pattern, e.g. "{url}" or "{$}".
+var wildcardRx = regexp.MustCompile(`\{([^}]*)\}`)
+
+// compileRoutes compiles the webapp's route table, most specific first. As
+// with the ServeMux, a pattern ending in "/" matches every path below it and
+// "{$}" only matches the end of the path.
+func compileRoutes(routes []webapp.Route) []route {
+	compiled := make([]route, 0, len(routes))
+	for _, rt := range routes {
+		var expr strings.Builder
+		var names []string
+		literal, last := 0, 0
+		for _, m := range wildcardRx.FindAllStringSubmatchIndex(rt.Pattern, -1) {
+			expr.WriteString(regexp.QuoteMeta(rt.Pattern[last:m[0]]))
+			literal += m[0] - last
+			last = m[1]
+			switch name := rt.Pattern[m[2]:m[3]]; name {
+			case "$":
+			case "url":
+				names = append(names, name)
+				expr.WriteString("(.+)")
+			default:
+				names = append(names, name)
+				expr.WriteString("([^/]+)")
+			}
+		}
+		expr.WriteString(regexp.QuoteMeta(rt.Pattern[last:]))
+		literal += len(rt.Pattern) - last
+		if !strings.HasSuffix(rt.Pattern, "/") {
+			expr.WriteString("$")
+		}
 
+		compiled = append(compiled, route{
+			method:  rt.Method,
+			rx:      regexp.MustCompile("^" + expr.String()),
+			names:   names,
+			handler: rt.Handler,
+			literal: literal,
+		})
+	}
+	slices.SortStableFunc(compiled, func(a, b route) int {
+		return b.literal - a.literal
+	})
+
+	return compiled
+}
+
+// routeRequest serves r with the first route from the webapp's table that
+// matches it, like the local server's mux does. r.URL.Path keeps the base
+// path, as the handlers expect.
+func (h *Handler) routeRequest(w http.ResponseWriter, r *http.Request) {
+	path, method := r.URL.Path, r.Method
 	switch {
-	case method == "POST" && strings.HasPrefix(path, "/recipes/summary/"):
-		// TODO handle error
-		u := strings.TrimPrefix(path, "/recipes/summary/")
-		decoded, _ := url.QueryUnescape(u)
-		log.Printf("Preparing summary for URL (path=%s, unescaped=%s, escaped=%s)\n ", path, u, decoded)
-		r = h.setPathValue(r, "url", decoded)
-		h.webapp.WithSessionRequired(h.webapp.WithSufficientQuota(h.webapp.PostCreateRecipe))(w, r)
-	case method == "GET" && path == "/recipes/suggestions/recent":
-		h.webapp.WithSessionRequired(h.webapp.GetRecentSuggestions)(w, r)
-	case method == "POST" && path == "/recipes/suggestionsV2/":
-		h.webapp.WithSessionRequired(h.webapp.WithSufficientQuota(h.webapp.GetRecipeWineSuggestionsV2))(w, r)
-	case method == "GET" && strings.HasPrefix(path, "/recipes/suggestions/"):
-		// TODO handle error
-		u := strings.TrimPrefix(path, "/recipes/suggestions/")
-		decoded, _ := url.QueryUnescape(u)
-		log.Printf("Preparing suggestions for URL (path=%s, unescaped=%s, escaped=%s)\n ", path, u, decoded)
-		r = h.setPathValue(r, "url", decoded)
-		h.webapp.WithSessionRequired(h.webapp.WithSufficientQuota(h.webapp.GetRecipeWineSuggestions))(w, r)
-	case method == "GET" && path == "/logout":
-		h.webapp.WithSessionRequired(h.webapp.DeleteSession)(w, r)
-	case method == "POST" && path == "/oauth/response/":
-		h.webapp.PostOauthResponse(w, r)
-	case method == "GET" && path == "/user":
-		h.webapp.WithSessionRequired(h.webapp.WithAccountDetails(h.webapp.GetUserDetails))(w, r)
-	case method == "GET" && path == "/healthz":
-		h.webapp.HealthStatus(w, r)
-	case method == "GET" && path == "/":
-		h.webapp.WithAccountDetails(h.webapp.GetHome)(w, r)
-	default:
-		http.NotFound(w, r)
+	case h.webapp.BasePath() != "" && path == h.webapp.BasePath():
+		// The base path without its trailing slash, redirected to the home
+		// page as the local server's mux does
+		http.Redirect(w, r, h.webapp.BasePath()+"/", http.StatusTemporaryRedirect)
+		return
+	case method == "GET" && path == h.webapp.BasePath()+"/ws/suggestions":
+		// API Gateway HTTP APIs can't upgrade connections to WebSockets
+		http.Error(w, "WebSocket streaming is not available here; use POST /recipes/suggestionsV2/", http.StatusNotImplemented)
+		return
+	}
+
+	for _, rt := range h.routes {
+		// GET routes answer HEAD requests too, as they do on the mux
+		if rt.method != method && !(rt.method == "GET" && method == "HEAD") {
+			continue
+		}
+		m := rt.rx.FindStringSubmatch(path)
+		if m == nil {
+			continue
+		}
+		for i, name := range rt.names {
+			value := m[i+1]
+			if decoded, err := url.QueryUnescape(value); err == nil {
+				value = decoded
+			}
+			r = h.setPathValue(r, name, value)
+		}
+		rt.handler(w, r)
+		return
 	}
+
+	h.webapp.NotFound(w, r)
 }
 
 // setPathValue simulates Go 1.22's http.Request.PathValue functionality
@@ -0,0 +1,201 @@
+package lambda
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/tmc/langchaingo/llms/fake"
+
+	"github.com/thedahv/wine-pairing-suggestions/cache"
+	"github.com/thedahv/wine-pairing-suggestions/data"
+	"github.com/thedahv/wine-pairing-suggestions/data/datatest"
+	"github.com/thedahv/wine-pairing-suggestions/mcp"
+	"github.com/thedahv/wine-pairing-suggestions/models"
+	"github.com/thedahv/wine-pairing-suggestions/webapp"
+)
+
+// newTestHandler returns a Handler over a webapp backed by a fake DynamoDB and
+// a model that is never expected to be called.
+func newTestHandler(t *testing.T, options ...webapp.Option) (*Handler, *data.DataLayer) {
+	t.Helper()
+	dl, _ := datatest.NewDataLayer(t)
+	model := fake.NewFakeLLM([]string{"unexpected model call"})
+	wa, err := webapp.NewWebapp(0, append([]webapp.Option{
+		webapp.WithMemoryCache(),
+		webapp.WithDatabase(dl),
+		webapp.WithModel(model, mcp.MakeServer(cache.NewMemory())),
+		webapp.WithLogLevel(webapp.LogLevelError),
+	}, options...)...)
+	if err != nil {
+		t.Fatalf("unable to create webapp: %v", err)
+	}
+	return newHandler(wa), dl
+}
+
+// get sends a GET for rawPath through the handler, signed in as accountID if
+// it is set.
+func get(t *testing.T, h *Handler, rawPath, accountID string) events.APIGatewayV2HTTPResponse {
+	t.Helper()
+	req := events.APIGatewayV2HTTPRequest{RawPath: rawPath}
+	req.RequestContext.HTTP.Method = "GET"
+	if accountID != "" {
+		req.Cookies = []string{"wine-suggestions-session=" + accountID}
+	}
+	return send(t, h, req)
+}
+
+// getWithKey sends a GET for rawPath through the handler with an API key.
+func getWithKey(t *testing.T, h *Handler, rawPath, apiKey string) events.APIGatewayV2HTTPResponse {
+	t.Helper()
+	req := events.APIGatewayV2HTTPRequest{RawPath: rawPath, Headers: map[string]string{"x-api-key": apiKey}}
+	req.RequestContext.HTTP.Method = "GET"
+	return send(t, h, req)
+}
+
+// send runs req through the handler.
+func send(t *testing.T, h *Handler, req events.APIGatewayV2HTTPRequest) events.APIGatewayV2HTTPResponse {
+	t.Helper()
+	resp, err := h.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", req.RequestContext.HTTP.Method, req.RawPath, err)
+	}
+	return resp
+}
+
+func TestRouteWineSuggestion(t *testing.T) {
+	h, dl := newTestHandler(t)
+	ctx := context.Background()
+	if _, err := dl.CreateAccountWithQuota(ctx, "acct", "acct@example.com", 5); err != nil {
+		t.Fatal(err)
+	}
+	// A recipe whose own URL has a /wine/ segment
+	recipe := "https://example.com/wine/pairings/braised-ribs"
+	if _, err := dl.CreateRecipePairing(ctx, recipe, data.PairingTypeURL, "Braised ribs", []data.Suggestion{
+		{Style: "Syrah", Region: "Northern Rhône"},
+		{Style: "Barolo", Region: "Piedmont"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	escaped := url.PathEscape(recipe)
+
+	resp := get(t, h, "/recipes/suggestions/"+escaped, "acct")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("suggestions status = %d: %s", resp.StatusCode, resp.Body)
+	}
+	var suggestions []models.Suggestion
+	if err := json.Unmarshal([]byte(resp.Body), &suggestions); err != nil || len(suggestions) != 2 {
+		t.Errorf("suggestions = %s, %v; want both stored suggestions", resp.Body, err)
+	}
+
+	resp = get(t, h, "/recipes/suggestions/"+escaped+"/wine/1", "acct")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("single suggestion status = %d: %s", resp.StatusCode, resp.Body)
+	}
+	var suggestion models.Suggestion
+	if err := json.Unmarshal([]byte(resp.Body), &suggestion); err != nil || suggestion.Style != "Barolo" {
+		t.Errorf("suggestion = %s, %v; want Barolo", resp.Body, err)
+	}
+}
+
+func TestRouteNotFound(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		options []webapp.Option
+		path    string
+	}{
+		{"unknown path", nil, "/nope"},
+		{"outside the base path", []webapp.Option{webapp.WithBasePath("/api/v1")}, "/schema/summary"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h, _ := newTestHandler(t, tc.options...)
+			resp := get(t, h, tc.path, "")
+			if resp.StatusCode != http.StatusNotFound {
+				t.Errorf("status = %d, want 404", resp.StatusCode)
+			}
+			if ct := resp.Headers["Content-Type"]; ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			var e struct {
+				Message string `json:"message"`
+			}
+			if err := json.Unmarshal([]byte(resp.Body), &e); err != nil || !strings.Contains(e.Message, tc.path) {
+				t.Errorf("body = %q, %v; want a JSON error naming the path", resp.Body, err)
+			}
+		})
+	}
+}
+
+func TestRouteTable(t *testing.T) {
+	for _, basePath := range []string{"", "/api/v1"} {
+		h, _ := newTestHandler(t, webapp.WithBasePath(basePath))
+		// Every route in the webapp's table is served, not just the ones a
+		// hand-written router remembers
+		fill := strings.NewReplacer("{url}", url.PathEscape("https://example.com/braised-ribs"), "{index}", "0", "{$}", "")
+		for _, rt := range h.webapp.Routes() {
+			path := fill.Replace(rt.Pattern)
+			if strings.HasSuffix(path, "/static/") {
+				// The static handler 404s for missing files itself
+				path += "css/app.css"
+			}
+			req := events.APIGatewayV2HTTPRequest{RawPath: path}
+			req.RequestContext.HTTP.Method = rt.Method
+			if resp := send(t, h, req); strings.Contains(resp.Body, "no route for") {
+				t.Errorf("%s %s isn't routed: %d %s", rt.Method, req.RawPath, resp.StatusCode, resp.Body)
+			}
+		}
+	}
+
+	// The most specific route wins, whatever its place in the table
+	h, _ := newTestHandler(t)
+	for _, tc := range []struct {
+		path, want string
+	}{
+		{"/recipes/suggestions/recent", "/recipes/suggestions/recent"},
+		{"/recipes/suggestions/https://example.com/ribs", "/recipes/suggestions/{url}"},
+		{"/recipes/suggestions/stream/https://example.com/ribs", "/recipes/suggestions/stream/{url}"},
+		{"/recipes/suggestions/https://example.com/wine/ribs/wine/1", "/recipes/suggestions/{url}/wine/{index}"},
+		{"/static/css/app.css", "/static/"},
+		{"/", "/{$}"},
+	} {
+		var got string
+		for _, rt := range h.routes {
+			if rt.method == "GET" && rt.rx.MatchString(tc.path) {
+				got = rt.rx.String()
+				break
+			}
+		}
+		want := ""
+		for _, rt := range compileRoutes([]webapp.Route{{Method: "GET", Pattern: tc.want}}) {
+			want = rt.rx.String()
+		}
+		if got != want {
+			t.Errorf("GET %s matched %s, want %s", tc.path, got, want)
+		}
+	}
+}
+
+// adminPaths are the admin routes reachable with a GET.
+var adminPaths = []string{"/admin/cache/stats", "/routes", "/admin/usage", "/stats/wines", "/admin/recipes/https:%2F%2Fexample.com%2Fbraised-ribs/validate"}
+
+func TestRouteAdminRequired(t *testing.T) {
+	h, dl := newTestHandler(t, webapp.WithApiKeys(map[string]webapp.ApiKeyConfig{
+		"admin-key": {Name: "ops", Unlimited: true, Admin: true},
+	}))
+	if _, err := dl.CreateAccountWithQuota(context.Background(), "user", "user@example.com", 5); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range adminPaths {
+		if resp := get(t, h, path, "user"); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("GET %s as a user: status = %d, want 403", path, resp.StatusCode)
+		}
+		if resp := getWithKey(t, h, path, "admin-key"); resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusUnauthorized {
+			t.Errorf("GET %s as an admin: status = %d", path, resp.StatusCode)
+		}
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"log"
 	"net/http"
 	"regexp"
-	"strings"
 )
 
 // pathValueKey is used as the key for storing path values in context
@@ -26,13 +25,25 @@ func (r *PathValueAdapter) PathValue(key string) string {
 	return ""
 }
 
-// WithPathValue creates a new request with a path value set in context
+// collapsedSchemeRx matches a URL at the start of a value whose "//" after
+// the scheme was collapsed to "/", as API Gateway does when normalizing paths.
+var collapsedSchemeRx = regexp.MustCompile(`(?i)^(https?):/([^/]|$)`)
+
+// restoreURLScheme undoes the collapsed "//" after an http or https scheme at
+// the start of value. Anything else, including URLs that are already correct
+// and scheme-like text later in the value, is returned unchanged.
+func restoreURLScheme(value string) string {
+	return collapsedSchemeRx.ReplaceAllString(value, "${1}://${2}")
+}
+
+// WithPathValue creates a new request with a path value set in context. The
+// "url" value has the "//" after its scheme restored if it was collapsed.
 func WithPathValue(r *http.Request, key, value string) *http.Request {
 	log.Println("setting WithPathValue for key: ", key)
 	if key == "url" {
 		log.Println("working with a url")
-		if match, _ := regexp.MatchString("https:/[^/]", value); match {
-			value = strings.Replace(value, "https:/", "https://", 1)
+		if fixed := restoreURLScheme(value); fixed != value {
+			value = fixed
 			log.Println("fixed url: ", value)
 		}
 	}
@@ -0,0 +1,46 @@
+package lambdahelpers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRestoreURLScheme(t *testing.T) {
+	for _, tc := range []struct {
+		name, value, want string
+	}{
+		{"collapsed http", "http:/example.com/ribs", "http://example.com/ribs"},
+		{"collapsed https", "https:/example.com/ribs", "https://example.com/ribs"},
+		{"uppercase scheme", "HTTPS:/example.com/ribs", "HTTPS://example.com/ribs"},
+		{"bare scheme", "https:/", "https://"},
+		{"already correct http", "http://example.com/ribs", "http://example.com/ribs"},
+		{"already correct https", "https://example.com/ribs", "https://example.com/ribs"},
+		{"other scheme", "ftp:/example.com/ribs", "ftp:/example.com/ribs"},
+		{"scheme later in the value", "recipes/https:/example.com", "recipes/https:/example.com"},
+		{"not a URL", "braised-short-ribs", "braised-short-ribs"},
+		{"empty", "", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := restoreURLScheme(tc.value); got != tc.want {
+				t.Errorf("restoreURLScheme(%q) = %q, want %q", tc.value, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWithPathValue(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r = WithPathValue(r, "url", "https:/example.com/ribs")
+	r = WithPathValue(r, "id", "https:/example.com/ribs")
+
+	// Only the "url" value is treated as a URL
+	if got := GetPathValue(r, "url"); got != "https://example.com/ribs" {
+		t.Errorf("url = %q, want the scheme restored", got)
+	}
+	if got := GetPathValue(r, "id"); got != "https:/example.com/ribs" {
+		t.Errorf("id = %q, want it unchanged", got)
+	}
+	if got := GetPathValue(r, "missing"); got != "" {
+		t.Errorf("missing = %q, want empty", got)
+	}
+}
@@ -2,17 +2,120 @@ package mcp
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"strings"
 
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
 	"github.com/thedahv/wine-pairing-suggestions/cache"
 	"github.com/thedahv/wine-pairing-suggestions/helpers"
+	"github.com/thedahv/wine-pairing-suggestions/models"
+	"github.com/tmc/langchaingo/llms"
+	"golang.org/x/sync/singleflight"
 )
 
-func MakeServer(c cache.Cacher) *server.MCPServer {
+// Fetcher fetches the raw contents of a URL, along with the URL it was finally
+// served from after redirects, like helpers.FetchRawFromURL.
+type Fetcher func(u string) (io.ReadCloser, string, error)
+
+// errDomainNotAllowed is reported for recipes outside the allowed domains,
+// including ones redirected there.
+var errDomainNotAllowed = errors.New("recipes from this site are not accepted; only verified recipe sources are allowed")
+
+// Summarizer summarizes the recipe at a URL for pairing.
+type Summarizer func(ctx context.Context, u string) (models.Summary, error)
+
+// toolConfig holds the settings the tools that fetch recipes share.
+type toolConfig struct {
+	fetch          Fetcher
+	allowedDomains []string
+	markdown       helpers.MarkdownOptions
+	summarize      Summarizer
+	prefs          models.PairingPreferences
+	onGenerated    func(ctx context.Context) error
+}
+
+// Option configures the tools built by MakeServer.
+type Option func(*toolConfig)
+
+// WithFetcher fetches recipes with fetch instead of helpers.FetchRawFromURL,
+// e.g. a stub returning fixture HTML to avoid network calls.
+func WithFetcher(fetch Fetcher) Option {
+	return func(c *toolConfig) {
+		c.fetch = fetch
+	}
+}
+
+// WithAllowedDomains restricts the tools to recipes hosted on these domains or
+// their subdomains. By default every host is allowed.
+func WithAllowedDomains(domains []string) Option {
+	return func(c *toolConfig) {
+		c.allowedDomains = domains
+	}
+}
+
+// WithMarkdownOptions sets how fetched pages are converted to markdown. The
+// default is helpers.DefaultMarkdownOptions.
+func WithMarkdownOptions(opts helpers.MarkdownOptions) Option {
+	return func(c *toolConfig) {
+		c.markdown = opts
+	}
+}
+
+// WithSummarizer has GeneratePairings summarize recipe URLs with summarize,
+// e.g. to reuse a host application's cache and checks, instead of fetching
+// and summarizing them itself.
+func WithSummarizer(summarize Summarizer) Option {
+	return func(c *toolConfig) {
+		c.summarize = summarize
+	}
+}
+
+// WithPreferences tailors the pairings from GeneratePairings to prefs.
+func WithPreferences(prefs models.PairingPreferences) Option {
+	return func(c *toolConfig) {
+		c.prefs = prefs
+	}
+}
+
+// WithOnGenerated calls onGenerated after GeneratePairings gets pairings from
+// the model, e.g. to charge the caller for them. If it returns an error, the
+// call fails with it instead of returning the pairings.
+func WithOnGenerated(onGenerated func(ctx context.Context) error) Option {
+	return func(c *toolConfig) {
+		c.onGenerated = onGenerated
+	}
+}
+
+// newToolConfig applies options over the defaults.
+func newToolConfig(options []Option) toolConfig {
+	c := toolConfig{fetch: helpers.FetchRawFromURL, markdown: helpers.DefaultMarkdownOptions()}
+	for _, option := range options {
+		option(&c)
+	}
+	return c
+}
+
+// Default cache lifetimes for FetchSite content. Raw HTML is large and rarely
+// read again once converted, while parsed markdown is reused across requests.
+const (
+	DefaultRawTTLSeconds    = 60 * 60
+	DefaultParsedTTLSeconds = 60 * 60 * 24 * 7
+)
+
+// MakeServer builds the tool server.
+func MakeServer(c cache.Cacher, options ...Option) *server.MCPServer {
+	return MakeServerWithTTLs(c, DefaultRawTTLSeconds, DefaultParsedTTLSeconds, options...)
+}
+
+// MakeServerWithTTLs builds the tool server with explicit cache lifetimes (in
+// seconds) for the raw HTML and parsed markdown written by FetchSite. A TTL of
+// 0 stores the entry without expiry.
+func MakeServerWithTTLs(c cache.Cacher, rawTTL, parsedTTL int, options ...Option) *server.MCPServer {
 	s := server.NewMCPServer(
 		"Wine Suggestions Helper Tools",
 		"1.0.0",
@@ -23,14 +126,68 @@ func MakeServer(c cache.Cacher) *server.MCPServer {
 		server.WithLogging(),
 	)
 
-	AddSiteFetchTool(s, c)
+	AddSiteFetchTool(s, c, rawTTL, parsedTTL, options...)
 	AddCacheGetTool(s, c)
 	AddCacheWriteTool(s, c)
 
 	return s
 }
 
-func AddSiteFetchTool(server *server.MCPServer, cache cache.Cacher) {
+// MakePairingServer builds a server with only the GeneratePairings tool, for
+// external agents. Unlike MakeServer's tools, which read and write the cache
+// directly, it is safe to serve over a network transport.
+func MakePairingServer(model llms.Model, options ...Option) *server.MCPServer {
+	s := server.NewMCPServer(
+		"Wine Pairing Suggestions",
+		"1.0.0",
+		server.WithToolCapabilities(false),
+		server.WithRecovery(),
+	)
+
+	AddGeneratePairingsTool(s, model, options...)
+
+	return s
+}
+
+// getOrFetchEx behaves like Cacher.GetOrFetch, but writes resolved values with
+// the given TTL in seconds. Simultaneous misses on key share one onMiss call
+// through g.
+func getOrFetchEx(g *singleflight.Group, c cache.Cacher, key string, seconds int, onMiss cache.Resolver) (string, error) {
+	hit, err := c.Get(key)
+	if err == nil {
+		return hit, nil
+	}
+	if err != cache.ErrKeyNotFound {
+		return "", err
+	}
+
+	val, err, _ := g.Do(key, func() (any, error) {
+		// A call that finished after the miss above has already stored it
+		if hit, err := c.Get(key); err == nil {
+			return hit, nil
+		}
+		val, err := onMiss()
+		if err != nil {
+			return "", err
+		}
+		if err := c.SetEx(key, val, seconds); err != nil {
+			// Not worth failing the tool call over a cache write
+			log.Printf("unable to cache resolved value for %s: %v\n", key, err)
+		}
+		return val, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve cache miss: %w", err)
+	}
+
+	return val.(string), nil
+}
+
+func AddSiteFetchTool(server *server.MCPServer, cache cache.Cacher, rawTTL, parsedTTL int, options ...Option) {
+	cfg := newToolConfig(options)
+	// fetches shares fetching and parsing a page between simultaneous calls
+	// for it
+	var fetches singleflight.Group
 	server.AddTool(mcp.NewTool(
 		"FetchSite",
 		mcp.WithDescription("Given a URL, fetch a website and return its contents in Markdown format"),
@@ -49,31 +206,21 @@ func AddSiteFetchTool(server *server.MCPServer, cache cache.Cacher) {
 				l.Printf("Tool called without a URL")
 				return mcp.NewToolResultError("a URL is required"), nil
 			}
+			if !helpers.HostAllowed(u, cfg.allowedDomains) {
+				l.Printf("Rejected URL outside allowed domains: %s\n", u)
+				return mcp.NewToolResultError(errDomainNotAllowed.Error()), nil
+			}
 
 			l.Printf("Fetching contents for %s\n", u)
-			contents, err := cache.GetOrFetch(fmt.Sprintf("recipes:raw:%s", u), func() (string, error) {
-				l.Println("Raw cache miss:", u)
-				resp, err := helpers.FetchRawFromURL(u)
-				if err != nil {
-					return "", fmt.Errorf("unable to fetch URL: %v", err)
-				}
-				defer resp.Close()
-
-				contents, err := io.ReadAll(resp)
-				if err != nil {
-					return "", fmt.Errorf("unable to read response: %v", err)
-				}
-
-				return string(contents), nil
-			})
+			contents, u, err := fetchRawCanonical(&fetches, cache, cfg.fetch, u, rawTTL, cfg.allowedDomains, l)
 			if err != nil {
 				return mcp.NewToolResultErrorFromErr("unable to fetch site", err), nil
 			}
 
 			l.Printf("Converting %s to markdown\n", u)
-			parsed, err := cache.GetOrFetch(fmt.Sprintf("recipes:parsed:%s", u), func() (string, error) {
+			parsed, err := getOrFetchEx(&fetches, cache, fmt.Sprintf("recipes:parsed:%s", u), parsedTTL, func() (string, error) {
 				l.Printf("Markdown cache miss: %s", u)
-				return helpers.CreateMarkdownFromRaw(u, contents)
+				return helpers.CreateMarkdownFromRawWithOptions(u, contents, cfg.markdown)
 			})
 			if err != nil {
 				return mcp.NewToolResultErrorFromErr("unable to generate markdown from site contents", err), nil
@@ -85,6 +232,75 @@ func AddSiteFetchTool(server *server.MCPServer, cache cache.Cacher) {
 	)
 }
 
+// fetchRawCanonical returns the raw contents of u, fetched with fetch on a
+// cache miss, and the canonical URL they are cached under. If fetching u
+// redirects, the raw contents are cached under the final URL and u is recorded
+// as an alias of it, so later requests for either address share one cache
+// entry. Simultaneous misses on u share one fetch through g. A redirect off
+// the allowed domains fails with errDomainNotAllowed, and nothing is cached
+// for it.
+func fetchRawCanonical(g *singleflight.Group, c cache.Cacher, fetch Fetcher, u string, rawTTL int, allowed []string, l *log.Logger) (string, string, error) {
+	if canonical, err := c.Get(fmt.Sprintf("recipes:canonical:%s", u)); err == nil {
+		l.Printf("Using canonical URL %s for %s\n", canonical, u)
+		u = canonical
+	}
+
+	key := fmt.Sprintf("recipes:raw:%s", u)
+	if hit, err := c.Get(key); err == nil {
+		return hit, u, nil
+	} else if err != cache.ErrKeyNotFound {
+		return "", u, err
+	}
+
+	l.Println("Raw cache miss:", u)
+	page, err, _ := g.Do(key, func() (any, error) {
+		return fetchAndCacheRaw(c, fetch, u, rawTTL, allowed, l)
+	})
+	if err != nil {
+		return "", u, err
+	}
+
+	return page.(rawPage).contents, page.(rawPage).url, nil
+}
+
+// rawPage is a fetched page and the canonical URL it is cached under.
+type rawPage struct {
+	contents, url string
+}
+
+// fetchAndCacheRaw fetches u for fetchRawCanonical and caches the result.
+func fetchAndCacheRaw(c cache.Cacher, fetch Fetcher, u string, rawTTL int, allowed []string, l *log.Logger) (rawPage, error) {
+	resp, final, err := fetch(u)
+	if err != nil {
+		return rawPage{}, fmt.Errorf("unable to fetch URL: %v", err)
+	}
+	defer resp.Close()
+	if final != "" && !helpers.HostAllowed(final, allowed) {
+		l.Printf("Rejected redirect outside allowed domains: %s\n", final)
+		return rawPage{}, errDomainNotAllowed
+	}
+
+	contents, err := io.ReadAll(resp)
+	if err != nil {
+		return rawPage{}, fmt.Errorf("unable to read response: %v", err)
+	}
+
+	if final != "" && final != u {
+		l.Printf("%s redirected to %s\n", u, final)
+		if err := c.Set(fmt.Sprintf("recipes:canonical:%s", u), final); err != nil {
+			log.Printf("unable to cache canonical URL for %s: %v\n", u, err)
+		}
+		u = final
+	}
+
+	if err := c.SetEx(fmt.Sprintf("recipes:raw:%s", u), string(contents), rawTTL); err != nil {
+		// Not worth failing the tool call over a cache write
+		log.Printf("unable to cache raw contents for %s: %v\n", u, err)
+	}
+
+	return rawPage{contents: string(contents), url: u}, nil
+}
+
 type CacheResult struct {
 	Ok    bool   `json:"ok"`
 	Value string `json:"value"`
@@ -176,3 +392,115 @@ func AddContentsHasherTool(server *server.MCPServer) {
 		},
 	)
 }
+
+// GeneratePairingsToolName is the name of the tool added by
+// AddGeneratePairingsTool.
+const GeneratePairingsToolName = "GeneratePairings"
+
+// AddGeneratePairingsTool adds a tool that takes a recipe summary or URL and
+// returns wine pairings as SuggestionsResponse JSON, tailored to
+// WithPreferences. URLs are summarized first, by WithSummarizer if it is set
+// or else by fetching them subject to WithAllowedDomains.
+func AddGeneratePairingsTool(server *server.MCPServer, model llms.Model, options ...Option) {
+	cfg := newToolConfig(options)
+	server.AddTool(
+		mcp.NewTool(
+			GeneratePairingsToolName,
+			mcp.WithDescription("Given a recipe summary or a recipe URL, suggest wine pairings for the dish"),
+			mcp.WithString("input", mcp.Description("A summary of the dish, or the http(s) URL of a recipe"), mcp.Required()),
+			mcp.WithOutputSchema[models.SuggestionsResponse](),
+		),
+		func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			l := log.New(log.Default().Writer(), "[Tool=GeneratePairings] ", log.Default().Flags())
+			input := strings.TrimSpace(request.GetString("input", ""))
+			if input == "" {
+				l.Println("Called without input")
+				return mcp.NewToolResultError("input is required"), nil
+			}
+
+			summary := models.Summary{Ok: true, Summary: input}
+			if strings.HasPrefix(input, "http://") || strings.HasPrefix(input, "https://") {
+				summarize := cfg.summarize
+				if summarize == nil {
+					if !helpers.HostAllowed(input, cfg.allowedDomains) {
+						l.Printf("Rejected URL outside allowed domains: %s\n", input)
+						return mcp.NewToolResultError(errDomainNotAllowed.Error()), nil
+					}
+					summarize = func(ctx context.Context, u string) (models.Summary, error) {
+						return summarizeURL(ctx, model, cfg, u)
+					}
+				}
+
+				var err error
+				if summary, err = summarize(ctx, input); err != nil {
+					return mcp.NewToolResultErrorFromErr("unable to summarize recipe", err), nil
+				}
+			}
+
+			l.Println("Generating pairings")
+			answer, err := models.GeneratePairingSuggestionsForPreferences(ctx, model, summary, cfg.prefs)
+			if err != nil {
+				return mcp.NewToolResultErrorFromErr("unable to generate pairings", err), nil
+			}
+			if cfg.onGenerated != nil {
+				if err := cfg.onGenerated(ctx); err != nil {
+					return mcp.NewToolResultErrorFromErr("unable to return pairings", err), nil
+				}
+			}
+			parsed, err := models.ParseSuggestionsResult(answer, models.ParseOptions{
+				Tolerant: true,
+				OnItemError: func(e *models.ItemError) {
+					l.Printf("Skipping malformed suggestion: %v\n", e)
+				},
+			})
+			if err != nil {
+				return mcp.NewToolResultErrorFromErr("unable to parse pairings", err), nil
+			}
+
+			result := models.SuggestionsResponse{Suggestions: parsed.Suggestions, Summary: summary.Summary, Truncated: parsed.Truncated}
+			out, err := json.Marshal(result)
+			if err != nil {
+				return mcp.NewToolResultErrorFromErr("unable to encode pairings", err), nil
+			}
+
+			return mcp.NewToolResultStructured(result, string(out)), nil
+		},
+	)
+}
+
+// summarizeURL fetches the recipe at u and summarizes it for pairing.
+func summarizeURL(ctx context.Context, model llms.Model, cfg toolConfig, u string) (models.Summary, error) {
+	resp, final, err := cfg.fetch(u)
+	if err != nil {
+		return models.Summary{}, fmt.Errorf("unable to fetch URL: %v", err)
+	}
+	defer resp.Close()
+	if final != "" && !helpers.HostAllowed(final, cfg.allowedDomains) {
+		return models.Summary{}, errDomainNotAllowed
+	}
+	contents, err := io.ReadAll(resp)
+	if err != nil {
+		return models.Summary{}, fmt.Errorf("unable to read response: %v", err)
+	}
+	if final != "" {
+		u = final
+	}
+
+	markdown, err := helpers.CreateMarkdownFromRawWithOptions(u, string(contents), cfg.markdown)
+	if err != nil {
+		return models.Summary{}, err
+	}
+	out, err := models.SummarizeRecipe(ctx, model, markdown)
+	if err != nil {
+		return models.Summary{}, err
+	}
+	summary, err := models.ParseSummary(out)
+	if err != nil {
+		return models.Summary{}, err
+	}
+	if !summary.Ok {
+		return summary, fmt.Errorf("model aborted recipe summary (%s): %s", summary.AbortCode, summary.AbortReason)
+	}
+
+	return summary, nil
+}
@@ -0,0 +1,415 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/tmc/langchaingo/llms"
+
+	"github.com/thedahv/wine-pairing-suggestions/cache"
+	"github.com/thedahv/wine-pairing-suggestions/models"
+)
+
+const recipeHTML = `<html><head><title>Braised Ribs</title></head><body><article>
+<h1>Braised Ribs</h1>
+<p>Brown the short ribs in a heavy pot, then braise them in red wine with onions, carrots and thyme for three hours until tender.</p>
+<ul><li>4 short ribs</li><li>1 bottle red wine</li><li>2 onions</li></ul>
+</article></body></html>`
+
+// stubFetcher serves recipeHTML for every URL, reporting final as the URL it
+// was served from if set, and counts its calls. Each fetch takes delay.
+type stubFetcher struct {
+	final string
+	delay time.Duration
+	calls atomic.Int32
+}
+
+func (f *stubFetcher) fetch(u string) (io.ReadCloser, string, error) {
+	f.calls.Add(1)
+	time.Sleep(f.delay)
+	if f.final != "" {
+		u = f.final
+	}
+	return io.NopCloser(strings.NewReader(recipeHTML)), u, nil
+}
+
+// callTool calls the named tool on s through an in-process client.
+func callTool(t *testing.T, s *server.MCPServer, name string, args map[string]any) *mcp.CallToolResult {
+	t.Helper()
+	ctx := context.Background()
+	c, err := client.NewInProcessClient(s)
+	if err != nil {
+		t.Fatalf("unable to create client: %v", err)
+	}
+	defer c.Close()
+	if err := c.Start(ctx); err != nil {
+		t.Fatalf("unable to start client: %v", err)
+	}
+	init := mcp.InitializeRequest{}
+	init.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
+	init.Params.ClientInfo = mcp.Implementation{Name: "test", Version: "1.0.0"}
+	if _, err := c.Initialize(ctx, init); err != nil {
+		t.Fatalf("unable to initialize client: %v", err)
+	}
+
+	req := mcp.CallToolRequest{}
+	req.Params.Name = name
+	req.Params.Arguments = args
+	result, err := c.CallTool(ctx, req)
+	if err != nil {
+		t.Fatalf("%s failed: %v", name, err)
+	}
+	return result
+}
+
+// resultText returns the text content of a tool result.
+func resultText(t *testing.T, result *mcp.CallToolResult) string {
+	t.Helper()
+	var sb strings.Builder
+	for _, content := range result.Content {
+		if text, ok := mcp.AsTextContent(content); ok {
+			sb.WriteString(text.Text)
+		}
+	}
+	return sb.String()
+}
+
+func newToolServer(c cache.Cacher, options ...Option) *server.MCPServer {
+	s := server.NewMCPServer("test", "1.0.0", server.WithToolCapabilities(true))
+	AddSiteFetchTool(s, c, DefaultRawTTLSeconds, DefaultParsedTTLSeconds, options...)
+	AddCacheGetTool(s, c)
+	AddCacheWriteTool(s, c)
+	return s
+}
+
+func TestSiteFetchTool(t *testing.T) {
+	c := cache.NewMemory()
+	fetcher := &stubFetcher{}
+	s := newToolServer(c, WithFetcher(fetcher.fetch))
+	u := "https://example.com/braised-ribs"
+
+	for i := range 2 {
+		result := callTool(t, s, "FetchSite", map[string]any{"URL": u})
+		if result.IsError {
+			t.Fatalf("call %d: FetchSite failed: %s", i, resultText(t, result))
+		}
+		if got := resultText(t, result); !strings.Contains(got, "braise them in red wine") {
+			t.Errorf("call %d: FetchSite = %q, want the recipe as markdown", i, got)
+		}
+	}
+	if n := fetcher.calls.Load(); n != 1 {
+		t.Errorf("fetched %d times, want 1 with the second call served from cache", n)
+	}
+
+	if raw, err := c.Get("recipes:raw:" + u); err != nil || raw != recipeHTML {
+		t.Errorf("raw cache = %q, %v; want the fetched HTML", raw, err)
+	}
+	if parsed, err := c.Get("recipes:parsed:" + u); err != nil || !strings.Contains(parsed, "Braised Ribs") {
+		t.Errorf("parsed cache = %q, %v; want the markdown", parsed, err)
+	}
+}
+
+func TestSiteFetchToolConcurrent(t *testing.T) {
+	c := cache.NewMemory()
+	// Slow enough that every call misses on the cold cache
+	fetcher := &stubFetcher{delay: 20 * time.Millisecond}
+	s := newToolServer(c, WithFetcher(fetcher.fetch))
+	u := "https://example.com/braised-ribs"
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if result := callTool(t, s, "FetchSite", map[string]any{"URL": u}); result.IsError {
+				t.Errorf("FetchSite failed: %s", resultText(t, result))
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := fetcher.calls.Load(); n != 1 {
+		t.Errorf("fetched %d times, want 1 shared by every call", n)
+	}
+}
+
+func TestSiteFetchToolRedirect(t *testing.T) {
+	c := cache.NewMemory()
+	final := "https://example.com/recipes/braised-ribs"
+	fetcher := &stubFetcher{final: final}
+	s := newToolServer(c, WithFetcher(fetcher.fetch))
+
+	for _, u := range []string{"https://example.com/braised-ribs", "https://example.com/braised-ribs", final} {
+		if result := callTool(t, s, "FetchSite", map[string]any{"URL": u}); result.IsError {
+			t.Fatalf("FetchSite(%s) failed: %s", u, resultText(t, result))
+		}
+	}
+	if n := fetcher.calls.Load(); n != 1 {
+		t.Errorf("fetched %d times, want 1 for both addresses", n)
+	}
+	if canonical, err := c.Get("recipes:canonical:https://example.com/braised-ribs"); err != nil || canonical != final {
+		t.Errorf("canonical = %q, %v; want %q", canonical, err, final)
+	}
+	if _, err := c.Get("recipes:raw:" + final); err != nil {
+		t.Errorf("raw contents aren't cached under the final URL: %v", err)
+	}
+}
+
+func TestSiteFetchToolAllowedDomains(t *testing.T) {
+	for _, tc := range []struct {
+		name, url string
+		// final is where the fetch is redirected to, if anywhere
+		final   string
+		allowed bool
+		// fetched is whether the URL is fetched before it is rejected
+		fetched bool
+	}{
+		{"allowed host", "https://example.com/braised-ribs", "", true, true},
+		{"subdomain", "https://www.example.com/braised-ribs", "", true, true},
+		{"disallowed host", "https://elsewhere.test/braised-ribs", "", false, false},
+		{"redirect to a subdomain", "https://example.com/braised-ribs", "https://www.example.com/braised-ribs", true, true},
+		{"redirect to a disallowed host", "https://example.com/braised-ribs", "https://elsewhere.test/braised-ribs", false, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := cache.NewMemory()
+			fetcher := &stubFetcher{final: tc.final}
+			s := newToolServer(c, WithFetcher(fetcher.fetch), WithAllowedDomains([]string{"example.com"}))
+
+			result := callTool(t, s, "FetchSite", map[string]any{"URL": tc.url})
+			if result.IsError == tc.allowed {
+				t.Errorf("FetchSite error = %v, want %v: %s", result.IsError, !tc.allowed, resultText(t, result))
+			}
+			if fetched := fetcher.calls.Load() > 0; fetched != tc.fetched {
+				t.Errorf("fetched = %v, want %v", fetched, tc.fetched)
+			}
+			if tc.allowed {
+				return
+			}
+			// Nothing from a rejected site is kept
+			for _, k := range []string{"recipes:canonical:" + tc.url, "recipes:raw:" + tc.url, "recipes:raw:" + tc.final} {
+				if _, err := c.Get(k); err == nil {
+					t.Errorf("%s was cached for a rejected site", k)
+				}
+			}
+		})
+	}
+}
+
+func TestSiteFetchToolErrors(t *testing.T) {
+	s := newToolServer(cache.NewMemory(), WithFetcher(func(u string) (io.ReadCloser, string, error) {
+		return nil, "", fmt.Errorf("connection refused")
+	}))
+
+	if result := callTool(t, s, "FetchSite", map[string]any{"URL": ""}); !result.IsError {
+		t.Errorf("FetchSite accepted an empty URL")
+	}
+	if result := callTool(t, s, "FetchSite", map[string]any{"URL": "https://example.com/braised-ribs"}); !result.IsError {
+		t.Errorf("FetchSite succeeded when the fetch failed")
+	}
+}
+
+func TestCacheGetTool(t *testing.T) {
+	c := cache.NewMemory()
+	s := newToolServer(c)
+
+	// The structured CacheResult doesn't survive the in-process transport, but
+	// its text mirrors the value
+	result := callTool(t, s, "CacheGet", map[string]any{"key": "recipes:summary:missing"})
+	if result.IsError {
+		t.Fatalf("CacheGet failed on a miss: %s", resultText(t, result))
+	}
+	if got := resultText(t, result); got != "" {
+		t.Errorf("CacheGet miss = %q, want no value", got)
+	}
+
+	if err := c.Set("recipes:summary:ribs", "braised ribs"); err != nil {
+		t.Fatal(err)
+	}
+	result = callTool(t, s, "CacheGet", map[string]any{"key": "recipes:summary:ribs"})
+	if result.IsError {
+		t.Fatalf("CacheGet failed on a hit: %s", resultText(t, result))
+	}
+	if got := resultText(t, result); got != "braised ribs" {
+		t.Errorf("CacheGet hit = %q, want the stored value", got)
+	}
+
+	if result := callTool(t, s, "CacheGet", map[string]any{}); !result.IsError {
+		t.Errorf("CacheGet accepted a missing key")
+	}
+}
+
+func TestCacheWriteTool(t *testing.T) {
+	c := cache.NewMemory()
+	s := newToolServer(c)
+
+	result := callTool(t, s, "CacheWrite", map[string]any{"key": "recipes:summary:ribs", "value": "braised ribs"})
+	if result.IsError {
+		t.Fatalf("CacheWrite failed: %s", resultText(t, result))
+	}
+	if got, err := c.Get("recipes:summary:ribs"); err != nil || got != "braised ribs" {
+		t.Errorf("cache = %q, %v; want the written value", got, err)
+	}
+
+	if result := callTool(t, s, "CacheWrite", map[string]any{"value": "braised ribs"}); !result.IsError {
+		t.Errorf("CacheWrite accepted a missing key")
+	}
+}
+
+// ttlRecorder records the TTL each key was last written with; the memory
+// cache itself ignores TTLs.
+type ttlRecorder struct {
+	cache.Cacher
+	mu   sync.Mutex
+	ttls map[string]int
+}
+
+func (r *ttlRecorder) SetEx(key, value string, seconds int) error {
+	r.mu.Lock()
+	r.ttls[key] = seconds
+	r.mu.Unlock()
+	return r.Cacher.SetEx(key, value, seconds)
+}
+
+func (r *ttlRecorder) ttl(key string) (int, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	seconds, ok := r.ttls[key]
+	return seconds, ok
+}
+
+func TestMakeServerWithTTLs(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		raw, parsed int
+		makeServer  func(c cache.Cacher, fetch Fetcher) *server.MCPServer
+	}{
+		{"explicit", 120, 86400, func(c cache.Cacher, fetch Fetcher) *server.MCPServer {
+			return MakeServerWithTTLs(c, 120, 86400, WithFetcher(fetch))
+		}},
+		{"no expiry", 0, 0, func(c cache.Cacher, fetch Fetcher) *server.MCPServer {
+			return MakeServerWithTTLs(c, 0, 0, WithFetcher(fetch))
+		}},
+		{"defaults", DefaultRawTTLSeconds, DefaultParsedTTLSeconds, func(c cache.Cacher, fetch Fetcher) *server.MCPServer {
+			return MakeServer(c, WithFetcher(fetch))
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &ttlRecorder{Cacher: cache.NewMemory(), ttls: map[string]int{}}
+			fetcher := &stubFetcher{}
+			s := tc.makeServer(c, fetcher.fetch)
+			u := "https://example.com/braised-ribs"
+
+			if result := callTool(t, s, "FetchSite", map[string]any{"URL": u}); result.IsError {
+				t.Fatalf("FetchSite failed: %s", resultText(t, result))
+			}
+
+			if got, ok := c.ttl("recipes:raw:" + u); !ok || got != tc.raw {
+				t.Errorf("raw TTL = %d (written: %v), want %d", got, ok, tc.raw)
+			}
+			if got, ok := c.ttl("recipes:parsed:" + u); !ok || got != tc.parsed {
+				t.Errorf("parsed TTL = %d (written: %v), want %d", got, ok, tc.parsed)
+			}
+		})
+	}
+}
+
+// pairingModel answers recipe summary prompts with a summary and every other
+// prompt with a suggestions array, recording the prompts.
+type pairingModel struct {
+	mu      sync.Mutex
+	prompts []string
+}
+
+func (m *pairingModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	var sb strings.Builder
+	for _, message := range messages {
+		for _, part := range message.Parts {
+			if text, ok := part.(llms.TextContent); ok {
+				sb.WriteString(text.Text)
+			}
+		}
+	}
+	m.mu.Lock()
+	m.prompts = append(m.prompts, sb.String())
+	m.mu.Unlock()
+
+	answer := `[{"style": "Syrah", "region": "Northern Rhône", "description": "Savory red.", "pairingNote": "Echoes the braise.", "confidence": 0.8}]`
+	if strings.Contains(sb.String(), "Summarize this recipe for wine pairing") {
+		answer = `{"ok": true, "summary": "Short ribs braised in red wine"}`
+	}
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: answer}}}, nil
+}
+
+func (m *pairingModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+func TestGeneratePairingsTool(t *testing.T) {
+	for _, tc := range []struct {
+		name, input string
+		options     []Option
+		// wantSummary is the summary the pairings were generated for
+		wantSummary string
+		wantPrompt  string
+	}{
+		{"summary", "Grilled salmon with dill", nil, "Grilled salmon with dill", "Grilled salmon with dill"},
+		{"URL", "https://example.com/braised-ribs", nil, "Short ribs braised in red wine", "Short ribs braised in red wine"},
+		{"summarizer", "https://example.com/braised-ribs", []Option{WithSummarizer(func(ctx context.Context, u string) (models.Summary, error) {
+			return models.Summary{Ok: true, Summary: "Summarized by the host for " + u}, nil
+		})}, "Summarized by the host for https://example.com/braised-ribs", "Summarized by the host"},
+		{"preferences", "Grilled salmon with dill", []Option{WithPreferences(models.PairingPreferences{AlcoholFree: true})}, "Grilled salmon with dill", "<ALCOHOL_FREE>"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			model := &pairingModel{}
+			fetcher := &stubFetcher{}
+			s := MakePairingServer(model, append([]Option{WithFetcher(fetcher.fetch)}, tc.options...)...)
+
+			result := callTool(t, s, GeneratePairingsToolName, map[string]any{"input": tc.input})
+			if result.IsError {
+				t.Fatalf("GeneratePairings failed: %s", resultText(t, result))
+			}
+			var resp models.SuggestionsResponse
+			if err := json.Unmarshal([]byte(resultText(t, result)), &resp); err != nil {
+				t.Fatalf("result isn't a SuggestionsResponse: %v", err)
+			}
+			if len(resp.Suggestions) != 1 || resp.Suggestions[0].Style != "Syrah" || resp.Summary != tc.wantSummary {
+				t.Errorf("result = %+v, want the Syrah pairing for %q", resp, tc.wantSummary)
+			}
+			if last := model.prompts[len(model.prompts)-1]; !strings.Contains(last, tc.wantPrompt) {
+				t.Errorf("pairing prompt doesn't contain %q:\n%s", tc.wantPrompt, last)
+			}
+		})
+	}
+}
+
+func TestGeneratePairingsToolErrors(t *testing.T) {
+	errNoQuota := errors.New("no quota left")
+	for _, tc := range []struct {
+		name, input string
+		options     []Option
+	}{
+		{"no input", " ", nil},
+		{"disallowed site", "https://elsewhere.example.org/ribs", []Option{WithAllowedDomains([]string{"example.com"})}},
+		{"redirect to a disallowed site", "https://example.com/ribs", []Option{WithAllowedDomains([]string{"example.com"}), WithFetcher((&stubFetcher{final: "https://elsewhere.example.org/ribs"}).fetch)}},
+		{"charge fails", "Grilled salmon", []Option{WithOnGenerated(func(ctx context.Context) error { return errNoQuota })}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fetcher := &stubFetcher{}
+			s := MakePairingServer(&pairingModel{}, append([]Option{WithFetcher(fetcher.fetch)}, tc.options...)...)
+			if result := callTool(t, s, GeneratePairingsToolName, map[string]any{"input": tc.input}); !result.IsError {
+				t.Errorf("GeneratePairings = %s, want an error", resultText(t, result))
+			}
+		})
+	}
+}
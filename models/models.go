@@ -5,56 +5,202 @@
 package models
 
 import (
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math/rand/v2"
 	"os"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	brtypes "github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
 	"github.com/tmc/langchaingo/agents"
 	"github.com/tmc/langchaingo/chains"
 	"github.com/tmc/langchaingo/llms"
 	"github.com/tmc/langchaingo/llms/anthropic"
 	"github.com/tmc/langchaingo/llms/bedrock"
+	"github.com/tmc/langchaingo/schema"
 	"github.com/tmc/langchaingo/tools"
+
+	"github.com/thedahv/wine-pairing-suggestions/helpers"
 )
 
 const awsClaudeKeySecret = "prod/Anthropic/WineSuggestions"
 
+// Default output token caps for generation calls. Suggestions are long JSON
+// arrays and get truncated mid-document at lower limits, while summaries are a
+// single paragraph.
+const (
+	DefaultSummaryMaxTokens     = 1024
+	DefaultSuggestionsMaxTokens = 4096
+)
+
+// Option configures a model returned by one of the "Make*Model" functions.
+type Option func(*modelConfig)
+
+type modelConfig struct {
+	maxTokens      int
+	bedrockModelID string
+	bedrockRegion  string
+}
+
+// DefaultBedrockModelID is the Bedrock model used unless BEDROCK_MODEL_ID or
+// WithBedrockModelID picks another.
+const DefaultBedrockModelID = "anthropic.claude-haiku-4-5-20251001-v1:0"
+
+// WithMaxTokens caps the number of output tokens for any call to the model
+// that doesn't set its own limit. It replaces the per-task defaults
+// (DefaultSummaryMaxTokens and DefaultSuggestionsMaxTokens).
+func WithMaxTokens(n int) Option {
+	return func(c *modelConfig) {
+		c.maxTokens = n
+	}
+}
+
+// WithBedrockModelID selects the Bedrock model, overriding BEDROCK_MODEL_ID.
+// It has no effect on other providers.
+func WithBedrockModelID(id string) Option {
+	return func(c *modelConfig) {
+		c.bedrockModelID = id
+	}
+}
+
+// WithBedrockRegion selects the AWS region for Bedrock, overriding the region
+// from the environment or shared AWS config. It has no effect on other
+// providers.
+func WithBedrockRegion(region string) Option {
+	return func(c *modelConfig) {
+		c.bedrockRegion = region
+	}
+}
+
+// configuredModel wraps a provider model to apply default call options
+// configured at construction time.
+type configuredModel struct {
+	llms.Model
+	maxTokens int
+}
+
+func (m *configuredModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	var opts llms.CallOptions
+	for _, o := range options {
+		o(&opts)
+	}
+	if opts.MaxTokens == 0 && m.maxTokens > 0 {
+		options = append([]llms.CallOption{llms.WithMaxTokens(m.maxTokens)}, options...)
+	}
+
+	return m.Model.GenerateContent(ctx, messages, options...)
+}
+
+// ConfiguredMaxTokens returns the cap set with WithMaxTokens.
+func (m *configuredModel) ConfiguredMaxTokens() int {
+	return m.maxTokens
+}
+
+func (m *configuredModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+// MaxTokensConfigured is implemented by models created with WithMaxTokens,
+// and by wrappers around them, to report the configured output token cap.
+// Generation functions leave the limit to that cap instead of applying their
+// own default.
+type MaxTokensConfigured interface {
+	ConfiguredMaxTokens() int
+}
+
+// ConfiguredMaxTokens returns the output token cap configured on model, or 0
+// if it has none.
+func ConfiguredMaxTokens(model llms.Model) int {
+	if m, ok := model.(MaxTokensConfigured); ok {
+		return m.ConfiguredMaxTokens()
+	}
+	return 0
+}
+
+// withDefaultMaxTokens prepends a limit of n output tokens to opts, unless
+// model has a configured cap of its own. Limits in opts still win.
+func withDefaultMaxTokens(model llms.Model, n int, opts []llms.CallOption) []llms.CallOption {
+	if ConfiguredMaxTokens(model) > 0 {
+		return opts
+	}
+	return append([]llms.CallOption{llms.WithMaxTokens(n)}, opts...)
+}
+
+// applyOptions wraps the model with the given options, returning it unchanged
+// if none apply.
+func applyOptions(llm llms.Model, opts []Option) llms.Model {
+	var c modelConfig
+	for _, o := range opts {
+		o(&c)
+	}
+	if c.maxTokens <= 0 {
+		return llm
+	}
+
+	return &configuredModel{Model: llm, maxTokens: c.maxTokens}
+}
+
 // MakeBedrockModel establishes a connection to AWS Bedrock. When working
 // locally, the code assumes the local environment has an AWS credentials for a
 // properly configured IAM role loaded in environment variables (see
-// `env.example`). It's configured to return a Claude 3.5 Haiku model. It
-// returns a LangChain instance configured for Bedrock.
-func MakeBedrockModel(ctx context.Context) (llms.Model, error) {
+// `env.example`). It's configured to return DefaultBedrockModelID in the
+// default AWS region unless BEDROCK_MODEL_ID, WithBedrockModelID, or
+// WithBedrockRegion say otherwise. It returns a LangChain instance configured
+// for Bedrock.
+func MakeBedrockModel(ctx context.Context, opts ...Option) (llms.Model, error) {
+	c := modelConfig{bedrockModelID: os.Getenv("BEDROCK_MODEL_ID")}
+	for _, o := range opts {
+		o(&c)
+	}
+	if c.bedrockModelID == "" {
+		c.bedrockModelID = DefaultBedrockModelID
+	}
+
+	var loadOpts []func(*config.LoadOptions) error
+	if c.bedrockRegion != "" {
+		loadOpts = append(loadOpts, config.WithRegion(c.bedrockRegion))
+	}
+
 	// cfg, err := config.LoadDefaultConfig(ctx, config.WithSharedConfigProfile(awsProfileName))
-	cfg, err := config.LoadDefaultConfig(ctx)
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
 	if err != nil {
-		log.Fatalf("unable to load SDK config, %v\n", err)
+		return nil, fmt.Errorf("unable to load AWS SDK config: %w", err)
 	}
 
 	client := bedrockruntime.NewFromConfig(cfg)
 	llm, err := bedrock.New(
 		bedrock.WithClient(client),
 		// Note, this version of the Bedrock SDK doesn't have this supported model name yet
-		bedrock.WithModel("anthropic.claude-haiku-4-5-20251001-v1:0"))
+		bedrock.WithModel(c.bedrockModelID))
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize Bedrock LLM: %w", err)
 	}
 
-	return llm, nil
+	return applyOptions(llm, opts), nil
 }
 
 const claudeModelId = "claude-haiku-4-5-20251001"
 
 // MakeClaude connects to claude assuming the ANTHROPIC_API_KEY environment variable
 // is set with a valid token.
-func MakeClaude(ctx context.Context) (llms.Model, error) {
+func MakeClaude(ctx context.Context, opts ...Option) (llms.Model, error) {
 	var anthropicKey string
 	if k := os.Getenv("ANTHROPIC_API_KEY"); k != "" {
 		anthropicKey = k
@@ -72,140 +218,1856 @@ func MakeClaude(ctx context.Context) (llms.Model, error) {
 		return llm, fmt.Errorf("unable to connect to Anthropic: %v", err)
 	}
 
-	return llm, nil
+	return applyOptions(llm, opts), nil
+}
+
+// MakeClaudeWithBedrockFallback serves Claude from the Anthropic API, falling
+// back to Claude on Bedrock for calls the API rejects as rate limited or
+// overloaded. If no Anthropic key is available, every call goes to Bedrock.
+// The same options apply to both models.
+func MakeClaudeWithBedrockFallback(ctx context.Context, opts ...Option) (llms.Model, error) {
+	br, brErr := MakeBedrockModel(ctx, opts...)
+	claude, err := MakeClaude(ctx, opts...)
+	return withBedrockFallback(claude, err, br, brErr)
+}
+
+// withBedrockFallback combines the results of creating the Anthropic and
+// Bedrock models for MakeClaudeWithBedrockFallback, serving from whichever
+// are available.
+func withBedrockFallback(claude llms.Model, err error, br llms.Model, brErr error) (llms.Model, error) {
+	if err != nil {
+		if brErr != nil {
+			return nil, fmt.Errorf("unable to create Claude (%v) or Bedrock fallback: %w", err, brErr)
+		}
+		log.Printf("[MakeClaudeWithBedrockFallback] Anthropic unavailable, serving all requests from Bedrock: %v\n", err)
+		return br, nil
+	}
+	if brErr != nil {
+		log.Printf("[MakeClaudeWithBedrockFallback] Bedrock unavailable, serving without fallback: %v\n", brErr)
+		return claude, nil
+	}
+
+	return &FallbackModel{Primary: claude, PrimaryName: "anthropic", Fallback: br, FallbackName: "bedrock"}, nil
+}
+
+// FallbackModel sends calls to Primary, retrying them on Fallback when Primary
+// is rate limited or overloaded. Names are used to log each fallback.
+type FallbackModel struct {
+	Primary      llms.Model
+	PrimaryName  string
+	Fallback     llms.Model
+	FallbackName string
+}
+
+func (m *FallbackModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	resp, err := m.Primary.GenerateContent(ctx, messages, options...)
+	if err == nil || !isRetryableError(err) || ctx.Err() != nil {
+		return resp, err
+	}
+
+	log.Printf("[FallbackModel] %s unavailable, falling back to %s: %v\n", m.PrimaryName, m.FallbackName, err)
+	return m.Fallback.GenerateContent(ctx, messages, options...)
+}
+
+// ConfiguredMaxTokens returns the output token cap configured on Primary.
+func (m *FallbackModel) ConfiguredMaxTokens() int {
+	return ConfiguredMaxTokens(m.Primary)
+}
+
+func (m *FallbackModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+// ErrNoRecording is returned by a ReplayModel asked for a prompt that isn't in
+// its fixture.
+var ErrNoRecording = errors.New("no recorded response for prompt")
+
+// ReplayModel returns recorded responses keyed by a hash of the prompt, so the
+// pipeline can run deterministically without a real LLM. Fixtures are JSON
+// files mapping prompt hashes to response text.
+//
+// Created with NewRecordingModel, it instead passes calls through to a real
+// model and writes each response to the fixture.
+type ReplayModel struct {
+	path      string
+	upstream  llms.Model
+	mu        sync.Mutex
+	responses map[string]string
+}
+
+// NewReplayModel loads the fixture at path and replays its responses.
+func NewReplayModel(path string) (*ReplayModel, error) {
+	m := &ReplayModel{path: path}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// NewRecordingModel records upstream's responses to the fixture at path,
+// keeping any responses already recorded there.
+func NewRecordingModel(upstream llms.Model, path string) (*ReplayModel, error) {
+	m := &ReplayModel{path: path, upstream: upstream}
+	if err := m.load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if m.responses == nil {
+		m.responses = make(map[string]string)
+	}
+
+	return m, nil
+}
+
+func (m *ReplayModel) load() error {
+	contents, err := os.ReadFile(m.path)
+	if err != nil {
+		return fmt.Errorf("unable to read fixture: %w", err)
+	}
+	if err := json.Unmarshal(contents, &m.responses); err != nil {
+		return fmt.Errorf("unable to parse fixture %s: %v", m.path, err)
+	}
+
+	return nil
+}
+
+// PromptHash returns the fixture key for messages: a SHA-256 of each
+// message's role and text parts.
+func PromptHash(messages []llms.MessageContent) string {
+	h := sha256.New()
+	for _, msg := range messages {
+		fmt.Fprintf(h, "%s\n", msg.Role)
+		for _, part := range msg.Parts {
+			if text, ok := part.(llms.TextContent); ok {
+				fmt.Fprintf(h, "%s\n", text.Text)
+			}
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (m *ReplayModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	key := PromptHash(messages)
+
+	if m.upstream == nil {
+		m.mu.Lock()
+		text, ok := m.responses[key]
+		m.mu.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("%w (hash %s)", ErrNoRecording, key)
+		}
+
+		return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: text}}}, nil
+	}
+
+	resp, err := m.upstream.GenerateContent(ctx, messages, options...)
+	if err != nil {
+		return resp, err
+	}
+	if len(resp.Choices) == 0 {
+		return resp, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.responses[key] = resp.Choices[0].Content
+	out, err := json.MarshalIndent(m.responses, "", "  ")
+	if err != nil {
+		return resp, fmt.Errorf("unable to encode fixture: %v", err)
+	}
+	if err := os.WriteFile(m.path, out, 0o644); err != nil {
+		return resp, fmt.Errorf("unable to write fixture: %v", err)
+	}
+
+	return resp, nil
+}
+
+// ConfiguredMaxTokens returns the output token cap configured on the model
+// being recorded, if any.
+func (m *ReplayModel) ConfiguredMaxTokens() int {
+	if m.upstream == nil {
+		return 0
+	}
+	return ConfiguredMaxTokens(m.upstream)
+}
+
+func (m *ReplayModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+// RetryModel wraps a model to retry calls that fail because the provider is
+// rate limiting or overloaded, backing off exponentially with jitter between
+// attempts. It gives up early rather than sleep past the context deadline.
+// Other errors are returned immediately.
+type RetryModel struct {
+	llms.Model
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// NewRetryModel wraps model with default retry settings: up to 4 attempts,
+// starting at a 500ms delay and backing off to at most 8s.
+func NewRetryModel(model llms.Model) *RetryModel {
+	return &RetryModel{
+		Model:       model,
+		MaxAttempts: 4,
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    8 * time.Second,
+	}
+}
+
+func (m *RetryModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := m.Model.GenerateContent(ctx, messages, options...)
+		if err == nil || !isRetryableError(err) || attempt >= m.MaxAttempts {
+			return resp, err
+		}
+
+		delay := m.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return resp, err
+		}
+
+		log.Printf("[RetryModel] Attempt %d/%d rate limited, retrying in %s: %v\n", attempt, m.MaxAttempts, delay, err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// ConfiguredMaxTokens returns the output token cap configured on the wrapped
+// model.
+func (m *RetryModel) ConfiguredMaxTokens() int {
+	return ConfiguredMaxTokens(m.Model)
+}
+
+func (m *RetryModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+// backoff returns the delay before the next attempt: the base delay doubled
+// for each failed attempt, capped at MaxDelay, with the upper half jittered.
+func (m *RetryModel) backoff(attempt int) time.Duration {
+	delay := m.BaseDelay << (attempt - 1)
+	if delay <= 0 || delay > m.MaxDelay {
+		delay = m.MaxDelay
+	}
+
+	half := delay / 2
+	return half + rand.N(half+1)
+}
+
+// retryableStatusCodes appear in Anthropic errors, which only carry the HTTP
+// status in the message: 429 is rate limited, 529 is overloaded.
+var retryableStatusCodes = []string{"status code: 429", "status code: 529", "status code: 503"}
+
+// IsRateLimitError reports whether err, or an error it wraps, is the provider
+// rate limiting or being overloaded. A RetryModel has already retried such
+// errors by the time they reach the caller.
+func IsRateLimitError(err error) bool {
+	return err != nil && isRetryableError(err)
+}
+
+// isRetryableError reports whether err is a transient rate-limit or overload
+// error from Anthropic or Bedrock.
+func isRetryableError(err error) bool {
+	var throttled *brtypes.ThrottlingException
+	var unavailable *brtypes.ModelNotReadyException
+	var quota *brtypes.ServiceQuotaExceededException
+	if errors.As(err, &throttled) || errors.As(err, &unavailable) || errors.As(err, &quota) {
+		return true
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, code := range retryableStatusCodes {
+		if strings.Contains(msg, code) {
+			return true
+		}
+	}
+
+	// Bedrock errors that langchaingo didn't wrap only keep the exception
+	// name in the message
+	return strings.Contains(msg, "rate limit") || strings.Contains(msg, "overloaded") ||
+		strings.Contains(msg, "throttlingexception") || strings.Contains(msg, "too many requests")
+}
+
+// Summary models a recipe summary, taking into account that the LLM may choose
+// not to summarize if it thinks there's an issue with the input.
+type Summary struct {
+	Ok            bool          `json:"ok"`
+	Summary       string        `json:"summary"`
+	AbortReason   string        `json:"abortReason"`
+	FlavorProfile FlavorProfile `json:"flavorProfile"`
+	// Language is the ISO 639-1 code of the recipe's original language, as
+	// detected by helpers.DetectLanguage. The summary itself is in English.
+	Language string `json:"language,omitempty"`
+	// AbortCode classifies AbortReason so clients can branch on it. It is
+	// empty when Ok.
+	AbortCode AbortCode `json:"abortCode,omitempty"`
+	// Ingredients lists the recipe's key ingredients, most flavorful first.
+	Ingredients []string `json:"ingredients,omitempty"`
+	// CookingMethods lists how the dish is cooked, e.g. "braised".
+	CookingMethods []string `json:"cookingMethods,omitempty"`
+	// Servings is how many people the recipe serves, and TotalTimeMinutes
+	// how long it takes start to finish; zero when unknown. The page's
+	// JSON-LD, when it has any, is preferred over the model's reading.
+	Servings         int `json:"servings,omitempty"`
+	TotalTimeMinutes int `json:"totalTimeMinutes,omitempty"`
+	// Allergens lists the common allergens (see Allergens) the model spotted
+	// in the recipe. It is informational only and no safety guarantee: the
+	// model can miss allergens, especially in unlisted or brand-name
+	// ingredients, so anyone with an allergy should check the recipe itself.
+	Allergens []string `json:"allergens,omitempty"`
+}
+
+// Allergens are the allergen names a Summary reports, in the order they are
+// listed.
+var Allergens = []string{"dairy", "eggs", "fish", "gluten", "nuts", "peanuts", "sesame", "shellfish", "soy"}
+
+// allergenAliases maps other names the model may use to one of Allergens.
+var allergenAliases = map[string]string{
+	"milk":       "dairy",
+	"lactose":    "dairy",
+	"egg":        "eggs",
+	"wheat":      "gluten",
+	"tree nuts":  "nuts",
+	"tree nut":   "nuts",
+	"nut":        "nuts",
+	"peanut":     "peanuts",
+	"crustacean": "shellfish",
+	"mollusc":    "shellfish",
+	"mollusk":    "shellfish",
+	"soya":       "soy",
+}
+
+// parseAllergens maps the model's allergen names to Allergens, ignoring case
+// and dropping unknown ones, in the order of Allergens.
+func parseAllergens(names []string) []string {
+	found := map[string]bool{}
+	for _, n := range names {
+		n = strings.ToLower(strings.Join(strings.Fields(n), " "))
+		if alias, ok := allergenAliases[n]; ok {
+			n = alias
+		}
+		found[n] = true
+	}
+
+	var allergens []string
+	for _, a := range Allergens {
+		if found[a] {
+			allergens = append(allergens, a)
+		}
+	}
+	return allergens
+}
+
+// AbortCode is why the model declined to summarize a recipe.
+type AbortCode string
+
+const (
+	AbortNotFood AbortCode = "NOT_FOOD"
+	AbortUnsafe  AbortCode = "UNSAFE"
+	AbortUnclear AbortCode = "UNCLEAR"
+	// AbortOther covers missing codes and any the model made up.
+	AbortOther AbortCode = "OTHER"
+)
+
+// parseAbortCode maps the model's code to a known AbortCode, ignoring case
+// and surrounding space.
+func parseAbortCode(code string) AbortCode {
+	switch c := AbortCode(strings.ToUpper(strings.TrimSpace(code))); c {
+	case AbortNotFood, AbortUnsafe, AbortUnclear:
+		return c
+	default:
+		return AbortOther
+	}
+}
+
+// FlavorProfile scores the characteristics of a dish that matter most for wine
+// pairing, each on a scale from 0 (none) to 5 (dominant).
+type FlavorProfile struct {
+	Sweetness float64 `json:"sweetness"`
+	Acidity   float64 `json:"acidity"`
+	Fat       float64 `json:"fat"`
+	Spice     float64 `json:"spice"`
+	Weight    float64 `json:"weight"`
+}
+
+// Suggestion models a wine pairing recommendation from an LLM prompt response
+// encoded in JSON format.
+type Suggestion struct {
+	Style       string  `json:"style"`
+	Region      string  `json:"region"`
+	Description string  `json:"description"`
+	PairingNote string  `json:"pairingNote"`
+	Confidence  float64 `json:"confidence"`
+	Rank        int     `json:"rank,omitempty"`
+	// Availability says how easy the wine is to find in the requested
+	// Market, if one was given.
+	Availability string `json:"availability,omitempty"`
+}
+
+// SummarizeRecipe takes a markdown representation of a recipe published on the
+// Internet and returns a summary that would be helpful to someone making wine
+// pairing recommendations for that recipe. Output is capped at
+// DefaultSummaryMaxTokens unless opts sets another limit.
+func SummarizeRecipe(ctx context.Context, model llms.Model, markdown string, opts ...llms.CallOption) (string, error) {
+	prompt := fmt.Sprintf(`
+	Summarize this recipe for wine pairing. Focus on flavors and key ingredients.
+
+	<RECIPE>
+	%s
+	</RECIPE>
+
+	Create a one-paragraph summary highlighting:
+	- Primary flavors (sweet, salty, acidic, bitter, umami)
+	- Cooking methods (grilled, braised, roasted, etc.)
+	- Key ingredients by flavor impact (most important first)
+	- Sauce/seasoning profile
+	- Overall dish weight (light, medium, heavy)
+
+	Also score the dish's flavor profile from 0 (none) to 5 (dominant) for
+	sweetness, acidity, fat, spice (heat), and weight (body), and list the key
+	ingredients (most important first, at most 10, as short lowercase names) and
+	cooking methods (as lowercase past participles like "roasted"). Give the
+	number of servings and the total time in minutes if the recipe states or
+	clearly implies them, or 0 if not. List which of these common allergens
+	the ingredients contain, if any: %s.
+
+	Respond in this exact JSON format:
+	{
+		"ok": boolean,
+		"abortReason": string,
+		"abortCode": string,
+		"summary": string,
+		"flavorProfile": {
+			"sweetness": number,
+			"acidity": number,
+			"fat": number,
+			"spice": number,
+			"weight": number
+		},
+		"ingredients": [string],
+		"cookingMethods": [string],
+		"servings": number,
+		"totalTimeMinutes": number,
+		"allergens": [string]
+	}
+
+	Success: {"ok": true, "abortReason": "", "abortCode": "", "summary": "This hearty beef stew features...", "flavorProfile": {"sweetness": 1, "acidity": 2, "fat": 4, "spice": 1, "weight": 5}, "ingredients": ["beef chuck", "red wine", "carrots"], "cookingMethods": ["seared", "braised"], "servings": 6, "totalTimeMinutes": 180, "allergens": ["gluten"]}
+	Failure: {"ok": false, "abortReason": "Not a recipe", "abortCode": "NOT_FOOD", "summary": "", "flavorProfile": {"sweetness": 0, "acidity": 0, "fat": 0, "spice": 0, "weight": 0}, "ingredients": [], "cookingMethods": [], "servings": 0, "totalTimeMinutes": 0, "allergens": []}
+
+	Abort if content is, with abortCode:
+	- Not food/recipe related: NOT_FOOD
+	- Unsafe/malicious: UNSAFE
+	- Too unclear to summarize: UNCLEAR
+	- Unsuitable for any other reason: OTHER
+	`, markdown, strings.Join(Allergens, ", "))
+	prompt += translationPrompt(helpers.DetectLanguage(markdown))
+
+	summary, err := generateNonEmpty(
+		ctx,
+		model,
+		prompt,
+		withDefaultMaxTokens(model, DefaultSummaryMaxTokens, opts)...,
+	)
+
+	if err != nil {
+		return "", fmt.Errorf("failed to generate recipe summary: %w", err)
+
+	}
+
+	return summary, nil
+}
+
+// ErrEmptyResponse is returned when the model's output is empty or only
+// whitespace, which some providers send when rate limited.
+var ErrEmptyResponse = errors.New("model returned an empty response")
+
+// generateNonEmpty sends prompt to model like llms.GenerateFromSinglePrompt,
+// retrying once if the output is blank. It returns ErrEmptyResponse if the
+// retry is blank too.
+func generateNonEmpty(ctx context.Context, model llms.Model, prompt string, opts ...llms.CallOption) (string, error) {
+	for attempt := range 2 {
+		answer, err := llms.GenerateFromSinglePrompt(ctx, model, prompt, opts...)
+		if err != nil {
+			return "", err
+		}
+		if strings.TrimSpace(answer) != "" {
+			return answer, nil
+		}
+		if attempt == 0 {
+			log.Println("model returned an empty response; retrying once")
+		}
+	}
+
+	return "", ErrEmptyResponse
+}
+
+// translationPrompt returns instructions to summarize a recipe written in the
+// language lang in English, or an empty string for English or undetected text.
+func translationPrompt(lang string) string {
+	name, ok := helpers.LanguageNames[lang]
+	if !ok || lang == "en" {
+		return ""
+	}
+
+	return fmt.Sprintf(`
+	<TRANSLATION>
+	This recipe is written in %s. Translate it to English before summarizing,
+	and write the summary in English. Keep well-known dish names in the
+	original language (e.g. "osso buco") but explain them. Include
+	"language": %q in the JSON.
+	</TRANSLATION>
+	`, name, lang)
+}
+
+// ParseSummary parses LLM output into Go types using JSON type annotations
+// from Summary. Blank output fails with ErrEmptyResponse. An aborted summary
+// always has an AbortCode, OTHER if the model's is missing or unknown.
+// TODO: Use https://pkg.go.dev/github.com/tmc/langchaingo@v0.1.14/outputparser#Structured
+func ParseSummary(output string) (Summary, error) {
+	var s Summary
+	if strings.TrimSpace(output) == "" {
+		return s, ErrEmptyResponse
+	}
+	if err := json.Unmarshal([]byte(output), &s); err != nil {
+		return s, fmt.Errorf("unable to parse Summary output: %v", err)
+	}
+	if s.Ok {
+		s.AbortCode = ""
+	} else {
+		s.AbortCode = parseAbortCode(string(s.AbortCode))
+	}
+	s.Ingredients = cleanTerms(s.Ingredients)
+	s.CookingMethods = cleanTerms(s.CookingMethods)
+	s.Servings = max(s.Servings, 0)
+	s.TotalTimeMinutes = max(s.TotalTimeMinutes, 0)
+	s.Allergens = parseAllergens(s.Allergens)
+
+	return s, nil
+}
+
+// cleanTerms trims terms and drops blank and repeated ones, ignoring case. An
+// empty result is nil, so it is left out of JSON.
+func cleanTerms(terms []string) []string {
+	var cleaned []string
+	for _, t := range terms {
+		t = strings.Join(strings.Fields(t), " ")
+		if t == "" || slices.ContainsFunc(cleaned, func(c string) bool { return strings.EqualFold(c, t) }) {
+			continue
+		}
+		cleaned = append(cleaned, t)
+	}
+	return cleaned
+}
+
+// SummarizeRecipes summarizes many markdown recipes at once, keyed however the
+// caller likes (e.g. by URL), running at most concurrency summaries at a time.
+// It returns the summaries and errors by key; every doc ends up in exactly one
+// of the two maps. Docs not yet started when ctx is cancelled fail with the
+// context's error.
+func SummarizeRecipes(ctx context.Context, model llms.Model, docs map[string]string, concurrency int) (map[string]Summary, map[string]error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	summaries := make(map[string]Summary, len(docs))
+	errs := make(map[string]error)
+	var mu sync.Mutex
+	record := func(key string, s Summary, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs[key] = err
+		} else {
+			summaries[key] = s
+		}
+	}
+
+	keys := make(chan string)
+	var wg sync.WaitGroup
+	for range min(concurrency, len(docs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range keys {
+				s, err := summarizeDoc(ctx, model, docs[key])
+				record(key, s, err)
+			}
+		}()
+	}
+
+	for key := range docs {
+		if ctx.Err() != nil {
+			record(key, Summary{}, ctx.Err())
+			continue
+		}
+		select {
+		case keys <- key:
+		case <-ctx.Done():
+			record(key, Summary{}, ctx.Err())
+		}
+	}
+	close(keys)
+	wg.Wait()
+
+	return summaries, errs
 }
 
-// Summary models a recipe summary, taking into account that the LLM may choose
-// not to summarize if it thinks there's an issue with the input.
-type Summary struct {
-	Ok          bool   `json:"ok"`
-	Summary     string `json:"summary"`
-	AbortReason string `json:"abortReason"`
+// summarizeDoc summarizes and parses a single recipe for SummarizeRecipes.
+func summarizeDoc(ctx context.Context, model llms.Model, markdown string) (Summary, error) {
+	if err := ctx.Err(); err != nil {
+		return Summary{}, err
+	}
+
+	out, err := SummarizeRecipe(ctx, model, markdown)
+	if err != nil {
+		return Summary{}, err
+	}
+	s, err := ParseSummary(out)
+	if err != nil {
+		return Summary{}, err
+	}
+	if s.Language == "" {
+		s.Language = helpers.DetectLanguage(markdown)
+	}
+	if !s.Ok {
+		return s, fmt.Errorf("model aborted recipe summary (%s): %s", s.AbortCode, s.AbortReason)
+	}
+
+	return s, nil
+}
+
+// SummarizeRecipeN requests n candidate summaries of the same recipe at once,
+// for pages where a single attempt is often wrong. Candidates come back in
+// request order, aborted ones included, skipping any that fail to generate or
+// parse; it only returns an error if every attempt fails. Use BestSummary to
+// pick one.
+func SummarizeRecipeN(ctx context.Context, model llms.Model, markdown string, n int, opts ...llms.CallOption) ([]Summary, error) {
+	if n < 1 {
+		n = 1
+	}
+
+	results := make([]Summary, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out, err := SummarizeRecipe(ctx, model, markdown, opts...)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			s, err := ParseSummary(out)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			if s.Language == "" {
+				s.Language = helpers.DetectLanguage(markdown)
+			}
+			results[i] = s
+		}()
+	}
+	wg.Wait()
+
+	var candidates []Summary
+	for i, s := range results {
+		if errs[i] == nil {
+			candidates = append(candidates, s)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("all %d summary candidates failed: %w", n, errs[0])
+	}
+
+	return candidates, nil
+}
+
+// BestSummary picks the successful candidate mentioning the most distinct
+// flavor and weight keywords, breaking ties by the longer summary and then by
+// order. It reports false if no candidate succeeded.
+func BestSummary(candidates []Summary) (Summary, bool) {
+	var best Summary
+	bestScore, found := -1, false
+	for _, c := range candidates {
+		if !c.Ok {
+			continue
+		}
+		score := summaryKeywordCount(c.Summary)
+		if score > bestScore || score == bestScore && len(c.Summary) > len(best.Summary) {
+			best, bestScore, found = c, score, true
+		}
+	}
+	return best, found
+}
+
+// summaryKeywordCount counts the distinct summaryQualityKeywords in text.
+func summaryKeywordCount(text string) int {
+	lower := strings.ToLower(text)
+	count := 0
+	for _, k := range summaryQualityKeywords {
+		if strings.Contains(lower, k) {
+			count++
+		}
+	}
+	return count
+}
+
+// ErrLowQualitySummary is returned by ValidateSummary when a summary is too
+// thin to produce useful pairings.
+var ErrLowQualitySummary = errors.New("recipe summary is too vague to suggest pairings")
+
+// minSummaryLength is the shortest summary, in characters, we consider
+// detailed enough to pair against.
+const minSummaryLength = 80
+
+// summaryQualityKeywords are flavor and dish-weight terms the summary prompt
+// asks for. A useful summary mentions at least one of them.
+var summaryQualityKeywords = []string{
+	"sweet", "salty", "acid", "bitter", "umami", "savory", "savoury", "spicy", "tangy", "smoky",
+	"rich", "creamy", "earthy", "herb", "citrus", "bright", "fatty", "buttery", "tart", "sour",
+	"light", "medium", "heavy", "hearty", "delicate", "bold", "weight",
+}
+
+// ValidateSummary checks that a successful summary is long enough and
+// mentions the flavor or weight characteristics wine pairing depends on. It
+// returns an error wrapping ErrLowQualitySummary if not.
+func ValidateSummary(s Summary) error {
+	text := strings.TrimSpace(s.Summary)
+	if text == "" {
+		return fmt.Errorf("%w: summary is empty", ErrLowQualitySummary)
+	}
+	if len(text) < minSummaryLength {
+		return fmt.Errorf("%w: summary is only %d characters", ErrLowQualitySummary, len(text))
+	}
+
+	lower := strings.ToLower(text)
+	for _, k := range summaryQualityKeywords {
+		if strings.Contains(lower, k) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: summary doesn't describe flavors or dish weight", ErrLowQualitySummary)
+}
+
+// CandidateStyles applies classic pairing heuristics to a flavor profile to
+// narrow the wine styles worth considering: fatty dishes want tannic reds or
+// bubbles, acidic dishes want high-acid wines, spicy dishes want off-dry and
+// low-tannin wines, and sweet dishes want wines at least as sweet. It returns
+// nil for an empty profile, where there's nothing to go on.
+func CandidateStyles(p FlavorProfile) []string {
+	if p == (FlavorProfile{}) {
+		return nil
+	}
+
+	var styles []string
+	add := func(s ...string) {
+		for _, style := range s {
+			if !slices.Contains(styles, style) {
+				styles = append(styles, style)
+			}
+		}
+	}
+
+	if p.Sweetness >= 3 {
+		add("Moscato d'Asti", "Late Harvest Riesling", "Sauternes")
+	}
+	if p.Spice >= 3 {
+		add("Off-dry Riesling", "Gewürztraminer", "Zinfandel")
+	}
+	if p.Acidity >= 4 {
+		add("Sauvignon Blanc", "Dry Riesling", "Albariño", "Sangiovese")
+	}
+	if p.Fat >= 4 && p.Spice < 3 {
+		add("Cabernet Sauvignon", "Syrah", "Nebbiolo")
+	}
+	if p.Fat >= 3 {
+		add("Champagne", "Oaked Chardonnay")
+	}
+	switch {
+	case p.Weight >= 4:
+		add("Malbec", "Syrah", "Oaked Chardonnay")
+	case p.Weight >= 3:
+		add("Pinot Noir", "Grenache", "Chenin Blanc")
+	default:
+		add("Pinot Grigio", "Sauvignon Blanc", "Gamay", "Dry Rosé")
+	}
+
+	return styles
+}
+
+// GeneratePairingSuggestions takes a summary of a recipe and generates wine pairing suggestions.
+// The prompt directs the model to return suggestions in JSON format conforming to the type specified
+// by Suggestion. Output is capped at DefaultSuggestionsMaxTokens unless opts sets another limit.
+func GeneratePairingSuggestions(ctx context.Context, model llms.Model, summary string, opts ...llms.CallOption) (string, error) {
+	return GeneratePairingSuggestionsForSummary(ctx, model, Summary{Ok: true, Summary: summary}, opts...)
+}
+
+// Occasion is the setting a meal is served in. It shapes the tone of the
+// suggestions and the prices they aim for.
+type Occasion string
+
+const (
+	OccasionCasual      Occasion = "casual"
+	OccasionDinnerParty Occasion = "dinner party"
+	OccasionCelebration Occasion = "celebration"
+	OccasionBudget      Occasion = "budget"
+)
+
+// occasionGuidance is the prompt guidance for each Occasion.
+var occasionGuidance = map[Occasion]string{
+	OccasionCasual:      "This is a casual weeknight dinner. Keep the tone relaxed and favor easy-drinking everyday wines around $10-20.",
+	OccasionDinnerParty: "This is a dinner party with guests. Favor crowd-pleasing wines with a story worth sharing, around $20-40.",
+	OccasionCelebration: "This is a celebration. Lean toward sparkling wines such as Champagne, Crémant, or Franciacorta and higher-tier bottlings from respected producers, around $35-80.",
+	OccasionBudget:      "This is a budget-conscious meal. Only suggest wines that are widely available for under $15.",
+}
+
+// ParseOccasion parses an occasion name, accepting "-" or "_" in place of
+// spaces. An empty name is the zero Occasion, which adds no guidance.
+func ParseOccasion(name string) (Occasion, error) {
+	o := Occasion(strings.ToLower(strings.NewReplacer("-", " ", "_", " ").Replace(strings.TrimSpace(name))))
+	if o == "" {
+		return o, nil
+	}
+	if _, ok := occasionGuidance[o]; !ok {
+		return "", fmt.Errorf("unknown occasion %q: must be one of casual, dinner party, celebration, budget", name)
+	}
+
+	return o, nil
+}
+
+// occasionPrompt returns the prompt block describing the occasion, or an empty
+// string for the zero Occasion.
+func occasionPrompt(o Occasion) string {
+	guidance, ok := occasionGuidance[o]
+	if !ok {
+		return ""
+	}
+
+	return fmt.Sprintf(`
+	<OCCASION>
+	%s
+	</OCCASION>
+`, guidance)
+}
+
+// Market is where the user shops for wine. Suggestions favor wines distributed
+// there and say how easy each is to find.
+type Market string
+
+const (
+	MarketUS Market = "US"
+	MarketUK Market = "UK"
+	MarketEU Market = "EU"
+)
+
+// marketNames describes each Market in the prompt.
+var marketNames = map[Market]string{
+	MarketUS: "the United States",
+	MarketUK: "the United Kingdom",
+	MarketEU: "the European Union",
+}
+
+// ParseMarket parses a market code, ignoring case and accepting "GB" for the
+// UK. An empty code is the zero Market, which adds no guidance.
+func ParseMarket(code string) (Market, error) {
+	m := Market(strings.ToUpper(strings.TrimSpace(code)))
+	if m == "GB" {
+		m = MarketUK
+	}
+	if m == "" {
+		return m, nil
+	}
+	if _, ok := marketNames[m]; !ok {
+		return "", fmt.Errorf("unknown market %q: must be one of US, UK, EU", code)
+	}
+
+	return m, nil
+}
+
+// marketPrompt returns the prompt block steering suggestions toward wines sold
+// in m, or an empty string for the zero Market.
+func marketPrompt(m Market) string {
+	name, ok := marketNames[m]
+	if !ok {
+		return ""
+	}
+
+	return fmt.Sprintf(`
+	<MARKET>
+	The user buys wine in %s. Prefer wines that are widely distributed there,
+	and avoid ones rarely exported to it, even if they would otherwise suit the
+	dish. Add an "availability" field to each suggestion with one short phrase
+	on how easy it is to find there, e.g. "Stocked by most supermarkets" or
+	"Specialist wine shops".
+	</MARKET>
+`, name)
+}
+
+// Persona is the voice the pairing suggestions are written in.
+type Persona string
+
+const (
+	PersonaApproachable    Persona = "approachable"
+	PersonaSommelierExpert Persona = "sommelier-expert"
+	PersonaPlayful         Persona = "playful"
+)
+
+// DefaultPersona is used when no persona is requested.
+const DefaultPersona = PersonaApproachable
+
+// personaRoles is the role and tone portion of the suggestion prompt for each
+// Persona.
+var personaRoles = map[Persona]string{
+	PersonaApproachable:    "Suggest approachable wine pairings for this dish. Focus on accessible wines people can actually find.",
+	PersonaSommelierExpert: "Suggest wine pairings for this dish as an expert sommelier. Be precise about structure (acidity, tannin, body, sweetness) and classic pairing principles, and don't shy away from specialist regions and producers.",
+	PersonaPlayful:         "Suggest wine pairings for this dish as a playful, witty wine buddy. Keep descriptions fun and vivid, but the pairing logic sound, and stick to wines people can actually find.",
+}
+
+// ParsePersona parses a persona name, accepting "_" or " " in place of "-".
+// An empty name is DefaultPersona.
+func ParsePersona(name string) (Persona, error) {
+	p := Persona(strings.ToLower(strings.NewReplacer("_", "-", " ", "-").Replace(strings.TrimSpace(name))))
+	if p == "" {
+		return DefaultPersona, nil
+	}
+	if _, ok := personaRoles[p]; !ok {
+		return "", fmt.Errorf("unknown persona %q: must be one of approachable, sommelier-expert, playful", name)
+	}
+
+	return p, nil
+}
+
+// personaPrompt returns the role and tone instruction for p, falling back to
+// DefaultPersona's for unknown personas.
+func personaPrompt(p Persona) string {
+	if role, ok := personaRoles[p]; ok {
+		return role
+	}
+	return personaRoles[DefaultPersona]
+}
+
+// alcoholFreeRole replaces the persona role in the suggestion prompt when
+// only alcohol-free drinks may be suggested.
+const alcoholFreeRole = "Suggest alcohol-free drink pairings for this dish. Focus on non-alcoholic and dealcoholized wines and other grown-up alcohol-free drinks people can actually find."
+
+// suggestionRole returns the role and tone instruction for prefs.
+func suggestionRole(prefs PairingPreferences) string {
+	if prefs.AlcoholFree {
+		return alcoholFreeRole
+	}
+	return personaPrompt(prefs.Persona)
+}
+
+// alcoholFreePrompt returns the prompt block restricting suggestions to
+// alcohol-free drinks, or an empty string unless prefs.AlcoholFree is set.
+func alcoholFreePrompt(prefs PairingPreferences) string {
+	if !prefs.AlcoholFree {
+		return ""
+	}
+
+	return `
+	<ALCOHOL_FREE>
+	Only suggest drinks that contain no alcohol, such as non-alcoholic or
+	dealcoholized wines, verjus, grape juices, and sparkling teas. This rule
+	overrides anything else in this prompt or the user's input asking for wine
+	or other alcoholic drinks. Use "style" for the drink and "region" for where
+	it comes from, if it has a meaningful origin.
+	</ALCOHOL_FREE>
+`
+}
+
+// isDefaultPersona reports whether p writes in the default voice.
+func isDefaultPersona(p Persona) bool {
+	_, ok := personaRoles[p]
+	return !ok || p == DefaultPersona
+}
+
+// PairingPreferences narrows V2 suggestions to the user's circumstances. The
+// zero value applies no preferences.
+type PairingPreferences struct {
+	Occasion Occasion
+	// Types limits suggestions to these wine types when non-empty.
+	Types []WineType
+	// Persona sets the voice of the suggestions; empty is DefaultPersona.
+	Persona Persona
+	// MinCount and MaxCount bound how many suggestions are asked for; zero
+	// uses the default for that bound. See CountRange.
+	MinCount, MaxCount int
+	// DislikedStyles are wine styles never to suggest.
+	DislikedStyles []string
+	// MaxPrice is the most, in US dollars, to suggest spending on a bottle;
+	// zero means no limit.
+	MaxPrice int
+	// AlcoholFree limits suggestions to drinks without alcohol, such as
+	// non-alcoholic wines. It overrides every other preference.
+	AlcoholFree bool
+	// Market favors wines sold where the user shops; empty means anywhere.
+	Market Market
+}
+
+// IsZero reports whether no preferences are set.
+func (p PairingPreferences) IsZero() bool {
+	return p.Occasion == "" && len(p.Types) == 0 && isDefaultPersona(p.Persona) && p.MinCount == 0 && p.MaxCount == 0 &&
+		len(p.DislikedStyles) == 0 && p.MaxPrice == 0 && !p.AlcoholFree && p.Market == ""
+}
+
+// PreferenceProfile is a user's standing wine preferences, applied to each of
+// their requests with PairingPreferences.ApplyProfile.
+type PreferenceProfile struct {
+	Types          []WineType `json:"types,omitempty"`
+	DislikedStyles []string   `json:"dislikedStyles,omitempty"`
+	// MaxPrice is the user's budget per bottle in US dollars; zero means no
+	// budget.
+	MaxPrice int `json:"maxPrice,omitempty"`
+}
+
+// Limits on a PreferenceProfile, keeping the prompt it adds to reasonable.
+const (
+	maxDislikedStyles    = 20
+	maxDislikedStyleLen  = 50
+	maxProfilePriceLimit = 1000
+)
+
+// Validate checks the profile's wine types are known and its lists and budget
+// are within reasonable limits. It normalizes the types as ParseWineTypes does
+// and drops blank disliked styles.
+func (p *PreferenceProfile) Validate() error {
+	types := make([]string, len(p.Types))
+	for i, t := range p.Types {
+		types[i] = string(t)
+	}
+	parsed, err := ParseWineTypes(strings.Join(types, ","))
+	if err != nil {
+		return err
+	}
+	p.Types = parsed
+
+	var styles []string
+	for _, s := range p.DislikedStyles {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if utf8.RuneCountInString(s) > maxDislikedStyleLen {
+			return fmt.Errorf("disliked styles must be at most %d characters", maxDislikedStyleLen)
+		}
+		styles = append(styles, s)
+	}
+	if len(styles) > maxDislikedStyles {
+		return fmt.Errorf("at most %d disliked styles are allowed", maxDislikedStyles)
+	}
+	p.DislikedStyles = styles
+
+	if p.MaxPrice < 0 || p.MaxPrice > maxProfilePriceLimit {
+		return fmt.Errorf("maxPrice must be between 0 and %d", maxProfilePriceLimit)
+	}
+
+	return nil
+}
+
+// ApplyProfile merges a user's profile into the preferences. Wine types given
+// for the request win over the profile's.
+func (p *PairingPreferences) ApplyProfile(profile PreferenceProfile) {
+	if len(p.Types) == 0 {
+		p.Types = profile.Types
+	}
+	p.DislikedStyles = profile.DislikedStyles
+	p.MaxPrice = profile.MaxPrice
+}
+
+// profilePrompt returns the prompt block for the disliked styles and budget in
+// prefs, or an empty string if neither is set.
+func profilePrompt(p PairingPreferences) string {
+	var lines []string
+	if len(p.DislikedStyles) > 0 {
+		lines = append(lines, fmt.Sprintf("The user dislikes these styles; never suggest them: %s.", strings.Join(p.DislikedStyles, ", ")))
+	}
+	if p.MaxPrice > 0 {
+		lines = append(lines, fmt.Sprintf("Only suggest wines commonly sold for $%d or less a bottle.", p.MaxPrice))
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf(`
+	<USER_PROFILE>
+	%s
+	</USER_PROFILE>
+`, strings.Join(lines, "\n\t"))
+}
+
+// Default and largest suggestion counts for a request.
+const (
+	DefaultMinSuggestions = 5
+	DefaultMaxSuggestions = 10
+	MaxSuggestionsCap     = 15
+)
+
+// CountRange returns how many suggestions to ask for. Unset bounds take their
+// default, moved if needed so the range stays valid, e.g. a MaxCount of 3
+// alone gives 3-3.
+func (p PairingPreferences) CountRange() (int, int) {
+	lo, hi := p.MinCount, p.MaxCount
+	if lo == 0 {
+		lo = DefaultMinSuggestions
+		if hi != 0 {
+			lo = min(lo, hi)
+		}
+	}
+	if hi == 0 {
+		hi = max(DefaultMaxSuggestions, lo)
+	}
+	return lo, hi
+}
+
+// countPrompt describes the suggestion count range for a prompt, e.g. "5-10"
+// or "exactly 3".
+func countPrompt(p PairingPreferences) string {
+	lo, hi := p.CountRange()
+	if lo == hi {
+		return fmt.Sprintf("exactly %d", lo)
+	}
+	return fmt.Sprintf("%d-%d", lo, hi)
+}
+
+// ParseSuggestionCounts parses the min and max suggestion counts of a request.
+// Empty values are 0, meaning the default. Each must be between 1 and
+// MaxSuggestionsCap, and min can't exceed max.
+func ParseSuggestionCounts(minValue, maxValue string) (int, int, error) {
+	parse := func(name, v string) (int, error) {
+		if v == "" {
+			return 0, nil
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > MaxSuggestionsCap {
+			return 0, fmt.Errorf("%s must be a whole number from 1 to %d", name, MaxSuggestionsCap)
+		}
+		return n, nil
+	}
+
+	lo, err := parse("min", minValue)
+	if err != nil {
+		return 0, 0, err
+	}
+	hi, err := parse("max", maxValue)
+	if err != nil {
+		return 0, 0, err
+	}
+	if lo != 0 && hi != 0 && lo > hi {
+		return 0, 0, fmt.Errorf("min (%d) can't be greater than max (%d)", lo, hi)
+	}
+
+	return lo, hi, nil
+}
+
+// TruncateSuggestions returns at most max suggestions, keeping the first ones.
+func TruncateSuggestions(suggestions []Suggestion, max int) []Suggestion {
+	if max > 0 && len(suggestions) > max {
+		return suggestions[:max]
+	}
+	return suggestions
+}
+
+// Key returns a stable, space-free encoding of the preferences for use in
+// cache keys. The default persona is left out so keys written before personas
+// existed still match.
+func (p PairingPreferences) Key() string {
+	types := make([]string, len(p.Types))
+	for i, t := range p.Types {
+		types[i] = string(t)
+	}
+	slices.Sort(types)
+
+	key := fmt.Sprintf("%s;%s", strings.ReplaceAll(string(p.Occasion), " ", "-"), strings.Join(types, "+"))
+	if !isDefaultPersona(p.Persona) {
+		key += ";" + string(p.Persona)
+	}
+	if p.MinCount != 0 || p.MaxCount != 0 {
+		lo, hi := p.CountRange()
+		key += fmt.Sprintf(";n%d-%d", lo, hi)
+	}
+	if len(p.DislikedStyles) > 0 {
+		disliked := make([]string, len(p.DislikedStyles))
+		for i, s := range p.DislikedStyles {
+			disliked[i] = strings.Join(strings.Fields(strings.ToLower(s)), "-")
+		}
+		slices.Sort(disliked)
+		key += ";no-" + strings.Join(disliked, "+")
+	}
+	if p.MaxPrice > 0 {
+		key += fmt.Sprintf(";max%d", p.MaxPrice)
+	}
+	if p.AlcoholFree {
+		key += ";na"
+	}
+	if p.Market != "" {
+		key += ";m" + string(p.Market)
+	}
+	return key
+}
+
+// Hash returns a short hex SHA-256 of Key, for cache keys that must stay
+// bounded and free of the free-text disliked styles. Equal preferences always
+// hash the same, however their lists are ordered.
+func (p PairingPreferences) Hash() string {
+	sum := sha256.Sum256([]byte(p.Key()))
+	return hex.EncodeToString(sum[:8])
+}
+
+// ExclusionNote explains why a wine that would otherwise have been suggested
+// was left out by a wine type filter.
+type ExclusionNote struct {
+	Style  string `json:"style"`
+	Reason string `json:"reason"`
+}
+
+// ParseWineTypes parses a comma-separated list of wine types such as
+// "white,rosé". An empty list is a nil slice.
+func ParseWineTypes(list string) ([]WineType, error) {
+	var types []WineType
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(strings.ReplaceAll(name, "é", "e")))
+		if name == "" {
+			continue
+		}
+		t := WineType(name)
+		switch t {
+		case WineTypeRed, WineTypeWhite, WineTypeRose, WineTypeSparkling:
+		default:
+			return nil, fmt.Errorf("unknown wine type %q: must be red, white, rose, or sparkling", name)
+		}
+		if !slices.Contains(types, t) {
+			types = append(types, t)
+		}
+	}
+
+	return types, nil
+}
+
+// typeFilterPrompt returns the prompt block restricting suggestions to types
+// and asking for exclusion notes, or an empty string if types is empty.
+func typeFilterPrompt(types []WineType) string {
+	if len(types) == 0 {
+		return ""
+	}
+
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = string(t)
+	}
+
+	return fmt.Sprintf(`
+	<WINE_TYPES>
+	Only suggest %s wines.
+	</WINE_TYPES>
+
+	If a wine of another type would have been one of the strongest matches for
+	this dish, add it to "exclusions" with its style and a one-line reason
+	explaining what the user gives up by leaving it out.
+`, strings.Join(names, " or "))
+}
+
+// ApplyTypeFilter drops suggestions whose style is clearly a type outside
+// types, keeping those that can't be classified. Without a filter, any
+// exclusion notes are cleared since nothing was excluded.
+func ApplyTypeFilter(r *SuggestionsResponse, types []WineType) {
+	if len(types) == 0 {
+		r.Exclusions = nil
+		return
+	}
+
+	kept := []Suggestion{}
+	for _, s := range r.Suggestions {
+		if t := ClassifyWineType(s.Style); t == WineTypeOther || slices.Contains(types, t) {
+			kept = append(kept, s)
+		}
+	}
+	r.Suggestions = kept
+}
+
+// GeneratePairingSuggestionsForSummary works like GeneratePairingSuggestions,
+// and also guides the model toward the CandidateStyles for the summary's
+// flavor profile.
+func GeneratePairingSuggestionsForSummary(ctx context.Context, model llms.Model, summary Summary, opts ...llms.CallOption) (string, error) {
+	return GeneratePairingSuggestionsForOccasion(ctx, model, summary, "", opts...)
+}
+
+// GeneratePairingSuggestionsForOccasion works like
+// GeneratePairingSuggestionsForSummary, and also tailors the tone and price
+// range of the suggestions to the occasion.
+func GeneratePairingSuggestionsForOccasion(ctx context.Context, model llms.Model, summary Summary, occasion Occasion, opts ...llms.CallOption) (string, error) {
+	return GeneratePairingSuggestionsForPreferences(ctx, model, summary, PairingPreferences{Occasion: occasion}, opts...)
+}
+
+// GeneratePairingSuggestionsForPreferences works like
+// GeneratePairingSuggestionsForOccasion, and also writes the suggestions in
+// the voice of prefs.Persona. The array output has no room for exclusion
+// notes, so prefs.Types is left to V2.
+func GeneratePairingSuggestionsForPreferences(ctx context.Context, model llms.Model, summary Summary, prefs PairingPreferences, opts ...llms.CallOption) (string, error) {
+	answer, err := generateNonEmpty(ctx, model, pairingSuggestionsPrompt(summary, prefs),
+		withDefaultMaxTokens(model, DefaultSuggestionsMaxTokens, opts)...)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate wine suggestions: %w", err)
+	}
+
+	return answer, nil
+}
+
+// servingsPrompt returns the prompt block asking for quantity guidance for a
+// recipe serving servings people, or an empty string if that's unknown.
+func servingsPrompt(servings int) string {
+	if servings <= 0 {
+		return ""
+	}
+
+	return fmt.Sprintf(`
+	<SERVINGS>
+	The recipe serves %d. Where it helps, say in the pairing note how much to
+	pour or buy, e.g. a glass each or a bottle for the table (a bottle pours
+	about five glasses).
+	</SERVINGS>
+`, servings)
+}
+
+// pairingSuggestionsPrompt builds the V1 prompt asking for a JSON array of
+// suggestions for summary, tailored to prefs.
+func pairingSuggestionsPrompt(summary Summary, prefs PairingPreferences) string {
+	guidance := alcoholFreePrompt(prefs) + occasionPrompt(prefs.Occasion) + profilePrompt(prefs) + marketPrompt(prefs.Market) + servingsPrompt(summary.Servings)
+	if candidates := CandidateStyles(summary.FlavorProfile); !prefs.AlcoholFree && len(candidates) > 0 {
+		guidance += fmt.Sprintf(`
+	<CANDIDATE_STYLES>
+	%s
+	</CANDIDATE_STYLES>
+
+	Classic pairing rules for this dish's flavor profile point to the candidate
+	styles above. Start from them, but include other styles if they clearly suit
+	the dish better.
+`, strings.Join(candidates, ", "))
+	}
+
+	return fmt.Sprintf(`
+	%s
+
+	<RECIPE_SUMMARY>
+	%s
+	</RECIPE_SUMMARY>
+%s
+	Generate %s wine pairings as JSON array. For each wine:
+	- Match the dish's weight and primary flavors
+	- Choose wines available at most wine shops
+	- Explain pairing logic simply
+	- Rate your confidence in the pairing from 0.0 (speculative) to 1.0 (classic match)
+
+	JSON format (exact structure required):
+	[
+		{
+			"style": "wine style name",
+			"region": "specific region",
+			"description": "one sentence about the wine",
+			"pairingNote": "one sentence why it pairs well",
+			"confidence": number
+		}
+	]
+
+	Example:
+	[
+		{
+			"style": "Cabernet Sauvignon",
+			"region": "Washington State",
+			"description": "Full-bodied red with dark fruit and moderate tannins.",
+			"pairingNote": "The wine's structure complements the rich beef while fruit balances the umami.",
+			"confidence": 0.9
+		}
+	]`,
+		suggestionRole(prefs),
+		summary.Summary,
+		guidance,
+		countPrompt(prefs),
+	)
+}
+
+// GeneratePairingSuggestionsWithLookup works like GeneratePairingSuggestions,
+// but lets the model check regions and producers with lookup, e.g. a wine
+// database tool, before answering. It runs a small agent limited to that one
+// tool. With a nil lookup, or if the agent doesn't finish, it falls back to the
+// plain single-prompt GeneratePairingSuggestions.
+func GeneratePairingSuggestionsWithLookup(ctx context.Context, model llms.Model, summary string, lookup tools.Tool) (string, error) {
+	if lookup == nil {
+		return GeneratePairingSuggestions(ctx, model, summary)
+	}
+
+	prompt := pairingSuggestionsPrompt(Summary{Ok: true, Summary: summary}, PairingPreferences{}) + fmt.Sprintf(`
+
+	Before answering, use the %s tool to verify the region and any producer
+	you name for each wine. Drop or correct suggestions the tool contradicts.
+	Don't look up more than a few wines.
+
+	Prefix the JSON array with "Final Answer: ".`, lookup.Name())
+
+	agent := agents.NewOneShotAgent(model, []tools.Tool{lookup}, agents.WithMaxIterations(lookupMaxIterations))
+	result, err := chains.Run(ctx, agents.NewExecutor(agent), prompt)
+	if errors.Is(err, agents.ErrNotFinished) {
+		log.Printf("lookup agent did not finish; generating suggestions without %s\n", lookup.Name())
+		return GeneratePairingSuggestions(ctx, model, summary)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to generate grounded wine suggestions: %v", err)
+	}
+
+	if i := strings.LastIndex(result, "Final Answer:"); i >= 0 {
+		result = result[i+len("Final Answer:"):]
+	}
+	return extractJSONArray(result), nil
+}
+
+// lookupMaxIterations bounds the tool calls GeneratePairingSuggestionsWithLookup
+// makes before giving up on the agent.
+const lookupMaxIterations = 4
+
+// GeneratePairingSuggestionsTyped generates suggestions like
+// GeneratePairingSuggestionsForSummary and parses them, for callers that
+// don't need the raw model output for caching.
+func GeneratePairingSuggestionsTyped(ctx context.Context, model llms.Model, summary Summary, opts ...llms.CallOption) ([]Suggestion, error) {
+	answer, err := GeneratePairingSuggestionsForSummary(ctx, model, summary, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseSuggestions(extractJSONArray(answer))
+}
+
+// extractJSONArray strips code fences and any prose around the outermost JSON
+// array in model output.
+func extractJSONArray(output string) string {
+	output = strings.ReplaceAll(output, "```json", "")
+	output = strings.ReplaceAll(output, "```", "")
+	output = strings.TrimSpace(output)
+	start, end := strings.Index(output, "["), strings.LastIndex(output, "]")
+	if start >= 0 && end > start {
+		output = output[start : end+1]
+	}
+
+	return output
+}
+
+// inventoryProfilePrompt returns the prompt block ordering the inventory by
+// the user's profile, or an empty string if it has no types or disliked
+// styles. The budget doesn't apply to wines the user already owns.
+func inventoryProfilePrompt(profile PreferenceProfile) string {
+	var lines []string
+	if len(profile.Types) > 0 {
+		names := make([]string, len(profile.Types))
+		for i, t := range profile.Types {
+			names[i] = string(t)
+		}
+		lines = append(lines, fmt.Sprintf("The user prefers %s wines; rank them ahead of others that pair about as well.", strings.Join(names, " or ")))
+	}
+	if len(profile.DislikedStyles) > 0 {
+		lines = append(lines, fmt.Sprintf("The user dislikes these styles; rank them last: %s.", strings.Join(profile.DislikedStyles, ", ")))
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf(`
+	<USER_PROFILE>
+	%s
+	</USER_PROFILE>
+`, strings.Join(lines, "\n\t"))
+}
+
+// PairFromInventory ranks the wines the user already owns against the recipe
+// summary instead of suggesting new ones. Each suggestion's Style is one of
+// the inventory entries and Rank orders them from best (1) to worst match.
+// Suggestions for wines that aren't in the inventory are dropped. The user's
+// profile moves preferred types up and disliked styles down the ranking.
+func PairFromInventory(ctx context.Context, model llms.Model, summary string, inventory []string, profile PreferenceProfile, opts ...llms.CallOption) ([]Suggestion, error) {
+	if len(inventory) == 0 {
+		return nil, fmt.Errorf("inventory cannot be empty")
+	}
+
+	prompt := fmt.Sprintf(`
+	Rank the wines the user already owns by how well they pair with this dish.
+	Only use wines from the inventory; do not suggest any others.
+
+	<RECIPE_SUMMARY>
+	%s
+	</RECIPE_SUMMARY>
+
+	<INVENTORY>
+	- %s
+	</INVENTORY>
+%s
+	Return every inventory wine as a JSON array, best match first. For each wine:
+	- Copy the wine exactly as written in the inventory into "style"
+	- Rank from 1 (best match) upward
+	- Explain pairing logic simply, including why weaker matches fall short
+	- Rate your confidence in the pairing from 0.0 (speculative) to 1.0 (classic match)
+
+	JSON format (exact structure required):
+	[
+		{
+			"style": "inventory wine, exactly as written",
+			"region": "region, if known from the wine name, otherwise empty",
+			"description": "one sentence about the wine",
+			"pairingNote": "one sentence on how it pairs with the dish",
+			"confidence": number,
+			"rank": number
+		}
+	]`,
+		summary,
+		strings.Join(inventory, "\n\t- "),
+		inventoryProfilePrompt(profile),
+	)
+
+	answer, err := generateNonEmpty(ctx, model, prompt,
+		withDefaultMaxTokens(model, DefaultSuggestionsMaxTokens, opts)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rank wine inventory: %w", err)
+	}
+
+	parsed, err := ParseSuggestions(extractJSONArray(answer))
+	if err != nil {
+		return nil, err
+	}
+
+	owned := make(map[string]bool, len(inventory))
+	for _, wine := range inventory {
+		owned[strings.ToLower(strings.TrimSpace(wine))] = true
+	}
+	ranked := []Suggestion{}
+	for _, s := range parsed {
+		if owned[strings.ToLower(strings.TrimSpace(s.Style))] {
+			ranked = append(ranked, s)
+		}
+	}
+	slices.SortStableFunc(ranked, func(a, b Suggestion) int {
+		return a.Rank - b.Rank
+	})
+
+	return ranked, nil
+}
+
+// ExplainPairing asks the model for a longer explanation, two to three
+// paragraphs of plain text, of why the wine style from region suits the
+// recipe summary. It is meant for readers new to wine. Output is capped at
+// DefaultSummaryMaxTokens unless opts sets another limit.
+func ExplainPairing(ctx context.Context, model llms.Model, summary, style, region string, opts ...llms.CallOption) (string, error) {
+	prompt := fmt.Sprintf(`
+	You are explaining a wine pairing to someone new to wine.
+
+	<RECIPE_SUMMARY>
+	%s
+	</RECIPE_SUMMARY>
+
+	<WINE>
+	%s from %s
+	</WINE>
+
+	In 2-3 short paragraphs, explain why this wine works with this dish:
+	- What the wine tastes like and how its body, acidity, tannin, or sweetness
+	  meet the dish's main flavors and weight
+	- Which ingredients or cooking methods it plays off, by name
+	- What to look for on the label or shelf, and a serving tip
+
+	Avoid jargon, or explain it when you use it. Answer in plain text, without
+	headings, lists, or markdown.
+	`, summary, style, region)
+
+	answer, err := generateNonEmpty(ctx, model, prompt,
+		withDefaultMaxTokens(model, DefaultSummaryMaxTokens, opts)...)
+	if err != nil {
+		return "", fmt.Errorf("failed to explain wine pairing: %w", err)
+	}
+
+	return strings.TrimSpace(answer), nil
 }
 
-// Suggestion models a wine pairing recommendation from an LLM prompt response
-// encoded in JSON format.
-type Suggestion struct {
-	Style       string `json:"style"`
-	Region      string `json:"region"`
-	Description string `json:"description"`
-	PairingNote string `json:"pairingNote"`
+// RecipeComparison contrasts the wine pairings for two recipes: wines that
+// suit both, wines that only suit one, and a short note on the difference.
+type RecipeComparison struct {
+	Shared []Suggestion `json:"shared"`
+	OnlyA  []Suggestion `json:"onlyA"`
+	OnlyB  []Suggestion `json:"onlyB"`
+	Note   string       `json:"note"`
 }
 
-// SummarizeRecipe takes a markdown representation of a recipe published on the
-// Internet and returns a summary that would be helpful to someone making wine
-// pairing recommendations for that recipe.
-func SummarizeRecipe(ctx context.Context, model llms.Model, markdown string) (string, error) {
+// CompareRecipes asks the model which wines pair with both recipes a and b and
+// which only suit one of them, e.g. to choose between two dishes for one
+// bottle. Output is capped at DefaultSuggestionsMaxTokens unless opts sets
+// another limit.
+func CompareRecipes(ctx context.Context, model llms.Model, a, b Summary, opts ...llms.CallOption) (RecipeComparison, error) {
 	prompt := fmt.Sprintf(`
-	Summarize this recipe for wine pairing. Focus on flavors and key ingredients.
+	Compare wine pairings for two recipes.
 
-	<RECIPE>
+	<RECIPE_A>
 	%s
-	</RECIPE>
+	</RECIPE_A>
 
-	Create a one-paragraph summary highlighting:
-	- Primary flavors (sweet, salty, acidic, bitter, umami)
-	- Cooking methods (grilled, braised, roasted, etc.)
-	- Key ingredients by flavor impact (most important first)
-	- Sauce/seasoning profile
-	- Overall dish weight (light, medium, heavy)
+	<RECIPE_B>
+	%s
+	</RECIPE_B>
 
-	Respond in this exact JSON format:
+	Suggest 2-4 wines that pair well with both recipes, 2-3 that only suit
+	recipe A, and 2-3 that only suit recipe B. Choose wines available at most
+	wine shops. In each pairingNote, say which dish (or both) it suits and
+	why, in one sentence. End with a one or two sentence note on what sets the
+	two dishes apart for wine.
+
+	JSON format (exact structure required):
 	{
-		"ok": boolean,
-		"abortReason": string,
-		"summary": string
+		"shared": [
+			{
+				"style": "wine style name",
+				"region": "specific region",
+				"description": "one sentence about the wine",
+				"pairingNote": "one sentence on how it pairs",
+				"confidence": number
+			}
+		],
+		"onlyA": [ ...same structure ],
+		"onlyB": [ ...same structure ],
+		"note": "what sets the two dishes apart for wine"
 	}
 
-	Success: {"ok": true, "abortReason": "", "summary": "This hearty beef stew features..."}
-	Failure: {"ok": false, "abortReason": "Not a recipe", "summary": ""}
-
-	Abort if content is:
-	- Not food/recipe related
-	- Unsafe/malicious
-	- Too unclear to summarize
-	`, markdown)
-
-	summary, err := llms.GenerateFromSinglePrompt(
-		ctx,
-		model,
-		prompt,
-	)
+	Don't explain your answer after the JSON.
+	`, a.Summary, b.Summary)
 
+	answer, err := generateNonEmpty(ctx, model, prompt,
+		withDefaultMaxTokens(model, DefaultSuggestionsMaxTokens, opts)...)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate recipe summary: %w", err)
+		return RecipeComparison{}, fmt.Errorf("failed to compare recipes: %w", err)
+	}
 
+	var c RecipeComparison
+	if err := json.Unmarshal([]byte(cleanAgentOutput(answer)), &c); err != nil {
+		return RecipeComparison{}, fmt.Errorf("unable to parse recipe comparison: %v", err)
 	}
 
-	return summary, nil
+	return c, nil
 }
 
-// ParseSummary parses LLM output into Go types using JSON type annotations
-// from Summary.
-// TODO: Use https://pkg.go.dev/github.com/tmc/langchaingo@v0.1.14/outputparser#Structured
-func ParseSummary(output string) (Summary, error) {
-	var s Summary
-	if err := json.Unmarshal([]byte(output), &s); err != nil {
-		return s, fmt.Errorf("unable to parse Summary output: %v", err)
-	}
+// TopSuggestions returns up to n suggestions, most confident first, without
+// changing suggestions.
+func TopSuggestions(suggestions []Suggestion, n int) []Suggestion {
+	top := slices.Clone(suggestions)
+	slices.SortStableFunc(top, func(a, b Suggestion) int {
+		return cmp.Compare(b.Confidence, a.Confidence)
+	})
 
-	return s, nil
+	return TruncateSuggestions(top, n)
 }
 
-// GeneratePairingSuggestions takes a summary of a recipe and generates wine pairing suggestions.
-// The prompt directs the model to return suggestions in JSON format conforming to the type specified
-// by Suggestion.
-func GeneratePairingSuggestions(ctx context.Context, model llms.Model, summary string) (string, error) {
+// PoorFitScore is the fit score below which ScorePairings flags a suggestion
+// as a poor match for the recipe.
+const PoorFitScore = 0.4
+
+// ScoredSuggestion is a suggestion with the model's second opinion of how well
+// it fits the recipe, from 0 (clashes) to 1 (ideal).
+type ScoredSuggestion struct {
+	Suggestion
+	FitScore float64 `json:"fitScore"`
+	FitNote  string  `json:"fitNote,omitempty"`
+	// Flagged is set when FitScore is below PoorFitScore.
+	Flagged bool `json:"flagged,omitempty"`
+}
+
+// ScorePairings asks the model to rate how well each suggestion fits the
+// recipe summary's flavor profile, as a sanity check that catches mismatches
+// such as a heavy red with delicate fish. Suggestions are returned best fit
+// first; ties keep their original order. It fails if the model doesn't score
+// every suggestion. Output is capped at DefaultSuggestionsMaxTokens unless
+// opts sets another limit.
+func ScorePairings(ctx context.Context, model llms.Model, summary string, s []Suggestion, opts ...llms.CallOption) ([]ScoredSuggestion, error) {
+	if len(s) == 0 {
+		return []ScoredSuggestion{}, nil
+	}
+
+	var wines strings.Builder
+	for i, suggestion := range s {
+		fmt.Fprintf(&wines, "\t%d. %s (%s): %s\n", i+1, suggestion.Style, suggestion.Region, suggestion.PairingNote)
+	}
+
 	prompt := fmt.Sprintf(`
-	Suggest approachable wine pairings for this dish. Focus on accessible wines people can actually find.
+	You are a sommelier double-checking wine pairings before they are served.
 
 	<RECIPE_SUMMARY>
 	%s
 	</RECIPE_SUMMARY>
 
-	Generate 5-10 wine pairings as JSON array. For each wine:
-	- Match the dish's weight and primary flavors
-	- Choose wines available at most wine shops
-	- Explain pairing logic simply
+	<PAIRINGS>
+	%s	</PAIRINGS>
 
-	JSON format (exact structure required):
+	Score how well each wine fits the dish from 0 to 1. Weigh the wine's body,
+	acidity, tannin, and sweetness against the dish's weight, fat, acidity,
+	spice, and main flavors. Score clear mismatches, such as a heavy tannic red
+	with delicate fish or a dry wine with a sweet dessert, below 0.4. Give a
+	one-sentence note on each score.
+
+	JSON format (exact structure required), one entry per pairing:
 	[
 		{
-			"style": "wine style name",
-			"region": "specific region",
-			"description": "one sentence about the wine",
-			"pairingNote": "one sentence why it pairs well"
+			"index": pairing number,
+			"score": number,
+			"note": "one sentence on the fit"
 		}
 	]
 
-	Example:
-	[
-		{
-			"style": "Cabernet Sauvignon",
-			"region": "Washington State",
-			"description": "Full-bodied red with dark fruit and moderate tannins.",
-			"pairingNote": "The wine's structure complements the rich beef while fruit balances the umami."
-		}
-	]`,
-		summary,
-	)
+	Don't explain your answer after the JSON.
+	`, summary, wines.String())
 
-	answer, err := llms.GenerateFromSinglePrompt(ctx, model, prompt)
+	answer, err := generateNonEmpty(ctx, model, prompt,
+		withDefaultMaxTokens(model, DefaultSuggestionsMaxTokens, opts)...)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate wine suggestions: %v", err)
+		return nil, fmt.Errorf("failed to score wine pairings: %w", err)
 	}
 
-	return answer, nil
+	var scores []struct {
+		Index int     `json:"index"`
+		Score float64 `json:"score"`
+		Note  string  `json:"note"`
+	}
+	if err := json.Unmarshal([]byte(extractJSONArray(answer)), &scores); err != nil {
+		return nil, fmt.Errorf("unable to parse pairing scores: %v", err)
+	}
+
+	scored := make([]ScoredSuggestion, len(s))
+	seen := make([]bool, len(s))
+	for _, score := range scores {
+		i := score.Index - 1
+		if i < 0 || i >= len(s) || seen[i] {
+			continue
+		}
+		seen[i] = true
+		fit := min(max(score.Score, 0), 1)
+		scored[i] = ScoredSuggestion{
+			Suggestion: s[i],
+			FitScore:   fit,
+			FitNote:    strings.TrimSpace(score.Note),
+			Flagged:    fit < PoorFitScore,
+		}
+	}
+	if missing := slices.Index(seen, false); missing >= 0 {
+		return nil, fmt.Errorf("model didn't score pairing %d of %d", missing+1, len(s))
+	}
+
+	slices.SortStableFunc(scored, func(a, b ScoredSuggestion) int {
+		return cmp.Compare(b.FitScore, a.FitScore)
+	})
+
+	return scored, nil
+}
+
+// StreamPairingSuggestions generates suggestions like GeneratePairingSuggestionsForSummary
+// while streaming the model output. onSuggestion is called with each suggestion
+// as soon as its JSON object has fully arrived, so callers can show results
+// before generation finishes. It returns the complete model output.
+func StreamPairingSuggestions(ctx context.Context, model llms.Model, summary Summary, onSuggestion func(Suggestion) error, opts ...llms.CallOption) (string, error) {
+	return StreamPairingSuggestionsForPreferences(ctx, model, summary, PairingPreferences{}, onSuggestion, opts...)
+}
+
+// StreamPairingSuggestionsForPreferences works like StreamPairingSuggestions,
+// tailoring the suggestions to prefs as GeneratePairingSuggestionsForPreferences
+// does.
+func StreamPairingSuggestionsForPreferences(ctx context.Context, model llms.Model, summary Summary, prefs PairingPreferences, onSuggestion func(Suggestion) error, opts ...llms.CallOption) (string, error) {
+	var scanner suggestionScanner
+	stream := llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+		for _, s := range scanner.Write(chunk) {
+			if err := onSuggestion(s); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return GeneratePairingSuggestionsForPreferences(ctx, model, summary, prefs, append(opts, stream)...)
+}
+
+// suggestionScanner incrementally picks complete suggestion objects out of a
+// streamed JSON array. It tracks nesting and string state across chunks so
+// braces inside string values don't confuse it.
+type suggestionScanner struct {
+	buf      []byte
+	depth    int
+	start    int
+	inString bool
+	escaped  bool
+}
+
+// Write appends a chunk of streamed output and returns any suggestions
+// completed by it. Objects that fail to parse are skipped; the complete output
+// is still parsed by the caller once the stream ends.
+func (s *suggestionScanner) Write(chunk []byte) []Suggestion {
+	var done []Suggestion
+	offset := len(s.buf)
+	s.buf = append(s.buf, chunk...)
+
+	for i := offset; i < len(s.buf); i++ {
+		c := s.buf[i]
+		if s.inString {
+			switch {
+			case s.escaped:
+				s.escaped = false
+			case c == '\\':
+				s.escaped = true
+			case c == '"':
+				s.inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			s.inString = true
+		case '[', '{':
+			s.depth++
+			if c == '{' && s.depth == 2 {
+				s.start = i
+			}
+		case ']', '}':
+			if c == '}' && s.depth == 2 {
+				var suggestion Suggestion
+				if err := json.Unmarshal(s.buf[s.start:i+1], &suggestion); err == nil {
+					done = append(done, suggestion)
+				}
+			}
+			if s.depth > 0 {
+				s.depth--
+			}
+		}
+	}
+
+	return done
 }
 
-func GeneratePairingSuggestionsV2(ctx context.Context, model llms.Model, tools []tools.Tool, input string) (string, error) {
+func GeneratePairingSuggestionsV2(ctx context.Context, model llms.Model, tools []tools.Tool, input string, prefs PairingPreferences) (string, error) {
+	exclusionsFormat := ""
+	if len(prefs.Types) > 0 {
+		exclusionsFormat = `
+		"exclusions": [
+			{
+			"style": "excluded wine name",
+			"reason": "one line on the tradeoff of leaving it out"
+			}
+		],`
+	}
+
 	prompt := fmt.Sprintf(`
 	Generate wine pairings for the user's recipe input. Use tools to fetch and cache web content.
 
 	<USER_INPUT>
 	%s
 	</USER_INPUT>
-
+%s
 	Process:
 	1. Check if input is URL or recipe text
 	2. Validate input is actually about food/recipes - abort with error if not
@@ -217,7 +2079,7 @@ func GeneratePairingSuggestionsV2(ctx context.Context, model llms.Model, tools [
 	- Use HashRecipeSummary to get content hash
 	- Check CacheGet("recipes:summarized:<hash>") for existing wine-pairing summary  
 	- If no cached summary: Create recipe summary focusing on wine pairing elements, then CacheWrite("recipes:summarized:<hash>", summary)
-	7. If no cached pairings: Generate 5-10 new wine pairings.
+	7. If no cached pairings: Generate %s new wine pairings.
 
 	NOTE: FetchSite tool automatically handles caching raw HTML at
 	"recipes:raw:<URL>" and parsed Markdown at "recipes:parsed:<URL>". The model
@@ -234,6 +2096,11 @@ func GeneratePairingSuggestionsV2(ctx context.Context, model llms.Model, tools [
 	- Match dish weight and flavors
 	- Accessible wines from common shops
 	- Simple pairing explanations
+	- Rate your confidence in each pairing as a number from 0.0 (speculative) to 1.0 (classic match)
+
+	If no wine genuinely suits the dish (e.g. some very sweet desserts), don't
+	force pairings: return an empty "suggestions" array and explain why in
+	"note", suggesting what to drink instead if anything.
 
 	Don't explain your answer after you create the JSON. Let the JSON be the final answer.
 
@@ -244,10 +2111,12 @@ func GeneratePairingSuggestionsV2(ctx context.Context, model llms.Model, tools [
 			"style": "wine name",
 			"region": "wine region", 
 			"description": "one sentence wine description",
-			"pairingNote": "one sentence pairing reason"
+			"pairingNote": "one sentence pairing reason",
+			"confidence": 0.8
 			}
-		],
+		],%s
 		"summary": "one paragraph summary of the recipe highlighting flavors, cooking methods, key ingredients, and dish weight",
+		"note": "only when suggestions is empty: why no wine suits the dish",
 		"error": string or null
 	}
 
@@ -255,69 +2124,597 @@ func GeneratePairingSuggestionsV2(ctx context.Context, model llms.Model, tools [
 	- Non-recipe content (URLs or text): Return error "Content is not about food or recipes"
 	- Failed fetches: Return error
 	- Invalid input: Return error	 
-	`, input)
+	`, input, alcoholFreePrompt(prefs)+v2PersonaPrompt(prefs.Persona)+occasionPrompt(prefs.Occasion)+typeFilterPrompt(prefs.Types)+profilePrompt(prefs)+marketPrompt(prefs.Market), countPrompt(prefs), exclusionsFormat)
 
 	agent := agents.NewOneShotAgent(model, tools, agents.WithMaxIterations(3))
 	//executor := agents.NewExecutor(agent, agents.WithCallbacksHandler(callbacks.LogHandler{}))
-	executor := agents.NewExecutor(agent)
+	executor := agents.NewExecutor(agent, agents.WithReturnIntermediateSteps())
 
-	result, err := chains.Run(ctx, executor, prompt)
+	outputs, err := chains.Call(ctx, executor, map[string]any{"input": prompt})
+	if errors.Is(err, agents.ErrNotFinished) {
+		steps, _ := outputs["intermediateSteps"].([]schema.AgentStep)
+		if partial, ok := salvageAgentSteps(steps); ok {
+			log.Printf("agent hit max iterations; returning partial suggestions from step output")
+			return partial, nil
+		}
+		return "", fmt.Errorf("%w after %d steps", ErrAgentUnfinished, len(steps))
+	}
 	if err != nil {
 		return "", fmt.Errorf("agent run error: %v", err)
 	}
+	result, ok := outputs["output"].(string)
+	if !ok {
+		return "", fmt.Errorf("agent run error: unexpected output %T", outputs["output"])
+	}
+
+	return cleanAgentOutput(result), nil
+}
+
+// ErrAgentUnfinished is returned by GeneratePairingSuggestionsV2 when the
+// agent runs out of iterations without producing any usable suggestions.
+// Callers may fall back to GeneratePairingSuggestionsForSummary.
+var ErrAgentUnfinished = errors.New("agent did not finish before max iterations")
+
+// salvageAgentSteps looks through the reasoning of an unfinished agent run,
+// latest step first, for a suggestions object it wrote before running out of
+// iterations. It returns that object marked partial.
+func salvageAgentSteps(steps []schema.AgentStep) (string, bool) {
+	for i := len(steps) - 1; i >= 0; i-- {
+		r, err := ParseSuggestionsV2(cleanAgentOutput(steps[i].Action.Log))
+		if err != nil || (len(r.Suggestions) == 0 && r.Note == "") {
+			continue
+		}
+		r.Partial = true
+		out, err := json.Marshal(r)
+		if err != nil {
+			continue
+		}
+		return string(out), true
+	}
+
+	return "", false
+}
+
+// v2PersonaPrompt returns the prompt block setting the voice of V2 pairings,
+// or an empty string for the default persona, whose voice the V2 prompt
+// already describes.
+func v2PersonaPrompt(p Persona) string {
+	if isDefaultPersona(p) {
+		return ""
+	}
 
+	return fmt.Sprintf(`
+	<PERSONA>
+	%s
+	Write the descriptions and pairing notes in this voice.
+	</PERSONA>
+`, personaPrompt(p))
+}
+
+// agentTracePrefixes are the line prefixes a ReAct-style agent emits while
+// reasoning. They never belong in the final JSON answer.
+var agentTracePrefixes = []string{"Thought:", "Action:", "Action Input:", "Observation:"}
+
+// cleanAgentOutput strips code fences and intermediate agent reasoning from
+// the agent output and isolates the final JSON object.
+func cleanAgentOutput(result string) string {
 	result = strings.ReplaceAll(result, "```json", "")
 	result = strings.ReplaceAll(result, "```", "")
+	if i := strings.LastIndex(result, "Final Answer:"); i >= 0 {
+		result = result[i+len("Final Answer:"):]
+	}
+
 	var sb strings.Builder
 	for l := range strings.Lines(result) {
-		if strings.HasPrefix(result, "Thought:") ||
-			strings.HasPrefix(result, "Action:") ||
-			strings.HasPrefix(result, "Action Input:") {
+		trimmed := strings.TrimSpace(l)
+		skip := false
+		for _, prefix := range agentTracePrefixes {
+			if strings.HasPrefix(trimmed, prefix) {
+				skip = true
+				break
+			}
+		}
+		if skip {
 			continue
 		}
 
 		sb.WriteString(l)
 	}
 
-	return strings.TrimSpace(sb.String()), nil
+	out := strings.TrimSpace(sb.String())
+	start, end := strings.Index(out, "{"), strings.LastIndex(out, "}")
+	if start >= 0 && end > start {
+		out = out[start : end+1]
+	}
+
+	return out
 }
 
 type SuggestionsResponse struct {
-	Suggestions []Suggestion `json:"suggestions"`
-	Summary     string       `json:"summary"`
-	ErrorMsg    string       `json:"error,omitempty"`
+	Suggestions []Suggestion            `json:"suggestions"`
+	Groups      map[string][]Suggestion `json:"groups,omitempty"`
+	Exclusions  []ExclusionNote         `json:"exclusions,omitempty"`
+	Summary     string                  `json:"summary"`
+	ErrorMsg    string                  `json:"error,omitempty"`
+	// Note explains why Suggestions is empty when the model found no wine
+	// that genuinely suits the dish, rather than forcing a pairing.
+	Note string `json:"note,omitempty"`
+	// Partial is set when the agent ran out of iterations and these are the
+	// suggestions it had written so far, or when they were generated without
+	// the agent as a fallback.
+	Partial bool `json:"partial,omitempty"`
+	// Truncated is set when the model's output was cut off and its last,
+	// incomplete suggestion was dropped.
+	Truncated bool `json:"truncated,omitempty"`
+	// Market is the market the suggestions were chosen for, if any.
+	Market Market `json:"market,omitempty"`
+	// Fallback is set when the model was unavailable and these are a
+	// deployment's curated defaults rather than pairings chosen for the dish.
+	Fallback bool `json:"fallback,omitempty"`
 }
 
 func ParseSuggestionsV2(output string) (SuggestionsResponse, error) {
 	var r SuggestionsResponse
+	if strings.TrimSpace(output) == "" {
+		return r, ErrEmptyResponse
+	}
 	err := json.Unmarshal([]byte(output), &r)
 	if err != nil {
-		return r, fmt.Errorf("could not parse response: %v", err)
+		return r, fmt.Errorf("could not parse response: %w", err)
 	}
 
 	if r.ErrorMsg != "" {
 		return r, fmt.Errorf("agent detected an error: %v", r.ErrorMsg)
 	}
+	if r.Suggestions == nil {
+		// A response with only a note still renders "suggestions": []
+		r.Suggestions = []Suggestion{}
+	}
 
 	return r, nil
 }
 
+// SuggestionsToJSONLD renders suggestions for the recipe at recipeURL as
+// schema.org JSON-LD: an ItemList of wine Products about the Recipe, suitable
+// for a <script type="application/ld+json"> block.
+func SuggestionsToJSONLD(s SuggestionsResponse, recipeURL string) ([]byte, error) {
+	type property struct {
+		Type  string `json:"@type"`
+		Name  string `json:"name"`
+		Value any    `json:"value"`
+	}
+	type product struct {
+		Type                      string     `json:"@type"`
+		Name                      string     `json:"name"`
+		Category                  string     `json:"category"`
+		Description               string     `json:"description,omitempty"`
+		DisambiguatingDescription string     `json:"disambiguatingDescription,omitempty"`
+		AdditionalProperty        []property `json:"additionalProperty,omitempty"`
+	}
+	type listItem struct {
+		Type     string  `json:"@type"`
+		Position int     `json:"position"`
+		Item     product `json:"item"`
+	}
+	type recipe struct {
+		Type        string `json:"@type"`
+		URL         string `json:"url"`
+		Description string `json:"description,omitempty"`
+	}
+	type itemList struct {
+		Context         string     `json:"@context"`
+		Type            string     `json:"@type"`
+		Name            string     `json:"name"`
+		About           recipe     `json:"about"`
+		NumberOfItems   int        `json:"numberOfItems"`
+		ItemListElement []listItem `json:"itemListElement"`
+	}
+
+	list := itemList{
+		Context:         "https://schema.org",
+		Type:            "ItemList",
+		Name:            "Wine pairings",
+		About:           recipe{Type: "Recipe", URL: recipeURL, Description: s.Summary},
+		NumberOfItems:   len(s.Suggestions),
+		ItemListElement: make([]listItem, len(s.Suggestions)),
+	}
+	for i, suggestion := range s.Suggestions {
+		wine := product{
+			Type:                      "Product",
+			Name:                      suggestion.Style,
+			Category:                  "Wine",
+			Description:               suggestion.Description,
+			DisambiguatingDescription: suggestion.PairingNote,
+		}
+		if suggestion.Region != "" {
+			wine.AdditionalProperty = append(wine.AdditionalProperty, property{Type: "PropertyValue", Name: "region", Value: suggestion.Region})
+		}
+		// Styles that can't be classified get no wineType rather than "other"
+		if t := ClassifyWineType(suggestion.Style); t != WineTypeOther {
+			wine.AdditionalProperty = append(wine.AdditionalProperty, property{Type: "PropertyValue", Name: "wineType", Value: string(t)})
+		}
+		list.ItemListElement[i] = listItem{Type: "ListItem", Position: i + 1, Item: wine}
+	}
+
+	return json.Marshal(list)
+}
+
+// schemaEnums lists the values of the string types whose values are fixed, so
+// their schemas can enumerate them.
+var schemaEnums = map[reflect.Type][]string{
+	reflect.TypeFor[AbortCode](): {string(AbortNotFood), string(AbortUnsafe), string(AbortUnclear), string(AbortOther)},
+	reflect.TypeFor[WineType]():  {string(WineTypeRed), string(WineTypeWhite), string(WineTypeRose), string(WineTypeSparkling), string(WineTypeOther)},
+}
+
+// JSONSchema describes the JSON encoding of v, e.g. a SuggestionsResponse or
+// Summary, as a JSON Schema derived from its struct tags, so clients can
+// validate responses or generate types. Fields without omitempty are
+// required.
+func JSONSchema(v any, title string) ([]byte, error) {
+	schema := typeSchema(reflect.TypeOf(v))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = title
+	return json.Marshal(schema)
+}
+
+// typeSchema returns the JSON Schema for values of t.
+func typeSchema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		properties := map[string]any{}
+		required := []string{}
+		for i := range t.NumField() {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" && opts == "" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			properties[name] = typeSchema(f.Type)
+			if !slices.Contains(strings.Split(opts, ","), "omitempty") {
+				required = append(required, name)
+			}
+		}
+		return map[string]any{
+			"type":                 "object",
+			"properties":           properties,
+			"required":             required,
+			"additionalProperties": false,
+		}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.String:
+		if values, ok := schemaEnums[t]; ok {
+			return map[string]any{"type": "string", "enum": values}
+		}
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	default:
+		return map[string]any{}
+	}
+}
+
 // ParseSuggestions parses LLM output into a Go type using JSON type annotations
-// from Suggestion.
+// from Suggestion. It fails if any suggestion is malformed; see
+// ParseSuggestionsWithOptions to skip bad items instead.
 func ParseSuggestions(output string) ([]Suggestion, error) {
-	var parsed []Suggestion
-	if err := json.Unmarshal([]byte(output), &parsed); err != nil {
-		return parsed, fmt.Errorf("suggestion parse error: %v", err)
+	return ParseSuggestionsWithOptions(output, ParseOptions{Strict: true})
+}
+
+// ParseOptions controls how ParseSuggestionsWithOptions handles malformed
+// suggestions.
+type ParseOptions struct {
+	// Strict fails the whole parse on the first malformed suggestion. When
+	// false, malformed suggestions are skipped.
+	Strict bool
+	// OnItemError, if set, is called with each malformed suggestion skipped
+	// in lenient mode, e.g. to log it.
+	OnItemError func(*ItemError)
+	// Tolerant recovers the complete suggestions from output that ends
+	// partway through the array, as it does when the model runs out of
+	// tokens, dropping the incomplete one at the end.
+	Tolerant bool
+}
+
+// ParseResult is what ParseSuggestionsResult recovered from model output.
+type ParseResult struct {
+	Suggestions []Suggestion `json:"suggestions"`
+	// Truncated is set when tolerant parsing dropped an incomplete
+	// suggestion at the end of the output.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// ItemError reports a suggestion that couldn't be parsed and its index in the
+// output array.
+type ItemError struct {
+	Index int
+	Err   error
+}
+
+func (e *ItemError) Error() string {
+	return fmt.Sprintf("suggestion %d: %v", e.Index, e.Err)
+}
+
+func (e *ItemError) Unwrap() error {
+	return e.Err
+}
+
+// ParseSuggestionsWithOptions parses each suggestion in the output array
+// separately so one malformed item can be identified, and in lenient mode
+// skipped while the valid ones are kept. Strict mode returns an *ItemError for
+// the first malformed item. Either mode fails if the output isn't an array,
+// with ErrEmptyResponse if it is blank.
+func ParseSuggestionsWithOptions(output string, opts ParseOptions) ([]Suggestion, error) {
+	result, err := ParseSuggestionsResult(output, opts)
+	return result.Suggestions, err
+}
+
+// ParseSuggestionsResult parses output like ParseSuggestionsWithOptions and
+// also reports whether tolerant parsing had to drop a truncated suggestion.
+// Output truncated before its first complete suggestion is still an error.
+func ParseSuggestionsResult(output string, opts ParseOptions) (ParseResult, error) {
+	var result ParseResult
+	if strings.TrimSpace(output) == "" {
+		return result, ErrEmptyResponse
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal([]byte(output), &items); err != nil {
+		recovered, truncated := truncatedArrayItems(output)
+		if !opts.Tolerant || !truncated || len(recovered) == 0 {
+			return result, fmt.Errorf("suggestion parse error: %v", err)
+		}
+		items, result.Truncated = recovered, true
+	}
+
+	result.Suggestions = make([]Suggestion, 0, len(items))
+	for i, item := range items {
+		var s Suggestion
+		if err := json.Unmarshal(item, &s); err != nil {
+			itemErr := &ItemError{Index: i, Err: err}
+			if opts.Strict {
+				return result, fmt.Errorf("suggestion parse error: %w", itemErr)
+			}
+			if opts.OnItemError != nil {
+				opts.OnItemError(itemErr)
+			}
+			continue
+		}
+		result.Suggestions = append(result.Suggestions, s)
+	}
+
+	return result, nil
+}
+
+// truncatedArrayItems returns the complete items of a JSON array that ends
+// before its closing bracket. It reports false if output is malformed in any
+// other way.
+func truncatedArrayItems(output string) ([]json.RawMessage, bool) {
+	dec := json.NewDecoder(strings.NewReader(output))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return nil, false
+	}
+
+	// The decoder reports input ending between items as a syntax error at
+	// the end of the input rather than as an EOF.
+	endedEarly := func(err error) bool {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return syntaxErr.Offset >= int64(len(output)) && strings.HasPrefix(syntaxErr.Error(), "unexpected end")
+		}
+		return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	}
+
+	var items []json.RawMessage
+	for dec.More() {
+		var item json.RawMessage
+		if err := dec.Decode(&item); err != nil {
+			return items, endedEarly(err)
+		}
+		items = append(items, item)
+	}
+	_, err := dec.Token()
+	return items, endedEarly(err)
+}
+
+// FilterByConfidence keeps the suggestions with a confidence of at least min,
+// in their original order. If none qualify, it returns only the most confident
+// suggestion and reports that it fell back so callers can explain why.
+func FilterByConfidence(suggestions []Suggestion, min float64) ([]Suggestion, bool) {
+	if len(suggestions) == 0 {
+		return suggestions, false
+	}
+
+	kept := []Suggestion{}
+	best := suggestions[0]
+	for _, s := range suggestions {
+		if s.Confidence >= min {
+			kept = append(kept, s)
+		}
+		if s.Confidence > best.Confidence {
+			best = s
+		}
+	}
+
+	if len(kept) == 0 {
+		return []Suggestion{best}, true
+	}
+
+	return kept, false
+}
+
+// WineType is a coarse classification of a wine style used to group
+// suggestions for display.
+type WineType string
+
+const (
+	WineTypeRed       WineType = "red"
+	WineTypeWhite     WineType = "white"
+	WineTypeRose      WineType = "rose"
+	WineTypeSparkling WineType = "sparkling"
+	WineTypeOther     WineType = "other"
+)
+
+var accentReplacer = strings.NewReplacer("é", "e", "è", "e", "ê", "e", "ü", "u", "ñ", "n", "ô", "o", "â", "a")
+
+// wineTypeKeywords is checked in order, so more specific types (sparkling,
+// rosé) win over the grape-based red and white lists. For example, "Sparkling
+// Rosé" is sparkling and "White Zinfandel" is rosé.
+var wineTypeKeywords = []struct {
+	wineType WineType
+	keywords []string
+}{
+	{WineTypeSparkling, []string{"sparkling", "champagne", "prosecco", "cava", "cremant", "franciacorta", "lambrusco", "spumante", "sekt", "pet-nat", "petillant", "brut"}},
+	{WineTypeRose, []string{"rose", "rosado", "rosato", "blush", "white zinfandel"}},
+	{WineTypeWhite, []string{"white", "blanc", "chardonnay", "riesling", "pinot grigio", "pinot gris", "gewurztraminer", "viognier", "albarino", "gruner", "vermentino", "muscadet", "sancerre", "chablis", "semillon", "soave", "torrontes", "verdejo", "vinho verde", "marsanne", "roussanne", "moscato", "assyrtiko", "fiano", "garganega"}},
+	{WineTypeRed, []string{"red", "rouge", "tinto", "cabernet", "merlot", "pinot noir", "syrah", "shiraz", "zinfandel", "malbec", "tempranillo", "sangiovese", "grenache", "garnacha", "nebbiolo", "barbera", "chianti", "rioja", "beaujolais", "gamay", "rhone", "primitivo", "carmenere", "montepulciano", "dolcetto", "petite sirah", "mourvedre", "monastrell", "barolo", "barbaresco", "valpolicella", "amarone", "bordeaux", "claret", "cotes du rhone", "nero d'avola", "aglianico", "pinotage", "touriga"}},
+}
+
+// ClassifyWineType maps a free-form wine style (e.g. "Oregon Pinot Noir") to a
+// WineType by keyword matching. Styles it doesn't recognize are WineTypeOther.
+func ClassifyWineType(style string) WineType {
+	normalized := accentReplacer.Replace(strings.ToLower(style))
+	for _, group := range wineTypeKeywords {
+		for _, k := range group.keywords {
+			if strings.Contains(normalized, k) {
+				return group.wineType
+			}
+		}
+	}
+
+	return WineTypeOther
+}
+
+// GroupSuggestionsByType buckets suggestions by the WineType of their style,
+// preserving the original order within each bucket.
+func GroupSuggestionsByType(suggestions []Suggestion) map[string][]Suggestion {
+	groups := make(map[string][]Suggestion)
+	for _, s := range suggestions {
+		t := string(ClassifyWineType(s.Style))
+		groups[t] = append(groups[t], s)
+	}
+
+	return groups
+}
+
+// WineCount is how often a wine style or region was suggested.
+type WineCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// WineStats tallies suggestions across many suggestion sets. Styles and
+// Regions are ordered from most to least suggested, then by name.
+type WineStats struct {
+	Sets        int         `json:"sets"`
+	Suggestions int         `json:"suggestions"`
+	Styles      []WineCount `json:"styles"`
+	Regions     []WineCount `json:"regions"`
+}
+
+// Top returns the stats with Styles and Regions cut to the n most suggested.
+// A non-positive n keeps everything.
+func (s WineStats) Top(n int) WineStats {
+	if n > 0 {
+		s.Styles = s.Styles[:min(n, len(s.Styles))]
+		s.Regions = s.Regions[:min(n, len(s.Regions))]
+	}
+	return s
+}
+
+// AggregateSuggestions counts the styles and regions suggested across sets.
+// Names are compared ignoring case and surrounding space; each is reported
+// with the spelling it was first seen with. Blank names aren't counted.
+func AggregateSuggestions(sets [][]Suggestion) WineStats {
+	stats := WineStats{Sets: len(sets)}
+	styles := newWineTally()
+	regions := newWineTally()
+	for _, set := range sets {
+		stats.Suggestions += len(set)
+		for _, s := range set {
+			styles.add(s.Style)
+			regions.add(s.Region)
+		}
+	}
+	stats.Styles = styles.sorted()
+	stats.Regions = regions.sorted()
+
+	return stats
+}
+
+// wineTally counts names case-insensitively, keeping the first spelling.
+type wineTally struct {
+	counts map[string]*WineCount
+}
+
+func newWineTally() *wineTally {
+	return &wineTally{counts: make(map[string]*WineCount)}
+}
+
+func (t *wineTally) add(name string) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return
+	}
+	key := strings.ToLower(name)
+	c, ok := t.counts[key]
+	if !ok {
+		c = &WineCount{Name: name}
+		t.counts[key] = c
 	}
+	c.Count++
+}
 
-	return parsed, nil
+func (t *wineTally) sorted() []WineCount {
+	out := make([]WineCount, 0, len(t.counts))
+	for _, c := range t.counts {
+		out = append(out, *c)
+	}
+	slices.SortFunc(out, func(a, b WineCount) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return out
 }
 
 type anthropicResponse struct {
 	Key string `json:"ANTHROPIC_WINESUGGESTIONS"`
 }
 
+// awsSecrets keeps secrets read from Secrets Manager, by name, for
+// helpers.CredentialsTTL, so models made on warm Lambda invocations skip the
+// network.
+var awsSecrets sync.Map // map[string]*helpers.Memo[string]
+
+// getAWSSecret returns the Anthropic key stored in the named secret, reading
+// Secrets Manager at most once per helpers.CredentialsTTL.
 func getAWSSecret(secret string) (string, error) {
+	m, _ := awsSecrets.LoadOrStore(secret, &helpers.Memo[string]{})
+	return m.(*helpers.Memo[string]).Get(func() (string, time.Duration, error) {
+		key, err := fetchAWSSecret(secret)
+		return key, helpers.CredentialsTTL, err
+	})
+}
+
+func fetchAWSSecret(secret string) (string, error) {
 	ctx := context.Background()
 
 	config, err := config.LoadDefaultConfig(ctx)
@@ -0,0 +1,244 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// spyModel records the options of each call and answers with response, or
+// fails with err if it is set.
+type spyModel struct {
+	response string
+	err      error
+	calls    []llms.CallOptions
+}
+
+func (m *spyModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	var opts llms.CallOptions
+	for _, o := range options {
+		o(&opts)
+	}
+	m.calls = append(m.calls, opts)
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: m.response}}}, nil
+}
+
+func (m *spyModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+func TestMaxTokens(t *testing.T) {
+	ctx := context.Background()
+	summary := Summary{Ok: true, Summary: "Braised short ribs"}
+
+	for _, tc := range []struct {
+		name    string
+		wrap    func(llms.Model) llms.Model
+		opts    []llms.CallOption
+		summary int
+		suggest int
+	}{
+		{"package defaults", func(m llms.Model) llms.Model { return m }, nil, DefaultSummaryMaxTokens, DefaultSuggestionsMaxTokens},
+		{"configured cap", func(m llms.Model) llms.Model {
+			return applyOptions(m, []Option{WithMaxTokens(1234)})
+		}, nil, 1234, 1234},
+		{"configured cap behind wrappers", func(m llms.Model) llms.Model {
+			return NewRetryModel(&FallbackModel{Primary: applyOptions(m, []Option{WithMaxTokens(1234)}), Fallback: m})
+		}, nil, 1234, 1234},
+		{"call option over configured cap", func(m llms.Model) llms.Model {
+			return applyOptions(m, []Option{WithMaxTokens(1234)})
+		}, []llms.CallOption{llms.WithMaxTokens(99)}, 99, 99},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			spy := &spyModel{response: `{"ok": true, "summary": "ribs"}`}
+			model := tc.wrap(spy)
+			if _, err := SummarizeRecipe(ctx, model, "# Braised short ribs", tc.opts...); err != nil {
+				t.Fatalf("SummarizeRecipe: %v", err)
+			}
+			if _, err := GeneratePairingSuggestionsForSummary(ctx, model, summary, tc.opts...); err != nil {
+				t.Fatalf("GeneratePairingSuggestionsForSummary: %v", err)
+			}
+			if len(spy.calls) != 2 {
+				t.Fatalf("provider got %d calls, want 2", len(spy.calls))
+			}
+			if got := spy.calls[0].MaxTokens; got != tc.summary {
+				t.Errorf("summary MaxTokens = %d, want %d", got, tc.summary)
+			}
+			if got := spy.calls[1].MaxTokens; got != tc.suggest {
+				t.Errorf("suggestions MaxTokens = %d, want %d", got, tc.suggest)
+			}
+		})
+	}
+}
+
+func TestCandidateStyles(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		profile FlavorProfile
+		want    []string
+	}{
+		{"empty profile", FlavorProfile{}, nil},
+		{"light dish", FlavorProfile{Weight: 1}, []string{"Pinot Grigio", "Sauvignon Blanc", "Gamay", "Dry Rosé"}},
+		{"medium dish", FlavorProfile{Weight: 3}, []string{"Pinot Noir", "Grenache", "Chenin Blanc"}},
+		{"sweet dessert", FlavorProfile{Sweetness: 4, Weight: 2}, []string{"Moscato d'Asti", "Late Harvest Riesling", "Sauternes", "Pinot Grigio", "Sauvignon Blanc", "Gamay", "Dry Rosé"}},
+		{"acidic dish", FlavorProfile{Acidity: 4, Weight: 3}, []string{"Sauvignon Blanc", "Dry Riesling", "Albariño", "Sangiovese", "Pinot Noir", "Grenache", "Chenin Blanc"}},
+		// Shared styles are listed once, where first suggested
+		{"rich braise", FlavorProfile{Fat: 4, Weight: 5}, []string{"Cabernet Sauvignon", "Syrah", "Nebbiolo", "Champagne", "Oaked Chardonnay", "Malbec"}},
+		// Spice rules out the tannic reds fat would otherwise call for
+		{"fatty and spicy", FlavorProfile{Fat: 4, Spice: 4, Weight: 4}, []string{"Off-dry Riesling", "Gewürztraminer", "Zinfandel", "Champagne", "Oaked Chardonnay", "Malbec", "Syrah"}},
+		{"just below the thresholds", FlavorProfile{Sweetness: 2, Acidity: 3, Fat: 2, Spice: 2, Weight: 2}, []string{"Pinot Grigio", "Sauvignon Blanc", "Gamay", "Dry Rosé"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := CandidateStyles(tc.profile); !slices.Equal(got, tc.want) {
+				t.Errorf("CandidateStyles(%+v) = %q, want %q", tc.profile, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSuggestionsToJSONLDWineType(t *testing.T) {
+	out, err := SuggestionsToJSONLD(SuggestionsResponse{Suggestions: []Suggestion{
+		{Style: "Pinot Noir", Region: "Burgundy"},
+		{Style: "Something Unusual"},
+	}}, "https://example.com/recipe")
+	if err != nil {
+		t.Fatalf("SuggestionsToJSONLD: %v", err)
+	}
+
+	var list struct {
+		ItemListElement []struct {
+			Item struct {
+				AdditionalProperty []struct {
+					Name  string `json:"name"`
+					Value string `json:"value"`
+				} `json:"additionalProperty"`
+			} `json:"item"`
+		} `json:"itemListElement"`
+	}
+	if err := json.Unmarshal(out, &list); err != nil {
+		t.Fatalf("unable to decode JSON-LD: %v", err)
+	}
+	if len(list.ItemListElement) != 2 {
+		t.Fatalf("got %d items, want 2", len(list.ItemListElement))
+	}
+
+	wineTypes := func(i int) []string {
+		var types []string
+		for _, p := range list.ItemListElement[i].Item.AdditionalProperty {
+			if p.Name == "wineType" {
+				types = append(types, p.Value)
+			}
+		}
+		return types
+	}
+	if got := wineTypes(0); len(got) != 1 || got[0] != string(WineTypeRed) {
+		t.Errorf("Pinot Noir wineType = %v, want [red]", got)
+	}
+	if got := wineTypes(1); len(got) != 0 {
+		t.Errorf("unclassified style wineType = %v, want none", got)
+	}
+}
+
+func TestWithBedrockFallback(t *testing.T) {
+	claude, br := &spyModel{response: "claude"}, &spyModel{response: "bedrock"}
+	errNoKey := errors.New("unable to get an Anthropic key")
+	errNoAWS := errors.New("unable to load AWS SDK config")
+
+	// Without an Anthropic key every call goes to Bedrock
+	if m, err := withBedrockFallback(nil, errNoKey, br, nil); err != nil || m != br {
+		t.Errorf("without an Anthropic key = %v, %v; want the Bedrock model", m, err)
+	}
+	if m, err := withBedrockFallback(claude, nil, nil, errNoAWS); err != nil || m != claude {
+		t.Errorf("without Bedrock = %v, %v; want the Anthropic model", m, err)
+	}
+	if _, err := withBedrockFallback(nil, errNoKey, nil, errNoAWS); !errors.Is(err, errNoAWS) {
+		t.Errorf("without either = %v, want an error", err)
+	}
+
+	m, err := withBedrockFallback(claude, nil, br, nil)
+	fallback, ok := m.(*FallbackModel)
+	if err != nil || !ok || fallback.Primary != claude || fallback.Fallback != br {
+		t.Fatalf("with both = %#v, %v; want Anthropic falling back to Bedrock", m, err)
+	}
+}
+
+func TestFallbackModel(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name       string
+		primaryErr error
+		want       string
+		wantErr    bool
+	}{
+		{"primary serves", nil, "claude", false},
+		{"rate limited", errors.New("API returned unexpected status code: 429: rate limit exceeded"), "bedrock", false},
+		{"other errors aren't retried", errors.New("invalid request"), "", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			primary := &spyModel{response: "claude", err: tc.primaryErr}
+			m := &FallbackModel{Primary: primary, PrimaryName: "anthropic", Fallback: &spyModel{response: "bedrock"}, FallbackName: "bedrock"}
+			got, err := llms.GenerateFromSinglePrompt(ctx, m, "pair this")
+			if (err != nil) != tc.wantErr || got != tc.want {
+				t.Errorf("GenerateContent = %q, %v; want %q", got, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestReplayModel(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "fixture.json")
+
+	upstream := &spyModel{response: "recorded"}
+	recorder, err := NewRecordingModel(upstream, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := llms.GenerateFromSinglePrompt(ctx, recorder, "pair this"); err != nil || got != "recorded" {
+		t.Fatalf("recording = %q, %v; want upstream's response", got, err)
+	}
+	if len(upstream.calls) != 1 {
+		t.Fatalf("upstream called %d times, want 1", len(upstream.calls))
+	}
+
+	replay, err := NewReplayModel(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := llms.GenerateFromSinglePrompt(ctx, replay, "pair this"); err != nil || got != "recorded" {
+		t.Errorf("replay = %q, %v; want the recorded response", got, err)
+	}
+	if _, err := llms.GenerateFromSinglePrompt(ctx, replay, "pair that"); !errors.Is(err, ErrNoRecording) {
+		t.Errorf("unrecorded prompt = %v, want ErrNoRecording", err)
+	}
+	if len(upstream.calls) != 1 {
+		t.Errorf("replay called upstream; %d calls, want 1", len(upstream.calls))
+	}
+
+	// Recording again keeps what's already in the fixture
+	upstream.response = "second"
+	recorder, err = NewRecordingModel(upstream, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := llms.GenerateFromSinglePrompt(ctx, recorder, "pair that"); err != nil {
+		t.Fatal(err)
+	}
+	replay, err = NewReplayModel(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for prompt, want := range map[string]string{"pair this": "recorded", "pair that": "second"} {
+		if got, err := llms.GenerateFromSinglePrompt(ctx, replay, prompt); err != nil || got != want {
+			t.Errorf("replay %q = %q, %v; want %q", prompt, got, err, want)
+		}
+	}
+}
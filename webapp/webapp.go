@@ -1,24 +1,34 @@
 package webapp
 
 import (
+	"bufio"
+	"bytes"
+	"cmp"
 	"context"
+	"crypto/sha256"
 	"embed"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
 	"io"
+	"io/fs"
 	"log"
 	"math/rand/v2"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
-	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/golang-jwt/jwt/v5"
 	adapter "github.com/i2y/langchaingo-mcp-adapter"
@@ -28,19 +38,39 @@ import (
 	mcpserver "github.com/mark3labs/mcp-go/server"
 	"github.com/tmc/langchaingo/llms"
 	"github.com/tmc/langchaingo/tools"
+	"golang.org/x/net/websocket"
 
 	"github.com/thedahv/wine-pairing-suggestions/cache"
 	"github.com/thedahv/wine-pairing-suggestions/data"
 	"github.com/thedahv/wine-pairing-suggestions/helpers"
 	"github.com/thedahv/wine-pairing-suggestions/lambdahelpers"
+	wpmcp "github.com/thedahv/wine-pairing-suggestions/mcp"
 	"github.com/thedahv/wine-pairing-suggestions/models"
 )
 
 //go:embed templates/**/*.html
 var templates embed.FS
 
+// templatesRoot is the directory in templates holding the view templates.
 const templatesRoot = "templates"
 
+// requiredTemplates are the templates handlers look up by name, including
+// those pulled in by other templates, so that a build missing one fails at
+// startup rather than on the first request.
+var requiredTemplates = []string{
+	"pages/home.html",
+	"layouts/base.html",
+}
+
+//go:embed static
+var static embed.FS
+
+const staticRoot = "static"
+
+// staticCacheControl lets browsers reuse static assets for an hour. Asset
+// names aren't fingerprinted, so a deploy can take that long to reach them.
+const staticCacheControl = "public, max-age=3600"
+
 const sessionCookieName = "wine-suggestions-session"
 
 type contextKey string
@@ -49,6 +79,13 @@ const sessionContextName contextKey = "AccountId"
 const quotaContextName contextKey = "quota"
 const emailContextName contextKey = "email"
 const dynamoAccountContextName contextKey = "dynamoAccount"
+const apiKeyContextName contextKey = "apiKey"
+const apiKeyHeader = "X-API-Key"
+const modelContextName contextKey = "model"
+const modelHeader = "X-Model"
+const truncatedHeader = "X-Suggestions-Truncated"
+const responseMetaContextName contextKey = "responseMeta"
+const requestIDHeader = "X-Request-ID"
 const maxQuota = 10
 const maxQuotaLifespanSeconds = 60 * 60 * 24 * 7
 
@@ -58,6 +95,72 @@ func sessionQuotaKey(accountID string) string {
 	return fmt.Sprintf("quotas:%s", accountID)
 }
 
+// LogLevel controls which webapp log messages are written.
+type LogLevel int
+
+const (
+	LogLevelDebug LogLevel = iota
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+// ParseLogLevel parses a level name: debug (or trace), info, warn, or error.
+func ParseLogLevel(name string) (LogLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug", "trace":
+		return LogLevelDebug, nil
+	case "", "info":
+		return LogLevelInfo, nil
+	case "warn", "warning":
+		return LogLevelWarn, nil
+	case "error":
+		return LogLevelError, nil
+	default:
+		return LogLevelInfo, fmt.Errorf("unknown log level %q", name)
+	}
+}
+
+// leveledLogger writes messages at or above its level. Printf and Println log
+// at info level, so it can stand in for a *log.Logger.
+type leveledLogger struct {
+	logger *log.Logger
+	level  LogLevel
+}
+
+// newLogger returns a logger with the given prefix at the webapp's log level.
+func (wa *Webapp) newLogger(prefix string) *leveledLogger {
+	return &leveledLogger{
+		logger: log.New(log.Default().Writer(), prefix, log.Default().Flags()),
+		level:  wa.logLevel,
+	}
+}
+
+func (l *leveledLogger) logf(level LogLevel, format string, v ...any) {
+	if level >= l.level {
+		l.logger.Output(3, fmt.Sprintf(format, v...))
+	}
+}
+
+// Debugf logs detail only useful while debugging, such as full payloads.
+func (l *leveledLogger) Debugf(format string, v ...any) { l.logf(LogLevelDebug, format, v...) }
+
+// Printf logs at info level.
+func (l *leveledLogger) Printf(format string, v ...any) { l.logf(LogLevelInfo, format, v...) }
+
+// Println logs at info level.
+func (l *leveledLogger) Println(v ...any) {
+	if LogLevelInfo >= l.level {
+		l.logger.Output(2, fmt.Sprintln(v...))
+	}
+}
+
+// Warnf logs recoverable problems.
+func (l *leveledLogger) Warnf(format string, v ...any) { l.logf(LogLevelWarn, format, v...) }
+
+// Errorf logs failures.
+func (l *leveledLogger) Errorf(format string, v ...any) { l.logf(LogLevelError, format, v...) }
+
 // getPathValue extracts path values from request, supporting both Go 1.22 PathValue and context-based fallback
 func getPathValue(r *http.Request, key string) string {
 	// Try Go 1.22 PathValue first (this will work in regular HTTP server)
@@ -72,17 +175,66 @@ func getPathValue(r *http.Request, key string) string {
 // Webapp contains handlers and functionality suited for implementing a wine suggestions web application.
 // Construct a new Webapp with NewWebapp.
 type Webapp struct {
-	port           int
-	tmpl           *template.Template
+	port int
+	tmpl *template.Template
+	// templates holds the view templates; see WithTemplates.
+	templates      fs.FS
 	cache          cache.Cacher
 	cacheEnabled   bool // Feature flag to enable/disable cache operations
 	dl             *data.DataLayer
 	googleClientID string
 	hostname       string
 	model          llms.Model
+	models         map[string]llms.Model
 	toolserver     *mcpserver.MCPServer
 	toolclient     *mcpclient.Client
+	// pairingMCP serves the GeneratePairings tool at "POST /mcp".
+	pairingMCP     http.Handler
 	tools          []tools.Tool
+	apiKeys        map[string]ApiKeyConfig
+	allowedDomains []string
+	cacheNamespace string
+	requestTimeout time.Duration
+	markdown       helpers.MarkdownOptions
+	logLevel       LogLevel
+	agentDisabled  bool
+	// nonAlcoholicOnly forces alcohol-free suggestions; see
+	// WithNonAlcoholicOnly.
+	nonAlcoholicOnly bool
+	// responseEnvelope wraps JSON responses in an APIResponse; see
+	// WithResponseEnvelope.
+	responseEnvelope bool
+	// minContentChars is the shortest fetched page, in characters of
+	// markdown, sent to the model; see WithMinContentChars.
+	minContentChars int
+	// promptSink receives the sampled model calls; see WithPromptLogging.
+	promptSink       PromptSink
+	promptSampleRate float64
+	// refreshInterval and refreshTopN schedule RefreshPopular; see
+	// WithPopularRefresh.
+	refreshInterval time.Duration
+	refreshTopN     int
+	// fallbackSuggestions are sent when generation fails; see
+	// WithFallbackSuggestions.
+	fallbackSuggestions []models.Suggestion
+	// basePath prefixes every route; see WithBasePath.
+	basePath string
+}
+
+// ApiKeyConfig describes a partner API key. Requests sending a known key in the
+// X-API-Key header don't need a login session. Unlimited keys skip quota
+// checks entirely; otherwise the key gets its own account starting with Quota.
+// Admin keys may also use the admin routes.
+type ApiKeyConfig struct {
+	Name      string `json:"name"`
+	Quota     int    `json:"quota"`
+	Unlimited bool   `json:"unlimited"`
+	Admin     bool   `json:"admin"`
+}
+
+// accountID returns the ID of the account requests with this key act as.
+func (c ApiKeyConfig) accountID() string {
+	return fmt.Sprintf("apikey:%s", c.Name)
 }
 
 // Option configures the Webapp with various options
@@ -120,6 +272,257 @@ func WithDatabase(dl *data.DataLayer) Option {
 	}
 }
 
+// WithApiKeys configures the partner API keys accepted in the X-API-Key
+// header, keyed by the secret key value.
+func WithApiKeys(keys map[string]ApiKeyConfig) Option {
+	return func(wa *Webapp) error {
+		wa.apiKeys = keys
+		return nil
+	}
+}
+
+// WithAllowedRecipeDomains limits accepted recipe URLs to the given domains
+// and their subdomains. An empty list accepts recipes from any site.
+func WithAllowedRecipeDomains(domains []string) Option {
+	return func(wa *Webapp) error {
+		wa.allowedDomains = domains
+		return nil
+	}
+}
+
+// WithCacheNamespace prefixes every cache key the webapp reads or writes with
+// namespace, so deployments sharing a cache server don't collide. It applies
+// whichever cache option is used. The MCP server given to WithModel has its
+// own cache, which should be wrapped with cache.NewNamespacedCache to match.
+func WithCacheNamespace(namespace string) Option {
+	return func(wa *Webapp) error {
+		wa.cacheNamespace = namespace
+		return nil
+	}
+}
+
+// WithRequestTimeout bounds how long a request may take end to end, including
+// fetching, summarizing, and generating. Requests that run out of time get a
+// 504. Zero, the default, sets no bound.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(wa *Webapp) error {
+		wa.requestTimeout = d
+		return nil
+	}
+}
+
+// WithMarkdownOptions sets how fetched recipe pages are converted to markdown.
+// The default is helpers.DefaultMarkdownOptions. The MCP server given to
+// WithModel should be built with the same mcp.WithMarkdownOptions.
+func WithMarkdownOptions(opts helpers.MarkdownOptions) Option {
+	return func(wa *Webapp) error {
+		wa.markdown = opts
+		return nil
+	}
+}
+
+// WithPromptLogging records a random sampleRate fraction (0 to 1) of the
+// prompts the webapp sends to models, with their responses, to sink for prompt
+// engineering. Account IDs are hashed before they reach the sink. Only calls
+// made by the webapp itself are sampled; the V2 agent's tools run in the MCP
+// server.
+func WithPromptLogging(sink PromptSink, sampleRate float64) Option {
+	return func(wa *Webapp) error {
+		if sink == nil {
+			return fmt.Errorf("prompt sink is nil")
+		}
+		if sampleRate < 0 || sampleRate > 1 {
+			return fmt.Errorf("prompt sample rate must be between 0 and 1, got %v", sampleRate)
+		}
+		wa.promptSink = sink
+		wa.promptSampleRate = sampleRate
+		return nil
+	}
+}
+
+// WithPopularRefresh counts requests for each recipe's suggestions and, every
+// interval once Start is called, regenerates the suggestions of the topN most
+// requested recipes with RefreshPopular, so popular recipes stay fresh in the
+// cache. It needs the cache, and does nothing on Lambda, where Start isn't
+// called.
+func WithPopularRefresh(interval time.Duration, topN int) Option {
+	return func(wa *Webapp) error {
+		if interval <= 0 {
+			return fmt.Errorf("refresh interval must be positive, got %s", interval)
+		}
+		if topN < 1 {
+			return fmt.Errorf("number of recipes to refresh must be positive, got %d", topN)
+		}
+		wa.refreshInterval = interval
+		wa.refreshTopN = topN
+		return nil
+	}
+}
+
+// DefaultFallbackSuggestions are versatile "safe bet" wines that suit most
+// dishes, for use with WithFallbackSuggestions.
+var DefaultFallbackSuggestions = []models.Suggestion{
+	{
+		Style:       "Pinot Noir",
+		Region:      "Burgundy, France",
+		Description: "A light-bodied red with bright acidity, red fruit, and soft tannins.",
+		PairingNote: "Gentle enough for fish and poultry yet has the fruit for pork, mushrooms, and lighter red meat.",
+		Confidence:  0.5,
+		Rank:        1,
+	},
+	{
+		Style:       "Dry Riesling",
+		Region:      "Alsace, France",
+		Description: "A crisp, aromatic white with citrus, stone fruit, and high acidity.",
+		PairingNote: "Its acidity cuts through rich dishes and its fruit flatters spice, salads, and seafood.",
+		Confidence:  0.5,
+		Rank:        2,
+	},
+	{
+		Style:       "Brut Champagne",
+		Region:      "Champagne, France",
+		Description: "A dry sparkling wine with fine bubbles, citrus, and toasty notes.",
+		PairingNote: "Bubbles and acidity refresh the palate between bites of almost anything, especially fried and salty food.",
+		Confidence:  0.5,
+		Rank:        3,
+	},
+}
+
+// fallbackHeader is set to "true" on suggestions from WithFallbackSuggestions.
+const fallbackHeader = "X-Suggestions-Fallback"
+
+// fallbackNote explains fallback suggestions in V2 responses.
+const fallbackNote = "Pairing suggestions are unavailable right now, so these are versatile wines that suit most dishes. Try again later for pairings chosen for this recipe."
+
+// WithFallbackSuggestions sends suggestions, such as
+// DefaultFallbackSuggestions, when generating suggestions fails, instead of an
+// error. They are flagged with the X-Suggestions-Fallback header and, in V2
+// responses, "fallback": true, and are neither stored nor charged against
+// quota. Alcohol-free deployments should pass alcohol-free suggestions.
+func WithFallbackSuggestions(suggestions []models.Suggestion) Option {
+	return func(wa *Webapp) error {
+		if len(suggestions) == 0 {
+			return fmt.Errorf("no fallback suggestions given")
+		}
+		wa.fallbackSuggestions = slices.Clone(suggestions)
+		return nil
+	}
+}
+
+// sendFallbackSuggestions answers a request whose suggestions couldn't be
+// generated because of err with the fallback suggestions, as a V1 array or a
+// V2 response. It reports false, having written nothing, if there are none.
+func (wa *Webapp) sendFallbackSuggestions(w http.ResponseWriter, r *http.Request, l *leveledLogger, err error, v2 bool) bool {
+	if len(wa.fallbackSuggestions) == 0 {
+		return false
+	}
+
+	l.Warnf("Sending fallback suggestions after generation failed: %v\n", err)
+	w.Header().Set(fallbackHeader, "true")
+	if v2 {
+		writeJSON(w, http.StatusOK, models.SuggestionsResponse{
+			Suggestions: wa.fallbackSuggestions,
+			Note:        fallbackNote,
+			Fallback:    true,
+		})
+		return true
+	}
+
+	out, err := json.Marshal(wa.fallbackSuggestions)
+	if err != nil {
+		return false
+	}
+	writeSuggestionsJSON(w, r, string(out))
+	return true
+}
+
+// defaultMinContentChars is the minimum page length unless
+// WithMinContentChars sets another. Real recipes are far longer; redirect
+// stubs and error pages usually aren't.
+const defaultMinContentChars = 200
+
+// WithMinContentChars sets the fewest characters of markdown a fetched recipe
+// page must have to be summarized. Shorter pages get a 422 without calling the
+// model. Zero disables the check.
+func WithMinContentChars(n int) Option {
+	return func(wa *Webapp) error {
+		if n < 0 {
+			return fmt.Errorf("minimum content length must not be negative, got %d", n)
+		}
+		wa.minContentChars = n
+		return nil
+	}
+}
+
+// WithModels registers alternative models that requests can pick by name with
+// the X-Model header, e.g. to A/B test models. Requests without the header use
+// the model from WithModel, and those naming an unregistered model get a 400.
+// Cached summaries and suggestions are shared across models, and the V2
+// agent's tools keep using the MCP server's model.
+func WithModels(models map[string]llms.Model) Option {
+	return func(wa *Webapp) error {
+		for name, model := range models {
+			if model == nil {
+				return fmt.Errorf("model %q is nil", name)
+			}
+		}
+		wa.models = models
+		return nil
+	}
+}
+
+// WithAgentDisabled makes "POST /recipes/suggestionsV2/" skip the langchaingo
+// agent and run the fetch, summarize, and generate steps directly, as the V1
+// routes do. Responses keep the same shape, but are slower to vary and never
+// carry a no-pairing note.
+func WithAgentDisabled() Option {
+	return func(wa *Webapp) error {
+		wa.agentDisabled = true
+		return nil
+	}
+}
+
+// WithNonAlcoholicOnly makes every pairing alcohol-free, whatever the request
+// or the user's profile asks for, for deployments such as family cooking
+// sites. Alcohol-free pairings are cached apart from regular ones and never
+// stored in DynamoDB, and the routes that only make sense for wine (inventory,
+// explanations, and comparisons) respond 403.
+func WithNonAlcoholicOnly() Option {
+	return func(wa *Webapp) error {
+		wa.nonAlcoholicOnly = true
+		return nil
+	}
+}
+
+// WithResponseEnvelope wraps every JSON response in an APIResponse, so clients
+// see one shape whatever the endpoint. Without it, endpoints keep responding
+// with their own bare arrays and objects while clients migrate.
+func WithResponseEnvelope() Option {
+	return func(wa *Webapp) error {
+		wa.responseEnvelope = true
+		return nil
+	}
+}
+
+// WithLogLevel sets the minimum level of request logs. At info and above,
+// payloads and cookie values are never logged.
+func WithLogLevel(level LogLevel) Option {
+	return func(wa *Webapp) error {
+		wa.logLevel = level
+		return nil
+	}
+}
+
+// WithTemplates parses the view templates from fsys instead of the ones
+// embedded in the binary. Templates are named by their path in fsys, e.g.
+// "pages/home.html", and fsys must hold every one handlers look up.
+func WithTemplates(fsys fs.FS) Option {
+	return func(wa *Webapp) error {
+		wa.templates = fsys
+		return nil
+	}
+}
+
 // WithGoogleClientID configures settings for Google OAuth.
 func WithGoogleClientID(id string) Option {
 	return func(wa *Webapp) error {
@@ -136,6 +539,31 @@ func WithHostname(hostname string) Option {
 	}
 }
 
+// WithBasePath serves every route under prefix, e.g. "/api/v1" for a gateway
+// that forwards the full path, so "/recipes/summary" is served at
+// "/api/v1/recipes/summary" and the home page at "/api/v1/". Unprefixed paths
+// get a 404. The home page's links, static assets and redirects use the
+// prefix too. An empty prefix or "/" serves routes at the root.
+func WithBasePath(prefix string) Option {
+	return func(wa *Webapp) error {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if prefix != "" && !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("base path %q must start with /", prefix)
+		}
+		if strings.ContainsAny(prefix, "{}?#") {
+			return fmt.Errorf("base path %q must be a literal path", prefix)
+		}
+		wa.basePath = prefix
+		return nil
+	}
+}
+
+// BasePath returns the prefix set with WithBasePath, without a trailing slash,
+// or "" if routes are served at the root.
+func (wa *Webapp) BasePath() string {
+	return wa.basePath
+}
+
 // WithModel sets the model for the webapp, allowing clients to decide which
 // model to use at startup.
 func WithModel(model llms.Model, server *server.MCPServer) Option {
@@ -167,12 +595,11 @@ func WithModel(model llms.Model, server *server.MCPServer) Option {
 // the given port. Call Start on a new webapp to begin receiving traffic.
 func NewWebapp(port int, options ...Option) (*Webapp, error) {
 	wa := &Webapp{
-		port: port,
-		tmpl: template.New(""),
-	}
-
-	if err := wa.buildTemplates(templatesRoot); err != nil {
-		return nil, fmt.Errorf("unable to build templates: %v", err)
+		port:            port,
+		tmpl:            template.New(""),
+		markdown:        helpers.DefaultMarkdownOptions(),
+		minContentChars: defaultMinContentChars,
+		logLevel:        LogLevelInfo,
 	}
 
 	for _, option := range options {
@@ -181,9 +608,32 @@ func NewWebapp(port int, options ...Option) (*Webapp, error) {
 		}
 	}
 
+	if wa.templates == nil {
+		embedded, err := fs.Sub(templates, templatesRoot)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open embedded templates: %v", err)
+		}
+		wa.templates = embedded
+	}
+	if err := wa.buildTemplates(wa.templates); err != nil {
+		return nil, fmt.Errorf("unable to build templates: %v", err)
+	}
+	if missing := missingTemplates(wa.tmpl, requiredTemplates); len(missing) > 0 {
+		return nil, fmt.Errorf("missing required templates: %s", strings.Join(missing, ", "))
+	}
+
 	if wa.cache == nil {
 		return wa, fmt.Errorf("no cache configured in options")
 	}
+	// Every pairing route needs the model, so fail here rather than with a
+	// nil dereference on the first request
+	if wa.model == nil {
+		return wa, fmt.Errorf("no model configured in options")
+	}
+	if wa.cacheNamespace != "" {
+		wa.cache = cache.NewNamespacedCache(wa.cache, wa.cacheNamespace)
+	}
+	wa.pairingMCP = wa.newPairingMCPHandler()
 
 	if wa.toolclient != nil {
 		defer wa.toolclient.Close()
@@ -192,6 +642,70 @@ func NewWebapp(port int, options ...Option) (*Webapp, error) {
 	return wa, nil
 }
 
+// Route is an entry in the webapp's route table.
+type Route struct {
+	Method  string           `json:"method"`
+	Pattern string           `json:"pattern"`
+	Handler http.HandlerFunc `json:"-"`
+}
+
+// Routes returns the route table served by Start, with each handler wrapped in
+// the middleware it requires and each pattern under the base path.
+func (wa *Webapp) Routes() []Route {
+	routes := []Route{
+		{"POST", "/recipes/summary", wa.WithSessionRequired(wa.WithSufficientQuota(wa.PostCreateRecipeFromContent))},
+		{"POST", "/recipes/summary/{url}", wa.WithSessionRequired(wa.WithSufficientQuota(wa.PostCreateRecipe))},
+		{"POST", "/recipes/summary/{url}/regenerate", wa.WithSessionRequired(wa.WithSufficientQuota(wa.PostRegenerateSummary))},
+		{"GET", "/recipes/markdown/{url}", wa.WithSessionRequired(wa.GetRecipeMarkdown)},
+		{"GET", "/recipes/export/{url}", wa.WithSessionRequired(wa.GetSuggestionsExport)},
+		{"GET", "/recipes/suggestions/recent", wa.WithSessionRequired(wa.GetRecentSuggestions)},
+		{"GET", "/recipes/suggestions/stream/{url}", wa.WithSessionRequired(wa.WithSufficientQuota(wa.GetRecipeWineSuggestionsStream))},
+		{"GET", "/recipes/suggestions/{url}", wa.WithSessionRequired(wa.WithSufficientQuota(wa.GetRecipeWineSuggestions))},
+		{"POST", "/recipes/suggestions", wa.WithSessionRequired(wa.WithSufficientQuota(wa.PostRecipeWineSuggestions))},
+		{"GET", "/recipes/suggestions/{url}/wine/{index}", wa.WithSessionRequired(wa.GetRecipeWineSuggestion)},
+		{"POST", "/pairings", wa.WithSessionRequired(wa.WithSufficientQuota(wa.PostPairings))},
+		{"POST", "/recipes/compare", wa.WithSessionRequired(wa.WithSufficientQuota(wa.PostCompareRecipes))},
+		{"POST", "/recipes/suggestions/{url}/from-inventory", wa.WithSessionRequired(wa.WithSufficientQuota(wa.PostInventorySuggestions))},
+		{"POST", "/recipes/suggestions/{url}/explain", wa.WithSessionRequired(wa.WithSufficientQuota(wa.PostExplainSuggestion))},
+		{"POST", "/recipes/suggestionsV2/", wa.WithSessionRequired(wa.WithSufficientQuota(wa.GetRecipeWineSuggestionsV2))},
+		{"GET", "/ws/suggestions", wa.WithSessionRequired(wa.WithSufficientQuota(wa.GetSuggestionsWebSocket))},
+		{"POST", "/mcp", wa.WithSessionRequired(wa.WithSufficientQuota(wa.PostMCP))},
+		{"GET", "/logout", wa.WithSessionRequired(wa.DeleteSession)},
+		{"POST", "/oauth/response/", wa.PostOauthResponse},
+		{"GET", "/user", wa.WithSessionRequired(wa.WithAccountDetails(wa.GetUserDetails))},
+		{"DELETE", "/user", wa.WithSessionRequired(wa.DeleteUser)},
+		{"GET", "/user/preferences", wa.WithSessionRequired(wa.GetUserPreferences)},
+		{"PUT", "/user/preferences", wa.WithSessionRequired(wa.PutUserPreferences)},
+		{"GET", "/admin/cache/stats", wa.WithAdminRequired(wa.GetCacheStats)},
+		{"GET", "/routes", wa.WithAdminRequired(wa.GetRoutes)},
+		{"GET", "/admin/usage", wa.WithAdminRequired(wa.GetUsage)},
+		{"POST", "/admin/recipes/invalidate", wa.WithAdminRequired(wa.PostInvalidateRecipe)},
+		{"GET", "/admin/recipes/{url}/validate", wa.WithAdminRequired(wa.GetValidateCachedSuggestions)},
+		{"GET", "/stats/wines", wa.WithAdminRequired(wa.GetWineStats)},
+		{"GET", "/schema/suggestions", wa.GetSuggestionsSchema},
+		{"GET", "/schema/summary", wa.GetSummarySchema},
+		{"GET", "/healthz", wa.HealthStatus},
+		{"GET", "/static/", wa.GetStatic},
+		{"GET", "/{$}", wa.WithAccountDetails(wa.GetHome)},
+	}
+	for i := range routes {
+		routes[i].Pattern = wa.basePath + routes[i].Pattern
+	}
+
+	return routes
+}
+
+// NotFound responds with a JSON 404 for requests that match no route.
+func (wa *Webapp) NotFound(w http.ResponseWriter, r *http.Request) {
+	helpers.SendJSONError(w, fmt.Errorf("no route for %s %s", r.Method, r.URL.Path), http.StatusNotFound)
+}
+
+// GetRoutes implements the route at "GET /routes" and lists the route table
+// for debugging.
+func (wa *Webapp) GetRoutes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, wa.Routes())
+}
+
 // Start registers the route handlers on the web app and begins listening for traffic.
 func (wa *Webapp) Start() error {
 	log.Println("starting up...")
@@ -220,113 +734,705 @@ func (wa *Webapp) Start() error {
 	}
 	log.Println("Database tables validated")
 
+	if wa.refreshInterval > 0 {
+		if wa.cacheEnabled {
+			log.Printf("refreshing the %d most requested recipes every %s\n", wa.refreshTopN, wa.refreshInterval)
+			go wa.refreshPopularEvery(ctx, wa.refreshInterval, wa.refreshTopN)
+		} else {
+			log.Println("popular recipe refresh needs the cache; not starting it")
+		}
+	}
+
 	log.Println("registering routes...")
-	mux := http.NewServeMux()
-	mux.HandleFunc("POST /recipes/summary/{url}", wa.WithSessionRequired(wa.WithSufficientQuota(wa.PostCreateRecipe)))
-	mux.HandleFunc("GET /recipes/suggestions/recent", wa.WithSessionRequired(wa.GetRecentSuggestions))
-	mux.HandleFunc("GET /recipes/suggestions/{url}", wa.WithSessionRequired(wa.WithSufficientQuota(wa.GetRecipeWineSuggestions)))
-	mux.HandleFunc("POST /recipes/suggestionsV2/", wa.WithSessionRequired(wa.WithSufficientQuota(wa.GetRecipeWineSuggestionsV2)))
-	mux.HandleFunc("GET /logout", wa.WithSessionRequired(wa.DeleteSession))
-	mux.HandleFunc("POST /oauth/response/", wa.PostOauthResponse)
-	mux.HandleFunc("GET /user", wa.WithSessionRequired(wa.WithAccountDetails(wa.GetUserDetails)))
-	mux.HandleFunc("GET /healthz", wa.HealthStatus)
-	mux.HandleFunc("GET /", wa.WithAccountDetails(wa.GetHome))
+	mux := wa.Handler()
 
 	log.Printf("listening on :%d\n", wa.port)
 	return http.ListenAndServe(fmt.Sprintf(":%d", wa.port), mux)
 }
 
-func (wa *Webapp) getCookie(name string, r *http.Request) (*http.Cookie, error) {
-	return r.Cookie(name)
+// Handler returns a mux serving every route in Routes, with NotFound for
+// anything else. Start serves it on the configured port; it can also be
+// mounted directly, e.g. in an httptest.Server, without the startup checks.
+func (wa *Webapp) Handler() http.Handler {
+	mux := http.NewServeMux()
+	for _, route := range wa.Routes() {
+		mux.HandleFunc(fmt.Sprintf("%s %s", route.Method, route.Pattern), route.Handler)
+	}
+	mux.HandleFunc("/", wa.NotFound)
+
+	return wa.HeadHandler(wa.EnvelopeHandler(wa.TimeoutHandler(wa.ModelHandler(mux))))
 }
 
-func (wa *Webapp) setCookie(name string, val string, w http.ResponseWriter) {
-	cookie := http.Cookie{
-		Name:     name,
-		Value:    val,
-		Expires:  time.Now().Add(7 * 24 * time.Hour),
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   strings.HasPrefix(wa.hostname, "https"),
-		SameSite: http.SameSiteLaxMode,
+// ModelHandler resolves the X-Model header of each request to one of the
+// models registered with WithModels, which the handlers then use in place of
+// the default. Requests naming an unknown model are rejected with a 400.
+func (wa *Webapp) ModelHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimSpace(r.Header.Get(modelHeader))
+		if name == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		model, ok := wa.models[name]
+		if !ok {
+			helpers.SendJSONError(w, fmt.Errorf("unknown model %q", name), http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), modelContextName, model)))
+	})
+}
+
+// modelFor returns the model the request behind ctx picked with X-Model, or
+// the default model. With prompt logging on, the model samples its calls to
+// the prompt sink.
+func (wa *Webapp) modelFor(ctx context.Context) llms.Model {
+	model := wa.model
+	if m, ok := ctx.Value(modelContextName).(llms.Model); ok {
+		model = m
+	}
+	if wa.promptSink == nil || wa.promptSampleRate == 0 {
+		return model
 	}
 
-	http.SetCookie(w, &cookie)
+	account, _ := ctx.Value(sessionContextName).(string)
+	return &promptLoggingModel{
+		Model:      model,
+		sink:       wa.promptSink,
+		sampleRate: wa.promptSampleRate,
+		account:    hashAccountID(account),
+		logger:     wa.newLogger("[PromptLog] "),
+	}
 }
 
-func (wa *Webapp) deleteCookie(name string, w http.ResponseWriter) {
-	cookie := http.Cookie{
-		Name:     name,
-		Value:    "",
-		Expires:  time.Unix(0, 0),
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   strings.HasPrefix(wa.hostname, "https"),
-		SameSite: http.SameSiteLaxMode,
-	}
+// requestModel calls the model modelFor picks for each call's context, for
+// code that is handed a single model up front.
+type requestModel struct {
+	wa *Webapp
+}
 
-	http.SetCookie(w, &cookie)
+func (m requestModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	return m.wa.modelFor(ctx).GenerateContent(ctx, messages, options...)
 }
 
-func (wa *Webapp) WithSessionRequired(next http.HandlerFunc) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// TODO encode the account ID somehow so it's not just bare in the cookie
-		cookie, err := r.Cookie(sessionCookieName)
-		if err == http.ErrNoCookie {
-			w.WriteHeader(http.StatusUnauthorized)
-			fmt.Fprint(w, "session required")
-			return
-		}
+func (m requestModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
 
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "unable to read session cookie: %v", err)
-			return
-		}
+// ConfiguredMaxTokens returns the output token cap configured on the default
+// model.
+func (m requestModel) ConfiguredMaxTokens() int {
+	return models.ConfiguredMaxTokens(m.wa.model)
+}
 
-		ctx := context.WithValue(r.Context(), sessionContextName, cookie.Value)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+// newPairingMCPHandler serves the GeneratePairings MCP tool over stateless
+// streamable HTTP. Recipe URLs are checked and summarized the way the pairing
+// routes do, pairings follow the deployment's restrictions, and each one is
+// charged against the caller's quota.
+func (wa *Webapp) newPairingMCPHandler() http.Handler {
+	l := wa.newLogger("[PostMCP]")
+	var prefs models.PairingPreferences
+	wa.restrictPreferences(&prefs)
+
+	s := wpmcp.MakePairingServer(requestModel{wa},
+		wpmcp.WithPreferences(prefs),
+		wpmcp.WithSummarizer(func(ctx context.Context, u string) (models.Summary, error) {
+			if _, err := wa.checkRecipeURL(u); err != nil {
+				return models.Summary{}, err
+			}
+			summary, _, err := wa.summarizeRecipeURL(ctx, l, u)
+			return summary, err
+		}),
+		wpmcp.WithOnGenerated(func(ctx context.Context) error {
+			accountID, _ := ctx.Value(sessionContextName).(string)
+			return wa.spendQuota(ctx, l, accountID)
+		}),
+	)
+
+	return mcpserver.NewStreamableHTTPServer(s, mcpserver.WithStateLess(true))
 }
 
-func (wa *Webapp) WithAccountDetails(next http.HandlerFunc) http.HandlerFunc {
-	fmt.Println("creating withAccountDetails middleware")
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		l := log.New(log.Default().Writer(), "withAccountDetails", log.Default().Flags())
+// PostMCP implements the route at "POST /mcp", where external agents can call
+// the GeneratePairings MCP tool. Messages are JSON-RPC, so they are never
+// wrapped by WithResponseEnvelope.
+func (wa *Webapp) PostMCP(w http.ResponseWriter, r *http.Request) {
+	skipEnvelope(r.Context())
+	wa.pairingMCP.ServeHTTP(w, r)
+}
 
-		l.Println("Cookies on request:")
-		for _, c := range r.Cookies() {
-			l.Printf(" - %s=%s\n", c.Name, c.Value)
-		}
+// PromptRecord is a model call sampled by WithPromptLogging.
+type PromptRecord struct {
+	Time time.Time `json:"time"`
+	// AccountHash identifies the caller's account without revealing it; see
+	// hashAccountID. It is empty for anonymous calls.
+	AccountHash string `json:"accountHash,omitempty"`
+	Prompt      string `json:"prompt"`
+	Response    string `json:"response"`
+}
 
-		cookie, err := r.Cookie(sessionCookieName)
-		if err == http.ErrNoCookie {
-			l.Println("login cookie not found")
-			// There is no account to load, so we'll move on without account information loaded
-			next(w, r)
-			return
-		}
+// PromptSink stores sampled prompt records.
+type PromptSink interface {
+	WritePrompt(ctx context.Context, rec PromptRecord) error
+}
 
-		accountID := cookie.Value
+// hashAccountID returns a hex SHA-256 hash of the account ID, so records from
+// one account can be grouped without identifying it. An empty ID stays empty.
+func hashAccountID(id string) string {
+	if id == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
 
-		// --- DynamoDB is PRIMARY source of truth ---
-		l.Printf("[DB] Fetching account details from DynamoDB (AccountID=%s)\n", accountID)
-		dynamoAccount, err := wa.dl.GetAccountByID(r.Context(), accountID)
-		if err != nil {
-			l.Printf("[DB] Failed to get account from DynamoDB: %v\n", err)
-		} else {
-			l.Printf("[DB] Loaded from DynamoDB: Email=%s, Quota=%d\n", dynamoAccount.Email, dynamoAccount.Quota)
-		}
+// promptLoggingModel is a model that writes a random sample of its calls to a
+// PromptSink. Sink failures are logged and never fail the call.
+type promptLoggingModel struct {
+	llms.Model
+	sink       PromptSink
+	sampleRate float64
+	account    string
+	logger     *leveledLogger
+}
 
-		// Use DB values for context (primary source)
-		var email string
-		var quota string
-		if err == nil {
-			email = dynamoAccount.Email
-			quota = strconv.Itoa(dynamoAccount.Quota)
-		}
+func (m *promptLoggingModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	resp, err := m.Model.GenerateContent(ctx, messages, options...)
+	if err != nil || rand.Float64() >= m.sampleRate {
+		return resp, err
+	}
 
-		// --- Cache as OPTIONAL performance layer ---
+	var prompt []string
+	for _, msg := range messages {
+		for _, part := range msg.Parts {
+			if text, ok := part.(llms.TextContent); ok {
+				prompt = append(prompt, text.Text)
+			}
+		}
+	}
+	var response string
+	if len(resp.Choices) > 0 {
+		response = resp.Choices[0].Content
+	}
+	m.write(ctx, strings.Join(prompt, "\n\n"), response)
+
+	return resp, nil
+}
+
+// ConfiguredMaxTokens returns the output token cap configured on the wrapped
+// model.
+func (m *promptLoggingModel) ConfiguredMaxTokens() int {
+	return models.ConfiguredMaxTokens(m.Model)
+}
+
+func (m *promptLoggingModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	out, err := m.Model.Call(ctx, prompt, options...)
+	if err == nil && rand.Float64() < m.sampleRate {
+		m.write(ctx, prompt, out)
+	}
+	return out, err
+}
+
+func (m *promptLoggingModel) write(ctx context.Context, prompt, response string) {
+	rec := PromptRecord{Time: time.Now().UTC(), AccountHash: m.account, Prompt: prompt, Response: response}
+	// Record the call even if the request has just been cancelled
+	if err := m.sink.WritePrompt(context.WithoutCancel(ctx), rec); err != nil {
+		m.logger.Warnf("unable to record prompt: %v\n", err)
+	}
+}
+
+// FilePromptSink appends prompt records to a file as JSON lines.
+type FilePromptSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFilePromptSink opens, or creates, the file at path to append prompt
+// records to.
+func NewFilePromptSink(path string) (*FilePromptSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open prompt log: %v", err)
+	}
+	return &FilePromptSink{file: f}, nil
+}
+
+func (s *FilePromptSink) WritePrompt(ctx context.Context, rec PromptRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the underlying file.
+func (s *FilePromptSink) Close() error {
+	return s.file.Close()
+}
+
+// promptRecordTTL is how long CachePromptSink keeps each record.
+const promptRecordTTL = 30 * 24 * time.Hour
+
+// CachePromptSink stores each prompt record as JSON under its own
+// "prompts:<time>:<random>" key in a cache, expiring after promptRecordTTL.
+// Records can be listed with the cache's key scans.
+type CachePromptSink struct {
+	cache cache.Cacher
+}
+
+// NewCachePromptSink returns a sink writing to c. c is used as-is, so callers
+// sharing a cache across environments should namespace it.
+func NewCachePromptSink(c cache.Cacher) *CachePromptSink {
+	return &CachePromptSink{cache: c}
+}
+
+func (s *CachePromptSink) WritePrompt(ctx context.Context, rec PromptRecord) error {
+	val, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("prompts:%d:%08x", rec.Time.UnixNano(), rand.Uint32())
+	return s.cache.SetEx(key, string(val), int(promptRecordTTL.Seconds()))
+}
+
+// TimeoutHandler gives each request a deadline of the configured request
+// timeout (see WithRequestTimeout), which model calls and fetches observe
+// through the request context. If the deadline has passed by the time next
+// responds, the response is replaced with a 504. Without a timeout, next is
+// returned as-is.
+func (wa *Webapp) TimeoutHandler(next http.Handler) http.Handler {
+	if wa.requestTimeout <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), wa.requestTimeout)
+		defer cancel()
+
+		next.ServeHTTP(&deadlineWriter{ResponseWriter: w, ctx: ctx, timeout: wa.requestTimeout}, r.WithContext(ctx))
+	})
+}
+
+// deadlineWriter swaps in a 504 for responses that begin after the request
+// deadline. Responses already underway, such as event streams, are left to
+// report the error themselves.
+type deadlineWriter struct {
+	http.ResponseWriter
+	ctx         context.Context
+	timeout     time.Duration
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (dw *deadlineWriter) WriteHeader(status int) {
+	if dw.wroteHeader {
+		return
+	}
+	dw.wroteHeader = true
+	if errors.Is(dw.ctx.Err(), context.DeadlineExceeded) {
+		dw.timedOut = true
+		dw.Header().Set("Content-Type", "application/json")
+		helpers.SendJSONError(dw.ResponseWriter, fmt.Errorf("request took longer than %s and was abandoned; try again shortly", dw.timeout), http.StatusGatewayTimeout)
+		return
+	}
+	dw.ResponseWriter.WriteHeader(status)
+}
+
+func (dw *deadlineWriter) Write(b []byte) (int, error) {
+	if !dw.wroteHeader {
+		dw.WriteHeader(http.StatusOK)
+	}
+	if dw.timedOut {
+		return len(b), nil
+	}
+	return dw.ResponseWriter.Write(b)
+}
+
+// Flush lets streaming handlers flush through the wrapper.
+func (dw *deadlineWriter) Flush() {
+	if f, ok := dw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the WebSocket route take over the connection through the
+// wrapper.
+func (dw *deadlineWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := dw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer can't be hijacked")
+	}
+	return h.Hijack()
+}
+
+// HeadHandler answers HEAD requests, which the mux routes to GET handlers,
+// with the headers and status the GET would have but no body. net/http drops
+// HEAD bodies itself, but the Lambda response recorder doesn't, so this keeps
+// health checks from monitoring tools cheap everywhere.
+func (wa *Webapp) HeadHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(headWriter{w}, r)
+	})
+}
+
+// headWriter discards the body of a HEAD response.
+type headWriter struct {
+	http.ResponseWriter
+}
+
+func (hw headWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// APIResponse is the envelope JSON responses are sent in when the webapp is
+// configured WithResponseEnvelope. Successful responses carry their payload in
+// Data; failed ones carry the message in Error.
+type APIResponse struct {
+	Data  json.RawMessage `json:"data"`
+	Error string          `json:"error,omitempty"`
+	Meta  ResponseMeta    `json:"meta"`
+}
+
+// ResponseMeta describes how a response was produced.
+type ResponseMeta struct {
+	RequestID string `json:"requestId"`
+	// Cached is set when the payload was served from the cache or DynamoDB
+	// rather than generated for this request.
+	Cached bool `json:"cached,omitempty"`
+	// unwrapped responses are sent as written, without an APIResponse
+	// envelope; see skipEnvelope.
+	unwrapped bool
+}
+
+// maxRequestIDLength bounds the X-Request-ID values accepted from clients.
+const maxRequestIDLength = 128
+
+// EnvelopeHandler tags each request with an ID, taken from the X-Request-ID
+// header when the client sends one and echoed back in the response. With
+// WithResponseEnvelope, JSON responses from next are buffered and rewritten
+// as an APIResponse; pages, event streams, and WebSockets pass through as-is.
+func (wa *Webapp) EnvelopeHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSpace(r.Header.Get(requestIDHeader))
+		if id == "" || len(id) > maxRequestIDLength || strings.ContainsFunc(id, unicode.IsControl) {
+			id = fmt.Sprintf("%016x", rand.Uint64())
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		meta := &ResponseMeta{RequestID: id}
+		r = r.WithContext(context.WithValue(r.Context(), responseMetaContextName, meta))
+		if !wa.responseEnvelope {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ew := &envelopeWriter{ResponseWriter: w, meta: meta}
+		next.ServeHTTP(ew, r)
+		ew.finish()
+	})
+}
+
+// markCached records in the response metadata that the payload was stored
+// rather than generated.
+func markCached(ctx context.Context) {
+	if meta, ok := ctx.Value(responseMetaContextName).(*ResponseMeta); ok {
+		meta.Cached = true
+	}
+}
+
+// skipEnvelope sends the response as written even when responses are
+// wrapped, for protocols whose clients expect their own message shapes.
+func skipEnvelope(ctx context.Context) {
+	if meta, ok := ctx.Value(responseMetaContextName).(*ResponseMeta); ok {
+		meta.unwrapped = true
+	}
+}
+
+// writeJSON writes data as a JSON response with the given status.
+func writeJSON(w http.ResponseWriter, status int, data any) {
+	out, err := json.Marshal(data)
+	if err != nil {
+		helpers.SendJSONError(w, fmt.Errorf("unable to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+// rateLimitRetryAfter is how long clients are asked to wait when the model
+// provider is rate limiting or overloaded.
+const rateLimitRetryAfter = 30 * time.Second
+
+// errModelBusy is reported in place of the provider's error when it is rate
+// limiting or overloaded.
+var errModelBusy = errors.New("we're getting a lot of requests right now; please try again in a moment")
+
+// sendError reports err like helpers.SendJSONError, except that errors from a
+// rate-limited or overloaded model provider become a 429 with a Retry-After
+// header. Handlers spend quota only after the model succeeds, so these don't
+// cost the user anything.
+func sendError(w http.ResponseWriter, err error, status int) {
+	if models.IsRateLimitError(err) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(rateLimitRetryAfter.Seconds())))
+		helpers.SendJSONError(w, errModelBusy, http.StatusTooManyRequests)
+		return
+	}
+	helpers.SendJSONError(w, err, status)
+}
+
+// envelopeWriter buffers a JSON response so finish can send it wrapped in an
+// APIResponse. Responses of any other content type are written through.
+type envelopeWriter struct {
+	http.ResponseWriter
+	meta        *ResponseMeta
+	status      int
+	buf         bytes.Buffer
+	wroteHeader bool
+	passthrough bool
+}
+
+func (ew *envelopeWriter) WriteHeader(status int) {
+	if ew.wroteHeader {
+		return
+	}
+	ew.wroteHeader = true
+	ew.status = status
+	if ew.meta.unwrapped || !strings.HasPrefix(ew.Header().Get("Content-Type"), "application/json") {
+		ew.passthrough = true
+		ew.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (ew *envelopeWriter) Write(b []byte) (int, error) {
+	if !ew.wroteHeader {
+		ew.WriteHeader(http.StatusOK)
+	}
+	if ew.passthrough {
+		return ew.ResponseWriter.Write(b)
+	}
+	return ew.buf.Write(b)
+}
+
+// Flush flushes responses written through. Buffered JSON is only sent once
+// the handler is done, so streamed arrays still arrive as one envelope.
+func (ew *envelopeWriter) Flush() {
+	if !ew.passthrough {
+		return
+	}
+	if f, ok := ew.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the WebSocket route take over the connection through the
+// wrapper.
+func (ew *envelopeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := ew.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer can't be hijacked")
+	}
+	ew.passthrough = true
+	return h.Hijack()
+}
+
+// finish sends the buffered response as an APIResponse. Error responses have
+// their message moved into Error; error bodies of another shape are kept in
+// Data.
+func (ew *envelopeWriter) finish() {
+	if !ew.wroteHeader || ew.passthrough {
+		return
+	}
+
+	resp := APIResponse{Meta: *ew.meta}
+	body := bytes.TrimSpace(ew.buf.Bytes())
+	if ew.status >= http.StatusBadRequest {
+		var serverErr helpers.ServerError
+		if err := json.Unmarshal(body, &serverErr); err == nil && serverErr.Message != "" {
+			resp.Error = serverErr.Message
+		} else {
+			resp.Error = http.StatusText(ew.status)
+			if json.Valid(body) {
+				resp.Data = body
+			}
+		}
+	} else if len(body) > 0 {
+		resp.Data = body
+	}
+
+	out, err := json.Marshal(resp)
+	if err != nil {
+		// The body isn't valid JSON after all; send it as the handler wrote it.
+		ew.ResponseWriter.WriteHeader(ew.status)
+		ew.ResponseWriter.Write(ew.buf.Bytes())
+		return
+	}
+	ew.Header().Del("Content-Length")
+	ew.ResponseWriter.WriteHeader(ew.status)
+	ew.ResponseWriter.Write(out)
+}
+
+func (wa *Webapp) getCookie(name string, r *http.Request) (*http.Cookie, error) {
+	return r.Cookie(name)
+}
+
+func (wa *Webapp) setCookie(name string, val string, w http.ResponseWriter) {
+	cookie := http.Cookie{
+		Name:     name,
+		Value:    val,
+		Expires:  time.Now().Add(7 * 24 * time.Hour),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(wa.hostname, "https"),
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	http.SetCookie(w, &cookie)
+}
+
+func (wa *Webapp) deleteCookie(name string, w http.ResponseWriter) {
+	cookie := http.Cookie{
+		Name:     name,
+		Value:    "",
+		Expires:  time.Unix(0, 0),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(wa.hostname, "https"),
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	http.SetCookie(w, &cookie)
+}
+
+func (wa *Webapp) WithSessionRequired(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := r.Header.Get(apiKeyHeader); key != "" {
+			wa.withApiKey(key, next)(w, r)
+			return
+		}
+
+		// TODO encode the account ID somehow so it's not just bare in the cookie
+		cookie, err := r.Cookie(sessionCookieName)
+		if err == http.ErrNoCookie {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, "session required")
+			return
+		}
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "unable to read session cookie: %v", err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), sessionContextName, cookie.Value)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// errAdminRequired is reported with a 403 when a signed-in account that isn't
+// an admin requests an admin route.
+var errAdminRequired = errors.New("admin access required")
+
+// WithAdminRequired guards the admin routes. Like WithSessionRequired it needs
+// a session or API key, and the key must also be an admin key. Session
+// cookies carry the bare account ID and aren't signed, so they are never
+// trusted with admin access. Everyone else gets a 403.
+func (wa *Webapp) WithAdminRequired(next http.HandlerFunc) http.HandlerFunc {
+	return wa.WithSessionRequired(func(w http.ResponseWriter, r *http.Request) {
+		if !wa.isAdmin(r.Context()) {
+			wa.newLogger("[WithAdminRequired]").Printf("Rejecting non-admin request for %s %s\n", r.Method, r.URL.Path)
+			helpers.SendJSONError(w, errAdminRequired, http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// isAdmin reports whether the request was made with an admin API key.
+func (wa *Webapp) isAdmin(ctx context.Context) bool {
+	cfg, ok := ctx.Value(apiKeyContextName).(ApiKeyConfig)
+	return ok && cfg.Admin
+}
+
+// withApiKey authenticates the request with a partner API key in place of a
+// session cookie. Limited keys act as their own account, created on first use
+// with the key's quota, so the usual quota checks and decrements apply.
+func (wa *Webapp) withApiKey(key string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := wa.newLogger("[withApiKey]")
+		cfg, ok := wa.apiKeys[key]
+		if !ok {
+			l.Println("Rejecting unknown API key")
+			helpers.SendJSONError(w, fmt.Errorf("invalid API key"), http.StatusUnauthorized)
+			return
+		}
+
+		accountID := cfg.accountID()
+		if !cfg.Unlimited {
+			l.Printf("[DB] Ensuring account exists for API key %s\n", cfg.Name)
+			if _, err := wa.dl.CreateAccountWithQuota(r.Context(), accountID, "", cfg.Quota); err != nil {
+				l.Warnf("[DB] Error creating API key account: %v\n", err)
+				helpers.SendJSONError(w, fmt.Errorf("unable to load API key account: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		ctx := context.WithValue(
+			context.WithValue(r.Context(), sessionContextName, accountID),
+			apiKeyContextName, cfg,
+		)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+func (wa *Webapp) WithAccountDetails(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l := wa.newLogger("withAccountDetails")
+
+		l.Debugf("Cookies on request:\n")
+		for _, c := range r.Cookies() {
+			l.Debugf(" - %s=%s\n", c.Name, c.Value)
+		}
+
+		var accountID string
+		cookie, err := r.Cookie(sessionCookieName)
+		if err == nil {
+			accountID = cookie.Value
+		} else if a, ok := r.Context().Value(sessionContextName).(string); ok && a != "" {
+			// Requests authenticated by API key carry their account in context
+			accountID = a
+		} else {
+			l.Println("login cookie not found")
+			// There is no account to load, so we'll move on without account information loaded
+			next(w, r)
+			return
+		}
+
+		// --- DynamoDB is PRIMARY source of truth ---
+		l.Printf("[DB] Fetching account details from DynamoDB (AccountID=%s)\n", accountID)
+		dynamoAccount, err := wa.dl.GetAccountByID(r.Context(), accountID)
+		if err != nil {
+			l.Printf("[DB] Failed to get account from DynamoDB: %v\n", err)
+		} else {
+			l.Printf("[DB] Loaded from DynamoDB: Email=%s, Quota=%d\n", dynamoAccount.Email, dynamoAccount.Quota)
+		}
+
+		// Use DB values for context (primary source)
+		var email string
+		var quota string
+		if err == nil {
+			email = dynamoAccount.Email
+			quota = strconv.Itoa(dynamoAccount.Quota)
+		}
+
+		// --- Cache as OPTIONAL performance layer ---
 		if wa.cacheEnabled {
 			l.Println("[CACHE] Cache enabled - fetching from cache as backup")
 			cacheQuota, err := wa.cache.GetOrFetch(fmt.Sprintf("quotas:%s", accountID), func() (string, error) {
@@ -380,8 +1486,19 @@ func (wa *Webapp) WithAccountDetails(next http.HandlerFunc) http.HandlerFunc {
 }
 
 func (wa *Webapp) WithSufficientQuota(next http.HandlerFunc) http.HandlerFunc {
+	checked := wa.withQuotaCheck(next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfg, ok := r.Context().Value(apiKeyContextName).(ApiKeyConfig); ok && cfg.Unlimited {
+			next(w, r)
+			return
+		}
+		checked(w, r)
+	}
+}
+
+func (wa *Webapp) withQuotaCheck(next http.HandlerFunc) http.HandlerFunc {
 	return wa.WithAccountDetails(func(w http.ResponseWriter, r *http.Request) {
-		l := log.New(log.Default().Writer(), "[WithSufficientQuota]", log.Default().Flags())
+		l := wa.newLogger("[WithSufficientQuota]")
 
 		// --- Use quota from context (loaded from DB or cache in WithAccountDetails) ---
 		var quota int64
@@ -411,7 +1528,7 @@ func (wa *Webapp) WithSufficientQuota(next http.HandlerFunc) http.HandlerFunc {
 		if quota <= 0 {
 			l.Printf("Account has insufficient quota (%d)\n", quota)
 			w.WriteHeader(http.StatusBadRequest)
-			helpers.SendJSONError(w, fmt.Errorf("the current account has insufficient quota"), http.StatusBadRequest)
+			helpers.SendJSONError(w, errQuotaExhausted, http.StatusBadRequest)
 			return
 		}
 
@@ -419,44 +1536,138 @@ func (wa *Webapp) WithSufficientQuota(next http.HandlerFunc) http.HandlerFunc {
 	})
 }
 
-// buildTemplates finds, compiles, and registers all view templates for this
-// webapp for use in route handlers, throwing an error if anything fails to
-// compile. Templates are named by their file path (including extension) within
-// the templates folder. For example, a template at
-// "webapp/templates/folder/template.html" will be called
-// "folder/template.html". Use wa.tmpl.Lookup("folder/template.html") to use it
-// in a handler.
-func (wa *Webapp) buildTemplates(parent string) error {
-	entries, err := templates.ReadDir(parent)
-	if err != nil {
-		return fmt.Errorf("embed ReadDir error on path=%s: %v", parent, err)
+// errQuotaExhausted is reported when a request loses the race for the last of
+// an account's quota.
+var errQuotaExhausted = errors.New("the current account has insufficient quota")
+
+// errQuotaUnavailable is reported when quota can't be charged because neither
+// DynamoDB nor the cache could be updated. The request isn't served for free.
+var errQuotaUnavailable = errors.New("unable to charge quota for this request; please try again")
+
+// spendQuota atomically takes one unit of quota from accountID, returning
+// errQuotaExhausted if none is left. WithSufficientQuota only checks the
+// balance up front, so concurrent requests can all pass it; this is the step
+// that decides which of them are actually served. DynamoDB is authoritative,
+// and the cache's floored decrement only decides when the database couldn't
+// be updated. If neither could be, it returns errQuotaUnavailable. Unlimited
+// API keys are never charged. Every generation served, charged or not, is
+// counted in the daily usage.
+func (wa *Webapp) spendQuota(ctx context.Context, l *leveledLogger, accountID string) error {
+	if cfg, ok := ctx.Value(apiKeyContextName).(ApiKeyConfig); ok && cfg.Unlimited {
+		wa.recordUsage(l)
+		return nil
 	}
 
-	for _, e := range entries {
-		n := e.Name()
-		if e.IsDir() {
-			err := wa.buildTemplates(path.Join(parent, n))
-			if err != nil {
-				return err
-			}
-		} else {
-			p := path.Join(parent, n)
-			if parent == "template" {
-				p = n
-			}
+	// PRIMARY: Decrement quota in DynamoDB
+	l.Printf("[DB] Decrementing quota for account %s in DynamoDB\n", accountID)
+	dbErr := wa.dl.DecrementAccountQuota(ctx, accountID)
+	if errors.Is(dbErr, data.ErrQuotaExhausted) {
+		l.Printf("[DB] Account %s has no quota left\n", accountID)
+		return errQuotaExhausted
+	}
+	if dbErr != nil {
+		l.Warnf("[DB] Error decrementing quota: %v\n", dbErr)
+		if !wa.cacheEnabled {
+			return errQuotaUnavailable
+		}
+	}
 
-			contents, err := templates.ReadFile(p)
-			if err != nil {
-				return fmt.Errorf("embed ReadFile error on path=%s: %v", p, err)
+	// OPTIONAL: Decrement in cache if enabled
+	if wa.cacheEnabled {
+		l.Printf("[CACHE] Cache enabled - decrementing quota for account %s in cache\n", accountID)
+		_, err := wa.cache.DecrFloor(sessionQuotaKey(accountID), 0)
+		if errors.Is(err, cache.ErrFloorReached) {
+			if dbErr != nil {
+				l.Printf("[CACHE] Account %s has no quota left\n", accountID)
+				return errQuotaExhausted
+			}
+		} else if err != nil {
+			l.Warnf("[CACHE] Error decrementing quota: %v\n", err)
+			if dbErr != nil {
+				return errQuotaUnavailable
 			}
-			name := strings.Replace(p, "templates"+string(filepath.Separator), "", 1)
-			template.Must(wa.tmpl.New(name).Parse(string(contents)))
 		}
 	}
 
+	wa.recordUsage(l)
 	return nil
 }
 
+// sendQuotaError reports an error from spendQuota: a 400 if the account is
+// out of quota, or a 503 if quota couldn't be charged.
+func sendQuotaError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	if errors.Is(err, errQuotaUnavailable) {
+		status = http.StatusServiceUnavailable
+	}
+	helpers.SendJSONError(w, err, status)
+}
+
+// usageTTL is how long each day's generation count is kept.
+const usageTTL = 90 * 24 * time.Hour
+
+// usageDateFormat is how days are written in usage cache keys and requests.
+const usageDateFormat = time.DateOnly
+
+// usageCacheKey counts the generations served on day, in UTC.
+func usageCacheKey(day time.Time) string {
+	return fmt.Sprintf("usage:%s", day.UTC().Format(usageDateFormat))
+}
+
+// recordUsage counts a generation in today's usage. The key is created with
+// its expiry before being incremented, so concurrent first requests of a day
+// neither lose counts nor leave a key that never expires.
+func (wa *Webapp) recordUsage(l *leveledLogger) {
+	if !wa.cacheEnabled {
+		return
+	}
+	key := usageCacheKey(time.Now())
+	if err := wa.cache.SetNx(key, "0", int(usageTTL.Seconds())); err != nil {
+		l.Warnf("[CACHE] Error creating usage counter: %v\n", err)
+		return
+	}
+	if _, err := wa.cache.Incr(key); err != nil {
+		l.Warnf("[CACHE] Error counting usage: %v\n", err)
+	}
+}
+
+// buildTemplates finds, compiles, and registers all view templates in fsys
+// for use in route handlers, returning an error if anything fails to compile.
+// Templates are named by their file path (including extension) within fsys.
+// For example, the embedded template at "webapp/templates/folder/template.html"
+// will be called "folder/template.html". Use wa.tmpl.Lookup("folder/template.html")
+// to use it in a handler.
+func (wa *Webapp) buildTemplates(fsys fs.FS) error {
+	return fs.WalkDir(fsys, ".", func(p string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("template ReadDir error on path=%s: %v", p, err)
+		}
+		if e.IsDir() {
+			return nil
+		}
+
+		contents, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return fmt.Errorf("template ReadFile error on path=%s: %v", p, err)
+		}
+		if _, err := wa.tmpl.New(p).Parse(string(contents)); err != nil {
+			return fmt.Errorf("unable to parse template %s: %v", p, err)
+		}
+		return nil
+	})
+}
+
+// missingTemplates returns the names in required that aren't defined in t.
+func missingTemplates(t *template.Template, required []string) []string {
+	var missing []string
+	for _, name := range required {
+		if t.Lookup(name) == nil {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
 // GetUserDetails fetches the latest information about the currently logged in user
 func (wa *Webapp) GetUserDetails(w http.ResponseWriter, r *http.Request) {
 	var quota, email string
@@ -475,11 +1686,149 @@ func (wa *Webapp) GetUserDetails(w http.ResponseWriter, r *http.Request) {
 		Quota: quota,
 	}
 
-	out, _ := json.Marshal(data)
+	writeJSON(w, http.StatusOK, data)
+}
+
+// preferenceProfileCacheKey is where the preference profile of an account is
+// cached.
+func preferenceProfileCacheKey(accountID string) string {
+	return fmt.Sprintf("prefs:%s", accountID)
+}
+
+// errPreferencesNeedCache is reported when preference profiles are used while
+// the cache, where they are kept, is disabled.
+var errPreferencesNeedCache = errors.New("preferences are only kept when the cache is enabled")
+
+// errWineRouteDisabled is reported by wine-only routes in alcohol-free
+// deployments.
+var errWineRouteDisabled = errors.New("this deployment only suggests alcohol-free drinks")
+
+// restrictPreferences applies the deployment's own limits to prefs, overriding
+// whatever the client or the user's profile asked for.
+func (wa *Webapp) restrictPreferences(prefs *models.PairingPreferences) {
+	if wa.nonAlcoholicOnly {
+		prefs.AlcoholFree = true
+		// Wine type filters would drop alcohol-free drinks
+		prefs.Types = nil
+	}
+}
+
+// preferenceProfile returns the saved preference profile for the account, or
+// a zero profile if there is none.
+func (wa *Webapp) preferenceProfile(accountID string) (models.PreferenceProfile, error) {
+	var profile models.PreferenceProfile
+	text, err := wa.cache.Get(preferenceProfileCacheKey(accountID))
+	if errors.Is(err, cache.ErrKeyNotFound) {
+		return profile, nil
+	}
+	if err != nil {
+		return profile, err
+	}
+	if err := json.Unmarshal([]byte(text), &profile); err != nil {
+		return profile, fmt.Errorf("unable to read preference profile: %v", err)
+	}
+
+	return profile, nil
+}
+
+// savedPreferenceProfile returns the account's saved preference profile for a
+// suggestions request. Failing to load it only skips the profile.
+func (wa *Webapp) savedPreferenceProfile(l *leveledLogger, accountID string) models.PreferenceProfile {
+	if !wa.cacheEnabled {
+		return models.PreferenceProfile{}
+	}
+	profile, err := wa.preferenceProfile(accountID)
+	if err != nil {
+		l.Warnf("[CACHE] Ignoring preference profile: %v\n", err)
+		return models.PreferenceProfile{}
+	}
+	return profile
+}
+
+// applyPreferenceProfile merges the account's saved preference profile, if
+// any, into prefs.
+func (wa *Webapp) applyPreferenceProfile(l *leveledLogger, accountID string, prefs *models.PairingPreferences) {
+	prefs.ApplyProfile(wa.savedPreferenceProfile(l, accountID))
+}
+
+// GetUserPreferences implements the route at "GET /user/preferences" and
+// returns the logged-in user's preference profile, empty if none is saved.
+func (wa *Webapp) GetUserPreferences(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := r.Context().Value(sessionContextName).(string)
+	if !ok {
+		helpers.SendJSONError(w, fmt.Errorf("unable to get account ID from context"), http.StatusInternalServerError)
+		return
+	}
+	if !wa.cacheEnabled {
+		helpers.SendJSONError(w, errPreferencesNeedCache, http.StatusNotFound)
+		return
+	}
+
+	profile, err := wa.preferenceProfile(accountID)
+	if err != nil {
+		helpers.SendJSONError(w, fmt.Errorf("unable to load preferences: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, profile)
+}
+
+// PutUserPreferences implements the route at "PUT /user/preferences". The JSON
+// body replaces the logged-in user's preference profile ({"types": [...],
+// "dislikedStyles": [...], "maxPrice": 25}), which is then applied to their
+// V2 and dish pairing requests.
+func (wa *Webapp) PutUserPreferences(w http.ResponseWriter, r *http.Request) {
+	l := wa.newLogger("[PutUserPreferences]")
+	accountID, ok := r.Context().Value(sessionContextName).(string)
+	if !ok {
+		helpers.SendJSONError(w, fmt.Errorf("unable to get account ID from context"), http.StatusInternalServerError)
+		return
+	}
+	if !wa.cacheEnabled {
+		helpers.SendJSONError(w, errPreferencesNeedCache, http.StatusNotFound)
+		return
+	}
+
+	var profile models.PreferenceProfile
+	if err := json.NewDecoder(r.Body).Decode(&profile); err != nil {
+		helpers.SendJSONError(w, fmt.Errorf("unable to read preferences: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := profile.Validate(); err != nil {
+		helpers.SendJSONError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	out, err := json.Marshal(profile)
+	if err != nil {
+		helpers.SendJSONError(w, fmt.Errorf("unable to encode preferences: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := wa.cache.Set(preferenceProfileCacheKey(accountID), string(out)); err != nil {
+		l.Warnf("[CACHE] Error storing preferences: %v\n", err)
+		helpers.SendJSONError(w, fmt.Errorf("unable to save preferences: %v", err), http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Add("Content-Type", "application/json")
 	fmt.Fprint(w, string(out))
 }
 
+// GetStatic implements the route at "GET /static/", serving the files embedded
+// from the static directory, such as the templates' stylesheet, with a content
+// type from their extension. Directories aren't listed. The Lambda router
+// returns bodies as text, so only text assets should go in static.
+func (wa *Webapp) GetStatic(w http.ResponseWriter, r *http.Request) {
+	name := path.Join(staticRoot, strings.TrimPrefix(r.URL.Path, wa.basePath+"/static/"))
+	if info, err := fs.Stat(static, name); err != nil || info.IsDir() {
+		wa.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Cache-Control", staticCacheControl)
+	http.ServeFileFS(w, r, static, name)
+}
+
 // GetHome implements home route "GET /" for the web app, serving the home page
 // and initializing the app.
 func (wa *Webapp) GetHome(w http.ResponseWriter, r *http.Request) {
@@ -496,11 +1845,13 @@ func (wa *Webapp) GetHome(w http.ResponseWriter, r *http.Request) {
 		Quota          string
 		GoogleClientID string
 		Hostname       string
+		BasePath       string
 	}{
 		Email:          email,
 		Quota:          quota,
 		GoogleClientID: wa.googleClientID,
 		Hostname:       wa.hostname,
+		BasePath:       wa.basePath,
 	}
 
 	// The template will render an inline login screen if there isn't an active session
@@ -519,13 +1870,36 @@ func (wa *Webapp) GetHome(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// errRecipeDomainNotAllowed is reported with a 403 for recipe URLs outside the
+// configured allowlist.
+var errRecipeDomainNotAllowed = errors.New("recipes from this site are not accepted; only verified recipe sources are allowed")
+
+// checkRecipeURL validates the path-escaped recipe URL u before anything is
+// fetched for it, returning the HTTP status to report it with: 400 if it isn't
+// a well-formed http(s) URL, or 403 with errRecipeDomainNotAllowed if it isn't
+// hosted on an allowed domain.
+func (wa *Webapp) checkRecipeURL(u string) (int, error) {
+	recipeUrl, err := url.PathUnescape(u)
+	if err != nil {
+		recipeUrl = u
+	}
+	if _, err := helpers.ValidateRecipeURL(recipeUrl); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if !helpers.HostAllowed(recipeUrl, wa.allowedDomains) {
+		return http.StatusForbidden, errRecipeDomainNotAllowed
+	}
+
+	return http.StatusOK, nil
+}
+
 // PostCreateRecipe implements a route at "POST /recipes/summary" to create a
 // new analysis of a recipe indicated by the url field in the form submission.
 // Returns an HTML partial with the summarized analysis of the given recipe.
 // Note: This endpoint uses cache if enabled, but does NOT store in DynamoDB
 // (raw/parsed content is out of scope for RecipePairing model).
 func (wa *Webapp) PostCreateRecipe(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
+	ctx := r.Context()
 	log.Println("Handling PostCreateRecipe")
 	u := getPathValue(r, "url")
 	log.Println("recipe is", u)
@@ -533,54 +1907,189 @@ func (wa *Webapp) PostCreateRecipe(w http.ResponseWriter, r *http.Request) {
 		helpers.SendJSONError(w, fmt.Errorf("URL required"), http.StatusBadRequest)
 		return
 	}
-	l := log.New(log.Default().Writer(), fmt.Sprintf("[PostCreateRecipe %s]", u[0:15]), log.Default().Flags())
+	if status, err := wa.checkRecipeURL(u); err != nil {
+		sendError(w, err, status)
+		return
+	}
+	l := wa.newLogger(fmt.Sprintf("[PostCreateRecipe %s]", u[:min(len(u), 15)]))
+
+	summary, status, err := wa.summarizeRecipeURL(ctx, l, u)
+	if err != nil {
+		sendError(w, err, status)
+		return
+	}
+
+	tmp := struct {
+		Summary          string   `json:"summary"`
+		Ingredients      []string `json:"ingredients,omitempty"`
+		CookingMethods   []string `json:"cookingMethods,omitempty"`
+		Servings         int      `json:"servings,omitempty"`
+		TotalTimeMinutes int      `json:"totalTimeMinutes,omitempty"`
+		Allergens        []string `json:"allergens,omitempty"`
+	}{
+		Summary:          summary.Summary,
+		Ingredients:      summary.Ingredients,
+		CookingMethods:   summary.CookingMethods,
+		Servings:         summary.Servings,
+		TotalTimeMinutes: summary.TotalTimeMinutes,
+		Allergens:        summary.Allergens,
+	}
+
+	writeJSON(w, http.StatusOK, tmp)
+}
+
+// maxRecipeContentBytes bounds the recipe text accepted by
+// PostCreateRecipeFromContent.
+const maxRecipeContentBytes = 256 << 10
+
+// PostCreateRecipeFromContent implements a route at "POST /recipes/summary"
+// to summarize recipe text or markdown sent as {"content": "..."} rather than
+// fetched from a URL. The summary is cached under the content hash and the
+// parsed Summary is returned as JSON.
+func (wa *Webapp) PostCreateRecipeFromContent(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	l := wa.newLogger("[PostCreateRecipeFromContent]")
+	l.Println("Handling PostCreateRecipeFromContent")
+
+	var req struct {
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRecipeContentBytes)).Decode(&req); err != nil {
+		helpers.SendJSONError(w, fmt.Errorf("unable to read request: %v", err), http.StatusBadRequest)
+		return
+	}
+	content := strings.TrimSpace(req.Content)
+	if content == "" {
+		helpers.SendJSONError(w, fmt.Errorf("content cannot be empty"), http.StatusBadRequest)
+		return
+	}
+
+	summary, status, err := wa.summarizeMarkdown(ctx, l, contentSummaryID(content), content)
+	if err != nil {
+		sendError(w, err, status)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, summary)
+}
+
+// GetRecipeMarkdown implements the route at "GET /recipes/markdown/{url}" and
+// returns the markdown the model was given for the recipe, as text/markdown.
+// It responds 404 if the recipe hasn't been fetched yet.
+func (wa *Webapp) GetRecipeMarkdown(w http.ResponseWriter, r *http.Request) {
+	l := wa.newLogger("[GetRecipeMarkdown]")
+	u := getPathValue(r, "url")
+	if u == "" {
+		helpers.SendJSONError(w, fmt.Errorf("URL required"), http.StatusBadRequest)
+		return
+	}
+	if !wa.cacheEnabled {
+		helpers.SendJSONError(w, fmt.Errorf("recipe markdown is only kept when the cache is enabled"), http.StatusNotFound)
+		return
+	}
+
+	if canonical, err := wa.cache.Get(fmt.Sprintf("recipes:canonical:%s", u)); err == nil {
+		u = canonical
+	}
+	md, err := wa.cache.Get(fmt.Sprintf("recipes:parsed:%s", u))
+	if errors.Is(err, cache.ErrKeyNotFound) {
+		helpers.SendJSONError(w, fmt.Errorf("recipe has not been fetched yet"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		l.Warnf("[CACHE] Error loading markdown: %v\n", err)
+		helpers.SendJSONError(w, fmt.Errorf("unable to load recipe markdown: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "text/markdown; charset=utf-8")
+	fmt.Fprint(w, md)
+}
+
+// fetchRecipeRaw fetches the raw HTML for the path-escaped recipe URL u,
+// returning it with the URL it was finally served from. If PREFER_RECIPE_FEEDS
+// is "true", the full entry from the site's feed is used when available, since
+// it is cleaner than the rendered page. A redirect off the allowed domains
+// fails with errRecipeDomainNotAllowed.
+func (wa *Webapp) fetchRecipeRaw(ctx context.Context, l *leveledLogger, u string) (string, string, error) {
+	recipeUrl, err := url.PathUnescape(u)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid URL encoding (%s): %v", u, err)
+	}
+	if os.Getenv("PREFER_RECIPE_FEEDS") == "true" {
+		if feedURL := helpers.FeedURLFor(recipeUrl); feedURL != "" {
+			if content, ok := helpers.FetchFromFeed(feedURL, recipeUrl); ok {
+				l.Println("Using feed content from:", feedURL)
+				// Wrap the entry so content extraction treats it as the article
+				return "<html><body><article>" + content + "</article></body></html>", recipeUrl, nil
+			}
+		}
+	}
+	l.Println("Fetching from URL:", recipeUrl)
+	rawReader, final, err := helpers.FetchRawFromURLContext(ctx, recipeUrl)
+	if err != nil {
+		return "", "", err
+	}
+	defer rawReader.Close()
+	if !helpers.HostAllowed(final, wa.allowedDomains) {
+		l.Printf("Rejected redirect outside allowed domains: %s\n", final)
+		return "", "", errRecipeDomainNotAllowed
+	}
+	contents, err := io.ReadAll(rawReader)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to read website contents: %v", err)
+	}
+
+	return string(contents), final, nil
+}
+
+// summarizeRecipeURL fetches the recipe at u, converts it to markdown, and
+// summarizes it for wine pairing, using the cache for each step if enabled. If
+// u redirects, the cached steps are stored under the canonical URL. On failure
+// it returns the HTTP status the error should be reported with.
+func (wa *Webapp) summarizeRecipeURL(ctx context.Context, l *leveledLogger, u string) (models.Summary, int, error) {
+	requested := u
 
 	// Fetch raw HTML (use cache if enabled)
 	var raw string
 	var err error
 	if wa.cacheEnabled {
+		if abort, ok := wa.cachedNotRecipe(u); ok {
+			l.Println("[CACHE] URL is known not to be a recipe")
+			return models.Summary{}, http.StatusBadRequest, abort
+		}
 		l.Println("[CACHE] Cache enabled - checking for raw HTML")
-		raw, err = wa.cache.GetOrFetch(fmt.Sprintf("recipes:raw:%s", u), func() (string, error) {
+		if canonical, err := wa.cache.Get(fmt.Sprintf("recipes:canonical:%s", u)); err == nil {
+			l.Printf("[CACHE] Using canonical URL %s\n", canonical)
+			u = canonical
+		}
+		raw, err = wa.cache.Get(fmt.Sprintf("recipes:raw:%s", u))
+		if errors.Is(err, cache.ErrKeyNotFound) {
 			l.Println("[CACHE] Cache miss - fetching raw HTML")
-			recipeUrl, err := url.PathUnescape(u)
-			if err != nil {
-				return "", fmt.Errorf("invalid URL encoding (%s): %v", u, err)
-			}
-			l.Println("Fetching from URL:", recipeUrl)
-			raw, err := helpers.FetchRawFromURL(recipeUrl)
-			if err != nil {
-				return "", err
-			}
-			defer raw.Close()
-			contents, err := io.ReadAll(raw)
-			if err != nil {
-				return "", fmt.Errorf("unable to read website contents")
+			var final string
+			raw, final, err = wa.fetchRecipeRaw(ctx, l, u)
+			if err == nil {
+				if final != "" && final != u {
+					l.Printf("[CACHE] %s redirected to %s - caching under canonical URL\n", u, final)
+					if err := wa.cache.Set(fmt.Sprintf("recipes:canonical:%s", u), final); err != nil {
+						l.Warnf("[CACHE] Error storing canonical URL: %v\n", err)
+					}
+					u = final
+				}
+				if err := wa.cache.Set(fmt.Sprintf("recipes:raw:%s", u), raw); err != nil {
+					l.Warnf("[CACHE] Error storing raw HTML: %v\n", err)
+				}
 			}
-			return string(contents), nil
-		})
+		}
 	} else {
 		l.Println("Cache disabled - fetching raw HTML directly")
-		recipeUrl, err := url.PathUnescape(u)
-		if err != nil {
-			helpers.SendJSONError(w, fmt.Errorf("invalid URL encoding (%s): %v", u, err), http.StatusBadRequest)
-			return
-		}
-		rawReader, err := helpers.FetchRawFromURL(recipeUrl)
-		if err != nil {
-			helpers.SendJSONError(w, fmt.Errorf("unable to fetch raw: %v", err), http.StatusBadRequest)
-			return
-		}
-		defer rawReader.Close()
-		contents, err := io.ReadAll(rawReader)
-		if err != nil {
-			helpers.SendJSONError(w, fmt.Errorf("unable to read website contents: %v", err), http.StatusInternalServerError)
-			return
-		}
-		raw = string(contents)
+		raw, _, err = wa.fetchRecipeRaw(ctx, l, u)
+	}
+	if errors.Is(err, errRecipeDomainNotAllowed) {
+		return models.Summary{}, http.StatusForbidden, err
 	}
 	if err != nil {
-		helpers.SendJSONError(w, fmt.Errorf("unable to fetch raw: %v", err), http.StatusBadRequest)
-		return
+		return models.Summary{}, http.StatusBadRequest, fmt.Errorf("unable to fetch raw: %v", err)
 	}
 
 	// Parse to markdown (use cache if enabled)
@@ -589,88 +2098,438 @@ func (wa *Webapp) PostCreateRecipe(w http.ResponseWriter, r *http.Request) {
 		l.Println("[CACHE] Cache enabled - checking for parsed markdown")
 		md, err = wa.cache.GetOrFetch(fmt.Sprintf("recipes:parsed:%s", u), func() (string, error) {
 			l.Println("[CACHE] Cache miss - parsing to markdown")
-			return helpers.CreateMarkdownFromRaw(u, raw)
+			return helpers.CreateMarkdownFromRawWithOptions(u, raw, wa.markdown)
 		})
 	} else {
 		l.Println("Cache disabled - parsing to markdown directly")
-		md, err = helpers.CreateMarkdownFromRaw(u, raw)
+		md, err = helpers.CreateMarkdownFromRawWithOptions(u, raw, wa.markdown)
+	}
+	if err != nil {
+		return models.Summary{}, http.StatusInternalServerError, fmt.Errorf("unable to get content from page: %v", err)
+	}
+	if n := utf8.RuneCountInString(strings.TrimSpace(md)); n < wa.minContentChars {
+		l.Printf("Page content is only %d characters; not summarizing\n", n)
+		return models.Summary{}, http.StatusUnprocessableEntity, fmt.Errorf("%w: found %d characters, need at least %d", errContentTooShort, n, wa.minContentChars)
+	}
+
+	summary, status, err := wa.summarizeMarkdown(ctx, l, u, md)
+	var abort *recipeAbortError
+	if wa.cacheEnabled && errors.As(err, &abort) {
+		wa.cacheNotRecipe(l, abort, slices.Compact([]string{requested, u})...)
 	}
 	if err != nil {
-		helpers.SendJSONError(w, fmt.Errorf("unable to get content from page: %v", err), http.StatusInternalServerError)
+		return summary, status, err
+	}
+
+	// The page's own structured data beats the model's reading of the prose
+	if meta, ok := helpers.RecipeMetadataFromHTML(raw); ok {
+		changed := false
+		if meta.Servings > 0 && meta.Servings != summary.Servings {
+			summary.Servings, changed = meta.Servings, true
+		}
+		if meta.TotalTimeMinutes > 0 && meta.TotalTimeMinutes != summary.TotalTimeMinutes {
+			summary.TotalTimeMinutes, changed = meta.TotalTimeMinutes, true
+		}
+		if changed && wa.cacheEnabled {
+			wa.storeSummaryDetails(l, u, summary)
+		}
+	}
+
+	return summary, status, nil
+}
+
+// errContentTooShort is reported with a 422 when a fetched page has too
+// little content to be a recipe, e.g. a redirect stub or an error page.
+var errContentTooShort = errors.New("the page has too little content to be a recipe")
+
+// errRecipeAborted is reported when the model declines to summarize content,
+// e.g. because it isn't a recipe.
+var errRecipeAborted = errors.New("model aborted recipe summary")
+
+// recipeAbortError is the errRecipeAborted for one summary, with the model's
+// code and reason.
+type recipeAbortError struct {
+	Code   models.AbortCode `json:"code"`
+	Reason string           `json:"reason"`
+}
+
+func (e *recipeAbortError) Error() string {
+	return fmt.Sprintf("%v (%s): %s", errRecipeAborted, e.Code, e.Reason)
+}
+
+func (e *recipeAbortError) Unwrap() error {
+	return errRecipeAborted
+}
+
+// notRecipeTTL is how long a URL confirmed not to be a recipe is remembered,
+// so submitting it again is turned away without fetching or summarizing.
+const notRecipeTTL = 7 * 24 * time.Hour
+
+// notRecipeCacheKey is where the abort for a URL that isn't a recipe is
+// cached.
+func notRecipeCacheKey(u string) string {
+	return fmt.Sprintf("recipes:notarecipe:%s", u)
+}
+
+// cachedNotRecipe returns the cached abort for u, if it is known not to be a
+// recipe.
+func (wa *Webapp) cachedNotRecipe(u string) (*recipeAbortError, bool) {
+	cached, err := wa.cache.Get(notRecipeCacheKey(u))
+	if err != nil {
+		return nil, false
+	}
+	var abort recipeAbortError
+	if err := json.Unmarshal([]byte(cached), &abort); err != nil {
+		return nil, false
+	}
+	return &abort, true
+}
+
+// cacheNotRecipe remembers the abort for each of urls if it confirms the
+// content isn't a usable recipe. Aborts for unclear content aren't kept, as
+// another try may do better.
+func (wa *Webapp) cacheNotRecipe(l *leveledLogger, abort *recipeAbortError, urls ...string) {
+	if abort.Code != models.AbortNotFood && abort.Code != models.AbortUnsafe {
+		return
+	}
+	out, err := json.Marshal(abort)
+	if err != nil {
 		return
 	}
+	for _, u := range urls {
+		if err := wa.cache.SetEx(notRecipeCacheKey(u), string(out), int(notRecipeTTL.Seconds())); err != nil {
+			l.Warnf("[CACHE] Error caching non-recipe %s: %v\n", u, err)
+		}
+	}
+}
+
+// summarizeMarkdown summarizes recipe markdown for wine pairing, caching the
+// summary and flavor profile under id (a URL or "content:<hash>") if the cache
+// is enabled. On failure it returns the HTTP status the error should be
+// reported with.
+func (wa *Webapp) summarizeMarkdown(ctx context.Context, l *leveledLogger, id, md string) (models.Summary, int, error) {
+	// A URL whose content was already summarized, e.g. pasted as text, reuses
+	// that summary
+	contentID := contentSummaryID(md)
+	if wa.cacheEnabled && id != contentID {
+		if summary, ok := wa.reuseContentSummary(l, id, contentID); ok {
+			summary.Language = helpers.DetectLanguage(md)
+			return summary, http.StatusOK, nil
+		}
+	}
 
 	// Summarize recipe (use cache if enabled)
-	var summary string
+	var summary models.Summary
+	var err error
 	if wa.cacheEnabled {
 		l.Println("[CACHE] Cache enabled - checking for summary")
-		summary, err = wa.cache.GetOrFetch(fmt.Sprintf("recipes:summarized:%s", u), func() (string, error) {
+		var text string
+		text, err = wa.cache.GetOrFetch(fmt.Sprintf("recipes:summarized:%s", id), func() (string, error) {
 			l.Println("[CACHE] Cache miss - generating summary")
-			out, err := models.SummarizeRecipe(ctx, wa.model, md)
+			out, err := models.SummarizeRecipe(ctx, wa.modelFor(ctx), md)
 			if err != nil {
-				return "", fmt.Errorf("unable to get summary prompt response: %v", err)
+				return "", fmt.Errorf("unable to get summary prompt response: %w", err)
 			}
 			parsed, err := models.ParseSummary(out)
 			if err != nil {
 				return "", fmt.Errorf("unable to parse summary prompt response: %v", err)
 			}
 			if !parsed.Ok {
-				return "", fmt.Errorf("model aborted recipe summary: %s", parsed.AbortReason)
+				return "", &recipeAbortError{Code: parsed.AbortCode, Reason: parsed.AbortReason}
+			}
+			if err := models.ValidateSummary(parsed); err != nil {
+				return "", err
 			}
+			summary = parsed
+			wa.storeSummaryDetails(l, id, parsed)
 			return parsed.Summary, nil
 		})
+		if err == nil && summary.Summary == "" {
+			// Cache hit - the flavor profile, if any, is cached alongside the summary
+			summary = wa.cachedSummary(id, text)
+		}
 	} else {
 		l.Println("Cache disabled - generating summary directly")
-		out, err := models.SummarizeRecipe(ctx, wa.model, md)
+		out, err := models.SummarizeRecipe(ctx, wa.modelFor(ctx), md)
 		if err != nil {
-			helpers.SendJSONError(w, fmt.Errorf("unable to get summary prompt response: %v", err), http.StatusInternalServerError)
-			return
+			return models.Summary{}, http.StatusInternalServerError, fmt.Errorf("unable to get summary prompt response: %w", err)
 		}
 		parsed, err := models.ParseSummary(out)
 		if err != nil {
-			helpers.SendJSONError(w, fmt.Errorf("unable to parse summary prompt response: %v", err), http.StatusInternalServerError)
-			return
+			return models.Summary{}, http.StatusInternalServerError, fmt.Errorf("unable to parse summary prompt response: %v", err)
 		}
 		if !parsed.Ok {
-			helpers.SendJSONError(w, fmt.Errorf("model aborted recipe summary: %s", parsed.AbortReason), http.StatusBadRequest)
-			return
+			return models.Summary{}, http.StatusBadRequest, &recipeAbortError{Code: parsed.AbortCode, Reason: parsed.AbortReason}
+		}
+		if err := models.ValidateSummary(parsed); err != nil {
+			l.Warnf("Rejecting low-quality summary: %v\n", err)
+			return models.Summary{}, http.StatusBadRequest, err
 		}
-		summary = parsed.Summary
+		summary = parsed
 	}
-	if err != nil {
-		helpers.SendJSONError(w, fmt.Errorf("unable to summarize recipe contents: %v", err), http.StatusInternalServerError)
-		return
+	if err == nil && summary.Language == "" {
+		summary.Language = helpers.DetectLanguage(md)
 	}
-
-	tmp := struct {
-		Summary string `json:"summary"`
-	}{
-		Summary: summary,
+	if errors.Is(err, models.ErrLowQualitySummary) {
+		l.Warnf("Rejecting low-quality summary: %v\n", err)
+		return models.Summary{}, http.StatusBadRequest, err
+	}
+	if errors.Is(err, errRecipeAborted) {
+		return models.Summary{}, http.StatusBadRequest, err
 	}
-
-	out, err := json.Marshal(tmp)
 	if err != nil {
-		helpers.SendJSONError(w, fmt.Errorf("unable to render summary JSON: %v", err), http.StatusInternalServerError)
-		return
+		return models.Summary{}, http.StatusInternalServerError, fmt.Errorf("unable to summarize recipe contents: %v", err)
 	}
-	w.Header().Add("Content-Type", "application/json")
-	fmt.Fprint(w, string(out))
-}
-
-func getCacheKeyForInput(input string) string {
-	// test for URL. use content hash otherwise.
-	if matches := recentSuggestionRx.FindString(input); matches != "" {
-		// Input is a URL, so use it directly as cache key
-		return fmt.Sprintf("recipes:suggestions-json:%s", matches)
+	if wa.cacheEnabled && id != contentID {
+		wa.copySummary(l, id, contentID, summary)
 	}
 
-	// Input is not a URL, hash the content for cache key
-	hash := helpers.HashContent(input)
-	return fmt.Sprintf("recipes:suggestions-json:content:%s", hash)
+	return summary, http.StatusOK, nil
 }
 
-// getPairingIDAndType determines the pairing ID and type from input.
-// Returns the ID (URL or content hash) and the corresponding PairingType.
+// regeneratedSummary is the body of a regenerated summary. Invalidated counts
+// the suggestion entries dropped along with it.
+type regeneratedSummary struct {
+	Summary     string `json:"summary"`
+	Invalidated int    `json:"invalidated"`
+}
+
+// PostRegenerateSummary implements the route at
+// "POST /recipes/summary/{url}/regenerate", summarizing the recipe again from
+// its cached markdown, without fetching the page, and overwriting the cached
+// summary. With ?invalidateSuggestions=true the recipe's suggestions and
+// explanations, including its stored pairing, are dropped too, so the next
+// request pairs against the new summary; otherwise a stored pairing keeps the
+// summary it was made with. It needs the cache, and responds 404 for recipes
+// that haven't been summarized before.
+func (wa *Webapp) PostRegenerateSummary(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	l := wa.newLogger("[PostRegenerateSummary]")
+	l.Println("Handling PostRegenerateSummary")
+
+	u := getPathValue(r, "url")
+	if u == "" {
+		helpers.SendJSONError(w, fmt.Errorf("URL required"), http.StatusBadRequest)
+		return
+	}
+	if status, err := wa.checkRecipeURL(u); err != nil {
+		sendError(w, err, status)
+		return
+	}
+	if !wa.cacheEnabled {
+		helpers.SendJSONError(w, fmt.Errorf("regenerating summaries needs the cache"), http.StatusNotFound)
+		return
+	}
+	accountID, ok := r.Context().Value(sessionContextName).(string)
+	if !ok {
+		helpers.SendJSONError(w, fmt.Errorf("unable to get account ID from context"), http.StatusInternalServerError)
+		return
+	}
+
+	urls := []string{u}
+	if canonical, err := wa.cache.Get(fmt.Sprintf("recipes:canonical:%s", u)); err == nil && canonical != u {
+		l.Printf("[CACHE] Using canonical URL %s\n", canonical)
+		u = canonical
+		urls = append(urls, canonical)
+	}
+	md, err := wa.cache.Get(fmt.Sprintf("recipes:parsed:%s", u))
+	if errors.Is(err, cache.ErrKeyNotFound) {
+		helpers.SendJSONError(w, fmt.Errorf("recipe has not been summarized yet"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.SendJSONError(w, fmt.Errorf("unable to load recipe markdown: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	l.Println("Regenerating summary from cached markdown")
+	out, err := models.SummarizeRecipe(ctx, wa.modelFor(ctx), md)
+	if err != nil {
+		sendError(w, fmt.Errorf("unable to get summary prompt response: %w", err), http.StatusInternalServerError)
+		return
+	}
+	summary, err := models.ParseSummary(out)
+	if err != nil {
+		helpers.SendJSONError(w, fmt.Errorf("unable to parse summary prompt response: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !summary.Ok {
+		helpers.SendJSONError(w, &recipeAbortError{Code: summary.AbortCode, Reason: summary.AbortReason}, http.StatusBadRequest)
+		return
+	}
+	if err := models.ValidateSummary(summary); err != nil {
+		l.Warnf("Rejecting low-quality summary: %v\n", err)
+		helpers.SendJSONError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := wa.spendQuota(r.Context(), l, accountID); err != nil {
+		sendQuotaError(w, err)
+		return
+	}
+
+	// The copy shared with pasted text of the same recipe is replaced too
+	for _, id := range []string{u, contentSummaryID(md)} {
+		if err := wa.storeSummary(l, id, summary); err != nil {
+			helpers.SendJSONError(w, fmt.Errorf("unable to store summary: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	resp := regeneratedSummary{Summary: summary.Summary}
+	if r.URL.Query().Get("invalidateSuggestions") == "true" {
+		for _, v := range urls {
+			if err := wa.dl.DeleteRecipePairing(ctx, v); err != nil {
+				l.Warnf("[DB] Error deleting stored pairing: %v\n", err)
+			}
+		}
+		keys, err := wa.suggestionCacheKeys(urls)
+		if err == nil {
+			resp.Invalidated, err = wa.deleteCacheKeys(keys)
+		}
+		if err != nil {
+			helpers.SendJSONError(w, fmt.Errorf("unable to invalidate suggestions: %v", err), http.StatusInternalServerError)
+			return
+		}
+		l.Printf("Invalidated %d suggestion entries\n", resp.Invalidated)
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// contentSummaryID is the id a recipe's summary is also cached under, keyed
+// by a normalized hash of its text, so URL and pasted-text inputs with the
+// same content share it.
+func contentSummaryID(md string) string {
+	return fmt.Sprintf("content:%s", helpers.HashRecipeContent(md))
+}
+
+// contentHashCacheKey is where the content hash of the recipe at u is cached.
+func contentHashCacheKey(u string) string {
+	return fmt.Sprintf("recipes:content-hash:%s", u)
+}
+
+// reuseContentSummary records that the recipe at u has the content contentID
+// and, if a summary for that content is cached, copies it under u and
+// returns it.
+func (wa *Webapp) reuseContentSummary(l *leveledLogger, u, contentID string) (models.Summary, bool) {
+	if err := wa.cache.Set(contentHashCacheKey(u), strings.TrimPrefix(contentID, "content:")); err != nil {
+		l.Warnf("[CACHE] Error storing content hash: %v\n", err)
+	}
+	text, err := wa.cache.Get(fmt.Sprintf("recipes:summarized:%s", contentID))
+	if err != nil {
+		return models.Summary{}, false
+	}
+
+	l.Printf("[CACHE] Reusing summary of identical content %s\n", contentID)
+	summary := wa.cachedSummary(contentID, text)
+	wa.copySummary(l, contentID, u, summary)
+	return summary, true
+}
+
+// copySummary caches summary and its flavor profile under id unless a summary
+// is already cached there. from is only used for logging.
+func (wa *Webapp) copySummary(l *leveledLogger, from, id string, summary models.Summary) {
+	key := fmt.Sprintf("recipes:summarized:%s", id)
+	if _, err := wa.cache.Get(key); !errors.Is(err, cache.ErrKeyNotFound) {
+		return
+	}
+	if err := wa.storeSummary(l, id, summary); err != nil {
+		l.Warnf("[CACHE] Error sharing summary of %s: %v\n", from, err)
+	}
+}
+
+// storeSummary caches summary and its flavor profile under id, replacing any
+// already cached there.
+func (wa *Webapp) storeSummary(l *leveledLogger, id string, summary models.Summary) error {
+	if err := wa.cache.Set(fmt.Sprintf("recipes:summarized:%s", id), summary.Summary); err != nil {
+		return err
+	}
+	wa.storeSummaryDetails(l, id, summary)
+	return nil
+}
+
+// summaryDetails is what is cached at flavorProfileCacheKey: the flavor
+// profile, whose fields are inlined so profiles cached on their own still
+// read, and the structured details of the summary.
+type summaryDetails struct {
+	models.FlavorProfile
+	Ingredients      []string `json:"ingredients,omitempty"`
+	CookingMethods   []string `json:"cookingMethods,omitempty"`
+	Servings         int      `json:"servings,omitempty"`
+	TotalTimeMinutes int      `json:"totalTimeMinutes,omitempty"`
+	Allergens        []string `json:"allergens,omitempty"`
+}
+
+// storeSummaryDetails caches the flavor profile and structured details of
+// summary under id.
+func (wa *Webapp) storeSummaryDetails(l *leveledLogger, id string, summary models.Summary) {
+	details, err := json.Marshal(summaryDetails{
+		FlavorProfile:    summary.FlavorProfile,
+		Ingredients:      summary.Ingredients,
+		CookingMethods:   summary.CookingMethods,
+		Servings:         summary.Servings,
+		TotalTimeMinutes: summary.TotalTimeMinutes,
+		Allergens:        summary.Allergens,
+	})
+	if err != nil {
+		return
+	}
+	if err := wa.cache.Set(flavorProfileCacheKey(id), string(details)); err != nil {
+		l.Warnf("[CACHE] Error storing flavor profile: %v\n", err)
+	}
+}
+
+// flavorProfileCacheKey is where the flavor profile and other details of a
+// summarized recipe are cached, alongside the prose summary at
+// "recipes:summarized:<url>".
+func flavorProfileCacheKey(u string) string {
+	return fmt.Sprintf("recipes:profile:%s", u)
+}
+
+// cachedSummary rebuilds a Summary from a cached prose summary, adding the
+// cached flavor profile and details when they exist.
+func (wa *Webapp) cachedSummary(u, text string) models.Summary {
+	summary := models.Summary{Ok: true, Summary: text}
+	if cached, err := wa.cache.Get(flavorProfileCacheKey(u)); err == nil {
+		var details summaryDetails
+		if err := json.Unmarshal([]byte(cached), &details); err != nil {
+			log.Printf("[CACHE] Ignoring unreadable flavor profile for %s: %v\n", u, err)
+		} else {
+			summary.FlavorProfile = details.FlavorProfile
+			summary.Ingredients = details.Ingredients
+			summary.CookingMethods = details.CookingMethods
+			summary.Servings = details.Servings
+			summary.TotalTimeMinutes = details.TotalTimeMinutes
+			summary.Allergens = details.Allergens
+		}
+	}
+	return summary
+}
+
+func getCacheKeyForInput(input string) string {
+	// test for URL. use content hash otherwise.
+	if matches := recentSuggestionRx.FindString(input); matches != "" {
+		// Input is a URL, so use it directly as cache key
+		return fmt.Sprintf("recipes:suggestions-json:%s", matches)
+	}
+
+	// Input is not a URL, hash the content for cache key
+	hash := helpers.HashContent(input)
+	return fmt.Sprintf("recipes:suggestions-json:content:%s", hash)
+}
+
+// getCacheKeyForPreferences returns the cache key for suggestions tailored to
+// pairing preferences, so each set of preferences is cached independently.
+// The preferences are hashed, since disliked styles are free text that could
+// hold glob characters the key scans would trip on, and come before the URL or
+// hash so recent-suggestion scans still find the URL.
+func getCacheKeyForPreferences(input string, prefs models.PairingPreferences) string {
+	return strings.Replace(getCacheKeyForInput(input), "recipes:suggestions-json:",
+		fmt.Sprintf("recipes:suggestions-json:prefs:%s:", prefs.Hash()), 1)
+}
+
+// getPairingIDAndType determines the pairing ID and type from input.
+// Returns the ID (URL or content hash) and the corresponding PairingType.
 func getPairingIDAndType(input string) (string, data.PairingType) {
 	if matches := recentSuggestionRx.FindString(input); matches != "" {
 		return matches, data.PairingTypeURL
@@ -688,344 +2547,1519 @@ func convertToDataSuggestions(modelSuggestions []models.Suggestion) []data.Sugge
 			Region:      ms.Region,
 			Description: ms.Description,
 			PairingNote: ms.PairingNote,
+			Confidence:  ms.Confidence,
+		}
+	}
+	return dataSuggestions
+}
+
+// convertFromDataSuggestions converts data.Suggestion slice to models.Suggestion slice
+func convertFromDataSuggestions(dataSuggestions []data.Suggestion) []models.Suggestion {
+	modelSuggestions := make([]models.Suggestion, len(dataSuggestions))
+	for i, ds := range dataSuggestions {
+		modelSuggestions[i] = models.Suggestion{
+			Style:       ds.Style,
+			Region:      ds.Region,
+			Description: ds.Description,
+			PairingNote: ds.PairingNote,
+			Confidence:  ds.Confidence,
+		}
+	}
+	return modelSuggestions
+}
+
+// reconstructSuggestionsJSON converts a data.Suggestion slice to JSON string
+func reconstructSuggestionsJSON(suggestions []data.Suggestion) (string, error) {
+	modelSuggestions := convertFromDataSuggestions(suggestions)
+	jsonBytes, err := json.Marshal(modelSuggestions)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal suggestions to JSON: %w", err)
+	}
+	return string(jsonBytes), nil
+}
+
+// reconstructSuggestionsV2JSON converts RecipePairing to SuggestionsResponse JSON string
+func reconstructSuggestionsV2JSON(pairing data.RecipePairing) (string, error) {
+	response := models.SuggestionsResponse{
+		Suggestions: convertFromDataSuggestions(pairing.Suggestions),
+		Summary:     pairing.Summary,
+	}
+	jsonBytes, err := json.Marshal(response)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal suggestions response to JSON: %w", err)
+	}
+	return string(jsonBytes), nil
+}
+
+// groupByTypeRequested reports whether the client asked for suggestions grouped
+// by wine type with the "?group=type" query parameter.
+func groupByTypeRequested(r *http.Request) bool {
+	return r.URL.Query().Get("group") == "type"
+}
+
+// minConfidenceParam reads the "?minConfidence=" query parameter, reporting
+// whether it was set.
+func minConfidenceParam(r *http.Request) (float64, bool, error) {
+	v := r.URL.Query().Get("minConfidence")
+	if v == "" {
+		return 0, false, nil
+	}
+
+	min, err := strconv.ParseFloat(v, 64)
+	if err != nil || min < 0 || min > 1 {
+		return 0, false, fmt.Errorf("minConfidence must be a number between 0 and 1")
+	}
+
+	return min, true, nil
+}
+
+// suggestionCountParams reads the "?min=" and "?max=" suggestion counts; see
+// models.ParseSuggestionCounts.
+func suggestionCountParams(r *http.Request) (int, int, error) {
+	return models.ParseSuggestionCounts(r.URL.Query().Get("min"), r.URL.Query().Get("max"))
+}
+
+// jsonArrayFlushEvery is how many elements a jsonArrayWriter writes between
+// flushes.
+const jsonArrayFlushEvery = 50
+
+// jsonArrayWriter streams a JSON array to a response one element at a time,
+// so list endpoints needn't buffer the whole encoding. The status and headers
+// are sent with the opening bracket, so errors after that can only be logged.
+type jsonArrayWriter struct {
+	w       http.ResponseWriter
+	enc     *json.Encoder
+	flusher http.Flusher
+	n       int
+}
+
+// newJSONArrayWriter sets the JSON content type and opens the array.
+func newJSONArrayWriter(w http.ResponseWriter) (*jsonArrayWriter, error) {
+	w.Header().Add("Content-Type", "application/json")
+	if _, err := io.WriteString(w, "["); err != nil {
+		return nil, err
+	}
+	flusher, _ := w.(http.Flusher)
+	return &jsonArrayWriter{w: w, enc: json.NewEncoder(w), flusher: flusher}, nil
+}
+
+// Write appends v to the array, flushing every jsonArrayFlushEvery elements.
+func (a *jsonArrayWriter) Write(v any) error {
+	if a.n > 0 {
+		if _, err := io.WriteString(a.w, ","); err != nil {
+			return err
+		}
+	}
+	if err := a.enc.Encode(v); err != nil {
+		return err
+	}
+	a.n++
+	if a.flusher != nil && a.n%jsonArrayFlushEvery == 0 {
+		a.flusher.Flush()
+	}
+	return nil
+}
+
+// Close ends the array.
+func (a *jsonArrayWriter) Close() error {
+	_, err := io.WriteString(a.w, "]")
+	return err
+}
+
+// writeJSONArray streams items to w as a JSON array.
+func writeJSONArray[T any](w http.ResponseWriter, items []T) error {
+	a, err := newJSONArrayWriter(w)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := a.Write(item); err != nil {
+			return err
+		}
+	}
+	return a.Close()
+}
+
+// writeSuggestionsJSON writes a V1 suggestions payload. If the request sets
+// minConfidence, less confident suggestions are dropped; if none are left, the
+// most confident one is kept and explained in the X-Suggestions-Note header.
+// A max count trims the list. If the request asks for grouping, the suggestion
+// array is replaced with a map of wine type to suggestions.
+func writeSuggestionsJSON(w http.ResponseWriter, r *http.Request, suggestionsJSON string) {
+	minConfidence, filter, err := minConfidenceParam(r)
+	if err != nil {
+		helpers.SendJSONError(w, err, http.StatusBadRequest)
+		return
+	}
+	_, maxCount, err := suggestionCountParams(r)
+	if err != nil {
+		helpers.SendJSONError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if filter || maxCount > 0 || groupByTypeRequested(r) {
+		parsed, err := models.ParseSuggestions(suggestionsJSON)
+		if err != nil {
+			helpers.SendJSONError(w, fmt.Errorf("unable to filter suggestions: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		if filter {
+			var fellBack bool
+			parsed, fellBack = models.FilterByConfidence(parsed, minConfidence)
+			if fellBack {
+				w.Header().Set("X-Suggestions-Note", fmt.Sprintf("no pairings met minConfidence=%g; returning the most confident pairing", minConfidence))
+			}
+		}
+		parsed = models.TruncateSuggestions(parsed, maxCount)
+
+		var body any = parsed
+		if groupByTypeRequested(r) {
+			body = models.GroupSuggestionsByType(parsed)
+		}
+		out, err := json.Marshal(body)
+		if err != nil {
+			helpers.SendJSONError(w, fmt.Errorf("unable to render suggestions JSON: %v", err), http.StatusInternalServerError)
+			return
+		}
+		suggestionsJSON = string(out)
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	fmt.Fprint(w, suggestionsJSON)
+}
+
+// validatedSuggestionsResponse is a V2 response whose suggestions carry fit
+// scores from models.ScorePairings.
+type validatedSuggestionsResponse struct {
+	models.SuggestionsResponse
+	Suggestions []models.ScoredSuggestion `json:"suggestions"`
+}
+
+// validateRequested reports whether the request asks for suggestions to be
+// scored against the recipe with "?validate=true".
+func validateRequested(r *http.Request) bool {
+	return r.URL.Query().Get("validate") == "true"
+}
+
+// writeSuggestionsV2JSON writes a V2 suggestions response. If the request asks
+// for grouping, the response also carries the suggestions grouped by wine type.
+// If it asks for validation, each suggestion is scored against the summary,
+// best fit first; if scoring fails, the suggestions are sent unscored.
+func (wa *Webapp) writeSuggestionsV2JSON(w http.ResponseWriter, r *http.Request, l *leveledLogger, responseJSON string) {
+	if groupByTypeRequested(r) || validateRequested(r) {
+		parsed, err := models.ParseSuggestionsV2(responseJSON)
+		if err != nil {
+			helpers.SendJSONError(w, fmt.Errorf("unable to read suggestions: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if groupByTypeRequested(r) {
+			parsed.Groups = models.GroupSuggestionsByType(parsed.Suggestions)
+		}
+
+		var body any = parsed
+		if validateRequested(r) {
+			ctx := r.Context()
+			scored, err := models.ScorePairings(ctx, wa.modelFor(ctx), parsed.Summary, parsed.Suggestions)
+			if err != nil {
+				l.Warnf("Unable to validate suggestions, sending them unscored: %v\n", err)
+			} else {
+				body = validatedSuggestionsResponse{SuggestionsResponse: parsed, Suggestions: scored}
+			}
+		}
+
+		out, err := json.Marshal(body)
+		if err != nil {
+			helpers.SendJSONError(w, fmt.Errorf("unable to render suggestions JSON: %v", err), http.StatusInternalServerError)
+			return
 		}
+		responseJSON = string(out)
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	fmt.Fprint(w, responseJSON)
+}
+
+// GetRecipeWineSuggestionsV2 implements the route at
+// "GET /recipes/suggestionsV2/{url}". Note that "POST /recipes" MUST be called first.
+// Otherwise, this route calls a bad request error since the recipe summary
+// hasn't been cached yet. This introduces a stateful dependency, but it
+// minimizes the need to pass the summary to this endpoint in the request.
+func (wa *Webapp) GetRecipeWineSuggestionsV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	l := wa.newLogger("[GetRecipeWineSuggestionsV2] ")
+	l.Println("Handling GetRecipeWineSuggestionsV2")
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		helpers.SendJSONError(w, fmt.Errorf("unable to read request: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	input := strings.TrimSpace(string(body))
+
+	if input == "" {
+		helpers.SendJSONError(w, fmt.Errorf("input cannot be empty"), http.StatusBadRequest)
+		return
+	}
+
+	var prefs models.PairingPreferences
+	if prefs.Occasion, err = models.ParseOccasion(r.URL.Query().Get("occasion")); err != nil {
+		helpers.SendJSONError(w, err, http.StatusBadRequest)
+		return
+	}
+	if prefs.Types, err = models.ParseWineTypes(r.URL.Query().Get("types")); err != nil {
+		helpers.SendJSONError(w, err, http.StatusBadRequest)
+		return
+	}
+	if prefs.Market, err = models.ParseMarket(r.URL.Query().Get("market")); err != nil {
+		helpers.SendJSONError(w, err, http.StatusBadRequest)
+		return
+	}
+	if prefs.Persona, err = models.ParsePersona(r.URL.Query().Get("persona")); err != nil {
+		helpers.SendJSONError(w, err, http.StatusBadRequest)
+		return
+	}
+	// The body is the recipe itself, so counts come from the query string
+	if prefs.MinCount, prefs.MaxCount, err = suggestionCountParams(r); err != nil {
+		helpers.SendJSONError(w, err, http.StatusBadRequest)
+		return
+	}
+	if accountID, ok := r.Context().Value(sessionContextName).(string); ok {
+		wa.applyPreferenceProfile(l, accountID, &prefs)
+	}
+	wa.restrictPreferences(&prefs)
+
+	k := getCacheKeyForInput(input)
+	pairingID, pairingType := getPairingIDAndType(input)
+	if pairingType == data.PairingTypeURL {
+		// Bare "www." links are valid input here; the agent resolves them
+		if !helpers.HostAllowed(pairingID, wa.allowedDomains) {
+			helpers.SendJSONError(w, errRecipeDomainNotAllowed, http.StatusForbidden)
+			return
+		}
+	}
+	if !prefs.IsZero() {
+		// Stored pairings are preference-neutral, so tailored results are
+		// only cached, under their own key.
+		k = getCacheKeyForPreferences(input, prefs)
+	}
+
+	// PRIMARY: Try DynamoDB first (source of truth)
+	l.Printf("[DB] Checking DynamoDB for pairing ID: %s (type: %s)\n", pairingID, pairingType)
+	if !prefs.IsZero() {
+		l.Printf("[DB] Skipping DynamoDB for preferences %q\n", prefs.Key())
+	} else if pairing, err := wa.dl.GetRecipePairing(ctx, pairingID); err == nil {
+		l.Printf("[DB] Found pairing in DynamoDB (created: %s)\n", pairing.DateCreated)
+
+		// Reconstruct JSON response from DynamoDB data
+		responseJSON, err := reconstructSuggestionsV2JSON(pairing)
+		if err != nil {
+			l.Warnf("[DB] Error reconstructing JSON from DynamoDB pairing: %v\n", err)
+		} else {
+			// Backfill cache if enabled
+			if wa.cacheEnabled {
+				l.Println("[CACHE] Cache enabled - backfilling cache from DynamoDB result")
+				if err := wa.cache.Set(k, responseJSON); err != nil {
+					l.Warnf("[CACHE] Error backfilling cache: %v\n", err)
+				}
+			}
+
+			markCached(ctx)
+			wa.writeSuggestionsV2JSON(w, r, l, responseJSON)
+			return
+		}
+	} else if !errors.Is(err, data.ErrNotFound) {
+		l.Warnf("[DB] Error querying DynamoDB: %v\n", err)
+	} else {
+		l.Println("[DB] Pairing not found in DynamoDB")
+	}
+
+	// OPTIONAL: Try cache if enabled and DB missed
+	if wa.cacheEnabled {
+		l.Printf("[CACHE] Cache enabled - checking cache for key: %s\n", k)
+		if cached, err := wa.cache.Get(k); err == nil {
+			l.Println("[CACHE] Cache hit, returning cached result")
+			markCached(ctx)
+			wa.writeSuggestionsV2JSON(w, r, l, cached)
+			return
+		}
+		l.Println("[CACHE] Cache miss")
+	}
+
+	// Both systems missed - generate new content
+	var response string
+	if wa.agentDisabled {
+		l.Println("Agent disabled - generating suggestions directly")
+		response, err = wa.directSuggestionsV2(ctx, l, input, pairingID, pairingType, prefs)
+	} else {
+		l.Println("Generating new suggestions with model")
+		response, err = models.GeneratePairingSuggestionsV2(ctx, wa.modelFor(ctx), wa.tools, input, prefs)
+		if errors.Is(err, models.ErrAgentUnfinished) {
+			l.Warnf("Agent did not finish - falling back to single-prompt suggestions: %v\n", err)
+			response, err = wa.fallbackSuggestionsV2(ctx, l, input, pairingID, pairingType, prefs)
+		}
+	}
+	if err != nil {
+		l.Errorf("Error from model: %v\n", err)
+		if wa.sendFallbackSuggestions(w, r, l, err, true) {
+			return
+		}
+		sendError(w, fmt.Errorf("error generating suggestions: %w", err), http.StatusInternalServerError)
+		return
+	}
+
+	l.Println("Model response received")
+	l.Debugf("Model response: %s\n", response)
+
+	// Parse the response to extract suggestions and summary
+	parsed, err := models.ParseSuggestionsV2(response)
+	if err != nil {
+		helpers.SendJSONError(w, fmt.Errorf("error generating suggestions: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Enforce the type filter and count, only report exclusions when a
+	// filter is active, and record the market the wines were chosen for
+	_, maxCount := prefs.CountRange()
+	if len(prefs.Types) > 0 || len(parsed.Exclusions) > 0 || len(parsed.Suggestions) > maxCount || prefs.Market != "" {
+		models.ApplyTypeFilter(&parsed, prefs.Types)
+		parsed.Market = prefs.Market
+		parsed.Suggestions = models.TruncateSuggestions(parsed.Suggestions, maxCount)
+		out, err := json.Marshal(parsed)
+		if err != nil {
+			helpers.SendJSONError(w, fmt.Errorf("unable to render suggestions JSON: %v", err), http.StatusInternalServerError)
+			return
+		}
+		response = string(out)
+	}
+
+	// PRIMARY: Store in DynamoDB. Partial results aren't stored anywhere, so
+	// the next request gets another chance at a complete answer.
+	if parsed.Partial {
+		l.Println("Not storing partial suggestions")
+	} else if len(parsed.Suggestions) == 0 {
+		// Stored pairings have nowhere to keep the note, so only the cache
+		// holds it
+		l.Printf("[DB] Not storing pairing without suggestions: %s\n", parsed.Note)
+	} else if prefs.IsZero() {
+		dataSuggestions := convertToDataSuggestions(parsed.Suggestions)
+		l.Printf("[DB] Storing pairing in DynamoDB (ID: %s, type: %s)\n", pairingID, pairingType)
+		if _, err := wa.dl.CreateRecipePairing(ctx, pairingID, pairingType, parsed.Summary, dataSuggestions); err != nil {
+			l.Warnf("[DB] Error storing in DynamoDB: %v\n", err)
+		}
+	}
+
+	// OPTIONAL: Store in cache if enabled
+	if wa.cacheEnabled && !parsed.Partial {
+		l.Printf("[CACHE] Cache enabled - storing suggestions in cache (key: %s)\n", k)
+		if err := wa.cache.Set(k, response); err != nil {
+			l.Warnf("[CACHE] Error storing in cache: %v\n", err)
+		}
+	}
+
+	accountID := r.Context().Value(sessionContextName)
+	if a, ok := accountID.(string); ok {
+		if err := wa.spendQuota(r.Context(), l, a); err != nil {
+			sendQuotaError(w, err)
+			return
+		}
+	} else {
+		l.Println("Unable to look up account ID from context to decrement quota")
+	}
+
+	wa.writeSuggestionsV2JSON(w, r, l, response)
+}
+
+// fallbackSuggestionsV2 generates V2 suggestions without the agent, for when
+// it runs out of iterations. Recipe text is paired directly; URLs need a
+// summary the agent or an earlier request already cached. The response is
+// marked partial.
+func (wa *Webapp) fallbackSuggestionsV2(ctx context.Context, l *leveledLogger, input, pairingID string, pairingType data.PairingType, prefs models.PairingPreferences) (string, error) {
+	summary := models.Summary{Ok: true, Summary: input}
+	if pairingType == data.PairingTypeURL {
+		if !wa.cacheEnabled {
+			return "", fmt.Errorf("agent did not finish and no recipe summary is available to fall back on")
+		}
+		text, err := wa.cache.Get(fmt.Sprintf("recipes:summarized:%s", pairingID))
+		if err != nil {
+			return "", fmt.Errorf("agent did not finish and no recipe summary is cached to fall back on: %v", err)
+		}
+		summary = wa.cachedSummary(pairingID, text)
+	}
+
+	return wa.pairSummaryV2(ctx, l, summary, prefs, true)
+}
+
+// directSuggestionsV2 generates V2 suggestions without the agent, for
+// deployments that disable it: URLs are fetched and summarized, and recipe
+// text summarized, the same way the V1 routes do, before pairing.
+func (wa *Webapp) directSuggestionsV2(ctx context.Context, l *leveledLogger, input, pairingID string, pairingType data.PairingType, prefs models.PairingPreferences) (string, error) {
+	var summary models.Summary
+	var err error
+	if pairingType == data.PairingTypeURL {
+		summary, _, err = wa.summarizeRecipeURL(ctx, l, pairingID)
+	} else {
+		summary, _, err = wa.summarizeMarkdown(ctx, l, contentSummaryID(input), input)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return wa.pairSummaryV2(ctx, l, summary, prefs, false)
+}
+
+// pairSummaryV2 generates single-prompt suggestions for summary and renders
+// them as a V2 response, marked partial if asked.
+func (wa *Webapp) pairSummaryV2(ctx context.Context, l *leveledLogger, summary models.Summary, prefs models.PairingPreferences, partial bool) (string, error) {
+	answer, err := models.GeneratePairingSuggestionsForPreferences(ctx, wa.modelFor(ctx), summary, prefs)
+	if err != nil {
+		return "", err
+	}
+	parsed, err := models.ParseSuggestionsResult(answer, models.ParseOptions{
+		Tolerant: true,
+		OnItemError: func(e *models.ItemError) {
+			l.Warnf("Warning: skipping malformed suggestion: %v\n", e)
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	if parsed.Truncated {
+		l.Warnf("Warning: model output was truncated; keeping %d complete suggestions\n", len(parsed.Suggestions))
+	}
+
+	out, err := json.Marshal(models.SuggestionsResponse{
+		Suggestions: parsed.Suggestions,
+		Summary:     summary.Summary,
+		Partial:     partial,
+		Truncated:   parsed.Truncated,
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to render suggestions: %v", err)
+	}
+	return string(out), nil
+}
+
+// GetRecipeWineSuggestions implements the route at
+// "GET /recipes/suggestions/{url}". Note that "POST /recipes" MUST be called first.
+// Otherwise, this route calls a bad request error since the recipe summary
+// hasn't been cached yet. This introduces a stateful dependency, but it
+// minimizes the need to pass the summary to this endpoint in the request.
+// Clients that already have the summary can avoid the dependency with
+// "POST /recipes/suggestions".
+func (wa *Webapp) GetRecipeWineSuggestions(w http.ResponseWriter, r *http.Request) {
+	l := wa.newLogger("[GetRecipeWineSuggestions]")
+	l.Println("Handling GetRecipeWineSuggestions")
+
+	wa.recipeWineSuggestions(w, r, l, getPathValue(r, "url"), "")
+}
+
+// suggestionsRequest is the body of "POST /recipes/suggestions".
+type suggestionsRequest struct {
+	URL     string `json:"url"`
+	Summary string `json:"summary"`
+}
+
+// PostRecipeWineSuggestions implements the route at
+// "POST /recipes/suggestions". It responds like
+// "GET /recipes/suggestions/{url}" for the recipe at the body's url, but a
+// summary in the body is used instead of the cached one, so the recipe needn't
+// have been summarized first. Without a summary, it falls back to the cache.
+// Suggestions generated from a summary the client provided are not stored,
+// since the summary may not match the recipe.
+func (wa *Webapp) PostRecipeWineSuggestions(w http.ResponseWriter, r *http.Request) {
+	l := wa.newLogger("[PostRecipeWineSuggestions]")
+	l.Println("Handling PostRecipeWineSuggestions")
+
+	var req suggestionsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		helpers.SendJSONError(w, fmt.Errorf("unable to read request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	wa.recipeWineSuggestions(w, r, l, strings.TrimSpace(req.URL), strings.TrimSpace(req.Summary))
+}
+
+// recipeWineSuggestions serves V1 suggestions for the recipe at u, generating
+// them from providedSummary if it is set, or else from the cached summary.
+func (wa *Webapp) recipeWineSuggestions(w http.ResponseWriter, r *http.Request, l *leveledLogger, u, providedSummary string) {
+	ctx := r.Context()
+	if u == "" {
+		helpers.SendJSONError(w, fmt.Errorf("URL required"), http.StatusBadRequest)
+		return
+	}
+	if _, _, err := minConfidenceParam(r); err != nil {
+		helpers.SendJSONError(w, err, http.StatusBadRequest)
+		return
+	}
+	var prefs models.PairingPreferences
+	var err error
+	if prefs.MinCount, prefs.MaxCount, err = suggestionCountParams(r); err != nil {
+		helpers.SendJSONError(w, err, http.StatusBadRequest)
+		return
+	}
+	if status, err := wa.checkRecipeURL(u); err != nil {
+		sendError(w, err, status)
+		return
+	}
+	wa.recordHit(l, u)
+
+	cacheKey := fmt.Sprintf("recipes:suggestions-json:%s", u)
+	pairingID := u // For this endpoint, the pairing ID is the URL itself
+	pairingType := data.PairingTypeURL
+	if accountID, ok := ctx.Value(sessionContextName).(string); ok {
+		wa.applyPreferenceProfile(l, accountID, &prefs)
+	}
+	wa.restrictPreferences(&prefs)
+	if !prefs.IsZero() {
+		cacheKey = getCacheKeyForPreferences(u, prefs)
+	}
+
+	// PRIMARY: Try DynamoDB first (source of truth)
+	l.Printf("[DB] Checking DynamoDB for pairing ID: %s\n", pairingID)
+	if !prefs.IsZero() {
+		l.Printf("[DB] Skipping DynamoDB for preferences %q\n", prefs.Key())
+	} else if pairing, err := wa.dl.GetRecipePairing(ctx, pairingID); err == nil {
+		l.Printf("[DB] Found pairing in DynamoDB (created: %s)\n", pairing.DateCreated)
+
+		// Reconstruct JSON response from DynamoDB data
+		suggestionsJSON, err := reconstructSuggestionsJSON(pairing.Suggestions)
+		if err != nil {
+			l.Warnf("[DB] Error reconstructing JSON from DynamoDB pairing: %v\n", err)
+		} else {
+			// Backfill cache if enabled
+			if wa.cacheEnabled {
+				l.Println("[CACHE] Cache enabled - backfilling cache from DynamoDB result")
+				if err := wa.cache.Set(cacheKey, suggestionsJSON); err != nil {
+					l.Warnf("[CACHE] Error backfilling cache: %v\n", err)
+				}
+			}
+
+			markCached(ctx)
+			writeSuggestionsJSON(w, r, suggestionsJSON)
+			return
+		}
+	} else if !errors.Is(err, data.ErrNotFound) {
+		l.Warnf("[DB] Error querying DynamoDB: %v\n", err)
+	} else {
+		l.Println("[DB] Pairing not found in DynamoDB")
+	}
+
+	// OPTIONAL: Try cache if enabled and DB missed
+	if wa.cacheEnabled {
+		l.Printf("[CACHE] Cache enabled - checking cache for key: %s\n", cacheKey)
+		if cached, err := wa.cache.Get(cacheKey); err == nil {
+			l.Println("[CACHE] Cache hit, returning cached suggestions")
+			markCached(ctx)
+			writeSuggestionsJSON(w, r, cached)
+			return
+		}
+		l.Println("[CACHE] Cache miss")
+	}
+
+	// Need to generate new - get summary first
+	var summary models.Summary
+	if providedSummary != "" {
+		l.Println("Using summary from request")
+		summary = models.Summary{Ok: true, Summary: providedSummary}
+	} else if wa.cacheEnabled {
+		// Try to get summary from cache (expected from prior PostCreateRecipe call)
+		l.Println("[CACHE] Cache enabled - fetching summary from cache")
+		text, err := wa.cache.GetOrFetch(fmt.Sprintf("recipes:summarized:%s", u), func() (string, error) {
+			return "", fmt.Errorf("expected a summary to be generated before this call")
+		})
+		if err != nil {
+			helpers.SendJSONError(w, fmt.Errorf("unable to load summary: %v", err), http.StatusInternalServerError)
+			return
+		}
+		summary = wa.cachedSummary(u, text)
+	} else {
+		// Cache disabled - need summary for generation but don't have it
+		// This endpoint requires PostCreateRecipe to be called first, which caches the summary
+		// Without cache, we don't have the summary, so we can't proceed
+		helpers.SendJSONError(w, fmt.Errorf("summary not available - this endpoint requires cache or prior pairing in DB"), http.StatusInternalServerError)
+		return
+	}
+
+	// Both systems missed - generate new content
+	accountID := r.Context().Value(sessionContextName)
+	a, ok := accountID.(string)
+	if !ok {
+		helpers.SendJSONError(w, fmt.Errorf("unable to get account ID from context"), http.StatusInternalServerError)
+		return
+	}
+
+	// Generate suggestions using LLM
+	l.Println("Generating new suggestions with model")
+	var suggestionsJSON string
+	if prefs.IsZero() {
+		suggestionsJSON, err = models.GeneratePairingSuggestionsForSummary(ctx, wa.modelFor(ctx), summary)
+	} else {
+		suggestionsJSON, err = models.GeneratePairingSuggestionsForPreferences(ctx, wa.modelFor(ctx), summary, prefs)
+	}
+	if err != nil {
+		if wa.sendFallbackSuggestions(w, r, l, err, false) {
+			return
+		}
+		sendError(w, fmt.Errorf("unable to get wine suggestions from the model: %w", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Spend quota only once suggestions were generated, so failed and
+	// rate-limited calls are free
+	if err := wa.spendQuota(r.Context(), l, a); err != nil {
+		sendQuotaError(w, err)
+		return
+	}
+
+	// Parse suggestions to store in DynamoDB, dropping any malformed ones
+	skipped := false
+	parsed, err := models.ParseSuggestionsResult(suggestionsJSON, models.ParseOptions{
+		Tolerant: true,
+		OnItemError: func(e *models.ItemError) {
+			l.Warnf("Warning: skipping malformed suggestion: %v\n", e)
+			skipped = true
+		},
+	})
+	if err != nil {
+		l.Warnf("Warning: unable to parse suggestions for DynamoDB storage: %v\n", err)
+		// Still return the response even if we can't parse for storage
+		writeSuggestionsJSON(w, r, suggestionsJSON)
+		return
+	}
+	modelSuggestions := parsed.Suggestions
+	if parsed.Truncated {
+		l.Warnf("Warning: model output was truncated; keeping %d complete suggestions\n", len(modelSuggestions))
+		w.Header().Set(truncatedHeader, "true")
+	}
+	if skipped || parsed.Truncated {
+		if out, err := json.Marshal(modelSuggestions); err == nil {
+			suggestionsJSON = string(out)
+		}
+	}
+
+	if providedSummary != "" {
+		writeSuggestionsJSON(w, r, suggestionsJSON)
+		return
+	}
+
+	// PRIMARY: Store in DynamoDB. Stored pairings are preference-neutral.
+	if prefs.IsZero() {
+		dataSuggestions := convertToDataSuggestions(modelSuggestions)
+		l.Printf("[DB] Storing pairing in DynamoDB (ID: %s, type: %s)\n", pairingID, pairingType)
+		if _, err := wa.dl.CreateRecipePairing(ctx, pairingID, pairingType, summary.Summary, dataSuggestions); err != nil {
+			l.Warnf("[DB] Error storing in DynamoDB: %v\n", err)
+		}
+	}
+
+	// OPTIONAL: Store in cache if enabled
+	if wa.cacheEnabled {
+		l.Printf("[CACHE] Cache enabled - storing suggestions in cache (key: %s)\n", cacheKey)
+		if err := wa.cache.Set(cacheKey, suggestionsJSON); err != nil {
+			l.Warnf("[CACHE] Error storing in cache: %v\n", err)
+		}
+	}
+
+	writeSuggestionsJSON(w, r, suggestionsJSON)
+}
+
+// GetRecipeWineSuggestion implements the route at
+// "GET /recipes/suggestions/{url}/wine/{index}", returning the single stored
+// suggestion at index for a recipe that already has suggestions. It never
+// generates, so it is not charged against quota.
+func (wa *Webapp) GetRecipeWineSuggestion(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	l := wa.newLogger("[GetRecipeWineSuggestion]")
+
+	u := getPathValue(r, "url")
+	if u == "" {
+		helpers.SendJSONError(w, fmt.Errorf("URL required"), http.StatusBadRequest)
+		return
+	}
+	index, err := strconv.Atoi(getPathValue(r, "index"))
+	if err != nil || index < 0 {
+		helpers.SendJSONError(w, fmt.Errorf("index must be a non-negative integer"), http.StatusBadRequest)
+		return
+	}
+	if status, err := wa.checkRecipeURL(u); err != nil {
+		sendError(w, err, status)
+		return
+	}
+
+	stored, found := wa.storedSuggestions(ctx, l, u)
+	suggestions := stored.Suggestions
+	if !found {
+		helpers.SendJSONError(w, fmt.Errorf("no suggestions found for this recipe"), http.StatusNotFound)
+		return
+	}
+	markCached(ctx)
+	if index >= len(suggestions) {
+		helpers.SendJSONError(w, fmt.Errorf("index %d is out of range: the recipe has %d suggestions", index, len(suggestions)), http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, suggestions[index])
+}
+
+// GetSuggestionsExport implements the route at
+// "GET /recipes/export/{url}", returning the stored suggestions for
+// a recipe for publishing elsewhere. "?format=json" (the default) gives a V2
+// style response; "?format=jsonld" gives schema.org JSON-LD for embedding in a
+// page. Nothing is generated, so it responds 404 for recipes without stored
+// suggestions.
+func (wa *Webapp) GetSuggestionsExport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	l := wa.newLogger("[GetSuggestionsExport]")
+
+	u := getPathValue(r, "url")
+	if u == "" {
+		helpers.SendJSONError(w, fmt.Errorf("URL required"), http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "jsonld" {
+		helpers.SendJSONError(w, fmt.Errorf("format must be json or jsonld"), http.StatusBadRequest)
+		return
+	}
+	if status, err := wa.checkRecipeURL(u); err != nil {
+		sendError(w, err, status)
+		return
+	}
+
+	stored, found := wa.storedSuggestions(ctx, l, u)
+	if !found {
+		helpers.SendJSONError(w, fmt.Errorf("no suggestions found for this recipe"), http.StatusNotFound)
+		return
+	}
+	markCached(ctx)
+
+	if format == "jsonld" {
+		out, err := models.SuggestionsToJSONLD(stored, u)
+		if err != nil {
+			helpers.SendJSONError(w, fmt.Errorf("unable to render JSON-LD: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", "application/ld+json")
+		w.Write(out)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, stored)
+}
+
+// inventoryRequest is the body of a request to pair from the user's wines.
+type inventoryRequest struct {
+	Wines []string `json:"wines"`
+}
+
+// maxInventoryWines bounds the inventory size so the prompt stays reasonable.
+const maxInventoryWines = 50
+
+// PostInventorySuggestions implements the route at
+// "POST /recipes/suggestions/{url}/from-inventory". The JSON body lists the
+// wines the user owns, which are ranked against the recipe rather than
+// suggesting new ones. Results are specific to the inventory, so they are not
+// stored.
+func (wa *Webapp) PostInventorySuggestions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	l := wa.newLogger("[PostInventorySuggestions]")
+	l.Println("Handling PostInventorySuggestions")
+	if wa.nonAlcoholicOnly {
+		helpers.SendJSONError(w, errWineRouteDisabled, http.StatusForbidden)
+		return
+	}
+
+	u := getPathValue(r, "url")
+	if u == "" {
+		helpers.SendJSONError(w, fmt.Errorf("URL required"), http.StatusBadRequest)
+		return
+	}
+	if status, err := wa.checkRecipeURL(u); err != nil {
+		sendError(w, err, status)
+		return
+	}
+
+	var req inventoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		helpers.SendJSONError(w, fmt.Errorf("unable to read inventory: %v", err), http.StatusBadRequest)
+		return
+	}
+	var wines []string
+	for _, wine := range req.Wines {
+		if wine = strings.TrimSpace(wine); wine != "" {
+			wines = append(wines, wine)
+		}
+	}
+	if len(wines) == 0 {
+		helpers.SendJSONError(w, fmt.Errorf("at least one wine is required"), http.StatusBadRequest)
+		return
+	}
+	if len(wines) > maxInventoryWines {
+		helpers.SendJSONError(w, fmt.Errorf("at most %d wines are allowed", maxInventoryWines), http.StatusBadRequest)
+		return
+	}
+
+	accountID, ok := r.Context().Value(sessionContextName).(string)
+	if !ok {
+		helpers.SendJSONError(w, fmt.Errorf("unable to get account ID from context"), http.StatusInternalServerError)
+		return
+	}
+
+	summary, status, err := wa.recipeSummary(ctx, l, u)
+	if err != nil {
+		sendError(w, err, status)
+		return
+	}
+
+	l.Printf("Ranking %d inventory wines\n", len(wines))
+	ranked, err := models.PairFromInventory(ctx, wa.modelFor(ctx), summary.Summary, wines, wa.savedPreferenceProfile(l, accountID))
+	if err != nil {
+		sendError(w, fmt.Errorf("unable to rank wine inventory: %w", err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := wa.spendQuota(r.Context(), l, accountID); err != nil {
+		sendQuotaError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ranked)
+}
+
+// recipeSummary returns the summary of the recipe at u, reusing the one stored
+// with its pairing if it has been paired before and otherwise summarizing it
+// (from the cache when possible). On failure it returns the HTTP status the
+// error should be reported with.
+func (wa *Webapp) recipeSummary(ctx context.Context, l *leveledLogger, u string) (models.Summary, int, error) {
+	if pairing, err := wa.dl.GetRecipePairing(ctx, u); err == nil {
+		l.Println("[DB] Using summary from stored pairing")
+		return models.Summary{Ok: true, Summary: pairing.Summary}, http.StatusOK, nil
+	}
+
+	return wa.summarizeRecipeURL(ctx, l, u)
+}
+
+// explainRequest is the body of a request to explain one suggestion.
+type explainRequest struct {
+	Style  string `json:"style"`
+	Region string `json:"region"`
+}
+
+// explanationResponse is the body of a pairing explanation.
+type explanationResponse struct {
+	Style       string `json:"style"`
+	Region      string `json:"region"`
+	Explanation string `json:"explanation"`
+}
+
+// explanationCacheKey is where the explanation of style from region for the
+// recipe at u is cached, ignoring case and spacing in both. The URL comes
+// last, like the tailored suggestion keys, so InvalidateRecipe can find it.
+func explanationCacheKey(u, style, region string) string {
+	slug := func(s string) string {
+		return strings.Join(strings.Fields(strings.ToLower(s)), "-")
+	}
+	return fmt.Sprintf("recipes:explanations:%s:%s:%s", slug(style), slug(region), u)
+}
+
+// PostExplainSuggestion implements the route at
+// "POST /recipes/suggestions/{url}/explain". The JSON body names a suggested
+// wine ({"style": "...", "region": "..."}), and the response explains in a few
+// paragraphs why it suits the recipe. Explanations are cached per recipe,
+// style and region, and only generating one spends quota.
+func (wa *Webapp) PostExplainSuggestion(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	l := wa.newLogger("[PostExplainSuggestion]")
+	l.Println("Handling PostExplainSuggestion")
+	if wa.nonAlcoholicOnly {
+		helpers.SendJSONError(w, errWineRouteDisabled, http.StatusForbidden)
+		return
+	}
+
+	u := getPathValue(r, "url")
+	if u == "" {
+		helpers.SendJSONError(w, fmt.Errorf("URL required"), http.StatusBadRequest)
+		return
+	}
+	if status, err := wa.checkRecipeURL(u); err != nil {
+		sendError(w, err, status)
+		return
+	}
+
+	var req explainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		helpers.SendJSONError(w, fmt.Errorf("unable to read request: %v", err), http.StatusBadRequest)
+		return
+	}
+	req.Style, req.Region = strings.TrimSpace(req.Style), strings.TrimSpace(req.Region)
+	if req.Style == "" {
+		helpers.SendJSONError(w, fmt.Errorf("style is required"), http.StatusBadRequest)
+		return
+	}
+
+	accountID, ok := r.Context().Value(sessionContextName).(string)
+	if !ok {
+		helpers.SendJSONError(w, fmt.Errorf("unable to get account ID from context"), http.StatusInternalServerError)
+		return
+	}
+
+	cacheKey := explanationCacheKey(u, req.Style, req.Region)
+	var explanation string
+	if wa.cacheEnabled {
+		if cached, err := wa.cache.Get(cacheKey); err == nil {
+			l.Println("[CACHE] Using cached explanation")
+			markCached(ctx)
+			explanation = cached
+		} else if !errors.Is(err, cache.ErrKeyNotFound) {
+			l.Warnf("[CACHE] Error reading cache: %v\n", err)
+		}
+	}
+
+	if explanation == "" {
+		summary, status, err := wa.recipeSummary(ctx, l, u)
+		if err != nil {
+			sendError(w, err, status)
+			return
+		}
+
+		l.Printf("Explaining %s (%s)\n", req.Style, req.Region)
+		explanation, err = models.ExplainPairing(ctx, wa.modelFor(ctx), summary.Summary, req.Style, req.Region)
+		if err != nil {
+			sendError(w, fmt.Errorf("unable to explain pairing: %w", err), http.StatusInternalServerError)
+			return
+		}
+
+		if err := wa.spendQuota(r.Context(), l, accountID); err != nil {
+			sendQuotaError(w, err)
+			return
+		}
+
+		if wa.cacheEnabled {
+			if err := wa.cache.Set(cacheKey, explanation); err != nil {
+				l.Warnf("[CACHE] Error storing explanation: %v\n", err)
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, explanationResponse{Style: req.Style, Region: req.Region, Explanation: explanation})
+}
+
+// compareRequest is the body of a request to compare two recipes.
+type compareRequest struct {
+	A string `json:"a"`
+	B string `json:"b"`
+}
+
+// comparedRecipe is one side of a recipe comparison. TopPairings are the
+// recipe's most confident stored suggestions, if it has been paired before.
+type comparedRecipe struct {
+	URL         string              `json:"url"`
+	Summary     string              `json:"summary"`
+	TopPairings []models.Suggestion `json:"topPairings,omitempty"`
+}
+
+// comparisonResponse is the body of a recipe comparison.
+type comparisonResponse struct {
+	A          comparedRecipe          `json:"a"`
+	B          comparedRecipe          `json:"b"`
+	Comparison models.RecipeComparison `json:"comparison"`
+}
+
+// comparedTopPairings is how many stored suggestions are shown per recipe in
+// a comparison.
+const comparedTopPairings = 3
+
+// PostCompareRecipes implements the route at "POST /recipes/compare". The JSON
+// body names two recipe URLs ({"a": "...", "b": "..."}), and the response
+// shows their summaries and top pairings side by side, with the wines that
+// suit both and those that suit only one. Summaries and suggestions are reused
+// when already stored or cached; the comparison itself is not stored.
+func (wa *Webapp) PostCompareRecipes(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	l := wa.newLogger("[PostCompareRecipes]")
+	l.Println("Handling PostCompareRecipes")
+	if wa.nonAlcoholicOnly {
+		helpers.SendJSONError(w, errWineRouteDisabled, http.StatusForbidden)
+		return
+	}
+
+	var req compareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		helpers.SendJSONError(w, fmt.Errorf("unable to read request: %v", err), http.StatusBadRequest)
+		return
+	}
+	req.A, req.B = strings.TrimSpace(req.A), strings.TrimSpace(req.B)
+	if req.A == "" || req.B == "" {
+		helpers.SendJSONError(w, fmt.Errorf("two recipe URLs (a and b) are required"), http.StatusBadRequest)
+		return
+	}
+	for _, u := range []string{req.A, req.B} {
+		if status, err := wa.checkRecipeURL(u); err != nil {
+			sendError(w, err, status)
+			return
+		}
+	}
+
+	accountID, ok := r.Context().Value(sessionContextName).(string)
+	if !ok {
+		helpers.SendJSONError(w, fmt.Errorf("unable to get account ID from context"), http.StatusInternalServerError)
+		return
 	}
-	return dataSuggestions
-}
 
-// convertFromDataSuggestions converts data.Suggestion slice to models.Suggestion slice
-func convertFromDataSuggestions(dataSuggestions []data.Suggestion) []models.Suggestion {
-	modelSuggestions := make([]models.Suggestion, len(dataSuggestions))
-	for i, ds := range dataSuggestions {
-		modelSuggestions[i] = models.Suggestion{
-			Style:       ds.Style,
-			Region:      ds.Region,
-			Description: ds.Description,
-			PairingNote: ds.PairingNote,
+	var resp comparisonResponse
+	var summaries [2]models.Summary
+	sides := []*comparedRecipe{&resp.A, &resp.B}
+	for i, u := range []string{req.A, req.B} {
+		side := sides[i]
+		side.URL = u
+		summary, status, err := wa.recipeSummary(ctx, l, side.URL)
+		if err != nil {
+			sendError(w, fmt.Errorf("unable to summarize %s: %w", side.URL, err), status)
+			return
+		}
+		summaries[i] = summary
+		side.Summary = summary.Summary
+		if stored, found := wa.storedSuggestions(ctx, l, side.URL); found {
+			side.TopPairings = models.TopSuggestions(stored.Suggestions, comparedTopPairings)
 		}
 	}
-	return modelSuggestions
-}
 
-// reconstructSuggestionsJSON converts a data.Suggestion slice to JSON string
-func reconstructSuggestionsJSON(suggestions []data.Suggestion) (string, error) {
-	modelSuggestions := convertFromDataSuggestions(suggestions)
-	jsonBytes, err := json.Marshal(modelSuggestions)
+	l.Println("Comparing recipes")
+	comparison, err := models.CompareRecipes(ctx, wa.modelFor(ctx), summaries[0], summaries[1])
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal suggestions to JSON: %w", err)
+		sendError(w, fmt.Errorf("unable to compare recipes: %w", err), http.StatusInternalServerError)
+		return
 	}
-	return string(jsonBytes), nil
+	resp.Comparison = comparison
+
+	if err := wa.spendQuota(r.Context(), l, accountID); err != nil {
+		sendQuotaError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resp)
 }
 
-// reconstructSuggestionsV2JSON converts RecipePairing to SuggestionsResponse JSON string
-func reconstructSuggestionsV2JSON(pairing data.RecipePairing) (string, error) {
-	response := models.SuggestionsResponse{
-		Suggestions: convertFromDataSuggestions(pairing.Suggestions),
-		Summary:     pairing.Summary,
+// dishRequest is the body of a request to pair with a free-text dish.
+type dishRequest struct {
+	Dish string `json:"dish"`
+}
+
+// maxDishLength bounds a dish description; longer text belongs in
+// "POST /recipes/summary".
+const maxDishLength = 500
+
+// PostPairings implements the route at "POST /pairings" for when there is no
+// recipe link, just a dish like "grilled lamb chops with rosemary". The
+// description is used as the recipe summary directly, skipping fetching and
+// summarizing. Results are not stored.
+func (wa *Webapp) PostPairings(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	l := wa.newLogger("[PostPairings]")
+	l.Println("Handling PostPairings")
+
+	var req dishRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4*maxDishLength)).Decode(&req); err != nil {
+		helpers.SendJSONError(w, fmt.Errorf("unable to read request: %v", err), http.StatusBadRequest)
+		return
 	}
-	jsonBytes, err := json.Marshal(response)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal suggestions response to JSON: %w", err)
+	dish := strings.TrimSpace(req.Dish)
+	if dish == "" {
+		helpers.SendJSONError(w, fmt.Errorf("dish cannot be empty"), http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(dish) > maxDishLength {
+		helpers.SendJSONError(w, fmt.Errorf("dish must be at most %d characters", maxDishLength), http.StatusBadRequest)
+		return
 	}
-	return string(jsonBytes), nil
-}
 
-// GetRecipeWineSuggestionsV2 implements the route at
-// "GET /recipes/suggestionsV2/{url}". Note that "POST /recipes" MUST be called first.
-// Otherwise, this route calls a bad request error since the recipe summary
-// hasn't been cached yet. This introduces a stateful dependency, but it
-// minimizes the need to pass the summary to this endpoint in the request.
-func (wa *Webapp) GetRecipeWineSuggestionsV2(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
-	l := log.New(log.Default().Writer(), "[GetRecipeWineSuggestionsV2] ", log.Default().Flags())
-	l.Println("Handling GetRecipeWineSuggestionsV2")
+	accountID, ok := r.Context().Value(sessionContextName).(string)
+	if !ok {
+		helpers.SendJSONError(w, fmt.Errorf("unable to get account ID from context"), http.StatusInternalServerError)
+		return
+	}
 
-	body, err := io.ReadAll(r.Body)
+	l.Debugf("Generating suggestions for dish: %s\n", dish)
+	var prefs models.PairingPreferences
+	var err error
+	if prefs.Persona, err = models.ParsePersona(r.URL.Query().Get("persona")); err != nil {
+		helpers.SendJSONError(w, err, http.StatusBadRequest)
+		return
+	}
+	if prefs.Market, err = models.ParseMarket(r.URL.Query().Get("market")); err != nil {
+		helpers.SendJSONError(w, err, http.StatusBadRequest)
+		return
+	}
+	if prefs.MinCount, prefs.MaxCount, err = suggestionCountParams(r); err != nil {
+		helpers.SendJSONError(w, err, http.StatusBadRequest)
+		return
+	}
+	wa.applyPreferenceProfile(l, accountID, &prefs)
+	wa.restrictPreferences(&prefs)
+	suggestionsJSON, err := models.GeneratePairingSuggestionsForPreferences(ctx, wa.modelFor(ctx), models.Summary{Ok: true, Summary: dish}, prefs)
 	if err != nil {
-		helpers.SendJSONError(w, fmt.Errorf("unable to read request: %v", err), http.StatusInternalServerError)
+		if wa.sendFallbackSuggestions(w, r, l, err, false) {
+			return
+		}
+		sendError(w, fmt.Errorf("unable to get wine suggestions from the model: %w", err), http.StatusInternalServerError)
 		return
 	}
 
-	input := strings.TrimSpace(string(body))
+	skipped := false
+	parsed, err := models.ParseSuggestionsResult(suggestionsJSON, models.ParseOptions{
+		Tolerant: true,
+		OnItemError: func(e *models.ItemError) {
+			l.Warnf("Warning: skipping malformed suggestion: %v\n", e)
+			skipped = true
+		},
+	})
+	if err == nil && parsed.Truncated {
+		l.Warnf("Warning: model output was truncated; keeping %d complete suggestions\n", len(parsed.Suggestions))
+		w.Header().Set(truncatedHeader, "true")
+	}
+	if err == nil && (skipped || parsed.Truncated) {
+		if out, err := json.Marshal(parsed.Suggestions); err == nil {
+			suggestionsJSON = string(out)
+		}
+	}
 
-	if input == "" {
-		helpers.SendJSONError(w, fmt.Errorf("input cannot be empty"), http.StatusBadRequest)
+	if err := wa.spendQuota(r.Context(), l, accountID); err != nil {
+		sendQuotaError(w, err)
 		return
 	}
 
-	k := getCacheKeyForInput(input)
-	pairingID, pairingType := getPairingIDAndType(input)
+	writeSuggestionsJSON(w, r, suggestionsJSON)
+}
 
-	// PRIMARY: Try DynamoDB first (source of truth)
-	l.Printf("[DB] Checking DynamoDB for pairing ID: %s (type: %s)\n", pairingID, pairingType)
-	if pairing, err := wa.dl.GetRecipePairing(ctx, pairingID); err == nil {
-		l.Printf("[DB] Found pairing in DynamoDB (created: %s)\n", pairing.DateCreated)
+// suggestionStreamMessage is a single message sent to the client while
+// suggestions stream in. Type is "suggestion", "done", or "error".
+type suggestionStreamMessage struct {
+	Type       string             `json:"type"`
+	Suggestion *models.Suggestion `json:"suggestion,omitempty"`
+	Error      string             `json:"error,omitempty"`
+}
 
-		// Reconstruct JSON response from DynamoDB data
-		responseJSON, err := reconstructSuggestionsV2JSON(pairing)
-		if err != nil {
-			l.Printf("[DB] Error reconstructing JSON from DynamoDB pairing: %v\n", err)
-		} else {
-			// Backfill cache if enabled
-			if wa.cacheEnabled {
-				l.Println("[CACHE] Cache enabled - backfilling cache from DynamoDB result")
-				if err := wa.cache.Set(k, responseJSON); err != nil {
-					l.Printf("[CACHE] Error backfilling cache: %v\n", err)
-				}
+// streamSuggestions produces V1 suggestions for the recipe at u, calling
+// onSuggestion with each one as it becomes available. Stored pairings are
+// replayed from DynamoDB (or cache, if enabled); otherwise the recipe is
+// summarized and suggestions are streamed from the model, stored, and charged
+// against the account's quota. A saved preference profile or an alcohol-free
+// deployment skips DynamoDB and keeps the suggestions under the cache key for
+// those preferences.
+func (wa *Webapp) streamSuggestions(ctx context.Context, l *leveledLogger, accountID, u string, onSuggestion func(models.Suggestion) error) error {
+	wa.recordHit(l, u)
+	replay := func(suggestions []models.Suggestion) error {
+		for _, s := range suggestions {
+			if err := onSuggestion(s); err != nil {
+				return err
 			}
-
-			w.Header().Add("Content-Type", "application/json")
-			fmt.Fprint(w, responseJSON)
-			return
 		}
+		return nil
+	}
+
+	cacheKey := fmt.Sprintf("recipes:suggestions-json:%s", u)
+	var prefs models.PairingPreferences
+	wa.applyPreferenceProfile(l, accountID, &prefs)
+	wa.restrictPreferences(&prefs)
+	if !prefs.IsZero() {
+		cacheKey = getCacheKeyForPreferences(u, prefs)
+	}
+
+	// PRIMARY: Try DynamoDB first (source of truth)
+	l.Printf("[DB] Checking DynamoDB for pairing ID: %s\n", u)
+	if !prefs.IsZero() {
+		l.Printf("[DB] Skipping DynamoDB for preferences %q\n", prefs.Key())
+	} else if pairing, err := wa.dl.GetRecipePairing(ctx, u); err == nil {
+		l.Printf("[DB] Found pairing in DynamoDB (created: %s)\n", pairing.DateCreated)
+		return replay(convertFromDataSuggestions(pairing.Suggestions))
 	} else if !errors.Is(err, data.ErrNotFound) {
-		l.Printf("[DB] Error querying DynamoDB: %v\n", err)
+		l.Warnf("[DB] Error querying DynamoDB: %v\n", err)
 	} else {
 		l.Println("[DB] Pairing not found in DynamoDB")
 	}
 
 	// OPTIONAL: Try cache if enabled and DB missed
 	if wa.cacheEnabled {
-		l.Printf("[CACHE] Cache enabled - checking cache for key: %s\n", k)
-		if cached, err := wa.cache.Get(k); err == nil {
-			l.Println("[CACHE] Cache hit, returning cached result")
-			w.Header().Add("Content-Type", "application/json")
-			fmt.Fprint(w, cached)
-			return
+		l.Printf("[CACHE] Cache enabled - checking cache for key: %s\n", cacheKey)
+		if cached, err := wa.cache.Get(cacheKey); err == nil {
+			if parsed, err := models.ParseSuggestions(cached); err == nil {
+				l.Println("[CACHE] Cache hit, replaying cached suggestions")
+				return replay(parsed)
+			}
 		}
 		l.Println("[CACHE] Cache miss")
 	}
 
-	// Both systems missed - generate new content
-	l.Println("Generating new suggestions with model")
-	response, err := models.GeneratePairingSuggestionsV2(ctx, wa.model, wa.tools, input)
+	summary, _, err := wa.summarizeRecipeURL(ctx, l, u)
 	if err != nil {
-		l.Printf("Error from model: %v\n", err)
-		helpers.SendJSONError(w, fmt.Errorf("error generating suggestions: %v", err), http.StatusInternalServerError)
-		return
+		return err
 	}
 
-	l.Println("Model response received")
-	if os.Getenv("LOG_LEVEL") == "TRACE" {
-		l.Println(response)
+	l.Println("Streaming new suggestions from model")
+	suggestionsJSON, err := models.StreamPairingSuggestionsForPreferences(ctx, wa.modelFor(ctx), summary, prefs, onSuggestion)
+	if err != nil {
+		return fmt.Errorf("unable to get wine suggestions from the model: %v", err)
 	}
 
-	// Parse the response to extract suggestions and summary
-	parsed, err := models.ParseSuggestionsV2(response)
+	if err := wa.spendQuota(ctx, l, accountID); err != nil {
+		return err
+	}
+
+	modelSuggestions, err := models.ParseSuggestionsWithOptions(suggestionsJSON, models.ParseOptions{
+		Tolerant: true,
+		OnItemError: func(e *models.ItemError) {
+			l.Warnf("Warning: skipping malformed streamed suggestion: %v\n", e)
+		},
+	})
 	if err != nil {
-		helpers.SendJSONError(w, fmt.Errorf("error generating suggestions: %v", err), http.StatusInternalServerError)
-		return
+		l.Warnf("Warning: unable to parse streamed suggestions for DynamoDB storage: %v\n", err)
+		return nil
 	}
 
 	// PRIMARY: Store in DynamoDB
-	dataSuggestions := convertToDataSuggestions(parsed.Suggestions)
-	l.Printf("[DB] Storing pairing in DynamoDB (ID: %s, type: %s)\n", pairingID, pairingType)
-	if _, err := wa.dl.CreateRecipePairing(ctx, pairingID, pairingType, parsed.Summary, dataSuggestions); err != nil {
-		l.Printf("[DB] Error storing in DynamoDB: %v\n", err)
+	if prefs.IsZero() {
+		l.Printf("[DB] Storing pairing in DynamoDB (ID: %s, type: %s)\n", u, data.PairingTypeURL)
+		if _, err := wa.dl.CreateRecipePairing(ctx, u, data.PairingTypeURL, summary.Summary, convertToDataSuggestions(modelSuggestions)); err != nil {
+			l.Warnf("[DB] Error storing in DynamoDB: %v\n", err)
+		}
 	}
 
 	// OPTIONAL: Store in cache if enabled
 	if wa.cacheEnabled {
-		l.Printf("[CACHE] Cache enabled - storing suggestions in cache (key: %s)\n", k)
-		if err := wa.cache.Set(k, response); err != nil {
-			l.Printf("[CACHE] Error storing in cache: %v\n", err)
-		}
-	}
-
-	accountID := r.Context().Value(sessionContextName)
-	if a, ok := accountID.(string); ok {
-		// PRIMARY: Decrement quota in DynamoDB
-		l.Printf("[DB] Decrementing quota for account %s in DynamoDB\n", a)
-		if err := wa.dl.DecrementAccountQuota(ctx, a); err != nil {
-			l.Printf("[DB] Error decrementing quota in DynamoDB: %v\n", err)
-		}
-
-		// OPTIONAL: Decrement in cache if enabled
-		if wa.cacheEnabled {
-			l.Printf("[CACHE] Cache enabled - decrementing quota for account %s in cache\n", a)
-			if err := wa.cache.Decr(sessionQuotaKey(a)); err != nil {
-				l.Printf("[CACHE] Error decrementing quota in cache: %v\n", err)
-			}
+		l.Printf("[CACHE] Cache enabled - storing suggestions in cache (key: %s)\n", cacheKey)
+		if err := wa.cache.Set(cacheKey, suggestionsJSON); err != nil {
+			l.Warnf("[CACHE] Error storing in cache: %v\n", err)
 		}
-	} else {
-		l.Println("Unable to look up account ID from context to decrement quota")
 	}
 
-	w.Header().Add("Content-Type", "application/json")
-	fmt.Fprint(w, response)
+	return nil
 }
 
-// GetRecipeWineSuggestions implements the route at
-// "GET /recipes/suggestions/{url}". Note that "POST /recipes" MUST be called first.
-// Otherwise, this route calls a bad request error since the recipe summary
-// hasn't been cached yet. This introduces a stateful dependency, but it
-// minimizes the need to pass the summary to this endpoint in the request.
-func (wa *Webapp) GetRecipeWineSuggestions(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
-	l := log.New(log.Default().Writer(), "[GetRecipeWineSuggestions]", log.Default().Flags())
-	l.Println("Handling GetRecipeWineSuggestions")
-
-	u := getPathValue(r, "url")
-	if u == "" {
-		helpers.SendJSONError(w, fmt.Errorf("URL required"), http.StatusBadRequest)
+// GetSuggestionsWebSocket implements the route at "GET /ws/suggestions". Once
+// the WebSocket is open, the client sends the recipe URL as its first message.
+// Each suggestion is sent as a JSON suggestionStreamMessage as soon as the
+// model produces it, followed by a "done" (or "error") message.
+func (wa *Webapp) GetSuggestionsWebSocket(w http.ResponseWriter, r *http.Request) {
+	accountID, ok := r.Context().Value(sessionContextName).(string)
+	if !ok {
+		helpers.SendJSONError(w, fmt.Errorf("unable to get account ID from context"), http.StatusInternalServerError)
 		return
 	}
 
-	cacheKey := fmt.Sprintf("recipes:suggestions-json:%s", u)
-	pairingID := u // For this endpoint, the pairing ID is the URL itself
-	pairingType := data.PairingTypeURL
-
-	// PRIMARY: Try DynamoDB first (source of truth)
-	l.Printf("[DB] Checking DynamoDB for pairing ID: %s\n", pairingID)
-	if pairing, err := wa.dl.GetRecipePairing(ctx, pairingID); err == nil {
-		l.Printf("[DB] Found pairing in DynamoDB (created: %s)\n", pairing.DateCreated)
-
-		// Reconstruct JSON response from DynamoDB data
-		suggestionsJSON, err := reconstructSuggestionsJSON(pairing.Suggestions)
-		if err != nil {
-			l.Printf("[DB] Error reconstructing JSON from DynamoDB pairing: %v\n", err)
-		} else {
-			// Backfill cache if enabled
-			if wa.cacheEnabled {
-				l.Println("[CACHE] Cache enabled - backfilling cache from DynamoDB result")
-				if err := wa.cache.Set(cacheKey, suggestionsJSON); err != nil {
-					l.Printf("[CACHE] Error backfilling cache: %v\n", err)
-				}
-			}
+	websocket.Handler(func(ws *websocket.Conn) {
+		defer ws.Close()
+		ctx := r.Context()
+		l := wa.newLogger("[GetSuggestionsWebSocket]")
 
-			w.Header().Add("Content-Type", "application/json")
-			fmt.Fprint(w, suggestionsJSON)
+		var u string
+		if err := websocket.Message.Receive(ws, &u); err != nil {
+			l.Printf("Unable to read recipe URL from socket: %v\n", err)
 			return
 		}
-	} else if !errors.Is(err, data.ErrNotFound) {
-		l.Printf("[DB] Error querying DynamoDB: %v\n", err)
-	} else {
-		l.Println("[DB] Pairing not found in DynamoDB")
-	}
-
-	// OPTIONAL: Try cache if enabled and DB missed
-	if wa.cacheEnabled {
-		l.Printf("[CACHE] Cache enabled - checking cache for key: %s\n", cacheKey)
-		if cached, err := wa.cache.Get(cacheKey); err == nil {
-			l.Println("[CACHE] Cache hit, returning cached suggestions")
-			w.Header().Add("Content-Type", "application/json")
-			fmt.Fprint(w, cached)
+		u = strings.TrimSpace(u)
+		if u == "" {
+			websocket.JSON.Send(ws, suggestionStreamMessage{Type: "error", Error: "URL required"})
+			return
+		}
+		if _, err := wa.checkRecipeURL(u); err != nil {
+			websocket.JSON.Send(ws, suggestionStreamMessage{Type: "error", Error: err.Error()})
 			return
 		}
-		l.Println("[CACHE] Cache miss")
-	}
 
-	// Need to generate new - get summary first
-	var summary string
-	if wa.cacheEnabled {
-		// Try to get summary from cache (expected from prior PostCreateRecipe call)
-		l.Println("[CACHE] Cache enabled - fetching summary from cache")
-		var err error
-		summary, err = wa.cache.GetOrFetch(fmt.Sprintf("recipes:summarized:%s", u), func() (string, error) {
-			return "", fmt.Errorf("expected a summary to be generated before this call")
+		err := wa.streamSuggestions(ctx, l, accountID, u, func(s models.Suggestion) error {
+			return websocket.JSON.Send(ws, suggestionStreamMessage{Type: "suggestion", Suggestion: &s})
 		})
 		if err != nil {
-			helpers.SendJSONError(w, fmt.Errorf("unable to load summary: %v", err), http.StatusInternalServerError)
+			l.Errorf("Error streaming suggestions: %v\n", err)
+			websocket.JSON.Send(ws, suggestionStreamMessage{Type: "error", Error: err.Error()})
 			return
 		}
-	} else {
-		// Cache disabled - need summary for generation but don't have it
-		// This endpoint requires PostCreateRecipe to be called first, which caches the summary
-		// Without cache, we don't have the summary, so we can't proceed
-		helpers.SendJSONError(w, fmt.Errorf("summary not available - this endpoint requires cache or prior pairing in DB"), http.StatusInternalServerError)
+
+		websocket.JSON.Send(ws, suggestionStreamMessage{Type: "done"})
+	}).ServeHTTP(w, r)
+}
+
+// GetRecipeWineSuggestionsStream implements the route at
+// "GET /recipes/suggestions/stream/{url}" using Server-Sent Events. Each
+// suggestion is sent as a "data:" event carrying a JSON suggestionStreamMessage
+// and flushed as soon as the model produces it, followed by a "done" (or
+// "error") event.
+//
+// In Lambda, responses are buffered by API Gateway and can't be flushed, so
+// all events arrive together once generation finishes. Clients there get the
+// same event stream, just without the incremental delivery.
+func (wa *Webapp) GetRecipeWineSuggestionsStream(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	l := wa.newLogger("[GetRecipeWineSuggestionsStream]")
+
+	u := getPathValue(r, "url")
+	if u == "" {
+		helpers.SendJSONError(w, fmt.Errorf("URL required"), http.StatusBadRequest)
+		return
+	}
+	if status, err := wa.checkRecipeURL(u); err != nil {
+		sendError(w, err, status)
 		return
 	}
 
-	// Both systems missed - generate new content
-	accountID := r.Context().Value(sessionContextName)
-	a, ok := accountID.(string)
+	accountID, ok := r.Context().Value(sessionContextName).(string)
 	if !ok {
 		helpers.SendJSONError(w, fmt.Errorf("unable to get account ID from context"), http.StatusInternalServerError)
 		return
 	}
 
-	// PRIMARY: Decrement quota in DynamoDB before generating
-	l.Printf("[DB] Decrementing quota for account %s in DynamoDB\n", a)
-	if err := wa.dl.DecrementAccountQuota(ctx, a); err != nil {
-		l.Printf("[DB] Error decrementing quota: %v\n", err)
+	flusher, canFlush := w.(http.Flusher)
+	if !canFlush {
+		l.Println("Response can't be flushed - events will be delivered when generation finishes")
 	}
 
-	// OPTIONAL: Decrement in cache if enabled
-	if wa.cacheEnabled {
-		l.Printf("[CACHE] Cache enabled - decrementing quota for account %s in cache\n", a)
-		if err := wa.cache.Decr(sessionQuotaKey(a)); err != nil {
-			l.Printf("[CACHE] Error decrementing quota: %v\n", err)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	send := func(m suggestionStreamMessage) error {
+		out, err := json.Marshal(m)
+		if err != nil {
+			return fmt.Errorf("unable to encode event: %v", err)
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", out); err != nil {
+			return err
 		}
+		if canFlush {
+			flusher.Flush()
+		}
+		return nil
 	}
 
-	// Generate suggestions using LLM
-	l.Println("Generating new suggestions with model")
-	suggestionsJSON, err := models.GeneratePairingSuggestions(ctx, wa.model, summary)
+	err := wa.streamSuggestions(ctx, l, accountID, u, func(s models.Suggestion) error {
+		return send(suggestionStreamMessage{Type: "suggestion", Suggestion: &s})
+	})
 	if err != nil {
-		helpers.SendJSONError(w, fmt.Errorf("unable to get wine suggestions from the model: %v", err), http.StatusInternalServerError)
+		l.Errorf("Error streaming suggestions: %v\n", err)
+		send(suggestionStreamMessage{Type: "error", Error: err.Error()})
 		return
 	}
 
-	// Parse suggestions to store in DynamoDB
-	modelSuggestions, err := models.ParseSuggestions(suggestionsJSON)
-	if err != nil {
-		l.Printf("Warning: unable to parse suggestions for DynamoDB storage: %v\n", err)
-		// Still return the response even if we can't parse for storage
-		w.Header().Add("Content-Type", "application/json")
-		fmt.Fprint(w, suggestionsJSON)
-		return
-	}
+	send(suggestionStreamMessage{Type: "done"})
+}
 
-	// PRIMARY: Store in DynamoDB
-	dataSuggestions := convertToDataSuggestions(modelSuggestions)
-	l.Printf("[DB] Storing pairing in DynamoDB (ID: %s, type: %s)\n", pairingID, pairingType)
-	if _, err := wa.dl.CreateRecipePairing(ctx, pairingID, pairingType, summary, dataSuggestions); err != nil {
-		l.Printf("[DB] Error storing in DynamoDB: %v\n", err)
+// maxRecentCards bounds how many suggestion cards GetRecentSuggestions loads
+// in one request.
+const maxRecentCards = 10
+
+// recentCardWines is how many wines a recent suggestion card shows.
+const recentCardWines = 3
+
+// recentSuggestionCard is a trimmed view of a previous pairing, enough for the
+// UI to render it without a follow-up request.
+type recentSuggestionCard struct {
+	URL         string              `json:"url"`
+	Summary     string              `json:"summary"`
+	Suggestions []models.Suggestion `json:"suggestions"`
+}
+
+// recentSuggestionCard loads the stored pairing for u from DynamoDB, falling
+// back to the cached suggestions JSON written by either API version.
+// Alcohol-free deployments only read the cache, under the key for their
+// preferences, so wine pairings never show up.
+func (wa *Webapp) recentSuggestionCard(ctx context.Context, u string) (recentSuggestionCard, error) {
+	card := recentSuggestionCard{URL: u}
+	cacheKey := fmt.Sprintf("recipes:suggestions-json:%s", u)
+	var prefs models.PairingPreferences
+	wa.restrictPreferences(&prefs)
+	if !prefs.IsZero() {
+		cacheKey = getCacheKeyForPreferences(u, prefs)
 	}
 
-	// OPTIONAL: Store in cache if enabled
-	if wa.cacheEnabled {
-		l.Printf("[CACHE] Cache enabled - storing suggestions in cache (key: %s)\n", cacheKey)
-		if err := wa.cache.Set(cacheKey, suggestionsJSON); err != nil {
-			l.Printf("[CACHE] Error storing in cache: %v\n", err)
+	err := data.ErrNotFound
+	var pairing data.RecipePairing
+	if prefs.IsZero() {
+		pairing, err = wa.dl.GetRecipePairing(ctx, u)
+	}
+	if err == nil {
+		card.Summary = pairing.Summary
+		card.Suggestions = convertFromDataSuggestions(pairing.Suggestions)
+	} else if wa.cacheEnabled {
+		cached, err := wa.cache.Get(cacheKey)
+		if err != nil {
+			return card, fmt.Errorf("no stored suggestions: %v", err)
+		}
+		if parsed, err := models.ParseSuggestionsV2(cached); err == nil {
+			card.Summary = parsed.Summary
+			card.Suggestions = parsed.Suggestions
+		} else if suggestions, err := models.ParseSuggestions(cached); err == nil {
+			card.Suggestions = suggestions
+			if summary, err := wa.cache.Get(fmt.Sprintf("recipes:summarized:%s", u)); err == nil {
+				card.Summary = summary
+			}
+		} else {
+			return card, fmt.Errorf("unable to parse cached suggestions: %v", err)
 		}
+	} else {
+		return card, fmt.Errorf("no stored suggestions: %v", err)
 	}
 
-	w.Header().Add("Content-Type", "application/json")
-	fmt.Fprint(w, suggestionsJSON)
+	if len(card.Suggestions) == 0 {
+		return card, fmt.Errorf("no suggestions in stored pairing")
+	}
+	if len(card.Suggestions) > recentCardWines {
+		card.Suggestions = card.Suggestions[:recentCardWines]
+	}
+
+	return card, nil
 }
 
+// maxRecentURLs is how many recipe URLs each source contributes to the pool
+// GetRecentSuggestions samples from.
+const maxRecentURLs = 20
+
+// recentKeysPageSize is how many cache keys GetRecentSuggestions asks for per
+// page while collecting recent URLs.
+const recentKeysPageSize = 50
+
 // GetRecentSuggestions implements the route at
 // "GET /recipes/suggestions/recent" and loads a sample of previously-cached recipe
-// analyses to give the user a quick way to explore the app.
+// analyses to give the user a quick way to explore the app. With ?cards=true it
+// returns each pairing's summary and top wines instead of just the URLs, up to
+// ?limit= cards (at most maxRecentCards).
 func (wa *Webapp) GetRecentSuggestions(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
-	l := log.New(log.Default().Writer(), "[GetRecentSuggestions]", log.Default().Flags())
+	ctx := r.Context()
+	l := wa.newLogger("[GetRecentSuggestions]")
 
 	// PRIMARY: Query DynamoDB for recent URL-based pairings
 	l.Println("[DB] Querying DynamoDB for recent URL pairings")
 	var dbURLs []string
-	if ids, err := wa.dl.GetRecentRecipePairingIDs(ctx, data.PairingTypeURL, 20); err == nil {
+	if ids, err := wa.dl.GetRecentRecipePairingIDs(ctx, data.PairingTypeURL, maxRecentURLs); err == nil {
 		dbURLs = ids
 		l.Printf("[DB] Found %d URL pairings in DynamoDB\n", len(dbURLs))
 	} else {
-		l.Printf("[DB] Error querying DynamoDB: %v\n", err)
+		l.Warnf("[DB] Error querying DynamoDB: %v\n", err)
 	}
 
 	// OPTIONAL: Query cache if enabled
 	var cacheURLs []string
 	if wa.cacheEnabled {
 		l.Println("[CACHE] Cache enabled - scanning cache for recipe suggestions")
-		if keys, err := wa.cache.GetKeys("recipes:suggestions-json:*"); err == nil {
+		// Page through the keys only until we have as many as DynamoDB gives
+		var cursor uint64
+		for {
+			keys, next, err := wa.cache.GetKeysPage("recipes:suggestions-json:*", cursor, recentKeysPageSize)
+			if err != nil {
+				l.Warnf("[CACHE] Error scanning cache: %v\n", err)
+				break
+			}
 			for _, k := range keys {
 				if u := recentSuggestionRx.FindString(k); u != "" {
 					cacheURLs = append(cacheURLs, u)
 				}
 			}
-			l.Printf("[CACHE] Found %d suggestions in cache\n", len(cacheURLs))
-		} else {
-			l.Printf("[CACHE] Error scanning cache: %v\n", err)
+			cursor = next
+			if cursor == 0 || len(cacheURLs) >= maxRecentURLs {
+				break
+			}
 		}
+		l.Printf("[CACHE] Found %d suggestions in cache\n", len(cacheURLs))
 	}
 
 	// Combine and deduplicate results
@@ -1055,24 +4089,44 @@ func (wa *Webapp) GetRecentSuggestions(w http.ResponseWriter, r *http.Request) {
 	})
 
 	var count = 3
-	if len(allURLs) < count {
-		count = len(allURLs)
+	if r.URL.Query().Get("cards") == "true" {
+		if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 {
+			count = min(n, maxRecentCards)
+		}
+
+		// Cards are written as they're loaded rather than collected first
+		cards, err := newJSONArrayWriter(w)
+		if err != nil {
+			l.Warnf("Error writing suggestion cards: %v\n", err)
+			return
+		}
+		for _, u := range allURLs {
+			if cards.n == count {
+				break
+			}
+			card, err := wa.recentSuggestionCard(ctx, u)
+			if err != nil {
+				l.Printf("Skipping %s: %v\n", u, err)
+				continue
+			}
+			if err := cards.Write(card); err != nil {
+				l.Warnf("Error writing suggestion card: %v\n", err)
+				return
+			}
+		}
+		if err := cards.Close(); err != nil {
+			l.Warnf("Error writing suggestion cards: %v\n", err)
+		}
+		return
 	}
 
-	var result []string
-	if count > 0 {
-		result = allURLs[0:count]
-	} else {
-		result = []string{}
+	if len(allURLs) < count {
+		count = len(allURLs)
 	}
 
-	out, err := json.Marshal(result)
-	if err != nil {
-		helpers.SendJSONError(w, fmt.Errorf("unable to encode URL suggestions: %v", err), http.StatusInternalServerError)
-		return
+	if err := writeJSONArray(w, allURLs[:count]); err != nil {
+		l.Warnf("Error writing URL suggestions: %v\n", err)
 	}
-	w.Header().Add("Content-Type", "application/json")
-	fmt.Fprint(w, string(out))
 }
 
 func (wa *Webapp) DeleteSession(w http.ResponseWriter, r *http.Request) {
@@ -1087,11 +4141,53 @@ func (wa *Webapp) DeleteSession(w http.ResponseWriter, r *http.Request) {
 	}
 
 	wa.deleteCookie(sessionCookieName, w)
-	http.Redirect(w, r, "/", http.StatusFound)
+	http.Redirect(w, r, wa.basePath+"/", http.StatusFound)
+}
+
+// DeleteUser implements the route at "DELETE /user". It removes the logged-in
+// user's account and everything cached for it, clears the session cookie,
+// and responds with 204 No Content.
+func (wa *Webapp) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	l := wa.newLogger("[DeleteUser]")
+	accountID, ok := r.Context().Value(sessionContextName).(string)
+	if !ok || accountID == "" {
+		helpers.SendJSONError(w, fmt.Errorf("unable to get account ID from context"), http.StatusInternalServerError)
+		return
+	}
+	if _, isApiKey := r.Context().Value(apiKeyContextName).(ApiKeyConfig); isApiKey {
+		helpers.SendJSONError(w, fmt.Errorf("API key accounts can't be deleted"), http.StatusBadRequest)
+		return
+	}
+
+	l.Printf("[DB] Deleting account %s\n", accountID)
+	if err := wa.dl.DeleteAccount(r.Context(), accountID); err != nil {
+		l.Warnf("[DB] Error deleting account: %v\n", err)
+		helpers.SendJSONError(w, fmt.Errorf("unable to delete account: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if wa.cacheEnabled {
+		keys := []string{
+			fmt.Sprintf("accounts:%s", accountID),
+			fmt.Sprintf("quotas:%s", accountID),
+			fmt.Sprintf("sessions:%s", accountID),
+			preferenceProfileCacheKey(accountID),
+		}
+		for _, k := range keys {
+			if err := wa.cache.Delete(k); err != nil {
+				l.Warnf("[CACHE] Error deleting %s: %v\n", k, err)
+				helpers.SendJSONError(w, fmt.Errorf("unable to delete cached account data: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	wa.deleteCookie(sessionCookieName, w)
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (wa *Webapp) PostOauthResponse(w http.ResponseWriter, r *http.Request) {
-	l := log.New(log.Default().Writer(), "[PostOauthResponse]", log.Default().Flags())
+	l := wa.newLogger("[PostOauthResponse]")
 	ctx := r.Context()
 	l.Println("Handling oauth response")
 	if err := r.ParseForm(); err != nil {
@@ -1145,7 +4241,7 @@ func (wa *Webapp) PostOauthResponse(w http.ResponseWriter, r *http.Request) {
 	// --- PRIMARY: Create/update account in DynamoDB ---
 	l.Printf("[DB] Creating/updating account in DynamoDB for AccountID: %s (email=%s)\n", claims.AccountID, claims.Email)
 	if _, err := wa.dl.CreateAccount(ctx, claims.AccountID, claims.Email); err != nil {
-		l.Printf("[DB] Error creating account in DynamoDB: %v\n", err)
+		l.Warnf("[DB] Error creating account in DynamoDB: %v\n", err)
 		// This is now critical since DB is primary - but we'll continue for backwards compatibility
 	}
 
@@ -1153,41 +4249,601 @@ func (wa *Webapp) PostOauthResponse(w http.ResponseWriter, r *http.Request) {
 	if wa.cacheEnabled {
 		l.Printf("[CACHE] Cache enabled - setting account details in cache for AccountID: %s\n", claims.AccountID)
 		if err := wa.cache.Set(fmt.Sprintf("accounts:%s", claims.AccountID), claims.Email); err != nil {
-			l.Printf("[CACHE] Error setting account email in cache: %v\n", err)
+			l.Warnf("[CACHE] Error setting account email in cache: %v\n", err)
 		}
 		if err := wa.cache.SetEx(fmt.Sprintf("sessions:%s", claims.AccountID), "", 60*60*24*7); err != nil {
-			l.Printf("[CACHE] Error setting session in cache: %v\n", err)
+			l.Warnf("[CACHE] Error setting session in cache: %v\n", err)
 		}
 		if err := wa.cache.SetNx(fmt.Sprintf("quotas:%s", claims.AccountID), strconv.Itoa(maxQuota), maxQuotaLifespanSeconds); err != nil {
-			l.Printf("[CACHE] Error setting quota in cache: %v\n", err)
+			l.Warnf("[CACHE] Error setting quota in cache: %v\n", err)
 		}
 	}
 
 	l.Println("Setting session cookie and redirecting")
 	wa.setCookie(sessionCookieName, claims.AccountID, w)
 
-	http.Redirect(w, r, "/", http.StatusFound)
+	http.Redirect(w, r, wa.basePath+"/", http.StatusFound)
+}
+
+// GetCacheStats implements the route at "GET /admin/cache/stats" and reports
+// hit/miss counters for the cache, if it is instrumented.
+func (wa *Webapp) GetCacheStats(w http.ResponseWriter, r *http.Request) {
+	c := wa.cache
+	reporter, ok := c.(cache.StatsReporter)
+	for !ok {
+		// Look beneath wrappers such as a cache namespace
+		u, canUnwrap := c.(interface{ Unwrap() cache.Cacher })
+		if !canUnwrap {
+			break
+		}
+		c = u.Unwrap()
+		reporter, ok = c.(cache.StatsReporter)
+	}
+	if !ok {
+		helpers.SendJSONError(w, fmt.Errorf("cache statistics are not enabled"), http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, reporter.Stats())
+}
+
+// GetUsage implements the route at "GET /admin/usage" and reports how many
+// generations were served on "?date=YYYY-MM-DD", in UTC, or today if no date
+// is given. Days are kept for usageTTL.
+func (wa *Webapp) GetUsage(w http.ResponseWriter, r *http.Request) {
+	day := time.Now().UTC()
+	if v := r.URL.Query().Get("date"); v != "" {
+		var err error
+		if day, err = time.Parse(usageDateFormat, v); err != nil {
+			helpers.SendJSONError(w, fmt.Errorf("date must be formatted YYYY-MM-DD"), http.StatusBadRequest)
+			return
+		}
+	}
+	if !wa.cacheEnabled {
+		helpers.SendJSONError(w, fmt.Errorf("usage is only counted when the cache is enabled"), http.StatusNotFound)
+		return
+	}
+
+	var generations int64
+	val, err := wa.cache.Get(usageCacheKey(day))
+	if err != nil && !errors.Is(err, cache.ErrKeyNotFound) {
+		helpers.SendJSONError(w, fmt.Errorf("unable to load usage: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err == nil {
+		if generations, err = strconv.ParseInt(val, 10, 64); err != nil {
+			helpers.SendJSONError(w, fmt.Errorf("unable to read usage: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"date": day.Format(usageDateFormat), "generations": generations})
+}
+
+// InvalidateRecipe deletes every cache entry derived from the recipe at u: the
+// raw HTML, markdown, summary, flavor profile, and suggestions, including
+// suggestions tailored to preferences and pairing explanations. If u redirected when it was fetched,
+// the entries under its canonical URL and the alias itself are removed too.
+// It returns how many keys were deleted. Pairings stored in DynamoDB are left
+// alone.
+func (wa *Webapp) InvalidateRecipe(u string) (int, error) {
+	urls := []string{u}
+	canonicalKey := fmt.Sprintf("recipes:canonical:%s", u)
+	if canonical, err := wa.cache.Get(canonicalKey); err == nil && canonical != u {
+		urls = append(urls, canonical)
+	}
+
+	keys := []string{canonicalKey}
+	for _, v := range urls {
+		keys = append(keys,
+			fmt.Sprintf("recipes:raw:%s", v),
+			fmt.Sprintf("recipes:parsed:%s", v),
+			fmt.Sprintf("recipes:summarized:%s", v),
+			flavorProfileCacheKey(v),
+			contentHashCacheKey(v),
+			notRecipeCacheKey(v),
+		)
+	}
+	suggestionKeys, err := wa.suggestionCacheKeys(urls)
+	if err != nil {
+		return 0, err
+	}
+
+	return wa.deleteCacheKeys(append(keys, suggestionKeys...))
+}
+
+// suggestionCacheKeys lists the cache keys holding suggestions for the recipes
+// at urls: plain, tailored to preferences, and pairing explanations.
+func (wa *Webapp) suggestionCacheKeys(urls []string) ([]string, error) {
+	var keys []string
+	for _, v := range urls {
+		keys = append(keys, fmt.Sprintf("recipes:suggestions-json:%s", v))
+	}
+	prefKeys, err := wa.cache.GetKeys("recipes:suggestions-json:prefs:*")
+	if err != nil {
+		return nil, fmt.Errorf("unable to list tailored suggestions: %v", err)
+	}
+	explanationKeys, err := wa.cache.GetKeys("recipes:explanations:*")
+	if err != nil {
+		return nil, fmt.Errorf("unable to list pairing explanations: %v", err)
+	}
+	for _, k := range append(prefKeys, explanationKeys...) {
+		for _, v := range urls {
+			if strings.HasSuffix(k, ":"+v) {
+				keys = append(keys, k)
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+// deleteCacheKeys deletes keys, returning how many existed.
+func (wa *Webapp) deleteCacheKeys(keys []string) (int, error) {
+	deleted := 0
+	for _, k := range keys {
+		if _, err := wa.cache.Get(k); errors.Is(err, cache.ErrKeyNotFound) {
+			continue
+		}
+		if err := wa.cache.Delete(k); err != nil {
+			return deleted, fmt.Errorf("unable to delete %s: %v", k, err)
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+// PostInvalidateRecipe implements the route at "POST /admin/recipes/invalidate".
+// The JSON body names the recipe ({"url": "..."}) whose cache entries should
+// be dropped so the next request fetches the page again.
+func (wa *Webapp) PostInvalidateRecipe(w http.ResponseWriter, r *http.Request) {
+	l := wa.newLogger("[PostInvalidateRecipe]")
+
+	var req struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		helpers.SendJSONError(w, fmt.Errorf("unable to read request: %v", err), http.StatusBadRequest)
+		return
+	}
+	u := strings.TrimSpace(req.URL)
+	if u == "" {
+		helpers.SendJSONError(w, fmt.Errorf("URL required"), http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := wa.InvalidateRecipe(u)
+	if err != nil {
+		helpers.SendJSONError(w, fmt.Errorf("unable to invalidate recipe: %v", err), http.StatusInternalServerError)
+		return
+	}
+	l.Printf("Invalidated %d cache entries for %s\n", deleted, u)
+
+	writeJSON(w, http.StatusOK, map[string]any{"url": u, "deleted": deleted})
+}
+
+// cachedSuggestionsValidation is the report of GetValidateCachedSuggestions.
+type cachedSuggestionsValidation struct {
+	URL string `json:"url"`
+	Key string `json:"key"`
+	// Format is "v1" for a suggestions array or "v2" for a V2 response.
+	Format string `json:"format"`
+	Valid  bool   `json:"valid"`
+	Error  string `json:"error,omitempty"`
+	// Line, Column, and Context locate a JSON syntax or type error, with
+	// Context showing the cached text around it.
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Context string `json:"context,omitempty"`
+	// Field is the JSON field holding a value of the wrong type.
+	Field string `json:"field,omitempty"`
+	// Result is what the entry parses to.
+	Result *models.SuggestionsResponse `json:"result,omitempty"`
+}
+
+// validationContextChars is how much cached text is shown either side of a
+// parse error.
+const validationContextChars = 40
+
+// GetValidateCachedSuggestions implements the route at
+// "GET /admin/recipes/{url}/validate". It parses the suggestions cached for
+// the recipe with the current code, V2 responses with models.ParseSuggestionsV2
+// and V1 arrays with models.ParseSuggestions, and reports the result or where
+// parsing failed, to check cached entries still read after a schema change.
+// It responds 404 if nothing is cached for the recipe.
+func (wa *Webapp) GetValidateCachedSuggestions(w http.ResponseWriter, r *http.Request) {
+	l := wa.newLogger("[GetValidateCachedSuggestions]")
+	u := getPathValue(r, "url")
+	if u == "" {
+		helpers.SendJSONError(w, fmt.Errorf("URL required"), http.StatusBadRequest)
+		return
+	}
+	if !wa.cacheEnabled {
+		helpers.SendJSONError(w, fmt.Errorf("suggestions are only cached when the cache is enabled"), http.StatusNotFound)
+		return
+	}
+
+	if canonical, err := wa.cache.Get(fmt.Sprintf("recipes:canonical:%s", u)); err == nil {
+		u = canonical
+	}
+	key := fmt.Sprintf("recipes:suggestions-json:%s", u)
+	cached, err := wa.cache.Get(key)
+	if errors.Is(err, cache.ErrKeyNotFound) {
+		helpers.SendJSONError(w, fmt.Errorf("no suggestions are cached for this recipe"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		l.Warnf("[CACHE] Error loading suggestions: %v\n", err)
+		helpers.SendJSONError(w, fmt.Errorf("unable to load cached suggestions: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, validateCachedSuggestions(u, key, cached))
+}
+
+// validateCachedSuggestions parses cached, the suggestions cached under key
+// for the recipe at u, and reports how it went.
+func validateCachedSuggestions(u, key, cached string) cachedSuggestionsValidation {
+	v := cachedSuggestionsValidation{URL: u, Key: key, Format: "v2"}
+	var result models.SuggestionsResponse
+	var err error
+	if strings.HasPrefix(strings.TrimSpace(cached), "[") {
+		v.Format = "v1"
+		result.Suggestions, err = models.ParseSuggestions(cached)
+	} else {
+		result, err = models.ParseSuggestionsV2(cached)
+	}
+	if err == nil {
+		v.Valid = true
+		v.Result = &result
+		return v
+	}
+
+	v.Error = err.Error()
+	offset := int64(-1)
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+		v.Field = typeErr.Field
+	}
+	if offset >= 0 {
+		offset = min(offset, int64(len(cached)))
+		before := cached[:offset]
+		v.Line = strings.Count(before, "\n") + 1
+		v.Column = utf8.RuneCountInString(before[strings.LastIndex(before, "\n")+1:]) + 1
+		start := max(0, int(offset)-validationContextChars)
+		end := min(len(cached), int(offset)+validationContextChars)
+		v.Context = strings.ToValidUTF8(cached[start:end], "")
+	}
+
+	return v
+}
+
+// hitsCacheKey counts the requests for suggestions for the recipe at u since
+// the last RefreshPopular.
+func hitsCacheKey(u string) string {
+	return fmt.Sprintf("hits:%s", u)
+}
+
+// recordHit counts a request for the suggestions of the recipe at u, when
+// popular recipes are being refreshed.
+func (wa *Webapp) recordHit(l *leveledLogger, u string) {
+	if wa.refreshInterval <= 0 || !wa.cacheEnabled {
+		return
+	}
+	if _, err := wa.cache.Incr(hitsCacheKey(u)); err != nil {
+		l.Warnf("[CACHE] Error counting hit: %v\n", err)
+	}
+}
+
+// refreshPopularEvery runs RefreshPopular every interval until ctx is done.
+func (wa *Webapp) refreshPopularEvery(ctx context.Context, interval time.Duration, topN int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := wa.RefreshPopular(ctx, topN); err != nil {
+				log.Printf("unable to refresh popular recipes: %v\n", err)
+			}
+		}
+	}
+}
+
+// popularRecipe is a recipe URL and how often its suggestions were requested.
+type popularRecipe struct {
+	url  string
+	hits int64
+}
+
+// RefreshPopular regenerates and stores the suggestions of the topN recipes
+// requested most since the last refresh, then resets every hit count, so each
+// run ranks recipes by their recent popularity. Recipes that fail to refresh
+// keep their old suggestions and are skipped. It returns the URLs refreshed,
+// most popular first. No quota is spent.
+func (wa *Webapp) RefreshPopular(ctx context.Context, topN int) ([]string, error) {
+	l := wa.newLogger("[RefreshPopular]")
+	if !wa.cacheEnabled {
+		return nil, fmt.Errorf("hit counts are only kept when the cache is enabled")
+	}
+
+	keys, err := wa.cache.GetKeys(hitsCacheKey("*"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to list hit counts: %v", err)
+	}
+	var popular []popularRecipe
+	for _, k := range keys {
+		val, err := wa.cache.Get(k)
+		if err != nil {
+			continue
+		}
+		hits, err := strconv.ParseInt(val, 10, 64)
+		if err != nil || hits <= 0 {
+			continue
+		}
+		popular = append(popular, popularRecipe{url: strings.TrimPrefix(k, hitsCacheKey("")), hits: hits})
+	}
+	slices.SortFunc(popular, func(a, b popularRecipe) int {
+		if a.hits != b.hits {
+			return cmp.Compare(b.hits, a.hits)
+		}
+		return strings.Compare(a.url, b.url)
+	})
+	popular = popular[:min(topN, len(popular))]
+
+	// Start the next window now, so requests during the refresh count toward it
+	if _, err := wa.deleteCacheKeys(keys); err != nil {
+		l.Warnf("[CACHE] Error resetting hit counts: %v\n", err)
+	}
+
+	var refreshed []string
+	for _, p := range popular {
+		if err := ctx.Err(); err != nil {
+			return refreshed, err
+		}
+		l.Printf("Refreshing %s (%d hits)\n", p.url, p.hits)
+		if err := wa.refreshSuggestions(ctx, l, p.url); err != nil {
+			l.Warnf("Error refreshing %s: %v\n", p.url, err)
+			continue
+		}
+		refreshed = append(refreshed, p.url)
+	}
+
+	return refreshed, nil
+}
+
+// refreshSuggestions regenerates the suggestions for the recipe at u the way
+// GetRecipeWineSuggestions would on a miss, replacing the stored ones.
+func (wa *Webapp) refreshSuggestions(ctx context.Context, l *leveledLogger, u string) error {
+	summary, _, err := wa.summarizeRecipeURL(ctx, l, u)
+	if err != nil {
+		return err
+	}
+
+	cacheKey := fmt.Sprintf("recipes:suggestions-json:%s", u)
+	var prefs models.PairingPreferences
+	wa.restrictPreferences(&prefs)
+	var suggestionsJSON string
+	if prefs.IsZero() {
+		suggestionsJSON, err = models.GeneratePairingSuggestionsForSummary(ctx, wa.modelFor(ctx), summary)
+	} else {
+		cacheKey = getCacheKeyForPreferences(u, prefs)
+		suggestionsJSON, err = models.GeneratePairingSuggestionsForPreferences(ctx, wa.modelFor(ctx), summary, prefs)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to get wine suggestions from the model: %w", err)
+	}
+
+	parsed, err := models.ParseSuggestionsResult(suggestionsJSON, models.ParseOptions{Tolerant: true})
+	if err != nil {
+		return fmt.Errorf("unable to parse suggestions: %v", err)
+	}
+	if len(parsed.Suggestions) == 0 {
+		return fmt.Errorf("model returned no suggestions")
+	}
+	out, err := json.Marshal(parsed.Suggestions)
+	if err != nil {
+		return fmt.Errorf("unable to render suggestions JSON: %v", err)
+	}
+
+	if prefs.IsZero() {
+		l.Printf("[DB] Storing pairing in DynamoDB (ID: %s, type: %s)\n", u, data.PairingTypeURL)
+		if _, err := wa.dl.CreateRecipePairing(ctx, u, data.PairingTypeURL, summary.Summary, convertToDataSuggestions(parsed.Suggestions)); err != nil {
+			l.Warnf("[DB] Error storing in DynamoDB: %v\n", err)
+		}
+	}
+	l.Printf("[CACHE] Storing suggestions in cache (key: %s)\n", cacheKey)
+	if err := wa.cache.Set(cacheKey, string(out)); err != nil {
+		return fmt.Errorf("unable to store suggestions in cache: %v", err)
+	}
+
+	return nil
+}
+
+// storedSuggestions returns the suggestions already generated for the recipe
+// at u, with its summary when known, from DynamoDB or else the cache, without
+// generating any.
+func (wa *Webapp) storedSuggestions(ctx context.Context, l *leveledLogger, u string) (models.SuggestionsResponse, bool) {
+	// Restricted deployments only keep their suggestions in the cache, under
+	// the key for their preferences
+	cacheKey := fmt.Sprintf("recipes:suggestions-json:%s", u)
+	var prefs models.PairingPreferences
+	wa.restrictPreferences(&prefs)
+	if !prefs.IsZero() {
+		cacheKey = getCacheKeyForPreferences(u, prefs)
+	}
+
+	// PRIMARY: Try DynamoDB first (source of truth)
+	if !prefs.IsZero() {
+		l.Printf("[DB] Skipping DynamoDB for preferences %q\n", prefs.Key())
+	} else if pairing, err := wa.dl.GetRecipePairing(ctx, u); err == nil {
+		return models.SuggestionsResponse{
+			Suggestions: convertFromDataSuggestions(pairing.Suggestions),
+			Summary:     pairing.Summary,
+		}, true
+	} else if !errors.Is(err, data.ErrNotFound) {
+		l.Warnf("[DB] Error querying DynamoDB: %v\n", err)
+	}
+
+	// FALLBACK: Check cache if enabled
+	if wa.cacheEnabled {
+		cached, err := wa.cache.Get(cacheKey)
+		if err == nil {
+			parsed, err := parseCachedSuggestions(cached)
+			if err == nil {
+				stored := models.SuggestionsResponse{Suggestions: parsed}
+				if summary, err := wa.cache.Get(fmt.Sprintf("recipes:summarized:%s", u)); err == nil {
+					stored.Summary = summary
+				}
+				return stored, true
+			}
+			l.Warnf("[CACHE] Unable to parse cached suggestions: %v\n", err)
+		} else if !errors.Is(err, cache.ErrKeyNotFound) {
+			l.Warnf("[CACHE] Error reading cache: %v\n", err)
+		}
+	}
+
+	return models.SuggestionsResponse{}, false
+}
+
+// parseCachedSuggestions reads suggestions cached under
+// "recipes:suggestions-json:", which hold either a V1 array or a V2 response.
+func parseCachedSuggestions(cached string) ([]models.Suggestion, error) {
+	parsed, err := models.ParseSuggestions(cached)
+	if err == nil {
+		return parsed, nil
+	}
+	var response models.SuggestionsResponse
+	if jerr := json.Unmarshal([]byte(cached), &response); jerr == nil {
+		return response.Suggestions, nil
+	}
+	return nil, err
+}
+
+// GetWineStats implements the route at "GET /stats/wines" and reports which
+// wine styles and regions are suggested most across cached recipes.
+// Suggestions tailored to preferences are left out so a recipe is only counted
+// once. "?top=N" keeps the N most suggested styles and regions.
+func (wa *Webapp) GetWineStats(w http.ResponseWriter, r *http.Request) {
+	l := wa.newLogger("[GetWineStats]")
+
+	top := 0
+	if v := r.URL.Query().Get("top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			helpers.SendJSONError(w, fmt.Errorf("top must be a positive whole number"), http.StatusBadRequest)
+			return
+		}
+		top = n
+	}
+	if !wa.cacheEnabled {
+		helpers.SendJSONError(w, fmt.Errorf("wine stats are only kept when the cache is enabled"), http.StatusNotFound)
+		return
+	}
+
+	keys, err := wa.cache.GetKeys("recipes:suggestions-json:*")
+	if err != nil {
+		helpers.SendJSONError(w, fmt.Errorf("unable to list cached suggestions: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var sets [][]models.Suggestion
+	for _, k := range keys {
+		if strings.HasPrefix(k, "recipes:suggestions-json:prefs:") {
+			continue
+		}
+		text, err := wa.cache.Get(k)
+		if err != nil {
+			l.Warnf("[CACHE] Skipping %s: %v\n", k, err)
+			continue
+		}
+		suggestions, err := parseCachedSuggestions(text)
+		if err != nil {
+			l.Warnf("[CACHE] Skipping unreadable suggestions at %s: %v\n", k, err)
+			continue
+		}
+		sets = append(sets, suggestions)
+	}
+
+	writeJSON(w, http.StatusOK, models.AggregateSuggestions(sets).Top(top))
+}
+
+// GetSuggestionsSchema implements the route at "GET /schema/suggestions",
+// returning the JSON Schema of the V2 suggestions response.
+func (wa *Webapp) GetSuggestionsSchema(w http.ResponseWriter, r *http.Request) {
+	writeSchema(w, models.SuggestionsResponse{}, "SuggestionsResponse")
+}
+
+// GetSummarySchema implements the route at "GET /schema/summary", returning
+// the JSON Schema of a recipe summary.
+func (wa *Webapp) GetSummarySchema(w http.ResponseWriter, r *http.Request) {
+	writeSchema(w, models.Summary{}, "Summary")
+}
+
+// writeSchema writes the JSON Schema of v.
+func writeSchema(w http.ResponseWriter, v any, title string) {
+	out, err := models.JSONSchema(v, title)
+	if err != nil {
+		helpers.SendJSONError(w, fmt.Errorf("unable to encode schema: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/schema+json")
+	fmt.Fprint(w, string(out))
+}
+
+// Health states reported by HealthStatus.
+const (
+	healthHealthy   = "healthy"
+	healthDegraded  = "degraded"
+	healthUnhealthy = "unhealthy"
+)
+
+// healthResponse is the body of a health check. Checks maps each dependency
+// ("cache", "database") to "ok" or the error it reported.
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
 }
 
+// HealthStatus implements the route at "GET /healthz". DynamoDB is the source
+// of truth and the cache only a speedup, so a failed cache check with a
+// working database reports "degraded" with a 200, while a failed database
+// reports "unhealthy" with a 503.
 func (wa *Webapp) HealthStatus(w http.ResponseWriter, r *http.Request) {
+	resp := healthResponse{Status: healthHealthy, Checks: map[string]string{}}
+
 	// Check cache only if enabled
 	if wa.cacheEnabled {
 		if ok, err := wa.cache.Check(); !ok || err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "unable to connect to cache: %v", err)
-			return
+			if err == nil {
+				err = fmt.Errorf("cache check failed")
+			}
+			resp.Status = healthDegraded
+			resp.Checks["cache"] = fmt.Sprintf("unable to connect to cache: %v", err)
+		} else {
+			resp.Checks["cache"] = "ok"
 		}
 	}
 
 	// Always check DynamoDB as it's the primary data source
 	// A simple table list check verifies DB connectivity
-	ctx := context.Background()
-	if _, err := wa.dl.GetRecentRecipePairingIDs(ctx, data.PairingTypeURL, 1); err != nil && !errors.Is(err, data.ErrNotFound) {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "unable to connect to database: %v", err)
-		return
+	if _, err := wa.dl.GetRecentRecipePairingIDs(r.Context(), data.PairingTypeURL, 1); err != nil && !errors.Is(err, data.ErrNotFound) {
+		resp.Status = healthUnhealthy
+		resp.Checks["database"] = fmt.Sprintf("unable to connect to database: %v", err)
+	} else {
+		resp.Checks["database"] = "ok"
 	}
 
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprint(w, "OK")
+	status := http.StatusOK
+	if resp.Status == healthUnhealthy {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, resp)
 }
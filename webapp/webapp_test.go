@@ -0,0 +1,1233 @@
+package webapp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	mcpclient "github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/tmc/langchaingo/llms"
+	"golang.org/x/net/websocket"
+
+	"github.com/thedahv/wine-pairing-suggestions/cache"
+	"github.com/thedahv/wine-pairing-suggestions/data"
+	"github.com/thedahv/wine-pairing-suggestions/data/datatest"
+	wpmcp "github.com/thedahv/wine-pairing-suggestions/mcp"
+	"github.com/thedahv/wine-pairing-suggestions/models"
+)
+
+const recipeHTML = `<html><head><title>Braised Short Ribs</title></head><body><article>
+<h1>Braised Short Ribs</h1>
+<p>Season the short ribs generously, brown them in a heavy pot, then braise them in red wine with onions, carrots, garlic and thyme for three hours until the meat falls off the bone.</p>
+<ul><li>4 beef short ribs</li><li>1 bottle red wine</li><li>2 onions</li><li>3 carrots</li><li>6 cloves garlic</li></ul>
+<p>Reduce the braising liquid to a glossy sauce and serve over mashed potatoes.</p>
+</article></body></html>`
+
+const summaryJSON = `{
+	"ok": true,
+	"summary": "Rich, heavy braised beef short ribs in a savory red wine sauce with sweet carrots, onions and thyme; umami-laden and fatty.",
+	"flavorProfile": {"sweetness": 1, "acidity": 2, "fat": 4, "spice": 0, "weight": 5},
+	"ingredients": ["short ribs", "red wine", "onions", "carrots", "garlic"],
+	"cookingMethods": ["braised"]
+}`
+
+var testSuggestions = []models.Suggestion{
+	{Style: "Cabernet Sauvignon", Region: "Napa Valley", Description: "Full-bodied red with dark fruit.", PairingNote: "Its tannins cut through the fat.", Confidence: 0.9},
+	{Style: "Syrah", Region: "Northern Rhône", Description: "Savory red with pepper and olive.", PairingNote: "Its savory notes echo the braise.", Confidence: 0.8},
+	{Style: "Zinfandel", Region: "Sonoma", Description: "Ripe, jammy red.", PairingNote: "Its fruit stands up to the rich sauce.", Confidence: 0.7},
+}
+
+// testModel is a fake llms.Model for handler tests. It answers summary prompts
+// with summaryJSON and every other prompt with testSuggestions, streaming one
+// suggestion per chunk when asked. Unlike langchaingo's fake model it is safe
+// for concurrent use.
+type testModel struct {
+	// step, if set, is received from before each streamed suggestion after
+	// the first, so a test can observe delivery between them.
+	step chan struct{}
+	// delay, if set, is how long generating suggestions takes, unless the
+	// context ends first.
+	delay time.Duration
+
+	generations atomic.Int32
+	mu          sync.Mutex
+	prompts     []string
+}
+
+func (m *testModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	var opts llms.CallOptions
+	for _, o := range options {
+		o(&opts)
+	}
+	var sb strings.Builder
+	for _, message := range messages {
+		for _, part := range message.Parts {
+			if text, ok := part.(llms.TextContent); ok {
+				sb.WriteString(text.Text)
+			}
+		}
+	}
+	prompt := sb.String()
+	m.mu.Lock()
+	m.prompts = append(m.prompts, prompt)
+	m.mu.Unlock()
+
+	if strings.Contains(prompt, "Summarize this recipe for wine pairing") {
+		return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: summaryJSON}}}, nil
+	}
+	m.generations.Add(1)
+	if m.delay > 0 {
+		select {
+		case <-time.After(m.delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	chunks := []string{"["}
+	for i, s := range testSuggestions {
+		out, err := json.Marshal(s)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			out = append([]byte(","), out...)
+		}
+		chunks = append(chunks, string(out))
+	}
+	chunks = append(chunks, "]")
+
+	if opts.StreamingFunc != nil {
+		for i, chunk := range chunks {
+			if m.step != nil && i > 1 && i < len(chunks)-1 {
+				select {
+				case <-m.step:
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+			}
+			if err := opts.StreamingFunc(ctx, []byte(chunk)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: strings.Join(chunks, "")}}}, nil
+}
+
+func (m *testModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+// lastPrompt returns the most recent prompt the model was sent.
+func (m *testModel) lastPrompt() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.prompts) == 0 {
+		return ""
+	}
+	return m.prompts[len(m.prompts)-1]
+}
+
+// testApp is a Webapp served by an httptest.Server, with the memory cache
+// enabled, a fake DynamoDB, a testModel, and a recipe site to fetch from.
+type testApp struct {
+	wa        *Webapp
+	srv       *httptest.Server
+	model     *testModel
+	db        *datatest.Server
+	dl        *data.DataLayer
+	recipeURL string
+}
+
+// newTestApp starts a testApp. Options are applied after the defaults, so
+// they can override them. Its environment changes rule out parallel tests.
+func newTestApp(t *testing.T, options ...Option) *testApp {
+	t.Helper()
+	app := &testApp{model: &testModel{}}
+	app.dl, app.db = datatest.NewDataLayer(t)
+
+	recipes := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, recipeHTML)
+	}))
+	t.Cleanup(recipes.Close)
+	app.recipeURL = recipes.URL + "/braised-short-ribs"
+
+	fetch := func(u string) (io.ReadCloser, string, error) {
+		return io.NopCloser(strings.NewReader(recipeHTML)), u, nil
+	}
+	server := wpmcp.MakeServer(cache.NewMemory(), wpmcp.WithFetcher(fetch))
+	wa, err := NewWebapp(0, append([]Option{
+		WithMemoryCache(),
+		WithDatabase(app.dl),
+		WithModel(app.model, server),
+		WithLogLevel(LogLevelError),
+	}, options...)...)
+	if err != nil {
+		t.Fatalf("unable to create webapp: %v", err)
+	}
+	wa.cacheEnabled = true
+	app.wa = wa
+
+	app.srv = httptest.NewServer(wa.Handler())
+	t.Cleanup(app.srv.Close)
+
+	return app
+}
+
+// createAccount stores an account with the given quota.
+func (app *testApp) createAccount(t *testing.T, id string, quota int) {
+	t.Helper()
+	if _, err := app.dl.CreateAccountWithQuota(context.Background(), id, id+"@example.com", quota); err != nil {
+		t.Fatalf("unable to create account: %v", err)
+	}
+}
+
+// quota returns the account's stored quota.
+func (app *testApp) quota(t *testing.T, id string) int {
+	t.Helper()
+	account, err := app.dl.GetAccountByID(context.Background(), id)
+	if err != nil {
+		t.Fatalf("unable to load account: %v", err)
+	}
+	return account.Quota
+}
+
+// recipePath returns path with the escaped recipe URL appended.
+func (app *testApp) recipePath(path string) string {
+	return path + url.PathEscape(app.recipeURL)
+}
+
+// newRequest builds a request to the app signed in as accountID, or anonymous
+// if accountID is empty.
+func (app *testApp) newRequest(t *testing.T, method, path, accountID string, body io.Reader) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(method, app.srv.URL+path, body)
+	if err != nil {
+		t.Fatalf("unable to build request: %v", err)
+	}
+	if accountID != "" {
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: accountID})
+	}
+	return req
+}
+
+// do sends a request to the app signed in as accountID and returns the
+// response with its body read.
+func (app *testApp) do(t *testing.T, method, path, accountID string, body io.Reader) (*http.Response, string) {
+	t.Helper()
+	resp, err := http.DefaultClient.Do(app.newRequest(t, method, path, accountID, body))
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("unable to read response: %v", err)
+	}
+	return resp, string(out)
+}
+
+func TestSummaryThenSuggestions(t *testing.T) {
+	app := newTestApp(t)
+	app.createAccount(t, "acct", 5)
+
+	resp, body := app.do(t, "POST", app.recipePath("/recipes/summary/"), "acct", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("summary status = %d: %s", resp.StatusCode, body)
+	}
+	var summary struct {
+		Summary     string   `json:"summary"`
+		Ingredients []string `json:"ingredients"`
+	}
+	if err := json.Unmarshal([]byte(body), &summary); err != nil {
+		t.Fatalf("unable to decode summary %q: %v", body, err)
+	}
+	if !strings.Contains(summary.Summary, "short ribs") || len(summary.Ingredients) == 0 {
+		t.Errorf("summary = %+v, want the model's summary", summary)
+	}
+
+	for i := range 2 {
+		resp, body = app.do(t, "GET", app.recipePath("/recipes/suggestions/"), "acct", nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: suggestions status = %d: %s", i, resp.StatusCode, body)
+		}
+		var suggestions []models.Suggestion
+		if err := json.Unmarshal([]byte(body), &suggestions); err != nil {
+			t.Fatalf("request %d: unable to decode suggestions %q: %v", i, body, err)
+		}
+		if len(suggestions) != len(testSuggestions) || suggestions[0].Style != testSuggestions[0].Style {
+			t.Errorf("request %d: suggestions = %+v, want the model's", i, suggestions)
+		}
+	}
+
+	// The second request was served from the stored pairing, free of charge
+	if n := app.model.generations.Load(); n != 1 {
+		t.Errorf("generated %d times, want 1", n)
+	}
+	if q := app.quota(t, "acct"); q != 4 {
+		t.Errorf("quota = %d, want 4", q)
+	}
+	pairing, err := app.dl.GetRecipePairing(context.Background(), app.recipeURL)
+	if err != nil || len(pairing.Suggestions) != len(testSuggestions) {
+		t.Errorf("stored pairing = %+v, %v; want the suggestions", pairing, err)
+	}
+}
+
+func TestSuggestionCounts(t *testing.T) {
+	app := newTestApp(t)
+	app.createAccount(t, "acct", 5)
+	if resp, body := app.do(t, "POST", app.recipePath("/recipes/summary/"), "acct", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("summary status = %d: %s", resp.StatusCode, body)
+	}
+
+	for _, query := range []string{"?min=0", "?max=16", "?min=5&max=3", "?min=three"} {
+		if resp, body := app.do(t, "GET", app.recipePath("/recipes/suggestions/")+query, "acct", nil); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400: %s", query, resp.StatusCode, body)
+		}
+	}
+	if n := app.model.generations.Load(); n != 0 {
+		t.Fatalf("invalid counts generated %d times, want 0", n)
+	}
+
+	resp, body := app.do(t, "GET", app.recipePath("/recipes/suggestions/")+"?min=3&max=3", "acct", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("suggestions status = %d: %s", resp.StatusCode, body)
+	}
+	if prompt := app.model.lastPrompt(); !strings.Contains(prompt, "Generate exactly 3 wine pairings") {
+		t.Errorf("prompt doesn't ask for exactly 3 pairings:\n%s", prompt)
+	}
+
+	// The counts are part of the cache key, so the default range is
+	// generated separately rather than served the 3-pairing answer
+	if resp, body := app.do(t, "GET", app.recipePath("/recipes/suggestions/"), "acct", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("default suggestions status = %d: %s", resp.StatusCode, body)
+	}
+	if prompt := app.model.lastPrompt(); !strings.Contains(prompt, "Generate 5-10 wine pairings") {
+		t.Errorf("default prompt doesn't ask for 5-10 pairings:\n%s", prompt)
+	}
+	if n := app.model.generations.Load(); n != 2 {
+		t.Errorf("generated %d times, want 2", n)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	app := newTestApp(t, WithRequestTimeout(100*time.Millisecond))
+	app.createAccount(t, "acct", 5)
+	if resp, body := app.do(t, "POST", app.recipePath("/recipes/summary/"), "acct", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("summary status = %d: %s", resp.StatusCode, body)
+	}
+
+	app.model.delay = 5 * time.Second
+	start := time.Now()
+	resp, body := app.do(t, "GET", app.recipePath("/recipes/suggestions/"), "acct", nil)
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("slow suggestions status = %d, want 504: %s", resp.StatusCode, body)
+	}
+	if elapsed := time.Since(start); elapsed >= app.model.delay {
+		t.Errorf("slow suggestions took %s, want the request abandoned at its deadline", elapsed)
+	}
+}
+
+func TestCacheKeyForPreferences(t *testing.T) {
+	app := newTestApp(t)
+	app.createAccount(t, "acct", 10)
+	if resp, body := app.do(t, "POST", app.recipePath("/recipes/summary/"), "acct", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("summary status = %d: %s", resp.StatusCode, body)
+	}
+
+	three := models.PairingPreferences{MinCount: 3, MaxCount: 3}
+	four := models.PairingPreferences{MinCount: 4, MaxCount: 4}
+	threeKey, fourKey := getCacheKeyForPreferences(app.recipeURL, three), getCacheKeyForPreferences(app.recipeURL, four)
+	if threeKey == fourKey {
+		t.Fatalf("both preference sets have the key %q", threeKey)
+	}
+	for _, key := range []string{threeKey, fourKey} {
+		if !strings.HasPrefix(key, "recipes:suggestions-json:prefs:") || !strings.HasSuffix(key, ":"+app.recipeURL) {
+			t.Errorf("key %q doesn't end with the URL after the preferences", key)
+		}
+	}
+	if key := getCacheKeyForPreferences(app.recipeURL, models.PairingPreferences{MinCount: 3, MaxCount: 3}); key != threeKey {
+		t.Errorf("equal preferences have keys %q and %q", key, threeKey)
+	}
+
+	for _, tc := range []struct {
+		query       string
+		generations int32
+	}{
+		{"?min=3&max=3", 1},
+		{"?min=4&max=4", 2},
+		// Each set is served from its own entry
+		{"?min=3&max=3", 2},
+		{"?min=4&max=4", 2},
+	} {
+		if resp, body := app.do(t, "GET", app.recipePath("/recipes/suggestions/")+tc.query, "acct", nil); resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", tc.query, resp.StatusCode, body)
+		}
+		if n := app.model.generations.Load(); n != tc.generations {
+			t.Errorf("%s: generated %d times, want %d", tc.query, n, tc.generations)
+		}
+	}
+
+	// Dropping one entry leaves the other in place
+	app.wa.cache.Delete(threeKey)
+	if _, err := app.wa.cache.Get(fourKey); err != nil {
+		t.Errorf("4-pairing entry = %v after dropping the 3-pairing one", err)
+	}
+}
+
+func TestRefreshPopular(t *testing.T) {
+	app := newTestApp(t)
+	ctx := context.Background()
+
+	// The recipe site serves the same page at any path
+	base := strings.TrimSuffix(app.recipeURL, "/braised-short-ribs")
+	hits := map[string]string{base + "/popular": "5", base + "/runner-up": "3", base + "/rare": "1"}
+	for u, n := range hits {
+		if err := app.wa.cache.Set(hitsCacheKey(u), n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	refreshed, err := app.wa.RefreshPopular(ctx, 2)
+	if err != nil {
+		t.Fatalf("RefreshPopular: %v", err)
+	}
+	if want := []string{base + "/popular", base + "/runner-up"}; !slices.Equal(refreshed, want) {
+		t.Errorf("refreshed %q, want %q", refreshed, want)
+	}
+	if n := app.model.generations.Load(); n != 2 {
+		t.Errorf("generated %d times, want 2", n)
+	}
+	for u := range hits {
+		_, err := app.dl.GetRecipePairing(ctx, u)
+		_, cacheErr := app.wa.cache.Get("recipes:suggestions-json:" + u)
+		if want := slices.Contains(refreshed, u); (err == nil) != want || (cacheErr == nil) != want {
+			t.Errorf("%s: stored pairing = %v, cached = %v; want stored and cached: %v", u, err, cacheErr, want)
+		}
+	}
+
+	// Every count was reset, so the next run has nothing to refresh
+	if keys, err := app.wa.cache.GetKeys(hitsCacheKey("*")); err != nil || len(keys) != 0 {
+		t.Errorf("hit counts after refresh = %q, %v; want none", keys, err)
+	}
+	if refreshed, err := app.wa.RefreshPopular(ctx, 2); err != nil || len(refreshed) != 0 {
+		t.Errorf("second refresh = %q, %v; want nothing refreshed", refreshed, err)
+	}
+}
+
+func TestUnknownPersona(t *testing.T) {
+	app := newTestApp(t)
+	app.createAccount(t, "acct", 5)
+
+	for _, tc := range []struct {
+		name, path, body string
+	}{
+		{"V2 suggestions", "/recipes/suggestionsV2/?persona=pirate", app.recipeURL},
+		{"pairings", "/pairings?persona=pirate", `{"dish": "Braised short ribs"}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, body := app.do(t, "POST", tc.path, "acct", strings.NewReader(tc.body))
+			if resp.StatusCode != http.StatusBadRequest || !strings.Contains(body, "unknown persona") {
+				t.Errorf("status = %d, want 400 naming the persona: %s", resp.StatusCode, body)
+			}
+		})
+	}
+	if n := app.model.generations.Load(); n != 0 {
+		t.Errorf("generated %d times, want 0", n)
+	}
+	if q := app.quota(t, "acct"); q != 5 {
+		t.Errorf("quota = %d, want 5", q)
+	}
+}
+
+func TestPreferenceProfileSuggestions(t *testing.T) {
+	app := newTestApp(t)
+	app.createAccount(t, "acct", 10)
+	if resp, body := app.do(t, "PUT", "/user/preferences", "acct", strings.NewReader(`{"types": ["red"], "dislikedStyles": ["Merlot"], "maxPrice": 40}`)); resp.StatusCode != http.StatusOK {
+		t.Fatalf("preferences status = %d: %s", resp.StatusCode, body)
+	}
+	if resp, body := app.do(t, "POST", app.recipePath("/recipes/summary/"), "acct", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("summary status = %d: %s", resp.StatusCode, body)
+	}
+	prefs := models.PairingPreferences{Types: []models.WineType{models.WineTypeRed}, DislikedStyles: []string{"Merlot"}, MaxPrice: 40}
+
+	for _, tc := range []struct {
+		name, method, path, body string
+		want                     []string
+		// cached is whether the suggestions are cached for the profile
+		cached bool
+	}{
+		{"suggestions", "GET", app.recipePath("/recipes/suggestions/"), "", []string{"<USER_PROFILE>", "never suggest them: Merlot", "$40 or less"}, true},
+		{"stream", "GET", app.recipePath("/recipes/suggestions/stream/"), "", []string{"<USER_PROFILE>", "never suggest them: Merlot", "$40 or less"}, true},
+		{"inventory", "POST", app.recipePath("/recipes/suggestions/") + "/from-inventory", `{"wines": ["Merlot", "Syrah"]}`, []string{"<USER_PROFILE>", "prefers red wines", "rank them last: Merlot"}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// Drop the suggestions cached by the previous case
+			app.wa.cache.Delete(getCacheKeyForPreferences(app.recipeURL, prefs))
+			before := app.model.generations.Load()
+			var body io.Reader
+			if tc.body != "" {
+				body = strings.NewReader(tc.body)
+			}
+			resp, respBody := app.do(t, tc.method, tc.path, "acct", body)
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d: %s", resp.StatusCode, respBody)
+			}
+			if app.model.generations.Load() == before {
+				t.Fatal("model wasn't asked for suggestions")
+			}
+			prompt := app.model.lastPrompt()
+			for _, want := range tc.want {
+				if !strings.Contains(prompt, want) {
+					t.Errorf("prompt is missing %q:\n%s", want, prompt)
+				}
+			}
+			if _, err := app.wa.cache.Get(getCacheKeyForPreferences(app.recipeURL, prefs)); (err == nil) != tc.cached {
+				t.Errorf("cached for the profile: %v, want %v", err == nil, tc.cached)
+			}
+		})
+	}
+
+	// Tailored suggestions are never cached or stored as the default pairing
+	if _, err := app.wa.cache.Get("recipes:suggestions-json:" + app.recipeURL); err == nil {
+		t.Error("tailored suggestions were cached under the default key")
+	}
+	if _, err := app.dl.GetRecipePairing(context.Background(), app.recipeURL); !errors.Is(err, data.ErrNotFound) {
+		t.Errorf("stored pairing lookup = %v, want ErrNotFound", err)
+	}
+}
+
+func TestAllowedRecipeDomains(t *testing.T) {
+	// Served on "localhost", outside the allowed "127.0.0.1"
+	elsewhere := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, recipeHTML)
+	}))
+	t.Cleanup(elsewhere.Close)
+	elsewhereURL := strings.Replace(elsewhere.URL, "127.0.0.1", "localhost", 1) + "/braised-short-ribs"
+
+	redirects := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/moved":
+			http.Redirect(w, r, "/braised-short-ribs", http.StatusMovedPermanently)
+		case "/moved-away":
+			http.Redirect(w, r, elsewhereURL, http.StatusMovedPermanently)
+		default:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, recipeHTML)
+		}
+	}))
+	t.Cleanup(redirects.Close)
+
+	for _, tc := range []struct {
+		name, url string
+		want      int
+	}{
+		{"allowed host", redirects.URL + "/braised-short-ribs", http.StatusOK},
+		{"redirect within the allowed host", redirects.URL + "/moved", http.StatusOK},
+		{"disallowed host", elsewhereURL, http.StatusForbidden},
+		{"redirect to a disallowed host", redirects.URL + "/moved-away", http.StatusForbidden},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newTestApp(t, WithAllowedRecipeDomains([]string{"127.0.0.1"}))
+			app.createAccount(t, "acct", 5)
+			resp, body := app.do(t, "POST", "/recipes/summary/"+url.PathEscape(tc.url), "acct", nil)
+			if resp.StatusCode != tc.want {
+				t.Fatalf("summary status = %d, want %d: %s", resp.StatusCode, tc.want, body)
+			}
+			if tc.want == http.StatusOK {
+				return
+			}
+			if !strings.Contains(body, errRecipeDomainNotAllowed.Error()) {
+				t.Errorf("body = %s, want %q", body, errRecipeDomainNotAllowed)
+			}
+			if n := app.model.generations.Load(); n != 0 {
+				t.Errorf("model generated %d times for a rejected site", n)
+			}
+			if _, err := app.wa.cache.Get("recipes:raw:" + elsewhereURL); err == nil {
+				t.Error("the rejected site's HTML was cached")
+			}
+			if got := app.quota(t, "acct"); got != 5 {
+				t.Errorf("quota = %d, want 5", got)
+			}
+		})
+	}
+}
+
+func TestExplainSuggestion(t *testing.T) {
+	app := newTestApp(t)
+	app.createAccount(t, "acct", 5)
+	if resp, body := app.do(t, "POST", app.recipePath("/recipes/summary/"), "acct", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("summary status = %d: %s", resp.StatusCode, body)
+	}
+	explain := func(body string) explanationResponse {
+		t.Helper()
+		resp, out := app.do(t, "POST", app.recipePath("/recipes/suggestions/")+"/explain", "acct", strings.NewReader(body))
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("explain status = %d: %s", resp.StatusCode, out)
+		}
+		var e explanationResponse
+		if err := json.Unmarshal([]byte(out), &e); err != nil || e.Explanation == "" {
+			t.Fatalf("explanation = %s, %v", out, err)
+		}
+		return e
+	}
+
+	explain(`{"style": "Syrah", "region": "Northern Rhône"}`)
+	prompt := app.model.lastPrompt()
+	for _, want := range []string{"Rich, heavy braised beef short ribs", "Syrah from Northern Rhône"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("explanation prompt is missing %q:\n%s", want, prompt)
+		}
+	}
+	if _, err := app.wa.cache.Get(explanationCacheKey(app.recipeURL, "Syrah", "Northern Rhône")); err != nil {
+		t.Errorf("explanation wasn't cached: %v", err)
+	}
+
+	// The same wine, written differently, is served from the cache
+	explain(`{"style": "syrah", "region": " northern  rhône "}`)
+	if n := app.model.generations.Load(); n != 1 {
+		t.Errorf("generated %d explanations, want 1 with the repeat cached", n)
+	}
+	if got := app.quota(t, "acct"); got != 4 {
+		t.Errorf("quota = %d, want 4 with only the first explanation charged", got)
+	}
+
+	// The same style from another region is explained afresh
+	explain(`{"style": "Syrah", "region": "Barossa Valley"}`)
+	if n := app.model.generations.Load(); n != 2 {
+		t.Errorf("generated %d explanations, want 2 for two regions", n)
+	}
+	if prompt := app.model.lastPrompt(); !strings.Contains(prompt, "Syrah from Barossa Valley") {
+		t.Errorf("explanation prompt doesn't name the new region:\n%s", prompt)
+	}
+}
+
+func TestSuggestionsRequireSession(t *testing.T) {
+	app := newTestApp(t)
+
+	if resp, body := app.do(t, "GET", app.recipePath("/recipes/suggestions/"), "", nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("anonymous suggestions status = %d, want 401: %s", resp.StatusCode, body)
+	}
+	if n := app.model.generations.Load(); n != 0 {
+		t.Errorf("generated %d times for an anonymous request", n)
+	}
+}
+
+func TestPostedSummarySuggestions(t *testing.T) {
+	app := newTestApp(t)
+	app.createAccount(t, "acct", 5)
+
+	body := fmt.Sprintf(`{"url": %q, "summary": "Grilled salmon with lemon and dill; light, bright and acidic."}`, app.recipeURL)
+	resp, out := app.do(t, "POST", "/recipes/suggestions", "acct", strings.NewReader(body))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("suggestions status = %d: %s", resp.StatusCode, out)
+	}
+	var suggestions []models.Suggestion
+	if err := json.Unmarshal([]byte(out), &suggestions); err != nil || len(suggestions) != len(testSuggestions) {
+		t.Fatalf("suggestions = %q, %v", out, err)
+	}
+	if prompt := app.model.lastPrompt(); !strings.Contains(prompt, "Grilled salmon with lemon and dill") {
+		t.Errorf("the posted summary wasn't sent to the model: %q", prompt)
+	}
+
+	// Suggestions for a client's summary are charged but never stored
+	if q := app.quota(t, "acct"); q != 4 {
+		t.Errorf("quota = %d, want 4", q)
+	}
+	if n := app.db.Len("RecipePairings"); n != 0 {
+		t.Errorf("stored %d pairings, want none", n)
+	}
+}
+
+func TestSuggestionsWebSocket(t *testing.T) {
+	app := newTestApp(t)
+	app.createAccount(t, "acct", 5)
+	app.model.step = make(chan struct{})
+
+	config, err := websocket.NewConfig("ws"+strings.TrimPrefix(app.srv.URL, "http")+"/ws/suggestions", app.srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Header.Set("Cookie", sessionCookieName+"=acct")
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		t.Fatalf("unable to open WebSocket: %v", err)
+	}
+	defer ws.Close()
+	if err := websocket.Message.Send(ws, app.recipeURL); err != nil {
+		t.Fatalf("unable to send recipe URL: %v", err)
+	}
+
+	receive := func() suggestionStreamMessage {
+		t.Helper()
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var m suggestionStreamMessage
+		if err := websocket.JSON.Receive(ws, &m); err != nil {
+			t.Fatalf("unable to receive message: %v", err)
+		}
+		return m
+	}
+
+	// The model holds each later suggestion until stepped, so every message
+	// must arrive while generation is still running
+	for i, want := range testSuggestions {
+		if i > 0 {
+			app.model.step <- struct{}{}
+		}
+		m := receive()
+		if m.Type != "suggestion" || m.Suggestion == nil || m.Suggestion.Style != want.Style {
+			t.Fatalf("message %d = %+v, want the %s suggestion", i, m, want.Style)
+		}
+	}
+	if m := receive(); m.Type != "done" {
+		t.Errorf("final message = %+v, want done", m)
+	}
+
+	if q := app.quota(t, "acct"); q != 4 {
+		t.Errorf("quota = %d, want 4", q)
+	}
+}
+
+func TestRecipeWineSuggestionsStream(t *testing.T) {
+	app := newTestApp(t)
+	app.createAccount(t, "acct", 5)
+	app.model.step = make(chan struct{})
+
+	resp, err := http.DefaultClient.Do(app.newRequest(t, "GET", app.recipePath("/recipes/suggestions/stream/"), "acct", nil))
+	if err != nil {
+		t.Fatalf("unable to open stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("stream status = %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	events := make(chan suggestionStreamMessage)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			payload, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var m suggestionStreamMessage
+			if err := json.Unmarshal([]byte(payload), &m); err != nil {
+				t.Errorf("unable to decode event %q: %v", payload, err)
+				return
+			}
+			events <- m
+		}
+	}()
+	next := func() suggestionStreamMessage {
+		t.Helper()
+		select {
+		case m, ok := <-events:
+			if !ok {
+				t.Fatalf("stream ended early")
+			}
+			return m
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for an event")
+		}
+		return suggestionStreamMessage{}
+	}
+
+	// The model holds each later suggestion until stepped, so every event
+	// must be flushed while generation is still running
+	for i, want := range testSuggestions {
+		if i > 0 {
+			app.model.step <- struct{}{}
+		}
+		m := next()
+		if m.Type != "suggestion" || m.Suggestion == nil || m.Suggestion.Style != want.Style {
+			t.Fatalf("event %d = %+v, want the %s suggestion", i, m, want.Style)
+		}
+	}
+	if m := next(); m.Type != "done" {
+		t.Errorf("final event = %+v, want done", m)
+	}
+}
+
+func TestNonAlcoholicOnlyStream(t *testing.T) {
+	app := newTestApp(t, WithNonAlcoholicOnly())
+	app.createAccount(t, "acct", 5)
+	ctx := context.Background()
+	// A wine pairing stored by a regular deployment sharing the table
+	if _, err := app.dl.CreateRecipePairing(ctx, app.recipeURL, data.PairingTypeURL, "Braised ribs", []data.Suggestion{
+		{Style: "Barolo", Region: "Piedmont"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, body := app.do(t, "GET", app.recipePath("/recipes/suggestions/stream/"), "acct", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("stream status = %d: %s", resp.StatusCode, body)
+	}
+	if strings.Contains(body, "Barolo") {
+		t.Errorf("stream replayed the stored wine pairing: %s", body)
+	}
+	if n := app.model.generations.Load(); n != 1 {
+		t.Fatalf("model generated %d times, want 1", n)
+	}
+	if prompt := app.model.lastPrompt(); !strings.Contains(prompt, "<ALCOHOL_FREE>") {
+		t.Errorf("streamed prompt is missing the alcohol-free guidance:\n%s", prompt)
+	}
+
+	// The stored wine pairing is left alone, and the new suggestions only
+	// go under the cache key for the restricted preferences
+	if pairing, err := app.dl.GetRecipePairing(ctx, app.recipeURL); err != nil || len(pairing.Suggestions) != 1 || pairing.Suggestions[0].Style != "Barolo" {
+		t.Errorf("stored pairing = %+v, %v; want the original wine pairing", pairing, err)
+	}
+	if _, err := app.wa.cache.Get("recipes:suggestions-json:" + app.recipeURL); err == nil {
+		t.Error("streamed suggestions were cached under the unrestricted key")
+	}
+	prefs := models.PairingPreferences{AlcoholFree: true}
+	if _, err := app.wa.cache.Get(getCacheKeyForPreferences(app.recipeURL, prefs)); err != nil {
+		t.Errorf("streamed suggestions weren't cached for the restricted preferences: %v", err)
+	}
+
+	// Recent cards come from the restricted suggestions, not the stored wines
+	resp, body = app.do(t, "GET", "/recipes/suggestions/recent?cards=true", "acct", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("recent status = %d: %s", resp.StatusCode, body)
+	}
+	var cards []recentSuggestionCard
+	if err := json.Unmarshal([]byte(body), &cards); err != nil || len(cards) != 1 {
+		t.Fatalf("recent cards = %s, %v; want one card", body, err)
+	}
+	if style := cards[0].Suggestions[0].Style; style != testSuggestions[0].Style {
+		t.Errorf("recent card starts with %s, want %s", style, testSuggestions[0].Style)
+	}
+}
+
+func TestPairingMCP(t *testing.T) {
+	app := newTestApp(t, WithNonAlcoholicOnly(), WithResponseEnvelope())
+	app.createAccount(t, "acct", 5)
+	ctx := context.Background()
+
+	c, err := mcpclient.NewStreamableHttpClient(app.srv.URL+"/mcp", transport.WithHTTPHeaders(map[string]string{
+		"Cookie": sessionCookieName + "=acct",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Start(ctx); err != nil {
+		t.Fatalf("unable to start client: %v", err)
+	}
+	init := mcp.InitializeRequest{}
+	init.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
+	init.Params.ClientInfo = mcp.Implementation{Name: "test", Version: "1.0.0"}
+	if _, err := c.Initialize(ctx, init); err != nil {
+		t.Fatalf("unable to initialize client: %v", err)
+	}
+	list, err := c.ListTools(ctx, mcp.ListToolsRequest{})
+	if err != nil || len(list.Tools) != 1 || list.Tools[0].Name != wpmcp.GeneratePairingsToolName {
+		t.Fatalf("tools = %+v, %v; want only GeneratePairings", list, err)
+	}
+
+	req := mcp.CallToolRequest{}
+	req.Params.Name = wpmcp.GeneratePairingsToolName
+	req.Params.Arguments = map[string]any{"input": app.recipeURL}
+	result, err := c.CallTool(ctx, req)
+	if err != nil || result.IsError {
+		t.Fatalf("GeneratePairings = %+v, %v", result, err)
+	}
+	text, _ := mcp.AsTextContent(result.Content[0])
+	var resp models.SuggestionsResponse
+	if err := json.Unmarshal([]byte(text.Text), &resp); err != nil || len(resp.Suggestions) != len(testSuggestions) {
+		t.Errorf("GeneratePairings = %s, %v; want the test suggestions", text.Text, err)
+	}
+
+	// The deployment's restrictions and the caller's quota apply
+	if prompt := app.model.lastPrompt(); !strings.Contains(prompt, "<ALCOHOL_FREE>") {
+		t.Errorf("pairing prompt is missing the alcohol-free guidance:\n%s", prompt)
+	}
+	if q := app.quota(t, "acct"); q != 4 {
+		t.Errorf("quota = %d, want 4", q)
+	}
+
+	// Agents need an account like everyone else
+	resp2, _ := app.do(t, "POST", "/mcp", "", strings.NewReader(`{"jsonrpc": "2.0", "id": 1, "method": "tools/list"}`))
+	if resp2.StatusCode != http.StatusUnauthorized {
+		t.Errorf("anonymous status = %d, want 401", resp2.StatusCode)
+	}
+}
+
+// postSuggestions requests suggestions for the test recipe with a posted
+// summary, which skips the summary step and is never served from storage.
+func (app *testApp) postSuggestions(t *testing.T, accountID string) (*http.Response, string) {
+	t.Helper()
+	body := fmt.Sprintf(`{"url": %q, "summary": "Braised short ribs in red wine; rich, heavy and savory."}`, app.recipeURL)
+	return app.do(t, "POST", "/recipes/suggestions", accountID, strings.NewReader(body))
+}
+
+func TestConcurrentQuota(t *testing.T) {
+	const requests = 10
+	app := newTestApp(t)
+	app.createAccount(t, "acct", 1)
+
+	var wg sync.WaitGroup
+	statuses := make(chan int, requests)
+	start := make(chan struct{})
+	for range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			resp, _ := app.postSuggestions(t, "acct")
+			statuses <- resp.StatusCode
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(statuses)
+
+	served := 0
+	for status := range statuses {
+		switch status {
+		case http.StatusOK:
+			served++
+		case http.StatusBadRequest:
+		default:
+			t.Errorf("unexpected status %d", status)
+		}
+	}
+	if served != 1 {
+		t.Errorf("served %d requests on a quota of 1, want 1", served)
+	}
+	if q := app.quota(t, "acct"); q != 0 {
+		t.Errorf("quota = %d, want 0", q)
+	}
+}
+
+func TestSpendQuotaDatabaseFailure(t *testing.T) {
+	t.Run("cache disabled", func(t *testing.T) {
+		app := newTestApp(t)
+		app.wa.cacheEnabled = false
+		app.createAccount(t, "acct", 5)
+		app.db.Fail("UpdateItem")
+
+		if resp, body := app.postSuggestions(t, "acct"); resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("status = %d, want 503 rather than serving for free: %s", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("cache enabled", func(t *testing.T) {
+		app := newTestApp(t)
+		app.createAccount(t, "acct", 5)
+		app.db.Fail("UpdateItem")
+
+		// The cache's copy of the quota is charged instead
+		if resp, body := app.postSuggestions(t, "acct"); resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d: %s", resp.StatusCode, body)
+		}
+		if q, err := app.wa.cache.Get(sessionQuotaKey("acct")); err != nil || q != "4" {
+			t.Errorf("cached quota = %q, %v; want 4", q, err)
+		}
+
+		// With neither store chargeable, the request isn't served
+		app.wa.cache = failingDecrCache{app.wa.cache}
+		if resp, body := app.postSuggestions(t, "acct"); resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("status = %d, want 503: %s", resp.StatusCode, body)
+		}
+	})
+}
+
+// failingDecrCache is a cache whose floored decrements always fail.
+type failingDecrCache struct {
+	cache.Cacher
+}
+
+func (failingDecrCache) DecrFloor(key string, floor int64) (int64, error) {
+	return 0, fmt.Errorf("cache unavailable")
+}
+
+func TestDeleteUser(t *testing.T) {
+	app := newTestApp(t)
+	app.createAccount(t, "acct", 5)
+	if err := app.wa.cache.Set("sessions:acct", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Loading the account caches its quota and email
+	if resp, body := app.do(t, "GET", "/user", "acct", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("user status = %d: %s", resp.StatusCode, body)
+	}
+	if resp, body := app.do(t, "PUT", "/user/preferences", "acct", strings.NewReader(`{"types": ["red"], "maxPrice": 40}`)); resp.StatusCode != http.StatusOK {
+		t.Fatalf("preferences status = %d: %s", resp.StatusCode, body)
+	}
+	keys := []string{"quotas:acct", "accounts:acct", "sessions:acct", preferenceProfileCacheKey("acct")}
+	for _, k := range keys {
+		if _, err := app.wa.cache.Get(k); err != nil {
+			t.Fatalf("%s wasn't cached before deletion: %v", k, err)
+		}
+	}
+
+	resp, body := app.do(t, "DELETE", "/user", "acct", nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete status = %d: %s", resp.StatusCode, body)
+	}
+
+	if _, err := app.dl.GetAccountByID(context.Background(), "acct"); !errors.Is(err, data.ErrNotFound) {
+		t.Errorf("account lookup after deletion = %v, want ErrNotFound", err)
+	}
+	for _, k := range keys {
+		if v, err := app.wa.cache.Get(k); !errors.Is(err, cache.ErrKeyNotFound) {
+			t.Errorf("%s = %q, %v after deletion; want it gone", k, v, err)
+		}
+	}
+	cleared := false
+	for _, c := range resp.Cookies() {
+		if c.Name == sessionCookieName {
+			cleared = c.Value == "" && c.Expires.Before(time.Now())
+		}
+	}
+	if !cleared {
+		t.Errorf("session cookie wasn't cleared: %v", resp.Header["Set-Cookie"])
+	}
+}
+
+// adminRoutes are requests to every admin route, each of which an admin can
+// make successfully. Invalidation comes last, since it drops the cached
+// suggestions validation reads.
+var adminRoutes = []struct {
+	method, path, body string
+}{
+	{"GET", "/admin/cache/stats", ""},
+	{"GET", "/routes", ""},
+	{"GET", "/admin/usage", ""},
+	{"GET", "/stats/wines", ""},
+	{"GET", "/admin/recipes/https:%2F%2Fexample.com%2Fbraised-ribs/validate", ""},
+	{"POST", "/admin/recipes/invalidate", `{"url": "https://example.com/braised-ribs"}`},
+}
+
+func TestAdminRequired(t *testing.T) {
+	app := newTestApp(t, WithCache(cache.NewInstrumentedCache(cache.NewMemory())), WithApiKeys(map[string]ApiKeyConfig{
+		"partner-key": {Name: "partner", Unlimited: true},
+		"admin-key":   {Name: "ops", Unlimited: true, Admin: true},
+	}))
+	app.createAccount(t, "user", 5)
+	if err := app.wa.cache.Set("recipes:suggestions-json:https://example.com/braised-ribs", `[{"style": "Syrah"}]`); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, route := range adminRoutes {
+		t.Run(route.method+" "+route.path, func(t *testing.T) {
+			request := func(accountID, apiKey string) int {
+				t.Helper()
+				req := app.newRequest(t, route.method, route.path, accountID, strings.NewReader(route.body))
+				if apiKey != "" {
+					req.Header.Set(apiKeyHeader, apiKey)
+				}
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatalf("%s %s: %v", route.method, route.path, err)
+				}
+				resp.Body.Close()
+				return resp.StatusCode
+			}
+
+			for _, tc := range []struct {
+				name, accountID, apiKey string
+				want                    int
+			}{
+				{"anonymous", "", "", http.StatusUnauthorized},
+				{"user", "user", "", http.StatusForbidden},
+				{"partner key", "", "partner-key", http.StatusForbidden},
+				// Session cookies aren't signed, so a cookie naming the admin
+				// key's account doesn't make an admin
+				{"forged session", "apikey:ops", "", http.StatusForbidden},
+				{"admin key", "", "admin-key", http.StatusOK},
+			} {
+				if got := request(tc.accountID, tc.apiKey); got != tc.want {
+					t.Errorf("%s: status = %d, want %d", tc.name, got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		options  []Option
+		method   string
+		path     string
+		wantCode int
+	}{
+		{"unknown path", nil, "GET", "/nope", http.StatusNotFound},
+		{"unknown method", nil, "DELETE", "/healthz", http.StatusNotFound},
+		{"outside the base path", []Option{WithBasePath("/api/v1")}, "GET", "/schema/summary", http.StatusNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newTestApp(t, tc.options...)
+			resp, body := app.do(t, tc.method, tc.path, "", nil)
+			if resp.StatusCode != tc.wantCode {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tc.wantCode)
+			}
+			if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			var e struct {
+				Message string `json:"message"`
+			}
+			if err := json.Unmarshal([]byte(body), &e); err != nil || !strings.Contains(e.Message, tc.path) {
+				t.Errorf("body = %q, %v; want a JSON error naming the path", body, err)
+			}
+		})
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	base := &fstest.MapFile{Data: []byte(`<main>{{block "main" .}}{{end}}</main>`)}
+
+	app := newTestApp(t, WithTemplates(fstest.MapFS{
+		"layouts/base.html": base,
+		"pages/home.html":   {Data: []byte(`{{template "layouts/base.html" .}}{{define "main"}}custom home{{end}}`)},
+	}))
+	resp, body := app.do(t, "GET", "/", "", nil)
+	if resp.StatusCode != http.StatusOK || body != "<main>custom home</main>" {
+		t.Errorf("home = %d %q, want the custom template", resp.StatusCode, body)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		fsys    fstest.MapFS
+		wantErr string
+	}{
+		{"missing home", fstest.MapFS{"layouts/base.html": base}, "pages/home.html"},
+		{"unparseable", fstest.MapFS{"layouts/base.html": base, "pages/home.html": {Data: []byte(`{{if}}`)}}, "unable to parse template pages/home.html"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewWebapp(0, WithMemoryCache(), WithModel(&testModel{}, wpmcp.MakeServer(cache.NewMemory())), WithTemplates(tc.fsys))
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("NewWebapp = %v, want an error naming %s", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateCachedSuggestions(t *testing.T) {
+	const u = "https://example.com/braised-ribs"
+	const key = "recipes:suggestions-json:" + u
+
+	t.Run("valid", func(t *testing.T) {
+		for _, tc := range []struct {
+			format, cached string
+		}{
+			{"v1", `[{"style": "Syrah", "region": "Northern Rhône", "confidence": 0.8}]`},
+			{"v2", `{"summary": "Braised ribs", "suggestions": [{"style": "Syrah", "region": "Northern Rhône", "confidence": 0.8}]}`},
+		} {
+			v := validateCachedSuggestions(u, key, tc.cached)
+			if !v.Valid || v.Format != tc.format || v.Error != "" {
+				t.Errorf("%s: validation = %+v, want valid", tc.format, v)
+				continue
+			}
+			if v.Result == nil || len(v.Result.Suggestions) != 1 || v.Result.Suggestions[0].Style != "Syrah" {
+				t.Errorf("%s: result = %+v, want the Syrah suggestion", tc.format, v.Result)
+			}
+		}
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		cached := "[\n  {\"style\": \"Syrah\", \"confidence\": \"high\"}\n]"
+		v := validateCachedSuggestions(u, key, cached)
+		if v.Valid || v.Result != nil || v.Error == "" {
+			t.Fatalf("validation = %+v, want an error", v)
+		}
+		if v.Format != "v1" || v.URL != u || v.Key != key {
+			t.Errorf("validation = %+v, want the v1 entry's URL and key", v)
+		}
+		if v.Line != 2 || v.Field == "" || !strings.Contains(v.Context, `"high"`) {
+			t.Errorf("error location = line %d, column %d, field %q, context %q; want line 2 near the bad confidence", v.Line, v.Column, v.Field, v.Context)
+		}
+	})
+}
+
+func TestUsage(t *testing.T) {
+	const generations = 3
+	app := newTestApp(t, WithApiKeys(map[string]ApiKeyConfig{
+		"admin-key": {Name: "ops", Unlimited: true, Admin: true},
+	}))
+	app.createAccount(t, "user", 5)
+	admin := func(query string) (*http.Response, string) {
+		t.Helper()
+		req := app.newRequest(t, "GET", "/admin/usage"+query, "", nil)
+		req.Header.Set(apiKeyHeader, "admin-key")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET /admin/usage%s: %v", query, err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(body)
+	}
+
+	for range generations {
+		if resp, body := app.postSuggestions(t, "user"); resp.StatusCode != http.StatusOK {
+			t.Fatalf("suggestions status = %d: %s", resp.StatusCode, body)
+		}
+	}
+	// Requests that aren't served aren't counted
+	if resp, _ := app.do(t, "POST", "/recipes/suggestions", "user", strings.NewReader("not json")); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("bad request status = %d", resp.StatusCode)
+	}
+
+	today := time.Now().UTC().Format(usageDateFormat)
+	if v, err := app.wa.cache.Get("usage:" + today); err != nil || v != strconv.Itoa(generations) {
+		t.Errorf("usage:%s = %q, %v; want %d", today, v, err, generations)
+	}
+
+	for _, tc := range []struct {
+		query, date string
+		want        int64
+	}{
+		{"", today, generations},
+		{"?date=" + today, today, generations},
+		{"?date=2000-01-01", "2000-01-01", 0},
+	} {
+		resp, body := admin(tc.query)
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("usage%s status = %d: %s", tc.query, resp.StatusCode, body)
+			continue
+		}
+		var usage struct {
+			Date        string `json:"date"`
+			Generations int64  `json:"generations"`
+		}
+		if err := json.Unmarshal([]byte(body), &usage); err != nil || usage.Date != tc.date || usage.Generations != tc.want {
+			t.Errorf("usage%s = %s, %v; want %d on %s", tc.query, body, err, tc.want, tc.date)
+		}
+	}
+
+	if resp, _ := admin("?date=yesterday"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("usage with a bad date: status = %d, want 400", resp.StatusCode)
+	}
+}